@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+const (
+	// maxAnalyzeBytes caps the size of a POST /analyze request body
+	maxAnalyzeBytes = 32 << 20
+	// analyzeWorkers bounds the number of documents parsed at once across all POST /analyze requests
+	analyzeWorkers = 6
+)
+
+var analyzeSlots = make(chan struct{}, analyzeWorkers)
+
+// analyzeDocument is one T-SQL document submitted to POST /analyze
+type analyzeDocument struct {
+	Name string `json:"name"`
+	Body string `json:"body"`
+}
+
+// analyzeResult holds the syntax errors and findings for one document, each finding keyed by report column
+type analyzeResult struct {
+	Name     string                         `json:"name"`
+	Errors   []string                       `json:"errors"`
+	Findings map[string][]map[string]string `json:"findings"`
+}
+
+// handleAnalyze parses a JSON array of {name, body} documents and returns their analyses in the same order.
+// The extractors run are those named in the comma-separated `extract` query parameter, or all of them. Names are
+// resolved against the database and whitelist of the last successful run of the target named in the `target`
+// query parameter, or else against the -database given to `sprocs serve`, with no whitelist.
+func (s *server) handleAnalyze(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "POST an array of {name, body} documents", http.StatusMethodNotAllowed)
+		return
+	}
+	extractors, err := selectExtractors(r.URL.Query().Get("extract"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	names := s.names
+	if name := r.URL.Query().Get("target"); len(name) > 0 {
+		var t *target
+		for _, c := range s.targets {
+			if c.Name == name {
+				t = c
+			}
+		}
+		if t == nil {
+			http.Error(w, "unknown target "+name, http.StatusNotFound)
+			return
+		}
+		t.mu.Lock()
+		names = t.names
+		t.mu.Unlock()
+		if names == nil {
+			http.Error(w, "target "+name+" has not been analyzed yet", http.StatusServiceUnavailable)
+			return
+		}
+	}
+	var docs []analyzeDocument
+	if err = json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAnalyzeBytes)).Decode(&docs); err != nil {
+		http.Error(w, "invalid request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	// a run replacing the catalogs and dictionaries waits for the documents to be parsed with them
+	referenceMu.RLock()
+	defer referenceMu.RUnlock()
+	results := make([]analyzeResult, len(docs))
+	wg := new(sync.WaitGroup)
+	for i, doc := range docs {
+		wg.Add(1)
+		go func(i int, doc analyzeDocument) {
+			defer wg.Done()
+			analyzeSlots <- struct{}{}
+			defer func() { <-analyzeSlots }()
+			results[i] = analyzeOne(doc, names, extractors)
+		}(i, doc)
+	}
+	wg.Wait()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(results)
+}
+
+// analyzeOne parses a document with the extractors' listeners, resolving names against names. A panic while doing
+// so is returned as an error of the document, with no findings, rather than bringing down the server.
+func analyzeOne(doc analyzeDocument, names *nameScope, extractors []Extractor) (res analyzeResult) {
+	defer func() {
+		if r := recover(); r != nil {
+			res = analyzeResult{Name: doc.Name, Errors: []string{fmt.Sprint("panic: ", r)}, Findings: map[string][]map[string]string{}}
+		}
+	}()
+	errors, findings := parseSproc(keyValue{doc.Name, doc.Body}, names, extractors, false)
+	res = analyzeResult{Name: doc.Name, Errors: errors, Findings: make(map[string][]map[string]string)}
+	if res.Errors == nil {
+		res.Errors = []string{}
+	}
+	for _, e := range extractors {
+		cols := e.Columns()
+		rows := make([]map[string]string, 0, len(findings[e.Name()]))
+		for _, f := range findings[e.Name()] {
+			row := make(map[string]string, len(f))
+			for j, v := range f {
+				if j < len(cols) {
+					row[cols[j]] = v
+				}
+			}
+			rows = append(rows, row)
+		}
+		res.Findings[e.Name()] = rows
+	}
+	return res
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/antlr/antlr4/runtime/Go/antlr"
+	parser "github.com/nycmonkey/sprocs/tsql"
+)
+
+// aseDialect is the -dialect of Sybase ASE procedures, which reads them from the ASE system catalogs and turns the
+// syntax ASE has and SQL Server lacks into blanks or equivalents before parsing
+const aseDialect = "ase"
+
+func init() {
+	grammars[aseDialect] = grammar{
+		description: "Sybase ASE: the " + defaultDialect + " grammar over definitions with ASE-only syntax rewritten, read from sysobjects and syscomments",
+		newLexer: func(input antlr.CharStream) antlr.Lexer {
+			return parser.NewtsqlLexer(antlr.NewInputStream(aseToTSQL(input.GetText(0, input.Size()-1))))
+		},
+		parse: grammars[defaultDialect].parse,
+	}
+}
+
+const (
+	// aseTableQ lists the tables of dbo, like tableQ
+	aseTableQ = `
+SELECT name FROM sysobjects WHERE type = 'U' AND uid = USER_ID('dbo')
+`
+	// aseSprocQ lists the procedures of the database with their group numbers, like activeSprocQ
+	aseSprocQ = `
+SELECT DISTINCT USER_NAME(o.uid), o.name, c.number FROM sysobjects o
+JOIN syscomments c ON c.id = o.id
+WHERE o.type = 'P'
+`
+	// aseDefinitionQ returns the text of a procedure in the 255 character rows ASE keeps it in
+	aseDefinitionQ = `
+SELECT text FROM syscomments WHERE id = OBJECT_ID(?) AND number = ? ORDER BY colid2, colid
+`
+)
+
+var (
+	// aseOuterJoin matches the *= and =* outer join operators, which SQL Server dropped
+	aseOuterJoin = regexp.MustCompile(`\*=|=\*`)
+	// aseIsolation matches the isolation level clause ASE allows at the end of a query, and SET TRANSACTION
+	// ISOLATION LEVEL given as a number
+	aseIsolation = regexp.MustCompile(`(?i)\bAT\s+ISOLATION\s+(LEVEL\s+)?(READ\s+UNCOMMITTED|READ\s+COMMITTED|REPEATABLE\s+READ|SERIALIZABLE|[0-3])\b|\bSET\s+TRANSACTION\s+ISOLATION\s+LEVEL\s+[0-3]\b`)
+	// aseLockHint matches the lock hints ASE allows after a table name without WITH ( ), which are also valid
+	// identifiers, so are only hints where aseTableRef ends just before them
+	aseLockHint = regexp.MustCompile(`(?i)\b(NOHOLDLOCK|HOLDLOCK|SHARED|READPAST)\b`)
+	// aseTableRef matches a table reference at the end of the text before a lock hint: a table of a FROM, JOIN or
+	// UPDATE, or following a comma in a FROM list, with an optional alias
+	aseTableRef = regexp.MustCompile(`(?i)(\bFROM|\bJOIN|\bUPDATE|,)\s+[\w#@.\[\]"]+(\s+(AS\s+)?(\w+))?\s+$`)
+	// aseClause matches the keywords starting the clauses of a statement, the last of which tells a comma of a
+	// FROM list from one of a select list
+	aseClause = regexp.MustCompile(`(?i)\b(SELECT|FROM|JOIN|WHERE|GROUP|ORDER|HAVING|SET|UPDATE|DELETE|INTO|VALUES)\b`)
+	// aseRaiserror matches RAISERROR without parentheses, as in raiserror 20001 "message", to the end of its line
+	aseRaiserror = regexp.MustCompile(`(?i)\bRAISERROR\s+[0-9@][^\n]*`)
+)
+
+// noopStatement replaces statements the grammar can't parse and that use no tables, being no longer than any of
+// them, so a statement is still there when it is the body of an IF
+const noopStatement = "PRINT 0"
+
+// aseToTSQL rewrites the ASE syntax of a definition the T-SQL grammar can't parse, keeping every other character
+// where it is so reported lines and columns still match the definition. Outer join operators become =, and
+// isolation clauses and lock hints without WITH are blanked out, as are RAISERROR statements without parentheses
+// but for a PRINT. String literals and comments are left alone.
+func aseToTSQL(text string) string {
+	code := []byte(maskLiterals(text))
+	out := []byte(text)
+	for _, m := range aseOuterJoin.FindAllIndex(code, -1) {
+		out[m[0]], out[m[0]+1] = '=', ' '
+	}
+	for _, m := range aseIsolation.FindAllIndex(code, -1) {
+		blankOut(out, m[0], m[1])
+	}
+	for _, m := range aseRaiserror.FindAllIndex(code, -1) {
+		blankOut(out, m[0], m[1])
+		copy(out[m[0]:], noopStatement)
+	}
+	for _, m := range aseLockHint.FindAllIndex(code, -1) {
+		if aseHintPosition(code[:m[0]]) {
+			// blanked in code too, so a hint following this one still follows the table reference
+			blankOut(out, m[0], m[1])
+			blankOut(code, m[0], m[1])
+		}
+	}
+	return string(out)
+}
+
+// aseNotAlias holds the keywords that may follow a table reference in place of an alias
+var aseNotAlias = map[string]bool{
+	"AND": true, "CROSS": true, "ELSE": true, "END": true, "FULL": true, "GROUP": true, "HAVING": true,
+	"INNER": true, "JOIN": true, "LEFT": true, "ON": true, "OR": true, "ORDER": true, "OUTER": true, "RIGHT": true,
+	"SELECT": true, "SET": true, "THEN": true, "UNION": true, "VALUES": true, "WHEN": true, "WHERE": true,
+}
+
+// aseHintPosition reports whether a lock hint following prev is one, directly after a table reference, rather
+// than a column or other identifier of the same name. Hints inside WITH ( ) are T-SQL too, so are left alone.
+func aseHintPosition(prev []byte) bool {
+	m := aseTableRef.FindSubmatchIndex(prev)
+	if m == nil {
+		return false
+	}
+	if m[8] >= 0 && aseNotAlias[strings.ToUpper(string(prev[m[8]:m[9]]))] {
+		return false
+	}
+	if prev[m[2]] != ',' {
+		return true
+	}
+	clauses := aseClause.FindAllIndex(prev[:m[2]], -1)
+	if len(clauses) == 0 {
+		return false
+	}
+	last := strings.ToUpper(string(prev[clauses[len(clauses)-1][0]:clauses[len(clauses)-1][1]]))
+	return last == "FROM" || last == "JOIN"
+}
+
+// blankOut replaces out[start:end] with spaces, keeping line breaks
+func blankOut(out []byte, start, end int) {
+	for i := start; i < end; i++ {
+		if out[i] != '\n' && out[i] != '\r' {
+			out[i] = ' '
+		}
+	}
+}
+
+// maskLiterals returns text with the contents of its string literals, quoted identifiers and comments replaced by
+// spaces, newlines aside, so the syntax around them can be searched with regular expressions
+func maskLiterals(text string) string {
+	out := []byte(text)
+	var end string
+	for i := 0; i < len(out); i++ {
+		if len(end) > 0 {
+			if strings.HasPrefix(text[i:], end) {
+				i += len(end) - 1
+				end = ""
+			} else if out[i] != '\n' {
+				out[i] = ' '
+			}
+			continue
+		}
+		switch {
+		case strings.HasPrefix(text[i:], "--"):
+			end = "\n"
+			i++
+		case strings.HasPrefix(text[i:], "/*"):
+			end = "*/"
+			i++
+		case out[i] == '\'' || out[i] == '"':
+			end = string(out[i])
+		case out[i] == '[':
+			end = "]"
+		}
+	}
+	return string(out)
+}
+
+// aseObjectName names a sproc entry as ASE's OBJECT_ID takes it, owner.name, along with its group number
+func aseObjectName(entry string) (string, int) {
+	_, number := sprocObject(entry)
+	if number == 0 {
+		number = 1
+	}
+	name := strings.TrimSpace(entry)
+	if i := strings.LastIndex(name, ";"); i >= 0 {
+		name = name[:i]
+	}
+	if !strings.Contains(name, ".") {
+		name = "dbo." + name
+	}
+	return name, number
+}
+
+// getASESprocs loads the sprocs and tables of the Sybase ASE database on dbHost and sends the sprocs to be parsed,
+// like getSprocs does for SQL Server. ASE speaks an older protocol than the SQL Server driver, so it is reached
+// through an ODBC data source. Its catalog has no account master, so only -dictionary keys are matched.
+func getASESprocs(defDir string, outCh chan<- keyValue) error {
+	log.Println("Querying", dbHost, "(Sybase ASE)")
+	defer close(outCh)
+	if sqlDriver.name() != "odbc" {
+		return errors.New("Sybase ASE databases are reached through an ODBC data source; add -driver odbc")
+	}
+	dsn, err := connString()
+	if err != nil {
+		return err
+	}
+	db, err := openDB(dsn)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	log.Println("Fetching list of known tables")
+	known := make(map[string]struct{})
+	logSQL(aseTableQ)
+	rows, err := db.Query(aseTableQ)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var tableName string
+		if err = rows.Scan(&tableName); err != nil {
+			rows.Close()
+			return err
+		}
+		known[strings.ToUpper(strings.TrimSpace(tableName))] = struct{}{}
+	}
+	rows.Close()
+	whitelist = known
+	log.Println("Loaded table whitelist with", len(whitelist), "values")
+	err = loadReferenceData(func() error {
+		catalog, historyTables, sprocDates, executionCounts = nil, nil, nil, nil
+		portfolios.reset()
+		return loadDictionaries(db)
+	})
+	if err != nil {
+		return err
+	}
+	if err = checkReferenceData(dictionaries); err != nil {
+		return err
+	}
+	var sprocNames []string
+	if len(sprocListPath) > 0 {
+		if sprocNames, err = readSprocList(sprocListPath, sprocListEntry); err != nil {
+			return err
+		}
+	} else {
+		log.Println("Looking up stored procedures")
+		logSQL(aseSprocQ)
+		if rows, err = db.Query(aseSprocQ); err != nil {
+			return err
+		}
+		for rows.Next() {
+			var (
+				owner, name string
+				number      int
+			)
+			if err = rows.Scan(&owner, &name, &number); err != nil {
+				rows.Close()
+				return err
+			}
+			if !excludedSproc(name) {
+				sprocNames = append(sprocNames, sprocEntry(owner, name, number))
+			}
+		}
+		rows.Close()
+		sort.Strings(sprocNames)
+	}
+	log.Println("Found", len(sprocNames), "stored procedures")
+	log.Println("Fetching stored procedure definitions")
+	defs := make([]keyValue, 0, len(sprocNames))
+	fetching := startPhase("Fetching", len(sprocNames))
+	defer fetching.Finish()
+	for _, sn := range sprocNames {
+		def, err := aseDefinition(db, sn)
+		fetching.Increment()
+		if err != nil {
+			return errors.New("error while querying definition of " + sn + ": " + err.Error())
+		}
+		if len(def) == 0 {
+			log.Println("No definition found for", sn)
+			continue
+		}
+		defs = append(defs, keyValue{key: sn, value: def})
+	}
+	db.Close()
+	fetching.Finish()
+	return sendDefinitions(defDir, defs, len(sprocNames), nil, outCh)
+}
+
+// aseDefinition returns the text of a procedure, joining the rows of syscomments it is split across
+func aseDefinition(db *sql.DB, entry string) (string, error) {
+	name, number := aseObjectName(entry)
+	logSQL(aseDefinitionQ, name, number)
+	rows, err := db.Query(sqlDriver.bind(aseDefinitionQ), name, number)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+	var b strings.Builder
+	for rows.Next() {
+		var text sql.NullString
+		if err = rows.Scan(&text); err != nil {
+			return "", err
+		}
+		b.WriteString(text.String)
+	}
+	return b.String(), rows.Err()
+}
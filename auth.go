@@ -0,0 +1,162 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// apiKey is a client allowed to call the server and the key it authenticates with
+type apiKey struct {
+	client, key string
+}
+
+// loadAPIKeys reads a CSV file of Client,Key rows, with a header row
+func loadAPIKeys(path string) ([]apiKey, error) {
+	records, err := readCSVFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var keys []apiKey
+	for i, rec := range records {
+		if i == 0 || len(rec) < 2 || len(strings.TrimSpace(rec[1])) == 0 {
+			continue
+		}
+		keys = append(keys, apiKey{strings.TrimSpace(rec[0]), strings.TrimSpace(rec[1])})
+	}
+	if len(keys) == 0 {
+		return nil, errors.New(path + " has no API keys")
+	}
+	return keys, nil
+}
+
+// rateLimiter is a token bucket per client, refilled at perMinute tokens a minute up to a burst of perMinute.
+// Buckets left alone for a minute are full, so are dropped, at most once a minute, rather than kept forever.
+type rateLimiter struct {
+	perMinute float64
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	swept     time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(perMinute int) *rateLimiter {
+	return &rateLimiter{perMinute: float64(perMinute), buckets: make(map[string]*bucket)}
+}
+
+// allow takes a token from the client's bucket, reporting false when it is empty
+func (r *rateLimiter) allow(client string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	if now.Sub(r.swept) >= time.Minute {
+		for c, b := range r.buckets {
+			if now.Sub(b.last) >= time.Minute {
+				delete(r.buckets, c)
+			}
+		}
+		r.swept = now
+	}
+	b, ok := r.buckets[client]
+	if !ok {
+		b = &bucket{r.perMinute, now}
+		r.buckets[client] = b
+	}
+	b.tokens = math.Min(r.perMinute, b.tokens+now.Sub(b.last).Minutes()*r.perMinute)
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// statusRecorder captures the status code written by a handler for the audit log
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusRecorder) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// authenticate returns the client presenting one of the keys, in an X-API-Key header, as a bearer token or as
+// the password of HTTP basic authentication (so browsers can prompt for it)
+func authenticate(r *http.Request, keys []apiKey) (string, bool) {
+	presented := r.Header.Get("X-API-Key")
+	if auth := r.Header.Get("Authorization"); len(presented) == 0 && strings.HasPrefix(auth, "Bearer ") {
+		presented = strings.TrimPrefix(auth, "Bearer ")
+	}
+	if _, password, ok := r.BasicAuth(); len(presented) == 0 && ok {
+		presented = password
+	}
+	if len(presented) == 0 {
+		return "", false
+	}
+	for _, k := range keys {
+		if subtle.ConstantTimeCompare([]byte(presented), []byte(k.key)) == 1 {
+			return k.client, true
+		}
+	}
+	return "", false
+}
+
+// protect wraps a handler with API key authentication, rate limiting and audit logging, each of which is only
+// applied when configured. Requests are limited by remote address before their key is checked, so keys can't be
+// guessed at any faster than the API can be called, then by the client the key authenticates.
+func (s *server) protect(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			client = r.RemoteAddr
+		}
+		rec := &statusRecorder{w, http.StatusOK}
+		defer func() {
+			if s.audit != nil {
+				s.audit.Printf("client=%q remote=%s %s %s status=%d", client, r.RemoteAddr, r.Method, r.URL.RequestURI(), rec.status)
+			}
+		}()
+		if s.limiter != nil && !s.limiter.allow("address "+client) {
+			rec.Header().Set("Retry-After", "60")
+			http.Error(rec, "rate limit exceeded", http.StatusTooManyRequests)
+			return
+		}
+		if len(s.keys) > 0 {
+			var ok bool
+			if client, ok = authenticate(r, s.keys); !ok {
+				client = "anonymous"
+				rec.Header().Set("WWW-Authenticate", `Basic realm="sprocs"`)
+				http.Error(rec, "a valid API key is required", http.StatusUnauthorized)
+				return
+			}
+		}
+		if s.limiter != nil && len(s.keys) > 0 && !s.limiter.allow("client "+client) {
+			rec.Header().Set("Retry-After", "60")
+			http.Error(rec, "rate limit exceeded", http.StatusTooManyRequests)
+			return
+		}
+		h.ServeHTTP(rec, r)
+	})
+}
+
+// openAuditLog returns a logger appending to path
+func openAuditLog(path string) (*log.Logger, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return log.New(f, "", log.LstdFlags|log.LUTC), nil
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+func init() {
+	commands["browse"] = command{
+		summary: "explore the results of a run in a terminal UI, or with typed commands under -plain",
+		run:     browse,
+	}
+}
+
+// runResults holds the per-sproc reports and definitions of a run directory
+type runResults struct {
+	dir    string
+	sprocs []string
+	// files maps each sproc to its definition file, as recorded in the run's manifest
+	files map[string]string
+	// reports maps each report with a leading `Stored Procedure` column to its header and rows
+	reports map[string]*report
+}
+
+type report struct {
+	header []string
+	rows   [][]string
+}
+
+// loadRun reads every per-sproc CSV report in dir and lists the sprocs found in them or in its definitions
+func loadRun(dir string) (*runResults, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.csv"))
+	if err != nil {
+		return nil, err
+	}
+	r := &runResults{dir: dir, reports: make(map[string]*report)}
+	seen := make(map[string]bool)
+	add := func(sproc string) {
+		if !seen[sproc] {
+			seen[sproc] = true
+			r.sprocs = append(r.sprocs, sproc)
+		}
+	}
+	for _, path := range paths {
+		if filepath.Base(path) == manifestFile {
+			continue
+		}
+		records, err := readCSVFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if len(records) == 0 || records[0][0] != "Stored Procedure" {
+			continue
+		}
+		r.reports[strings.TrimSuffix(filepath.Base(path), ".csv")] = &report{records[0], records[1:]}
+		for _, rec := range records[1:] {
+			add(rec[0])
+		}
+	}
+	if r.files, err = readManifest(dir); err != nil {
+		return nil, err
+	}
+	for sproc := range r.files {
+		add(sproc)
+	}
+	if len(r.files) == 0 {
+		defs, err := ioutil.ReadDir(filepath.Join(dir, "sproc_definitions"))
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		for _, fi := range defs {
+			if strings.HasSuffix(fi.Name(), ".sql") {
+				add(strings.TrimSuffix(fi.Name(), ".sql"))
+			}
+		}
+	}
+	if len(r.reports) == 0 && len(r.sprocs) == 0 {
+		return nil, fmt.Errorf("%s does not contain the results of a run", dir)
+	}
+	sort.Strings(r.sprocs)
+	return r, nil
+}
+
+// readCSVFile reads every record of a CSV file, undoing the quoting of formula-like cells by safeRow
+func readCSVFile(path string) ([][]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	rd := csv.NewReader(f)
+	rd.FieldsPerRecord = -1
+	records, err := rd.ReadAll()
+	for _, rec := range records {
+		for i := range rec {
+			rec[i] = unquoteCell(rec[i])
+		}
+	}
+	return records, err
+}
+
+// reportNames returns the names of the loaded reports in order
+func (r *runResults) reportNames() []string {
+	names := make([]string, 0, len(r.reports))
+	for name := range r.reports {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// definition returns the raw definition of a sproc
+func (r *runResults) definition(sproc string) (string, error) {
+	name, ok := r.files[sproc]
+	if !ok {
+		name = defFileName(sproc)
+	}
+	b, err := ioutil.ReadFile(filepath.Join(r.dir, "sproc_definitions", name))
+	return string(b), err
+}
+
+// filter returns the sprocs whose name contains the case-insensitive substring
+func (r *runResults) filter(substr string) []string {
+	var out []string
+	for _, sproc := range r.sprocs {
+		if strings.Contains(strings.ToUpper(sproc), strings.ToUpper(substr)) {
+			out = append(out, sproc)
+		}
+	}
+	return out
+}
+
+// referencing returns the rows of every report with a cell equal to value, ignoring case, in any column but the first
+func (r *runResults) referencing(value string) map[string][][]string {
+	found := make(map[string][][]string)
+	for name, rep := range r.reports {
+		for _, row := range rep.rows {
+			for _, cell := range row[1:] {
+				if strings.EqualFold(cell, value) {
+					found[name] = append(found[name], row)
+					break
+				}
+			}
+		}
+	}
+	return found
+}
+
+const browseHelp = `Commands:
+  ls [filter]       list sprocs, optionally only those whose name contains filter
+  show <sproc>      show every report row for a sproc
+  def <sproc>       print the definition of a sproc
+  grep <text>       find definition lines containing text
+  refs <value>      show report rows naming a table, portfolio code or other value
+  help              show this message
+  quit              exit`
+
+func browse(args []string) error {
+	fs := flag.NewFlagSet("browse", flag.ExitOnError)
+	plain := fs.Bool("plain", false, "read commands line by line instead of opening the terminal UI, e.g. when piping them in")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: sprocs browse [flags] <runDir>\n\nFlags:")
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	run, err := loadRun(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if !*plain {
+		return run.explore()
+	}
+	return run.commands(os.Stdin)
+}
+
+// commands runs the browse commands read from in, a line at a time
+func (r *runResults) commands(in io.Reader) error {
+	fmt.Printf("%d sprocs; reports: %s\n%s\n", len(r.sprocs), strings.Join(r.reportNames(), ", "), browseHelp)
+	lines := bufio.NewScanner(in)
+	for {
+		fmt.Print("> ")
+		if !lines.Scan() {
+			fmt.Println()
+			return lines.Err()
+		}
+		fields := strings.SplitN(strings.TrimSpace(lines.Text()), " ", 2)
+		arg := ""
+		if len(fields) == 2 {
+			arg = strings.TrimSpace(fields[1])
+		}
+		switch fields[0] {
+		case "":
+		case "ls":
+			for _, sproc := range r.filter(arg) {
+				fmt.Println(sproc)
+			}
+		case "show":
+			r.show(os.Stdout, r.resolve(arg))
+		case "def":
+			def, err := r.definition(r.resolve(arg))
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+			fmt.Println(def)
+		case "grep":
+			r.grep(os.Stdout, arg)
+		case "refs":
+			found := r.referencing(arg)
+			for _, name := range r.reportNames() {
+				for _, row := range found[name] {
+					fmt.Printf("%s: %s\n", name, strings.Join(row, ", "))
+				}
+			}
+		case "help":
+			fmt.Println(browseHelp)
+		case "quit", "exit":
+			return nil
+		default:
+			fmt.Println("unknown command", fields[0]+"; type help for a list of commands")
+		}
+	}
+}
+
+// resolve returns the sproc named arg, or the only sproc whose name contains it
+func (r *runResults) resolve(arg string) string {
+	for _, sproc := range r.sprocs {
+		if strings.EqualFold(sproc, arg) {
+			return sproc
+		}
+	}
+	if matches := r.filter(arg); len(matches) == 1 {
+		return matches[0]
+	}
+	return arg
+}
+
+func (r *runResults) show(w io.Writer, sproc string) {
+	fmt.Fprintln(w, sproc)
+	for _, name := range r.reportNames() {
+		rep := r.reports[name]
+		for _, row := range rep.rows {
+			if row[0] != sproc {
+				continue
+			}
+			var cells []string
+			for i, cell := range row[1:] {
+				if i+1 < len(rep.header) && len(cell) > 0 {
+					cells = append(cells, rep.header[i+1]+"="+cell)
+				}
+			}
+			fmt.Fprintf(w, "  %s: %s\n", name, strings.Join(cells, ", "))
+		}
+	}
+}
+
+// grep prints each definition line containing text, ignoring case, prefixed with the sproc and line number
+func (r *runResults) grep(w io.Writer, text string) {
+	if len(text) == 0 {
+		return
+	}
+	for _, sproc := range r.sprocs {
+		def, err := r.definition(sproc)
+		if err != nil {
+			continue
+		}
+		for i, line := range strings.Split(def, "\n") {
+			if strings.Contains(strings.ToUpper(line), strings.ToUpper(text)) {
+				fmt.Fprintf(w, "%s:%d: %s\n", sproc, i+1, strings.TrimSpace(line))
+			}
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+// maxDuration caps how long a run may take, 0 for no limit. Once it is reached no further definitions are
+// fetched or parsed, but those being parsed are finished and the reports of the run are written as usual.
+var maxDuration time.Duration
+
+// runBudget is the time left to the current run
+var runBudget struct {
+	// deadline is zero when the run has no -max-duration
+	deadline time.Time
+	// reached is set once the run stopped early for having run out of time
+	reached bool
+}
+
+// manifest statuses of the sprocs of a run
+const (
+	statusAnalyzed   = "analyzed"
+	statusSkipped    = "skipped"
+	statusNotFetched = "not fetched"
+)
+
+// startBudget starts counting -max-duration for a new run
+func startBudget() {
+	runBudget.deadline, runBudget.reached = time.Time{}, false
+	if maxDuration > 0 {
+		runBudget.deadline = time.Now().Add(maxDuration)
+	}
+}
+
+// outOfTime reports whether the run has reached its -max-duration, logging it the first time
+func outOfTime(left int) bool {
+	if runBudget.deadline.IsZero() || time.Now().Before(runBudget.deadline) {
+		return false
+	}
+	if !runBudget.reached {
+		log.Println("Reached -max-duration", maxDuration, "with", left, "stored procedures left; finishing with partial results")
+		runBudget.reached = true
+	}
+	return true
+}
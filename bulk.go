@@ -0,0 +1,192 @@
+package main
+
+import (
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/antlr/antlr4/runtime/Go/antlr"
+	parser "github.com/nycmonkey/sprocs/tsql"
+)
+
+func init() {
+	RegisterExtractor(fileTransferExtractor{})
+}
+
+// fileTransferExtractor reports the data each sproc moves between tables and files: bcp run through xp_cmdshell,
+// BULK INSERT and OPENROWSET(BULK ...)
+type fileTransferExtractor struct{}
+
+func (fileTransferExtractor) Name() string {
+	return "file_transfers"
+}
+
+func (fileTransferExtractor) Columns() []string {
+	return []string{"Line", "Method", "Direction", "Table", "File", "Command"}
+}
+
+func (fileTransferExtractor) NewListener(sproc string, names *nameScope, emit func([]string)) antlr.ParseTreeListener {
+	return &fileTransferListener{&parser.BasetsqlListener{}, sproc, names, emit, make(localVars)}
+}
+
+type fileTransferListener struct {
+	*parser.BasetsqlListener
+	sproc string
+	names *nameScope
+	emit  func([]string)
+	vars  localVars
+}
+
+// EnterDeclare_local is called when the parser enters a `declare_local` node
+func (l *fileTransferListener) EnterDeclare_local(ctx *parser.Declare_localContext) {
+	l.vars.declare(ctx)
+}
+
+// EnterSet_statement is called when the parser enters a `set_statement` node
+func (l *fileTransferListener) EnterSet_statement(ctx *parser.Set_statementContext) {
+	l.vars.set(ctx)
+}
+
+// EnterExecute_statement is called when the parser enters an `execute_statement` node, where xp_cmdshell may run bcp
+func (l *fileTransferListener) EnterExecute_statement(ctx *parser.Execute_statementContext) {
+	if ctx.Func_proc_name() == nil {
+		return
+	}
+	name := strings.Split(l.names.normalizeProcName(ctx.Func_proc_name().GetText()), ".")
+	if !strings.EqualFold(name[len(name)-1], "xp_cmdshell") || ctx.Execute_statement_arg(0) == nil {
+		return
+	}
+	arg := ctx.Execute_statement_arg(0).(*parser.Execute_statement_argContext)
+	if arg.Constant_LOCAL_ID() == nil {
+		return
+	}
+	line := strconv.Itoa(ctx.GetStart().GetLine())
+	cmd, ok := l.vars.literal(arg.Constant_LOCAL_ID().GetText())
+	if !ok {
+		// a command built at run time: report it if it looks like bcp, without the details
+		if expr := l.vars.resolve(arg.Constant_LOCAL_ID().GetText()); strings.Contains(strings.ToLower(expr), "bcp") {
+			l.emit([]string{line, "bcp", "", "", "", expr})
+		}
+		return
+	}
+	args := commandArgs(cmd)
+	for i, a := range args {
+		if exe := strings.ToLower(filepath.Base(strings.Replace(a, `\`, "/", -1))); exe != "bcp" && exe != "bcp.exe" {
+			continue
+		}
+		var object, direction, file string
+		if rest := args[i+1:]; len(rest) >= 3 {
+			object, direction, file = rest[0], strings.ToLower(rest[1]), rest[2]
+		}
+		switch direction {
+		case "in":
+			l.emit([]string{line, "bcp", "import", l.bcpTable(object), file, cmd})
+		case "out":
+			l.emit([]string{line, "bcp", "export", l.bcpTable(object), file, cmd})
+		case "queryout":
+			l.emit([]string{line, "bcp", "export", l.queryTables(object), file, cmd})
+		case "format":
+			l.emit([]string{line, "bcp", "format", l.bcpTable(object), file, cmd})
+		default:
+			l.emit([]string{line, "bcp", "", "", "", cmd})
+		}
+		return
+	}
+}
+
+// bcpTable returns the normalized name of the table a bcp command copies
+func (l *fileTransferListener) bcpTable(object string) string {
+	if strings.Count(object, ".") > 3 {
+		return object
+	}
+	return l.names.normalizeTableName(object)
+}
+
+// queryTables returns the tables read by a bcp queryout query
+func (l *fileTransferListener) queryTables(query string) string {
+	var tables []string
+	parseTSQL(l.sproc+" bcp", query, NewTableListener(l.sproc, l.names, func(f []string) {
+		tables = append(tables, f[0])
+	}))
+	sort.Strings(tables)
+	return strings.Join(tables, "; ")
+}
+
+// EnterRowset_function is called when the parser enters a `rowset_function` node, which reads a file when it is
+// OPENROWSET(BULK ...)
+func (l *fileTransferListener) EnterRowset_function(ctx *parser.Rowset_functionContext) {
+	if ctx.GetData_file() == nil {
+		return
+	}
+	file, _ := unquoteString(ctx.GetData_file().GetText())
+	var table string
+	for node := ctx.GetParent(); node != nil; node = node.GetParent() {
+		if ins, ok := node.(*parser.Insert_statementContext); ok {
+			if ins.Ddl_object() != nil {
+				table = l.names.normalizeTableName(ins.Ddl_object().GetText())
+			}
+			break
+		}
+	}
+	l.emit([]string{strconv.Itoa(ctx.GetStart().GetLine()), "OPENROWSET(BULK)", "import", table, file, sourceText(ctx)})
+}
+
+// ExitTsql_file is called when the parser reaches the end of the TSQL input, at which point its tokens are scanned
+// for BULK INSERT, which the grammar predates
+func (l *fileTransferListener) ExitTsql_file(ctx *parser.Tsql_fileContext) {
+	var tokens []antlr.Token
+	if stream, ok := ctx.GetParser().GetTokenStream().(*antlr.CommonTokenStream); ok {
+		for _, t := range stream.GetAllTokens() {
+			if t.GetChannel() == antlr.TokenDefaultChannel && t.GetTokenType() != antlr.TokenEOF {
+				tokens = append(tokens, t)
+			}
+		}
+	}
+	for i := 0; i+4 < len(tokens); i++ {
+		if !strings.EqualFold(tokens[i].GetText(), "BULK") || !strings.EqualFold(tokens[i+1].GetText(), "INSERT") {
+			continue
+		}
+		from := i + 2
+		for from < len(tokens) && !strings.EqualFold(tokens[from].GetText(), "FROM") {
+			from++
+		}
+		if from+1 >= len(tokens) {
+			return
+		}
+		table := l.names.dottedName(tokens, from-1)
+		file, ok := l.vars.literal(tokens[from+1].GetText())
+		if !ok {
+			file = tokens[from+1].GetText()
+		}
+		stmt := tokens[i].GetInputStream().GetText(tokens[i].GetStart(), tokens[from+1].GetStop())
+		l.emit([]string{strconv.Itoa(tokens[i].GetLine()), "BULK INSERT", "import", table, file, stmt})
+		i = from + 1
+	}
+}
+
+// commandArgs splits a command line into its arguments, keeping double-quoted arguments whole
+func commandArgs(cmd string) []string {
+	var (
+		args   []string
+		arg    []rune
+		quoted bool
+	)
+	for _, r := range cmd {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case (r == ' ' || r == '\t') && !quoted:
+			if len(arg) > 0 {
+				args = append(args, string(arg))
+				arg = arg[:0]
+			}
+		default:
+			arg = append(arg, r)
+		}
+	}
+	if len(arg) > 0 {
+		args = append(args, string(arg))
+	}
+	return args
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/antlr/antlr4/runtime/Go/antlr"
+	parser "github.com/nycmonkey/sprocs/tsql"
+)
+
+func init() {
+	RegisterExtractor(callExtractor{})
+}
+
+// callExtractor reports the procedures each sproc executes by name
+type callExtractor struct{}
+
+func (callExtractor) Name() string {
+	return "calls"
+}
+
+func (callExtractor) Columns() []string {
+	return []string{"Called Procedure", "Line", "Control Flow"}
+}
+
+func (callExtractor) NewListener(sproc string, names *nameScope, emit func([]string)) antlr.ParseTreeListener {
+	return &callListener{&parser.BasetsqlListener{}, names, emit}
+}
+
+type callListener struct {
+	*parser.BasetsqlListener
+	names *nameScope
+	emit  func([]string)
+}
+
+// EnterExecute_statement is called when the parser enters an `execute_statement` node
+func (l *callListener) EnterExecute_statement(ctx *parser.Execute_statementContext) {
+	if ctx.Func_proc_name() == nil {
+		// EXEC (@sql) runs dynamic SQL rather than a named procedure
+		return
+	}
+	flow := controlFlow(ctx)
+	if len(flow) == 0 {
+		flow = alwaysRuns
+	}
+	l.emit([]string{l.names.normalizeProcName(ctx.Func_proc_name().GetText()), strconv.Itoa(ctx.GetStart().GetLine()), flow})
+}
+
+// normalizeProcName strips brackets, and the database and schema when they are the sproc database and dbo, from a
+// procedure name, so procedures are named as sprocEntry names them
+func (s *nameScope) normalizeProcName(in string) string {
+	elems := strings.Split(strings.TrimSpace(in), ".")
+	for i, elem := range elems {
+		elems[i] = removeBrackets(elem)
+	}
+	if len(elems) == 3 && strings.EqualFold(elems[0], s.db()) {
+		elems = elems[1:]
+	}
+	if len(elems) == 2 && (strings.EqualFold(elems[0], "dbo") || len(elems[0]) == 0) {
+		elems = elems[1:]
+	}
+	return strings.Join(elems, ".")
+}
+
+// Summarize writes call_cycles.csv, the groups of sprocs that call each other in a cycle, and execution_order.csv,
+// the sprocs in the call graph ordered so each comes after every sproc it calls
+func (callExtractor) Summarize(rows [][]string) error {
+	g := newCallGraph(rows)
+	sccs := g.components()
+	var cycles [][]string
+	cycleOf := make(map[string]int)
+	for _, scc := range sccs {
+		if len(scc) > 1 || g.calls[scc[0]][scc[0]] {
+			cycles = append(cycles, scc)
+			for _, n := range scc {
+				cycleOf[n] = len(cycles)
+			}
+		}
+	}
+	out := make([][]string, 0, len(cycles))
+	for i, c := range cycles {
+		out = append(out, []string{strconv.Itoa(i + 1), strconv.Itoa(len(c)), strings.Join(c, ", ")})
+	}
+	if err := writeCSVFile("call_cycles.csv", []string{"Cycle", "Stored Procedures", "Members"}, out); err != nil {
+		return err
+	}
+	out = out[:0]
+	for i, n := range g.order(sccs) {
+		cycle := ""
+		if c, ok := cycleOf[n.name]; ok {
+			cycle = strconv.Itoa(c)
+		}
+		out = append(out, []string{strconv.Itoa(i + 1), n.name, strconv.Itoa(n.level), cycle})
+	}
+	return writeCSVFile("execution_order.csv", []string{"Order", "Stored Procedure", "Level", "Cycle"}, out)
+}
+
+// callGraph is the graph of sprocs calling other procedures, excluding system procedures
+type callGraph struct {
+	nodes []string
+	calls map[string]map[string]bool
+}
+
+func newCallGraph(rows [][]string) *callGraph {
+	g := &callGraph{calls: make(map[string]map[string]bool)}
+	names := make(map[string]string)
+	node := func(name string) string {
+		key := strings.ToUpper(name)
+		if n, ok := names[key]; ok {
+			return n
+		}
+		names[key] = name
+		g.nodes = append(g.nodes, name)
+		g.calls[name] = make(map[string]bool)
+		return name
+	}
+	for _, row := range rows {
+		node(row[0])
+	}
+	for _, row := range rows {
+		if p := strings.ToLower(row[1]); strings.HasPrefix(p, "sp_") || strings.HasPrefix(p, "xp_") {
+			continue
+		}
+		g.calls[node(row[0])][node(row[1])] = true
+	}
+	sort.Strings(g.nodes)
+	return g
+}
+
+// components returns the strongly connected components of the graph (Tarjan's algorithm), each sorted by name
+func (g *callGraph) components() [][]string {
+	var (
+		index   = make(map[string]int)
+		low     = make(map[string]int)
+		onStack = make(map[string]bool)
+		stack   []string
+		sccs    [][]string
+		visit   func(string)
+	)
+	visit = func(v string) {
+		index[v] = len(index)
+		low[v] = index[v]
+		stack = append(stack, v)
+		onStack[v] = true
+		for _, w := range sortedKeys(g.calls[v]) {
+			if _, seen := index[w]; !seen {
+				visit(w)
+				if low[w] < low[v] {
+					low[v] = low[w]
+				}
+			} else if onStack[w] && index[w] < low[v] {
+				low[v] = index[w]
+			}
+		}
+		if low[v] == index[v] {
+			var scc []string
+			for {
+				w := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				onStack[w] = false
+				scc = append(scc, w)
+				if w == v {
+					break
+				}
+			}
+			sort.Strings(scc)
+			sccs = append(sccs, scc)
+		}
+	}
+	for _, v := range g.nodes {
+		if _, seen := index[v]; !seen {
+			visit(v)
+		}
+	}
+	return sccs
+}
+
+type orderedSproc struct {
+	name  string
+	level int
+}
+
+// order lists the sprocs callees first. A sproc's level is one more than the highest level of the sprocs it calls;
+// the members of a cycle share a level. Sprocs on the same level are ordered by name.
+func (g *callGraph) order(sccs [][]string) []orderedSproc {
+	// Tarjan's algorithm emits components in reverse topological order, i.e. callees first
+	component := make(map[string]int)
+	for i, scc := range sccs {
+		for _, n := range scc {
+			component[n] = i
+		}
+	}
+	levels := make([]int, len(sccs))
+	for i, scc := range sccs {
+		for _, n := range scc {
+			for callee := range g.calls[n] {
+				if c := component[callee]; c != i && levels[c]+1 > levels[i] {
+					levels[i] = levels[c] + 1
+				}
+			}
+		}
+	}
+	var out []orderedSproc
+	for i, scc := range sccs {
+		for _, n := range scc {
+			out = append(out, orderedSproc{n, levels[i]})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].level != out[j].level {
+			return out[i].level < out[j].level
+		}
+		return out[i].name < out[j].name
+	})
+	return out
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/antlr/antlr4/runtime/Go/antlr"
+	parser "github.com/nycmonkey/sprocs/tsql"
+)
+
+// candidateExtractor reports string literals that look like portfolio codes but are not account master keys,
+// which usually means a stale or mistyped code
+type candidateExtractor struct {
+	pattern *regexp.Regexp
+}
+
+// candidatePatternFlag is a flag.Value that registers a candidateExtractor for the pattern it is given
+type candidatePatternFlag struct{}
+
+func (candidatePatternFlag) String() string {
+	return ""
+}
+
+func (candidatePatternFlag) Set(v string) error {
+	re, err := regexp.Compile(v)
+	if err != nil {
+		return err
+	}
+	RegisterExtractor(candidateExtractor{re})
+	return nil
+}
+
+func (candidateExtractor) Name() string {
+	return "candidate_codes"
+}
+
+func (candidateExtractor) Columns() []string {
+	return []string{"Literal", "First Line"}
+}
+
+func (e candidateExtractor) NewListener(sproc string, names *nameScope, emit func([]string)) antlr.ParseTreeListener {
+	return &candidateListener{&parser.BasetsqlListener{}, e.pattern, emit, make(map[string]bool)}
+}
+
+type candidateListener struct {
+	*parser.BasetsqlListener
+	pattern *regexp.Regexp
+	emit    func([]string)
+	seen    map[string]bool
+}
+
+// EnterConstant is called when the parser enters a `constant` node
+func (l *candidateListener) EnterConstant(ctx *parser.ConstantContext) {
+	lit, ok := unquoteString(strings.TrimSpace(ctx.GetText()))
+	if !ok || l.seen[lit] || !l.pattern.MatchString(lit) || portfolios.contains(lit) {
+		return
+	}
+	l.seen[lit] = true
+	l.emit([]string{lit, strconv.Itoa(ctx.GetStart().GetLine())})
+}
+
+// unquoteString returns the value of a T-SQL string literal such as 'abc' or N'abc', with each quote it escapes by
+// doubling undoubled, and false if text is not a string literal
+func unquoteString(text string) (string, bool) {
+	if strings.HasPrefix(text, "N") || strings.HasPrefix(text, "n") {
+		text = text[1:]
+	}
+	if len(text) < 2 || text[0] != '\'' || text[len(text)-1] != '\'' {
+		return "", false
+	}
+	inner := strings.Replace(text[1:len(text)-1], "''", "", -1)
+	if strings.Contains(inner, "'") {
+		// an expression such as 'a' + @b + 'c' rather than one literal
+		return "", false
+	}
+	return strings.Replace(text[1:len(text)-1], "''", "'", -1), true
+}
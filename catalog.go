@@ -0,0 +1,157 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/antlr/antlr4/runtime/Go/antlr"
+	parser "github.com/nycmonkey/sprocs/tsql"
+)
+
+const catalogQ = `
+SELECT name, type_desc, modify_date FROM sys.objects
+WHERE schema_id = SCHEMA_ID('dbo') AND type IN ('U', 'V', 'P', 'PC', 'X', 'FN', 'IF', 'TF', 'FS', 'FT', 'SN')
+`
+
+// catalogObject is an object of the sproc database's dbo schema
+type catalogObject struct {
+	name, kind, modified string
+	// run is the run whose catalog.csv recorded the object, when it comes from a previous run
+	run string
+}
+
+var (
+	// catalog holds the objects of the sproc database, keyed by upper-cased name, as of the current run.
+	// It is empty when the run does not read a database.
+	catalog map[string]catalogObject
+	// lastCatalog holds the objects recorded by the previous run of the same database
+	lastCatalog map[string]catalogObject
+)
+
+// loadCatalog fetches the objects of the sproc database, saves them to catalog.csv, and loads those of the
+// previous run for the last known details of objects since dropped
+func loadCatalog(db *sql.DB) error {
+	log.Println("Fetching catalog of database objects")
+	logSQL(catalogQ)
+	rows, err := db.Query(catalogQ)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	objects := make(map[string]catalogObject)
+	var out [][]string
+	for rows.Next() {
+		var (
+			o        catalogObject
+			modified time.Time
+		)
+		if err = rows.Scan(&o.name, &o.kind, &modified); err != nil {
+			return err
+		}
+		o.modified = formatTime(modified)
+		objects[strings.ToUpper(o.name)] = o
+		out = append(out, []string{o.name, o.kind, o.modified})
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	sortRows(out)
+	lastCatalog = previousCatalog()
+	catalog = objects
+	loadTemporalTables(db)
+	return writeCSVFile("catalog.csv", []string{"Object", "Type", "Modified"}, out)
+}
+
+// previousCatalog reads catalog.csv from the previous run of the same database, if it has one
+func previousCatalog() map[string]catalogObject {
+	objects := make(map[string]catalogObject)
+	prev := previousRun()
+	if len(prev) == 0 {
+		return objects
+	}
+	records, err := readCSVFile(filepath.Join(prev, "catalog.csv"))
+	if err != nil || len(records) == 0 {
+		return objects
+	}
+	for _, rec := range records[1:] {
+		if len(rec) >= 3 {
+			objects[strings.ToUpper(rec[0])] = catalogObject{rec[0], rec[1], rec[2], filepath.Base(prev)}
+		}
+	}
+	return objects
+}
+
+// missingExtractor reports the objects of the sproc database a sproc refers to that the catalog does not hold.
+// Missing tables are otherwise left out of table_sources.csv by the whitelist.
+type missingExtractor struct{}
+
+func init() {
+	RegisterExtractor(missingExtractor{})
+}
+
+func (missingExtractor) Name() string {
+	return "missing_objects"
+}
+
+func (missingExtractor) Columns() []string {
+	return []string{"Object", "Reference", "Last Known Type", "Last Known Modified", "Last Seen Run"}
+}
+
+func (missingExtractor) NewListener(sproc string, names *nameScope, emit func([]string)) antlr.ParseTreeListener {
+	l := &missingListener{emit: emit, seen: make(map[string]bool)}
+	l.TableListener = NewTableListener(sproc, names, func([]string) {})
+	l.TableListener.missed = l.report
+	return l
+}
+
+// missingListener finds the tables a sproc uses like a TableListener, along with the procedures it executes and
+// the user-defined functions it calls
+type missingListener struct {
+	*TableListener
+	emit func([]string)
+	seen map[string]bool
+}
+
+// report emits an object missing from the catalog, once, along with what was last known of it
+func (l *missingListener) report(name, reference string) {
+	key := strings.ToUpper(name)
+	if len(catalog) == 0 || l.seen[key+" "+reference] {
+		return
+	}
+	if _, ok := catalog[key]; ok {
+		return
+	}
+	l.seen[key+" "+reference] = true
+	last := lastCatalog[key]
+	l.emit([]string{name, reference, last.kind, last.modified, last.run})
+}
+
+// EnterExecute_statement is called when the parser enters an `execute_statement` node
+func (l *missingListener) EnterExecute_statement(ctx *parser.Execute_statementContext) {
+	if ctx.Func_proc_name() == nil {
+		return
+	}
+	name := l.names.normalizeProcName(ctx.Func_proc_name().GetText())
+	lower := strings.ToLower(name)
+	if strings.Contains(name, ".") || strings.HasPrefix(lower, "sp_") || strings.HasPrefix(lower, "xp_") {
+		// procedures of other databases, and system procedures, are not in the catalog
+		return
+	}
+	l.report(name, "EXEC")
+}
+
+// EnterScalar_function_name is called when the parser enters a `scalar_function_name` node. User-defined
+// functions must be called with their schema, which tells them apart from built-in functions.
+func (l *missingListener) EnterScalar_function_name(ctx *parser.Scalar_function_nameContext) {
+	if ctx.Func_proc_name() == nil {
+		return
+	}
+	elems := strings.Split(ctx.Func_proc_name().GetText(), ".")
+	if len(elems) != 2 || !strings.EqualFold(removeBrackets(elems[0]), "dbo") {
+		return
+	}
+	l.report(removeBrackets(elems[1]), "function call")
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// connOptions are the connection-level settings of the metadata queries, so a run can be kept from blocking, or
+// being blocked by, the work of a busy production server
+var connOptions = struct {
+	intent intentFlag
+	// connectTimeout and queryTimeout are in seconds; 0 keeps the driver's defaults of 15 and 30
+	connectTimeout, queryTimeout timeoutFlag
+	// lockTimeout is how long, in milliseconds, a query waits for a lock before failing; -1 waits indefinitely
+	lockTimeout int
+	isolation   isolationFlag
+}{intent: "ReadOnly", lockTimeout: -1}
+
+// intentFlag is a flag.Value for the ApplicationIntent of the connection: ReadOnly, which routes to a readable
+// secondary of an availability group when there is one, or ReadWrite
+type intentFlag string
+
+func (i *intentFlag) String() string {
+	return string(*i)
+}
+
+func (i *intentFlag) Set(v string) error {
+	switch strings.ToLower(v) {
+	case "readonly":
+		*i = "ReadOnly"
+	case "readwrite":
+		*i = "ReadWrite"
+	default:
+		return fmt.Errorf("unknown application intent %q (available: ReadOnly, ReadWrite)", v)
+	}
+	return nil
+}
+
+// timeoutFlag is a flag.Value for a timeout in seconds, up to the 65535 go-mssqldb reads its timeouts into
+type timeoutFlag uint
+
+func (t *timeoutFlag) String() string {
+	return strconv.FormatUint(uint64(*t), 10)
+}
+
+func (t *timeoutFlag) Set(v string) error {
+	n, err := strconv.ParseUint(v, 10, 16)
+	if err != nil {
+		return fmt.Errorf("invalid timeout %q (available: 0 to 65535 seconds)", v)
+	}
+	*t = timeoutFlag(n)
+	return nil
+}
+
+// isolationFlag is a flag.Value for the transaction isolation level of the session, empty for the server's
+// default
+type isolationFlag string
+
+// isolationLevels maps the values accepted by -isolation to their T-SQL
+var isolationLevels = map[string]string{
+	"read-uncommitted": "READ UNCOMMITTED",
+	"read-committed":   "READ COMMITTED",
+	"repeatable-read":  "REPEATABLE READ",
+	"snapshot":         "SNAPSHOT",
+	"serializable":     "SERIALIZABLE",
+}
+
+func (i *isolationFlag) String() string {
+	return string(*i)
+}
+
+func (i *isolationFlag) Set(v string) error {
+	if _, ok := isolationLevels[strings.ToLower(v)]; !ok {
+		return fmt.Errorf("unknown isolation level %q (available: read-uncommitted, read-committed, repeatable-read, snapshot, serializable)", v)
+	}
+	*i = isolationFlag(strings.ToLower(v))
+	return nil
+}
+
+// connParams returns the DSN parameters for the connection flags
+func connParams() string {
+	params := ";ApplicationIntent=" + string(connOptions.intent)
+	if connOptions.connectTimeout > 0 {
+		params += ";dial timeout=" + strconv.FormatUint(uint64(connOptions.connectTimeout), 10)
+	}
+	if connOptions.queryTimeout > 0 {
+		// the driver's connection timeout is a deadline on each read and write of the connection, so a query fails
+		// once the server has sent nothing for this long, however long the query has run in all
+		params += ";connection timeout=" + strconv.FormatUint(uint64(connOptions.queryTimeout), 10)
+	}
+	return params
+}
+
+// sessionOptions returns the statements applying the session-level connection flags, or an empty string when
+// none are set
+func sessionOptions() string {
+	var stmts []string
+	if connOptions.lockTimeout >= 0 {
+		stmts = append(stmts, "SET LOCK_TIMEOUT "+strconv.Itoa(connOptions.lockTimeout))
+	}
+	if len(connOptions.isolation) > 0 {
+		stmts = append(stmts, "SET TRANSACTION ISOLATION LEVEL "+isolationLevels[string(connOptions.isolation)])
+	}
+	return strings.Join(stmts, "; ")
+}
+
+// sessionConnector opens connections with a driver, applying the session-level connection flags to each. They last
+// as long as the session, so are applied again whenever the pool opens a connection, e.g. after losing one.
+type sessionConnector struct {
+	driver  driver.Driver
+	dsn     string
+	options string
+}
+
+func (c sessionConnector) Connect(context.Context) (driver.Conn, error) {
+	conn, err := c.driver.Open(c.dsn)
+	if err != nil {
+		return nil, err
+	}
+	logSQL(c.options)
+	stmt, err := conn.Prepare(c.options)
+	if err == nil {
+		_, err = stmt.Exec(nil)
+		stmt.Close()
+	}
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("couldn't set the session options: %v", err)
+	}
+	return conn, nil
+}
+
+func (c sessionConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+// openSessionDB opens a connection pool to the database of dsn, like openDB, whose connections all have the
+// session-level connection flags applied
+func openSessionDB(dsn string) (*sql.DB, error) {
+	db, err := openDB(dsn)
+	if err != nil {
+		return nil, err
+	}
+	options := sessionOptions()
+	if len(options) == 0 {
+		return db, nil
+	}
+	// sql.Open connects lazily, so closing the pool only gives up its handle on the driver
+	d := db.Driver()
+	db.Close()
+	return sql.OpenDB(sessionConnector{d, dsn, options}), nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/antlr/antlr4/runtime/Go/antlr"
+	parser "github.com/nycmonkey/sprocs/tsql"
+)
+
+// constValue evaluates an expression made only of literals, string concatenation with + and CAST or CONVERT,
+// such as 'GU' + 'G123' or CAST(123 AS varchar). It returns the value and whether it is a string, or false if
+// the expression isn't one it can evaluate.
+func constValue(tree antlr.Tree) (value string, str, ok bool) {
+	switch ctx := tree.(type) {
+	case *parser.Primitive_expressionContext:
+		if ctx.Constant() == nil {
+			return "", false, false
+		}
+		text := strings.TrimSpace(ctx.Constant().GetText())
+		if lit, ok := unquoteString(text); ok {
+			return lit, true, true
+		}
+		return text, false, true
+	case *parser.Bracket_expressionContext:
+		return constValue(ctx.Expression())
+	case *parser.Binary_operator_expressionContext:
+		if ctx.GetOp() == nil || ctx.GetOp().GetText() != "+" {
+			return "", false, false
+		}
+		a, aStr, aOK := constValue(ctx.Expression(0))
+		b, bStr, bOK := constValue(ctx.Expression(1))
+		// adding numbers, or a string to a number, is left alone
+		if !aOK || !bOK || !aStr || !bStr {
+			return "", false, false
+		}
+		return a + b, true, true
+	case *parser.Function_call_expressionContext:
+		if ctx.COLLATE() != nil {
+			return constValue(ctx.Expression())
+		}
+		return constValue(ctx.Function_call())
+	case *parser.Function_callContext:
+		if ctx.CAST() == nil && ctx.CONVERT() == nil || ctx.Data_type() == nil {
+			return "", false, false
+		}
+		// the value is the only expression of CAST, and the first of CONVERT, before its style
+		v, _, ok := constValue(ctx.Expression(0))
+		if !ok {
+			return "", false, false
+		}
+		dataType := strings.ToUpper(ctx.Data_type().GetText())
+		if strings.Contains(dataType, "CHAR") {
+			return v, true, true
+		}
+		if n, isInt := integerKey(v); isInt && strings.Contains(dataType, "INT") {
+			return n, false, true
+		}
+		return v, false, true
+	}
+	return "", false, false
+}
+
+// EnterBinary_operator_expression is called when the parser enters a `binary_operator_expression` node
+func (l *CodeListener) EnterBinary_operator_expression(ctx *parser.Binary_operator_expressionContext) {
+	l.lookupConst(ctx)
+}
+
+// EnterFunction_call is called when the parser enters a `function_call` node
+func (l *CodeListener) EnterFunction_call(ctx *parser.Function_callContext) {
+	if ctx.CAST() != nil || ctx.CONVERT() != nil {
+		l.lookupConst(ctx)
+	}
+}
+
+// lookupConst looks up the value of a constant expression built from literals
+func (l *CodeListener) lookupConst(tree antlr.Tree) {
+	if v, _, ok := constValue(tree); ok {
+		l.dict.lookup(v, l.info.Codes)
+		l.dict.lookupWildcard(v, l.info.Codes)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/antlr/antlr4/runtime/Go/antlr"
+	parser "github.com/nycmonkey/sprocs/tsql"
+)
+
+// alwaysRuns is the control flow of a statement outside any IF or ELSE branch, loop or TRY or CATCH block
+const alwaysRuns = "always"
+
+// controlFlow returns the branches, loops and TRY or CATCH blocks a node is nested in, outermost first, e.g.
+// "TRY > IF", or an empty string when it runs whenever the sproc does. The conditions of IF and WHILE are always
+// evaluated, so they count as outside the statement.
+func controlFlow(node antlr.Tree) string {
+	var flow []string
+	for child, parent := node, node.GetParent(); parent != nil; child, parent = parent, parent.GetParent() {
+		switch p := parent.(type) {
+		case *parser.If_statementContext:
+			switch child {
+			case p.Sql_clause(0):
+				flow = append(flow, "IF")
+			case p.Sql_clause(1):
+				flow = append(flow, "ELSE")
+			}
+		case *parser.While_statementContext:
+			if p.Sql_clause() != nil && child == p.Sql_clause() {
+				flow = append(flow, "WHILE")
+			}
+		case *parser.Try_catch_statementContext:
+			switch {
+			case p.GetTry_clauses() != nil && child == p.GetTry_clauses():
+				flow = append(flow, "TRY")
+			case p.GetCatch_clauses() != nil && child == p.GetCatch_clauses():
+				flow = append(flow, "CATCH")
+			}
+		}
+	}
+	for i, j := 0, len(flow)-1; i < j; i, j = i+1, j-1 {
+		flow[i], flow[j] = flow[j], flow[i]
+	}
+	return strings.Join(flow, " > ")
+}
+
+// controlFlows summarizes the control flows of the references to an object: always when any of them always runs,
+// or else each distinct flow
+func controlFlows(flows map[string]bool) string {
+	if flows[""] {
+		return alwaysRuns
+	}
+	return joinSet(flows)
+}
+
+// joinSet returns the elements of a set in order, separated by semicolons
+func joinSet(set map[string]bool) string {
+	list := make([]string, 0, len(set))
+	for e := range set {
+		list = append(list, e)
+	}
+	sort.Strings(list)
+	return strings.Join(list, "; ")
+}
+
+// referenceType names the kind of statement a table reference belongs to, as statementType does, along with the
+// clause naming the table when it says more: SELECT INTO, INSERT EXEC, a JOIN, the FROM of an INSERT, UPDATE or
+// DELETE rather than its target, or a subquery of a statement such as IF
+func referenceType(node antlr.Tree, write bool) string {
+	stmt := statementType(node)
+	if q, ok := node.GetParent().(*parser.Query_specificationContext); ok && q.INTO() != nil {
+		return "SELECT INTO"
+	}
+	if write {
+		if i, ok := node.GetParent().(*parser.Insert_statementContext); ok {
+			if _, exec := insertExecTarget(runNames, i); exec != nil {
+				return "INSERT EXEC"
+			}
+		}
+		return stmt
+	}
+	for n := node.GetParent(); n != nil; n = n.GetParent() {
+		switch n.(type) {
+		case *parser.Join_partContext:
+			return stmt + " JOIN"
+		case *parser.Table_sourcesContext:
+			switch stmt {
+			case "SELECT":
+				return stmt
+			case "INSERT", "UPDATE", "DELETE":
+				return stmt + " FROM"
+			}
+			// a subquery of another statement, such as IF EXISTS (SELECT ...)
+			return "SELECT in " + stmt
+		}
+	}
+	return stmt
+}
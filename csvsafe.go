@@ -0,0 +1,54 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	// plainNumber matches a signed number, which a spreadsheet reads as a number rather than a formula
+	plainNumber = regexp.MustCompile(`^[+-](\d+\.?\d*|\.\d+)([eE][+-]?\d+)?$`)
+	// plainVariable matches a T-SQL variable or parameter such as @AsOfDate or @@ROWCOUNT
+	plainVariable = regexp.MustCompile(`^@@?[\pL_#$][\pL\pN_#$@]*$`)
+)
+
+// isFormula reports whether a spreadsheet would evaluate a CSV cell as a formula: one starting with =, or with
+// +, - or @ other than a plain number or variable. A leading tab or carriage return is skipped over by some
+// spreadsheets, so counts as whatever follows it.
+func isFormula(cell string) bool {
+	cell = strings.TrimLeft(cell, "\t\r")
+	if len(cell) < 2 {
+		return len(cell) == 1 && cell[0] == '='
+	}
+	switch cell[0] {
+	case '=':
+		return true
+	case '+', '-':
+		return !plainNumber.MatchString(cell)
+	case '@':
+		return !plainVariable.MatchString(cell)
+	}
+	return false
+}
+
+// safeRow returns a copy of row in which every cell that a spreadsheet would evaluate as a formula is prefixed
+// with a quote, leaving negative numbers and @parameters as they are. Cells already starting with quotes before
+// such a formula gain one more, so unquoteCell can restore any value.
+func safeRow(row []string) []string {
+	out := make([]string, len(row))
+	for i, cell := range row {
+		out[i] = cell
+		if isFormula(strings.TrimLeft(cell, "'")) {
+			out[i] = "'" + cell
+		}
+	}
+	return out
+}
+
+// unquoteCell reverses safeRow for a cell read back from a report
+func unquoteCell(cell string) string {
+	if rest := strings.TrimLeft(cell, "'"); len(rest) < len(cell) && isFormula(rest) {
+		return cell[1:]
+	}
+	return cell
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+func init() {
+	commands["datahub"] = command{
+		summary: "push the sprocs, tables and lineage of a run to DataHub",
+		run:     pushDataHub,
+	}
+}
+
+var catalogClient = &http.Client{Timeout: 60 * time.Second}
+
+// qualifiedTableName expands a table as reported in table_sources.csv to database.schema.table
+func qualifiedTableName(table string) string {
+	if strings.Count(table, ".") >= 2 {
+		return table
+	}
+	return dbName + ".dbo." + table
+}
+
+// runHost returns the database host a run directory, named <date>T<time>_<host>@<database> or <date>_<host>, was
+// produced from
+func runHost(dir string) string {
+	parts := strings.SplitN(filepath.Base(filepath.Clean(dir)), "_", 2)
+	if len(parts) != 2 {
+		return dbHost
+	}
+	if i := strings.LastIndex(parts[1], "@"); i >= 0 {
+		return parts[1][:i]
+	}
+	return parts[1]
+}
+
+// lineage is the tables each sproc of a run reads and writes, from table_sources.csv
+type lineage struct {
+	sprocs        []string
+	reads, writes map[string][]string
+}
+
+func loadLineage(dir string) (*lineage, error) {
+	records, err := readCSVFile(filepath.Join(dir, "table_sources.csv"))
+	if err != nil {
+		return nil, err
+	}
+	l := &lineage{reads: make(map[string][]string), writes: make(map[string][]string)}
+	if len(records) == 0 {
+		return l, nil
+	}
+	seen := make(map[string]bool)
+	for _, rec := range records[1:] {
+		if len(rec) < 3 {
+			return nil, errors.New(dir + ": table_sources.csv has no Access column; rerun the analysis")
+		}
+		sproc, table := rec[0], qualifiedTableName(rec[1])
+		if !seen[sproc] {
+			seen[sproc] = true
+			l.sprocs = append(l.sprocs, sproc)
+		}
+		if strings.Contains(rec[2], "R") {
+			l.reads[sproc] = append(l.reads[sproc], table)
+		}
+		if strings.Contains(rec[2], "W") {
+			l.writes[sproc] = append(l.writes[sproc], table)
+		}
+	}
+	sort.Strings(l.sprocs)
+	return l, nil
+}
+
+// postJSON sends v to url, failing on any non-2xx response
+func postJSON(url string, header http.Header, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	for k, vs := range header {
+		req.Header[k] = vs
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := catalogClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("%s: unexpected response %s: %s", url, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// dataHubEmitter upserts aspects through the GMS ingestProposal endpoint
+type dataHubEmitter struct {
+	gms    string
+	header http.Header
+}
+
+func (d *dataHubEmitter) upsert(entityType, urn, aspectName string, aspect interface{}) error {
+	value, err := json.Marshal(aspect)
+	if err != nil {
+		return err
+	}
+	proposal := map[string]interface{}{
+		"proposal": map[string]interface{}{
+			"entityType": entityType,
+			"entityUrn":  urn,
+			"changeType": "UPSERT",
+			"aspectName": aspectName,
+			"aspect":     map[string]string{"value": string(value), "contentType": "application/json"},
+		},
+	}
+	return postJSON(strings.TrimSuffix(d.gms, "/")+"/aspects?action=ingestProposal", d.header, proposal)
+}
+
+func pushDataHub(args []string) error {
+	fs := flag.NewFlagSet("datahub", flag.ExitOnError)
+	gms := fs.String("gms", "http://localhost:8080", "DataHub GMS base URL")
+	token := fs.String("token", "", "DataHub access token, or a vault:// or azurekv:// reference to one (default $DATAHUB_TOKEN)")
+	env := fs.String("env", "PROD", "DataHub fabric of the datasets and jobs")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: sprocs datahub [flags] <runDir>\n\nFlags:")
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	lin, err := loadLineage(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if len(*token) == 0 {
+		*token = os.Getenv("DATAHUB_TOKEN")
+	}
+	if strings.Contains(*token, "://") {
+		if *token, err = resolveSecret(*token); err != nil {
+			return err
+		}
+	}
+	d := &dataHubEmitter{gms: *gms, header: http.Header{"X-Restli-Protocol-Version": {"2.0.0"}}}
+	if len(*token) > 0 {
+		d.header.Set("Authorization", "Bearer "+*token)
+	}
+	dataset := func(table string) string {
+		return fmt.Sprintf("urn:li:dataset:(urn:li:dataPlatform:mssql,%s,%s)", strings.ToLower(table), *env)
+	}
+	host := runHost(fs.Arg(0))
+	flow := fmt.Sprintf("urn:li:dataFlow:(mssql,%s,%s)", host+"."+dbName, *env)
+	if err = d.upsert("dataFlow", flow, "dataFlowInfo", map[string]interface{}{
+		"name": dbName + " stored procedures", "description": "Stored procedures of " + dbName + " on " + host,
+	}); err != nil {
+		return err
+	}
+	tables := make(map[string]bool)
+	for _, sproc := range lin.sprocs {
+		job := fmt.Sprintf("urn:li:dataJob:(%s,%s)", flow, sproc)
+		if err = d.upsert("dataJob", job, "dataJobInfo", map[string]interface{}{
+			"name": sproc, "type": map[string]string{"string": "SQL"},
+		}); err != nil {
+			return err
+		}
+		var inputs, outputs []string
+		for _, t := range lin.reads[sproc] {
+			inputs, tables[t] = append(inputs, dataset(t)), true
+		}
+		for _, t := range lin.writes[sproc] {
+			outputs, tables[t] = append(outputs, dataset(t)), true
+		}
+		if err = d.upsert("dataJob", job, "dataJobInputOutput", map[string]interface{}{
+			"inputDatasets": nonNil(inputs), "outputDatasets": nonNil(outputs),
+		}); err != nil {
+			return err
+		}
+	}
+	for t := range tables {
+		if err = d.upsert("dataset", dataset(t), "status", map[string]bool{"removed": false}); err != nil {
+			return err
+		}
+	}
+	log.Println("Pushed", len(lin.sprocs), "sprocs and", len(tables), "tables to", *gms)
+	return nil
+}
+
+// nonNil keeps empty lists from being encoded as null
+func nonNil(list []string) []string {
+	if list == nil {
+		return []string{}
+	}
+	return list
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// dbDriver is a database/sql driver connections can be made with, along with its connection string syntax
+type dbDriver interface {
+	// name is the name the driver is registered with
+	name() string
+	// dsn returns the connection string for database on host, with the -application-intent, timeouts and login
+	dsn(host, database, user, password string) string
+	// bind rewrites the ? placeholders of a query into the driver's own
+	bind(query string) string
+}
+
+// dbDrivers holds the drivers accepted by -driver
+var dbDrivers = map[string]dbDriver{
+	"mssql":     mssqlDriver{},
+	"sqlserver": sqlserverDriver{},
+	"odbc":      odbcDriver{},
+}
+
+// sqlDriver is the driver every connection is made with
+var sqlDriver dbDriver = mssqlDriver{}
+
+// driverFlag is a flag.Value selecting sqlDriver by name
+type driverFlag struct{}
+
+func (driverFlag) String() string {
+	return sqlDriver.name()
+}
+
+func (driverFlag) Set(v string) error {
+	d, ok := dbDrivers[strings.ToLower(v)]
+	if !ok {
+		names := make([]string, 0, len(dbDrivers))
+		for name := range dbDrivers {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("unknown driver %q (available: %s)", v, strings.Join(names, ", "))
+	}
+	registered := false
+	for _, name := range sql.Drivers() {
+		registered = registered || name == d.name()
+	}
+	if !registered {
+		return fmt.Errorf("driver %s is not built in; rebuild with -tags %s", d.name(), d.name())
+	}
+	sqlDriver = d
+	return nil
+}
+
+// openDB opens a connection pool to the database of dsn with sqlDriver
+func openDB(dsn string) (*sql.DB, error) {
+	logSQL("-- connect " + dsn)
+	return sql.Open(sqlDriver.name(), dsn)
+}
+
+// mssqlDriver is go-mssqldb under the name mssql, taking semicolon-separated key=value connection strings. They
+// are given in the driver's odbc: form, whose braced values may hold any character.
+type mssqlDriver struct{}
+
+func (mssqlDriver) name() string {
+	return "mssql"
+}
+
+func (mssqlDriver) dsn(host, database, user, password string) string {
+	dsn := "odbc:server=" + bracedValue(host) + ";database=" + bracedValue(database) + connParams()
+	if len(user) > 0 {
+		dsn += ";user id=" + bracedValue(user)
+	}
+	if len(password) > 0 {
+		dsn += ";password=" + bracedValue(password)
+	}
+	return dsn
+}
+
+// bracedValue quotes a value of an ODBC-style connection string in braces, doubling those closing it, so that a
+// semicolon or brace in a password can't end it early or add another key
+func bracedValue(value string) string {
+	return "{" + strings.Replace(value, "}", "}}", -1) + "}"
+}
+
+func (mssqlDriver) bind(query string) string {
+	return query
+}
+
+// sqlserverDriver is go-mssqldb under the name sqlserver, taking sqlserver:// URLs. It passes queries through
+// as they are, so parameters must be named @p1, @p2 and so on.
+type sqlserverDriver struct{}
+
+func (sqlserverDriver) name() string {
+	return "sqlserver"
+}
+
+func (sqlserverDriver) dsn(host, database, user, password string) string {
+	q := url.Values{"database": {database}}
+	for _, p := range strings.Split(connParams(), ";") {
+		if kv := strings.SplitN(p, "=", 2); len(kv) == 2 {
+			q.Set(kv[0], kv[1])
+		}
+	}
+	u := url.URL{Scheme: "sqlserver", Host: host, RawQuery: q.Encode()}
+	if i := strings.Index(host, `\`); i >= 0 {
+		// a named instance is the path of the URL
+		u.Host, u.Path = host[:i], "/"+host[i+1:]
+	}
+	if len(user) > 0 {
+		u.User = url.UserPassword(user, password)
+	}
+	return u.String()
+}
+
+func (sqlserverDriver) bind(query string) string {
+	return numberPlaceholders(query, "@p")
+}
+
+// numberPlaceholders rewrites the ? placeholders of a query outside its string literals and bracketed names as
+// prefix followed by their number
+func numberPlaceholders(query, prefix string) string {
+	var b strings.Builder
+	n := 0
+	// end is the character closing the string literal or bracketed name being read, if any
+	var end rune
+	for _, r := range query {
+		switch {
+		case end != 0:
+			if r == end {
+				end = 0
+			}
+		case r == '\'':
+			end = '\''
+		case r == '[':
+			end = ']'
+		case r == '?':
+			n++
+			b.WriteString(prefix + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// odbcDriver connects through an ODBC data source, named by -host, as configured on the machine by its DBAs. It
+// is built in with -tags odbc. Its connection strings have no keys for -connect-timeout and -query-timeout, which
+// connStringFor refuses with it.
+type odbcDriver struct{}
+
+func (odbcDriver) name() string {
+	return "odbc"
+}
+
+func (odbcDriver) dsn(host, database, user, password string) string {
+	dsn := "DSN=" + bracedValue(host) + ";Database=" + bracedValue(database) + ";ApplicationIntent=" + string(connOptions.intent)
+	if len(user) == 0 {
+		return dsn + ";Trusted_Connection=Yes"
+	}
+	dsn += ";UID=" + bracedValue(user)
+	if len(password) > 0 {
+		dsn += ";PWD=" + bracedValue(password)
+	}
+	return dsn
+}
+
+func (odbcDriver) bind(query string) string {
+	return query
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/antlr/antlr4/runtime/Go/antlr"
+	parser "github.com/nycmonkey/sprocs/tsql"
+)
+
+// dbmailParams are the parameters of msdb.dbo.sp_send_dbmail in order, for calls passing them by position
+var dbmailParams = []string{"@profile_name", "@recipients", "@copy_recipients", "@blind_copy_recipients",
+	"@subject", "@body", "@body_format", "@importance", "@sensitivity", "@file_attachments", "@query"}
+
+func init() {
+	RegisterExtractor(dbmailExtractor{})
+}
+
+// dbmailExtractor reports the recipients, subject and query of each sp_send_dbmail call, along with the tables the
+// query reads, so data leaving by database mail can be audited
+type dbmailExtractor struct{}
+
+func (dbmailExtractor) Name() string {
+	return "database_mail"
+}
+
+func (dbmailExtractor) Columns() []string {
+	return []string{"Line", "Recipients", "Copy Recipients", "Blind Copy Recipients", "Subject", "Query", "Query Tables"}
+}
+
+func (dbmailExtractor) NewListener(sproc string, names *nameScope, emit func([]string)) antlr.ParseTreeListener {
+	return &dbmailListener{&parser.BasetsqlListener{}, sproc, names, emit, make(localVars)}
+}
+
+type dbmailListener struct {
+	*parser.BasetsqlListener
+	sproc string
+	names *nameScope
+	emit  func([]string)
+	vars  localVars
+}
+
+// EnterDeclare_local is called when the parser enters a `declare_local` node
+func (l *dbmailListener) EnterDeclare_local(ctx *parser.Declare_localContext) {
+	l.vars.declare(ctx)
+}
+
+// EnterSet_statement is called when the parser enters a `set_statement` node
+func (l *dbmailListener) EnterSet_statement(ctx *parser.Set_statementContext) {
+	l.vars.set(ctx)
+}
+
+// EnterExecute_statement is called when the parser enters an `execute_statement` node
+func (l *dbmailListener) EnterExecute_statement(ctx *parser.Execute_statementContext) {
+	if ctx.Func_proc_name() == nil {
+		return
+	}
+	name := strings.Split(l.names.normalizeProcName(ctx.Func_proc_name().GetText()), ".")
+	if !strings.EqualFold(name[len(name)-1], "sp_send_dbmail") {
+		return
+	}
+	args := make(map[string]string)
+	for i, a := range ctx.AllExecute_statement_arg() {
+		arg := a.(*parser.Execute_statement_argContext)
+		param := ""
+		if p := arg.GetParameter(); p != nil {
+			param = strings.ToLower(p.GetText())
+		} else if i < len(dbmailParams) {
+			param = dbmailParams[i]
+		}
+		if arg.Constant_LOCAL_ID() != nil {
+			args[param] = l.value(arg.Constant_LOCAL_ID().GetText())
+		}
+	}
+	var tables []string
+	if query := args["@query"]; len(query) > 0 && !strings.HasPrefix(query, "@") {
+		tl := NewTableListener(l.sproc, l.names, func(f []string) {
+			tables = append(tables, f[0]+" ("+f[1]+")")
+		})
+		parseTSQL(l.sproc+" @query", query, tl)
+		sort.Strings(tables)
+	}
+	l.emit([]string{strconv.Itoa(ctx.GetStart().GetLine()), args["@recipients"], args["@copy_recipients"],
+		args["@blind_copy_recipients"], args["@subject"], args["@query"], strings.Join(tables, "; ")})
+}
+
+// value returns the text of a string literal argument, or the literal last assigned to a variable argument. Any
+// other argument is returned as written.
+func (l *dbmailListener) value(text string) string {
+	if lit, ok := l.vars.literal(text); ok {
+		return lit
+	}
+	return text
+}
+
+// localVars holds the expression last assigned to each variable of a sproc, as written, keyed by upper-cased name
+type localVars map[string]string
+
+func (v localVars) declare(ctx *parser.Declare_localContext) {
+	if ctx.Expression() != nil {
+		v[strings.ToUpper(ctx.LOCAL_ID().GetText())] = strings.TrimSpace(sourceText(ctx.Expression()))
+	}
+}
+
+func (v localVars) set(ctx *parser.Set_statementContext) {
+	if ctx.LOCAL_ID() == nil || ctx.Expression() == nil {
+		return
+	}
+	name := strings.ToUpper(ctx.LOCAL_ID().GetText())
+	if ctx.Assignment_operator() != nil {
+		// SET @v += x leaves @v unknown
+		delete(v, name)
+		return
+	}
+	v[name] = strings.TrimSpace(sourceText(ctx.Expression()))
+}
+
+// resolve returns the expression last assigned to text, when it names a variable, or else text itself
+func (v localVars) resolve(text string) string {
+	text = strings.TrimSpace(text)
+	if expr, ok := v[strings.ToUpper(text)]; ok {
+		return expr
+	}
+	return text
+}
+
+// literal returns the value of a string literal, or of the string literal last assigned to a variable
+func (v localVars) literal(text string) (string, bool) {
+	return unquoteString(v.resolve(text))
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"html"
+	"io"
+	"io/ioutil"
+	"os"
+	"unicode"
+
+	"github.com/antlr/antlr4/runtime/Go/antlr"
+)
+
+// astNode is the JSON rendering of a parse tree node: rule nodes carry a rule name and children, token nodes
+// carry the token type and text. Both carry the position they start at.
+type astNode struct {
+	Rule     string     `json:"rule,omitempty"`
+	Token    string     `json:"token,omitempty"`
+	Text     string     `json:"text,omitempty"`
+	Line     int        `json:"line,omitempty"`
+	Column   int        `json:"column,omitempty"`
+	Error    bool       `json:"error,omitempty"`
+	Children []*astNode `json:"children,omitempty"`
+}
+
+func init() {
+	commands["ast"] = command{
+		summary: "dump the full parse tree of a T-SQL file as JSON or an s-expression",
+		run:     dumpAST,
+	}
+	commands["tokens"] = command{
+		summary: "print the lexer token stream of a T-SQL file with types and positions",
+		run:     dumpTokens,
+	}
+	commands["tree"] = command{
+		summary: "render the parse tree of a sproc as a DOT graph or HTML page",
+		run:     renderTree,
+	}
+}
+
+func dumpAST(args []string) error {
+	fs := flag.NewFlagSet("ast", flag.ExitOnError)
+	format := fs.String("format", "json", "output format: json or sexpr")
+	addParseFlags(fs)
+	dir := fs.String("dir", "", "sproc_definitions directory of a previous run, to look up <sproc> by name")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: sprocs ast [flags] <file|sproc>\n\nFlags:")
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	def, err := readDefinition(fs.Arg(0), *dir)
+	if err != nil {
+		return err
+	}
+	tree, recog, errors := parseTSQLTree(fs.Arg(0), def)
+	for _, e := range errors {
+		fmt.Fprintln(os.Stderr, e)
+	}
+	if tree == nil {
+		return fmt.Errorf("couldn't parse %s", fs.Arg(0))
+	}
+	switch *format {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.SetEscapeHTML(false)
+		return enc.Encode(newASTNode(tree, recog))
+	case "sexpr":
+		_, err = fmt.Println(tree.ToStringTree(nil, recog))
+		return err
+	default:
+		return fmt.Errorf("unknown format %q", *format)
+	}
+}
+
+func renderTree(args []string) error {
+	fs := flag.NewFlagSet("tree", flag.ExitOnError)
+	format := fs.String("format", "dot", "output format: dot or html")
+	dir := fs.String("dir", "", "sproc_definitions directory of a previous run, to look up <sproc> by name")
+	addParseFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: sprocs tree [flags] <file|sproc>\n\nFlags:")
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	def, err := readDefinition(fs.Arg(0), *dir)
+	if err != nil {
+		return err
+	}
+	tree, recog, errors := parseTSQLTree(fs.Arg(0), def)
+	for _, e := range errors {
+		fmt.Fprintln(os.Stderr, e)
+	}
+	if tree == nil {
+		return fmt.Errorf("couldn't parse %s", fs.Arg(0))
+	}
+	root := newASTNode(tree, recog)
+	w := bufio.NewWriter(os.Stdout)
+	switch *format {
+	case "dot":
+		fmt.Fprintf(w, "digraph %q {\n\tnode [fontname=\"Courier\"];\n", fs.Arg(0))
+		var next int
+		writeDOT(w, root, &next)
+		fmt.Fprintln(w, "}")
+	case "html":
+		fmt.Fprintf(w, "<!DOCTYPE html>\n<html><head><meta charset=\"utf-8\"><title>%s</title>\n<style>%s</style></head><body>\n",
+			html.EscapeString(fs.Arg(0)), treeCSS)
+		fmt.Fprintf(w, "<h1>%s</h1>\n<ul>\n", html.EscapeString(fs.Arg(0)))
+		writeHTML(w, root)
+		fmt.Fprintln(w, "</ul>\n</body></html>")
+	default:
+		return fmt.Errorf("unknown format %q", *format)
+	}
+	return w.Flush()
+}
+
+func dumpTokens(args []string) error {
+	fs := flag.NewFlagSet("tokens", flag.ExitOnError)
+	dir := fs.String("dir", "", "sproc_definitions directory of a previous run, to look up <sproc> by name")
+	addParseFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: sprocs tokens [flags] <file|sproc>\n\nFlags:")
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	def, err := readDefinition(fs.Arg(0), *dir)
+	if err != nil {
+		return err
+	}
+	errCh := make(chan keyValue)
+	done := make(chan struct{})
+	var errors []string
+	go func() {
+		for e := range errCh {
+			errors = append(errors, e.value)
+		}
+		close(done)
+	}()
+	tokens, lexer := lexTSQL(fs.Arg(0), def, errCh)
+	close(errCh)
+	<-done
+	w := bufio.NewWriter(os.Stdout)
+	for _, t := range tokens {
+		channel := "default"
+		if t.GetChannel() != antlr.TokenDefaultChannel {
+			channel = "hidden"
+		}
+		var note string
+		for _, r := range t.GetText() {
+			if r > unicode.MaxASCII {
+				note = "\tnon-ASCII"
+				break
+			}
+		}
+		fmt.Fprintf(w, "%d:%d\t%s\t%s\t%q%s\n", t.GetLine(), t.GetColumn()+1, tokenName(lexer, t), channel, t.GetText(), note)
+	}
+	if err = w.Flush(); err != nil {
+		return err
+	}
+	for _, e := range errors {
+		fmt.Fprintln(os.Stderr, "lexer:", e)
+	}
+	if len(errors) > 0 {
+		return fmt.Errorf("%d lexer errors in %s", len(errors), fs.Arg(0))
+	}
+	return nil
+}
+
+// readDefinition returns the contents of the file named by arg or, failing that, the definition of the sproc named
+// arg saved in dir by a previous run
+func readDefinition(arg, dir string) (string, error) {
+	def, err := ioutil.ReadFile(arg)
+	if os.IsNotExist(err) && len(dir) > 0 {
+		def, err = ioutil.ReadFile(definitionPath(dir, arg))
+	}
+	return decodeSQLFile(def), err
+}
+
+const treeCSS = `body{font-family:monospace} ul{list-style:none;padding-left:1.2em} .rule{color:#1a4f8b;font-weight:bold}
+.token{color:#555} .token b{color:#000} .error{color:#c00} .pos{color:#999;font-size:smaller}`
+
+// writeDOT emits n and its descendants as Graphviz nodes and edges, numbering nodes from *next
+func writeDOT(w io.Writer, n *astNode, next *int) int {
+	id := *next
+	*next++
+	switch {
+	case len(n.Rule) > 0:
+		fmt.Fprintf(w, "\tn%d [label=%q];\n", id, n.Rule)
+	case n.Error:
+		fmt.Fprintf(w, "\tn%d [shape=box, color=red, label=%q];\n", id, n.Token+"\n"+n.Text)
+	default:
+		fmt.Fprintf(w, "\tn%d [shape=box, style=filled, fillcolor=lightgrey, label=%q];\n", id, n.Token+"\n"+n.Text)
+	}
+	for _, child := range n.Children {
+		fmt.Fprintf(w, "\tn%d -> n%d;\n", id, writeDOT(w, child, next))
+	}
+	return id
+}
+
+// writeHTML emits n and its descendants as nested, collapsible list items
+func writeHTML(w io.Writer, n *astNode) {
+	pos := fmt.Sprintf(`<span class="pos">%d:%d</span>`, n.Line, n.Column)
+	if len(n.Rule) == 0 {
+		class := "token"
+		if n.Error {
+			class = "error"
+		}
+		fmt.Fprintf(w, "<li class=%q>%s <b>%s</b> %s</li>\n", class, html.EscapeString(n.Token), html.EscapeString(n.Text), pos)
+		return
+	}
+	fmt.Fprintf(w, "<li><details open><summary><span class=\"rule\">%s</span> %s</summary><ul>\n", n.Rule, pos)
+	for _, child := range n.Children {
+		writeHTML(w, child)
+	}
+	fmt.Fprintln(w, "</ul></details></li>")
+}
+
+// newASTNode converts a parse tree into its JSON rendering
+func newASTNode(t antlr.Tree, recog antlr.Parser) *astNode {
+	switch n := t.(type) {
+	case antlr.TerminalNode:
+		tok := n.GetSymbol()
+		node := &astNode{
+			Token:  tokenName(recog, tok),
+			Text:   tok.GetText(),
+			Line:   tok.GetLine(),
+			Column: tok.GetColumn() + 1,
+		}
+		_, node.Error = n.(antlr.ErrorNode)
+		return node
+	case antlr.RuleNode:
+		node := &astNode{Rule: recog.GetRuleNames()[n.GetRuleContext().GetRuleIndex()]}
+		if ctx, ok := n.(antlr.ParserRuleContext); ok && ctx.GetStart() != nil {
+			node.Line, node.Column = ctx.GetStart().GetLine(), ctx.GetStart().GetColumn()+1
+		}
+		for _, child := range n.GetChildren() {
+			node.Children = append(node.Children, newASTNode(child, recog))
+		}
+		return node
+	}
+	return nil
+}
+
+// tokenName returns the symbolic name of a token's type as known to recog, e.g. SELECT or LOCAL_ID
+func tokenName(recog antlr.Recognizer, t antlr.Token) string {
+	if t.GetTokenType() == antlr.TokenEOF {
+		return "EOF"
+	}
+	names := recog.GetSymbolicNames()
+	if tt := t.GetTokenType(); tt > 0 && tt < len(names) && len(names[tt]) > 0 {
+		return names[tt]
+	}
+	if names = recog.GetLiteralNames(); t.GetTokenType() > 0 && t.GetTokenType() < len(names) {
+		return names[t.GetTokenType()]
+	}
+	return ""
+}
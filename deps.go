@@ -0,0 +1,116 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// recordTransitiveDependencies writes table_dependencies.csv, the tables each sproc uses either in its own
+// statements ("direct") or through the procedures it calls, however deeply nested ("inherited"), from the
+// calls.csv and table_sources.csv of the current run. An inherited table is reported with the shortest chain of
+// calls reaching it and the accesses of every callee using it.
+func recordTransitiveDependencies() error {
+	calls, err := readCSVFile(filepath.Join(outDir, "calls.csv"))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	sources, err := readCSVFile(filepath.Join(outDir, "table_sources.csv"))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if len(calls) == 0 || len(sources) == 0 {
+		return nil
+	}
+	// procedures are keyed upper-cased, as they are called case-insensitively
+	names := make(map[string]string)
+	callees := make(map[string][]string)
+	for _, rec := range calls[1:] {
+		if len(rec) < 2 {
+			continue
+		}
+		caller, callee := strings.ToUpper(rec[0]), strings.ToUpper(rec[1])
+		names[caller] = rec[0]
+		if _, ok := names[callee]; !ok {
+			names[callee] = rec[1]
+		}
+		callees[caller] = append(callees[caller], callee)
+	}
+	// tables maps each procedure to the access of each table it uses directly
+	tables := make(map[string]map[string]string)
+	for _, rec := range sources[1:] {
+		if len(rec) < 3 {
+			continue
+		}
+		proc := strings.ToUpper(rec[0])
+		names[proc] = rec[0]
+		if tables[proc] == nil {
+			tables[proc] = make(map[string]string)
+		}
+		tables[proc][rec[1]] = mergeAccess(tables[proc][rec[1]], rec[2])
+	}
+	procs := make(map[string]bool)
+	for p := range tables {
+		procs[p] = true
+	}
+	for p := range callees {
+		procs[p] = true
+	}
+	var rows [][]string
+	for proc := range procs {
+		for table, access := range tables[proc] {
+			rows = append(rows, []string{names[proc], table, access, "direct", ""})
+		}
+		inherited := make(map[string]string)
+		via := make(map[string]string)
+		// breadth first, so each table is reached by the shortest chain of calls
+		path := map[string]string{proc: names[proc]}
+		queue := []string{proc}
+		for len(queue) > 0 {
+			caller := queue[0]
+			queue = queue[1:]
+			for _, callee := range callees[caller] {
+				if _, seen := path[callee]; seen {
+					continue
+				}
+				path[callee] = path[caller] + " > " + names[callee]
+				queue = append(queue, callee)
+				for table, access := range tables[callee] {
+					if _, own := tables[proc][table]; own {
+						continue
+					}
+					if _, ok := via[table]; !ok {
+						via[table] = path[callee]
+					}
+					inherited[table] = mergeAccess(inherited[table], access)
+				}
+			}
+		}
+		for table, access := range inherited {
+			rows = append(rows, []string{names[proc], table, access, "inherited", via[table]})
+		}
+	}
+	sortRows(rows)
+	return writeCSVFile("table_dependencies.csv", []string{"Stored Procedure", "Table Used", "Access", "Dependency", "Via"}, rows)
+}
+
+// mergeAccess combines two accesses to a table, each R, W or RW
+func mergeAccess(a, b string) string {
+	r := strings.Contains(a, "R") || strings.Contains(b, "R")
+	w := strings.Contains(a, "W") || strings.Contains(b, "W")
+	switch {
+	case r && w:
+		return "RW"
+	case w:
+		return "W"
+	case r:
+		return "R"
+	}
+	return a + b
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/antlr/antlr4/runtime/Go/antlr"
+	parser "github.com/nycmonkey/sprocs/tsql"
+)
+
+// maxDiagnosticInput caps the length of the input quoted in a grammar diagnostic
+const maxDiagnosticInput = 200
+
+// grammarDiagnostics holds the ambiguity and context sensitivity reports of each parse when
+// -grammar-diagnostics is set, keyed by sproc
+var grammarDiagnostics = struct {
+	sync.Mutex
+	enabled bool
+	reports map[string][][]string
+}{reports: make(map[string][][]string)}
+
+// diagnosticsFlag is a flag.Value that turns on the parser's diagnostic reports and registers the
+// diagnosticsExtractor writing them to grammar_diagnostics.csv
+type diagnosticsFlag bool
+
+func (f *diagnosticsFlag) String() string {
+	return strconv.FormatBool(bool(*f))
+}
+
+func (f *diagnosticsFlag) IsBoolFlag() bool {
+	return true
+}
+
+func (f *diagnosticsFlag) Set(v string) error {
+	on, err := strconv.ParseBool(v)
+	if err != nil {
+		return err
+	}
+	if on && !bool(*f) {
+		grammarDiagnostics.enabled = true
+		RegisterExtractor(diagnosticsExtractor{})
+	}
+	*f = diagnosticsFlag(on)
+	return nil
+}
+
+// recordDiagnostic saves a report of the parser's adaptive prediction for a sproc. The same reports are turned
+// into syntax errors by antlr's DiagnosticErrorListener; they are kept apart here so parsing_errors.csv is unchanged.
+func recordDiagnostic(sproc, kind string, recognizer antlr.Parser, startIndex, stopIndex int, alts string) {
+	if !grammarDiagnostics.enabled {
+		return
+	}
+	rule := ""
+	if ctx := recognizer.GetParserRuleContext(); ctx != nil {
+		if i := ctx.GetRuleIndex(); i >= 0 && i < len(recognizer.GetRuleNames()) {
+			rule = recognizer.GetRuleNames()[i]
+		}
+	}
+	stream := recognizer.GetTokenStream()
+	start := stream.Get(startIndex)
+	input := start.GetText()
+	if stopIndex > startIndex {
+		// quote the source between the tokens rather than the tokens alone, which would lose their spacing
+		input = start.GetInputStream().GetText(start.GetStart(), stream.Get(stopIndex).GetStop())
+	}
+	input = strings.Join(strings.Fields(input), " ")
+	if len(input) > maxDiagnosticInput {
+		input = input[:maxDiagnosticInput] + "..."
+	}
+	grammarDiagnostics.Lock()
+	grammarDiagnostics.reports[sproc] = append(grammarDiagnostics.reports[sproc],
+		[]string{kind, rule, strconv.Itoa(start.GetLine()), strconv.Itoa(start.GetColumn() + 1), alts, input})
+	grammarDiagnostics.Unlock()
+}
+
+// ReportAmbiguity is called by the parser when input matches more than one alternative of a rule
+func (l *errorListener) ReportAmbiguity(recognizer antlr.Parser, dfa *antlr.DFA, startIndex, stopIndex int, exact bool, ambigAlts *antlr.BitSet, configs antlr.ATNConfigSet) {
+	kind := "ambiguity"
+	if exact {
+		kind = "exact ambiguity"
+	}
+	alts := ""
+	if ambigAlts != nil {
+		alts = ambigAlts.String()
+	}
+	recordDiagnostic(l.sprocName, kind, recognizer, startIndex, stopIndex, alts)
+}
+
+// ReportAttemptingFullContext is called by the parser when a decision needs full context to be made
+func (l *errorListener) ReportAttemptingFullContext(recognizer antlr.Parser, dfa *antlr.DFA, startIndex, stopIndex int, conflictingAlts *antlr.BitSet, configs antlr.ATNConfigSet) {
+	alts := ""
+	if conflictingAlts != nil {
+		alts = conflictingAlts.String()
+	}
+	recordDiagnostic(l.sprocName, "full context", recognizer, startIndex, stopIndex, alts)
+}
+
+// ReportContextSensitivity is called by the parser when full context prediction chose a different alternative
+// than local context alone would have
+func (l *errorListener) ReportContextSensitivity(recognizer antlr.Parser, dfa *antlr.DFA, startIndex, stopIndex, prediction int, configs antlr.ATNConfigSet) {
+	recordDiagnostic(l.sprocName, "context sensitivity", recognizer, startIndex, stopIndex, strconv.Itoa(prediction))
+}
+
+// diagnosticsExtractor reports the parser's diagnostics for each sproc, telling where the grammar makes it work
+// hardest
+type diagnosticsExtractor struct{}
+
+func (diagnosticsExtractor) Name() string {
+	return "grammar_diagnostics"
+}
+
+func (diagnosticsExtractor) Columns() []string {
+	return []string{"Kind", "Rule", "Line", "Column", "Alternatives", "Input"}
+}
+
+func (diagnosticsExtractor) NewListener(sproc string, names *nameScope, emit func([]string)) antlr.ParseTreeListener {
+	return &diagnosticsListener{&parser.BasetsqlListener{}, sproc, emit}
+}
+
+type diagnosticsListener struct {
+	*parser.BasetsqlListener
+	sproc string
+	emit  func([]string)
+}
+
+// ExitTsql_file is called when the parser reaches the end of the TSQL input, by which time every diagnostic
+// of the parse has been recorded
+func (l *diagnosticsListener) ExitTsql_file(ctx *parser.Tsql_fileContext) {
+	grammarDiagnostics.Lock()
+	reports := grammarDiagnostics.reports[l.sproc]
+	delete(grammarDiagnostics.reports, l.sproc)
+	grammarDiagnostics.Unlock()
+	for _, r := range reports {
+		l.emit(r)
+	}
+}
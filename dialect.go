@@ -0,0 +1,83 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/antlr/antlr4/runtime/Go/antlr"
+	parser "github.com/nycmonkey/sprocs/tsql"
+)
+
+const defaultDialect = `sqlserver2012`
+
+// grammar is a bundled build of the T-SQL grammar. Every build must generate the contexts and listener interface
+// of package tsql, so the listeners in this package work unchanged whichever dialect is selected.
+type grammar struct {
+	description string
+	newLexer    func(input antlr.CharStream) antlr.Lexer
+	// parse runs the grammar's start rule over stream, reporting syntax errors to errL
+	parse func(stream antlr.TokenStream, errL antlr.ErrorListener) (antlr.ParseTree, antlr.Parser)
+}
+
+// grammars holds the bundled grammar builds, keyed by the name accepted by -dialect
+var grammars = map[string]grammar{
+	defaultDialect: {
+		description: "grammars-v4 tsql.g4 snapshot covering SQL Server 2012 syntax",
+		newLexer: func(input antlr.CharStream) antlr.Lexer {
+			return parser.NewtsqlLexer(input)
+		},
+		parse: func(stream antlr.TokenStream, errL antlr.ErrorListener) (antlr.ParseTree, antlr.Parser) {
+			p := parser.NewtsqlParser(stream)
+			p.RemoveErrorListeners()
+			p.AddErrorListener(errL)
+			p.BuildParseTrees = true
+			switch {
+			case grammarDiagnostics.enabled:
+				// SLL prediction never needs full context, so has nothing to report
+				p.GetInterpreter().SetPredictionMode(antlr.PredictionModeLLExactAmbigDetection)
+			case faster:
+				p.GetInterpreter().SetPredictionMode(antlr.PredictionModeSLL)
+			}
+			return p.Tsql_file(), p
+		},
+	},
+}
+
+// dialectFlag is a flag.Value that only accepts the names of bundled grammars
+type dialectFlag string
+
+// dialect names the grammar build used to lex and parse definitions
+var dialect = dialectFlag(defaultDialect)
+
+func (d *dialectFlag) String() string {
+	return string(*d)
+}
+
+func (d *dialectFlag) Set(v string) error {
+	if _, ok := grammars[v]; !ok {
+		return fmt.Errorf("unknown dialect %q (available: %s)", v, strings.Join(dialectNames(), ", "))
+	}
+	*d = dialectFlag(v)
+	return nil
+}
+
+func (d *dialectFlag) grammar() grammar {
+	return grammars[string(*d)]
+}
+
+func dialectNames() []string {
+	names := make([]string, 0, len(grammars))
+	for name := range grammars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// addParseFlags registers the flags that control parsing on fs
+func addParseFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&faster, "fast", false, "use a faster but less error-tolerant parsing strategy")
+	fs.Var(&dialect, "dialect", "grammar build to parse with, one of: "+strings.Join(dialectNames(), ", "))
+}
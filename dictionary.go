@@ -0,0 +1,315 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// dictionary is a named set of keys matched against the identifiers and constants in each sproc. Every key
+// belongs to a column, e.g. the PortfolioShortName column of the account master, which is reported alongside it.
+type dictionary struct {
+	name    string
+	columns []string
+	keys    map[string]map[string]struct{}
+	// query, when set, is run against the sproc database to load the keys; each result column is a key column
+	query string
+	// numeric columns hold integer keys, matched by value whatever the format of the number in the sproc
+	numeric map[string]bool
+}
+
+// portfolios is the account master dictionary, loaded from the -portfolio-columns of -portfolio-view and reported
+// in codes.csv
+var portfolios = newDictionary("codes", portfolioShortName, guggenheimUnitShortName, relationshipShortName,
+	clientShortName, accountShortName, portfolioCode).numericColumns(portfolioCode)
+
+// portfolioView is the account master view, or table, portfolios is loaded from
+var portfolioView = "dbo.vw_AMPortfolioMaster"
+
+// portfolioQuery selects the columns of portfolios from portfolioView
+func portfolioQuery() string {
+	cols := make([]string, len(portfolios.columns))
+	for i, col := range portfolios.columns {
+		cols[i] = quoteName(col)
+	}
+	parts := strings.Split(portfolioView, ".")
+	for i, part := range parts {
+		parts[i] = quoteName(strings.Trim(part, "[]"))
+	}
+	return "\nSELECT " + strings.Join(cols, ", ") + "\n  FROM " + strings.Join(parts, ".") + "\n"
+}
+
+// portfolioColumnsFlag is a flag.Value replacing the columns of portfolios with a comma-separated list, each
+// column holding integer keys marked with :int
+type portfolioColumnsFlag struct{}
+
+func (portfolioColumnsFlag) String() string {
+	cols := make([]string, len(portfolios.columns))
+	for i, col := range portfolios.columns {
+		cols[i] = col
+		if portfolios.numeric[col] {
+			cols[i] += ":int"
+		}
+	}
+	return strings.Join(cols, ",")
+}
+
+func (portfolioColumnsFlag) Set(v string) error {
+	var cols, numeric []string
+	for _, col := range strings.Split(v, ",") {
+		col = strings.TrimSpace(col)
+		if strings.HasSuffix(strings.ToLower(col), ":int") {
+			col = strings.TrimSpace(col[:len(col)-len(":int")])
+			numeric = append(numeric, col)
+		}
+		if len(col) == 0 {
+			return errors.New("expected a comma-separated list of column names, each optionally followed by :int")
+		}
+		cols = append(cols, col)
+	}
+	// portfolios is replaced in place, as its extractor and reports identify it by pointer
+	*portfolios = *newDictionary(portfolios.name, cols...).numericColumns(numeric...)
+	return nil
+}
+
+// dictionaries holds the user-defined dictionaries registered with -dictionary
+var dictionaries []*dictionary
+
+func newDictionary(name string, columns ...string) *dictionary {
+	d := &dictionary{name: name, keys: make(map[string]map[string]struct{}), numeric: make(map[string]bool)}
+	for _, col := range columns {
+		d.addColumn(col)
+	}
+	return d
+}
+
+func (d *dictionary) addColumn(col string) {
+	if _, ok := d.keys[col]; ok {
+		return
+	}
+	d.columns = append(d.columns, col)
+	d.keys[col] = make(map[string]struct{})
+}
+
+// add records a key under col, ignoring blank keys
+func (d *dictionary) add(col, key string) {
+	if len(strings.TrimSpace(key)) == 0 {
+		return
+	}
+	d.addColumn(col)
+	d.keys[col][key] = struct{}{}
+}
+
+// reset removes every key, keeping the columns, so the dictionary can be loaded again
+func (d *dictionary) reset() {
+	for _, col := range d.columns {
+		d.keys[col] = make(map[string]struct{})
+	}
+}
+
+// size returns the number of keys across all columns
+func (d *dictionary) size() (n int) {
+	for _, keys := range d.keys {
+		n += len(keys)
+	}
+	return
+}
+
+// rows returns a column, key row for every key, ordered by column then key
+func (d *dictionary) rows() [][]string {
+	rows := make([][]string, 0, d.size())
+	for col, keys := range d.keys {
+		for k := range keys {
+			rows = append(rows, []string{col, k})
+		}
+	}
+	sortRows(rows)
+	return rows
+}
+
+// contains reports whether id is a key in any column
+func (d *dictionary) contains(id string) bool {
+	for _, keys := range d.keys {
+		if _, ok := keys[id]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// numericColumns marks columns as holding integer keys, which must be added formatted as by strconv.FormatInt
+func (d *dictionary) numericColumns(cols ...string) *dictionary {
+	for _, col := range cols {
+		d.numeric[col] = true
+	}
+	return d
+}
+
+// lookup records a `column:id` entry in found for every column in which id is a key. In a numeric column, id is
+// looked up by its integer value, so 00123, 123.0 and 1.23E2 all match the key 123, which is recorded instead.
+func (d *dictionary) lookup(id string, found map[string]struct{}) {
+	n, isInt := integerKey(id)
+	for _, col := range d.columns {
+		key := id
+		if d.numeric[col] && isInt {
+			key = n
+		}
+		if _, ok := d.keys[col][key]; ok {
+			found[col+":"+key] = struct{}{}
+		}
+	}
+}
+
+// integerKey returns the decimal form of the integer a numeric constant stands for, and false if it doesn't
+// stand for an integer
+func integerKey(text string) (string, bool) {
+	text = strings.TrimSpace(text)
+	if i, err := strconv.ParseInt(text, 10, 64); err == nil {
+		return strconv.FormatInt(i, 10), true
+	}
+	f, err := strconv.ParseFloat(text, 64)
+	// beyond 2^53 a float may not hold the integer written
+	if err != nil || f != math.Trunc(f) || math.Abs(f) > 1<<53 {
+		return "", false
+	}
+	return strconv.FormatInt(int64(f), 10), true
+}
+
+// lookupWildcard handles LIKE patterns with a leading or trailing %, recording a `column:pattern` entry in found
+// for every column with a key the pattern matches
+func (d *dictionary) lookupWildcard(id string, found map[string]struct{}) {
+	// handle suffix wildcards
+	if strings.HasSuffix(id, "%") {
+		id = strings.TrimSuffix(id, "%")
+		for _, col := range d.columns {
+			for k := range d.keys[col] {
+				if strings.HasPrefix(k, id) {
+					found[col+":"+id] = struct{}{}
+					break
+				}
+			}
+		}
+	}
+	// handle prefix wildcards
+	if strings.HasPrefix(id, "%") {
+		id = strings.TrimPrefix(id, "%")
+		for _, col := range d.columns {
+			for k := range d.keys[col] {
+				if strings.HasSuffix(k, id) {
+					found[col+":"+id] = struct{}{}
+					break
+				}
+			}
+		}
+	}
+}
+
+// loadQuery fills the dictionary from its query, one key column per result column. Keys of a numeric column are
+// recorded as integers, so 123.00 from a decimal column is the key 123.
+func (d *dictionary) loadQuery(db *sql.DB) error {
+	logSQL(d.query)
+	rows, err := db.Query(d.query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	cols, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+	vals := make([]sql.NullString, len(cols))
+	dest := make([]interface{}, len(cols))
+	for i := range vals {
+		dest[i] = &vals[i]
+	}
+	for rows.Next() {
+		if err = rows.Scan(dest...); err != nil {
+			return err
+		}
+		for i, v := range vals {
+			if !v.Valid {
+				continue
+			}
+			key := v.String
+			if n, ok := integerKey(key); ok && d.numeric[cols[i]] {
+				key = n
+			}
+			d.add(cols[i], key)
+		}
+	}
+	return rows.Err()
+}
+
+// loadFile fills the dictionary from a CSV file whose header row names the key columns
+func (d *dictionary) loadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	records, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		return err
+	}
+	if len(records) == 0 {
+		return errors.New(path + " is empty")
+	}
+	for _, rec := range records[1:] {
+		for i, v := range rec {
+			if i < len(records[0]) {
+				d.add(records[0][i], v)
+			}
+		}
+	}
+	return nil
+}
+
+// loadDictionaries runs the queries of the query-backed dictionaries
+func loadDictionaries(db *sql.DB) error {
+	for _, d := range dictionaries {
+		if len(d.query) == 0 {
+			continue
+		}
+		d.reset()
+		if err := d.loadQuery(db); err != nil {
+			return fmt.Errorf("loading dictionary %s: %v", d.name, err)
+		}
+		log.Println("Loaded dictionary", d.name, "with", d.size(), "keys")
+	}
+	return nil
+}
+
+// dictionaryFlag is a flag.Value registering a dictionary, and an extractor reporting it in <name>_codes.csv,
+// for each `name=query:<SQL>` or `name=file:<path.csv>` it is given
+type dictionaryFlag struct{}
+
+func (dictionaryFlag) String() string {
+	return ""
+}
+
+func (dictionaryFlag) Set(v string) error {
+	kv := strings.SplitN(v, "=", 2)
+	if len(kv) != 2 || len(strings.TrimSpace(kv[0])) == 0 {
+		return errors.New("expected name=query:<SQL> or name=file:<path.csv>")
+	}
+	d := newDictionary(strings.TrimSpace(kv[0]) + "_codes")
+	switch {
+	case strings.HasPrefix(kv[1], "query:"):
+		d.query = strings.TrimPrefix(kv[1], "query:")
+	case strings.HasPrefix(kv[1], "file:"):
+		if err := d.loadFile(strings.TrimPrefix(kv[1], "file:")); err != nil {
+			return err
+		}
+	default:
+		return errors.New("expected name=query:<SQL> or name=file:<path.csv>")
+	}
+	dictionaries = append(dictionaries, d)
+	RegisterExtractor(codeExtractor{d})
+	return nil
+}
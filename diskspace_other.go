@@ -0,0 +1,14 @@
+// +build !windows
+
+package main
+
+import "syscall"
+
+// freeSpace returns the number of bytes available to this process on the volume holding dir
+func freeSpace(dir string) (uint64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return 0, err
+	}
+	return uint64(st.Bavail) * uint64(st.Bsize), nil
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// schemaChange is a table or sproc that appeared or disappeared since the previous run
+type schemaChange struct {
+	Change string `json:"change"`
+	Object string `json:"object"`
+	// Owner is the team owning a sproc, when -owners is set
+	Owner string `json:"owner,omitempty"`
+}
+
+// lastDrift holds the schema changes found by the most recent run
+var lastDrift []schemaChange
+
+// previousRun returns the most recent run of the same database before the current one, or "" if there is none
+func previousRun() string {
+	dirs, err := runDirs(filepath.Dir(outDir), sourceName())
+	if err != nil {
+		return ""
+	}
+	for i := len(dirs) - 1; i >= 0; i-- {
+		parts := strings.SplitN(filepath.Base(dirs[i]), "_", 2)
+		if filepath.Clean(dirs[i]) != filepath.Clean(outDir) && strings.EqualFold(parts[1], runSourceName()) {
+			return dirs[i]
+		}
+	}
+	return ""
+}
+
+// recordSchemaDrift compares the tables and sprocs of the current run with those of the previous one, logging
+// each change and writing them to schema_drift.csv
+func recordSchemaDrift() error {
+	lastDrift = nil
+	prev := previousRun()
+	if len(prev) == 0 {
+		return nil
+	}
+	var changes []schemaChange
+	compare := func(kind string, before, after map[string]bool) {
+		owner := func(name string) string {
+			if kind != "sproc" {
+				return ""
+			}
+			team, _ := ownerOf(name)
+			return team
+		}
+		for name := range before {
+			if !after[name] {
+				changes = append(changes, schemaChange{kind + " dropped", name, owner(name)})
+			}
+		}
+		for name := range after {
+			if !before[name] {
+				changes = append(changes, schemaChange{kind + " added", name, owner(name)})
+			}
+		}
+	}
+	before, err := runTables(prev)
+	if err != nil {
+		return err
+	}
+	after, err := runTables(outDir)
+	if err != nil {
+		return err
+	}
+	// runs of projects, and those made before catalog.csv was written, have no tables to compare
+	if before != nil && after != nil {
+		compare("table", before, after)
+	}
+	if before, err = runSprocs(prev); err != nil {
+		return err
+	}
+	if after, err = runSprocs(outDir); err != nil {
+		return err
+	}
+	compare("sproc", before, after)
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].Change != changes[j].Change {
+			return changes[i].Change < changes[j].Change
+		}
+		return changes[i].Object < changes[j].Object
+	})
+	header := []string{"Change", "Object"}
+	if len(owners) > 0 {
+		header = append(header, "Owner")
+	}
+	rows := make([][]string, 0, len(changes))
+	for _, c := range changes {
+		log.Println("Schema drift since", filepath.Base(prev)+":", c.Change, c.Object)
+		row := []string{c.Change, c.Object}
+		if len(owners) > 0 {
+			row = append(row, c.Owner)
+		}
+		rows = append(rows, row)
+	}
+	lastDrift = changes
+	return writeCSVFile("schema_drift.csv", header, rows)
+}
+
+// runTables returns the tables and views in the catalog.csv of a run, or nil if it has none
+func runTables(dir string) (map[string]bool, error) {
+	records, err := readCSVFile(filepath.Join(dir, "catalog.csv"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	tables := make(map[string]bool)
+	if len(records) == 0 {
+		return tables, nil
+	}
+	for _, rec := range records[1:] {
+		if len(rec) >= 2 && (rec[1] == "USER_TABLE" || rec[1] == "VIEW") {
+			tables[rec[0]] = true
+		}
+	}
+	return tables, nil
+}
+
+// runSprocs returns the sprocs whose definitions a run saved, and those a run stopped by -max-duration found
+// but didn't fetch, so they aren't taken for dropped
+func runSprocs(dir string) (map[string]bool, error) {
+	records, err := readCSVFile(filepath.Join(dir, "definition_hashes.csv"))
+	if err != nil {
+		return nil, err
+	}
+	sprocs := make(map[string]bool)
+	for i, rec := range records {
+		if i > 0 {
+			sprocs[rec[0]] = true
+		}
+	}
+	manifest, err := readCSVFile(filepath.Join(dir, manifestFile))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, rec := range manifest {
+		if len(rec) >= 3 && rec[2] == statusNotFetched {
+			sprocs[rec[0]] = true
+		}
+	}
+	return sprocs, nil
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// envPrefix starts the name of the environment variable that sets each flag not given on the command line:
+// SPROCS_HOST for -host, SPROCS_SERVE_ADDR for `sprocs serve -addr`
+const envPrefix = "SPROCS_"
+
+// parseFlags parses a subcommand's arguments, then its environment variables
+func parseFlags(fs *flag.FlagSet, args []string) {
+	fs.Parse(args)
+	if err := flagsFromEnv(fs, envPrefix+envName(fs.Name())+"_"); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		fs.Usage()
+		os.Exit(2)
+	}
+}
+
+// flagsFromEnv sets each flag not given on the command line from the environment variable named by prefix
+// and the flag name, if it is set
+func flagsFromEnv(fs *flag.FlagSet, prefix string) error {
+	given := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if given[f.Name] || err != nil {
+			return
+		}
+		name := prefix + envName(f.Name)
+		if v, ok := os.LookupEnv(name); ok {
+			if e := fs.Set(f.Name, v); e != nil {
+				err = fmt.Errorf("invalid value %q for %s: %v", v, name, e)
+			}
+		}
+	})
+	return err
+}
+
+func envName(flagName string) string {
+	return strings.ToUpper(strings.Replace(flagName, "-", "_", -1))
+}
+
+// isTerminal reports whether f is a character device, as a console is and a pipe or file is not
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
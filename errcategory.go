@@ -0,0 +1,69 @@
+package main
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// categories of syntax errors in parsing_errors.csv
+const (
+	errGrammarGap = "unsupported syntax"
+	errEncoding   = "probable encoding issue"
+	errTruncated  = "truncated definition"
+	errTSQL       = "T-SQL error"
+)
+
+// classifyParseError guesses why the parser rejected some text from the message of the error it reported:
+//
+//   - a replacement or NUL character, or one the lexer couldn't recognize outside ASCII, suggests the definition was
+//     decoded with the wrong encoding
+//   - running into the end of the input suggests the definition was cut short, e.g. by a column too narrow for it
+//   - a character or punctuation the lexer or parser didn't expect in a file is likely a genuine mistake in it
+//   - anything else is taken to be syntax our grammar doesn't support
+//
+// Definitions read from a database compiled there, so apart from encoding and truncation their errors are the
+// grammar's.
+func classifyParseError(msg string, fromDatabase bool) string {
+	if strings.ContainsAny(msg, "�\x00") {
+		return errEncoding
+	}
+	offending := offendingText(msg)
+	if strings.Contains(msg, "token recognition error") {
+		for _, r := range offending {
+			if r >= utf8.RuneSelf {
+				return errEncoding
+			}
+		}
+	}
+	if strings.Contains(msg, "'<EOF>'") {
+		return errTruncated
+	}
+	if fromDatabase {
+		return errGrammarGap
+	}
+	if strings.Contains(msg, "token recognition error") {
+		return errTSQL
+	}
+	if (strings.Contains(msg, "missing ") || strings.Contains(msg, "extraneous input")) && len(offending) == 1 &&
+		strings.ContainsAny(offending, ",;()=.") {
+		return errTSQL
+	}
+	return errGrammarGap
+}
+
+// offendingText returns the input quoted by an antlr error message, e.g. FOO in "mismatched input 'FOO' expecting
+// ..." or "token recognition error at: 'FOO'"
+func offendingText(msg string) string {
+	i := strings.Index(msg, "'")
+	if i < 0 {
+		return ""
+	}
+	j := strings.LastIndex(msg, "'")
+	if k := strings.Index(msg[i+1:], "' "); k >= 0 {
+		j = i + 1 + k
+	}
+	if j <= i {
+		return ""
+	}
+	return msg[i+1 : j]
+}
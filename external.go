@@ -0,0 +1,63 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"strings"
+)
+
+const externalSprocQ = `
+SELECT SCHEMA_NAME(o.schema_id), o.name, o.type_desc, COALESCE(a.name, ep.dll_name),
+       COALESCE(am.assembly_class + '.' + am.assembly_method, '')
+FROM sys.objects o
+LEFT JOIN sys.assembly_modules am ON am.object_id = o.object_id
+LEFT JOIN sys.assemblies a ON a.assembly_id = am.assembly_id
+LEFT JOIN sys.extended_procedures ep ON ep.object_id = o.object_id
+WHERE o.type IN ('PC', 'X')
+`
+
+// skipExternalSprocs writes external_procedures.csv, the CLR and extended procedures of the sproc database with
+// the assembly or DLL implementing each, and returns the sprocs left to parse, which have T-SQL definitions
+func skipExternalSprocs(db *sql.DB, sprocNames []string) ([]string, error) {
+	if dialect == synapseDialect {
+		// a dedicated SQL pool has no CLR or extended procedures
+		return sprocNames, nil
+	}
+	logSQL(externalSprocQ)
+	rows, err := db.Query(externalSprocQ)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	external := make(map[string]bool)
+	var out [][]string
+	for rows.Next() {
+		var (
+			schema, name, kind string
+			module, entry      sql.NullString
+		)
+		if err = rows.Scan(&schema, &name, &kind, &module, &entry); err != nil {
+			return nil, err
+		}
+		sn := sprocEntry(schema, name, 0)
+		external[strings.ToUpper(sn)] = true
+		out = append(out, []string{sn, kind, module.String, entry.String})
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	sortRows(out)
+	if err = writeCSVFile("external_procedures.csv", []string{"Stored Procedure", "Type", "Assembly or DLL", "Entry Point"}, out); err != nil {
+		return nil, err
+	}
+	kept := sprocNames[:0]
+	for _, sn := range sprocNames {
+		if !external[strings.ToUpper(sn)] {
+			kept = append(kept, sn)
+		}
+	}
+	if skipped := len(sprocNames) - len(kept); skipped > 0 {
+		log.Println("Skipping", skipped, "CLR and extended stored procedures, listed in external_procedures.csv")
+	}
+	return kept, nil
+}
@@ -0,0 +1,410 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/antlr/antlr4/runtime/Go/antlr"
+	parser "github.com/nycmonkey/sprocs/tsql"
+)
+
+// Extractor pulls one kind of finding out of the parse tree of each sproc. Each extractor's findings are written
+// to a report named after it, one row per finding.
+type Extractor interface {
+	// Name identifies the extractor to -extract and names its report, <Name>.csv
+	Name() string
+	// Columns are the headings of the report columns following the stored procedure name
+	Columns() []string
+	// NewListener returns a listener for the parse tree of a single sproc, which resolves the names of tables and
+	// procedures against names and passes each finding to emit
+	NewListener(sproc string, names *nameScope, emit func(finding []string)) antlr.ParseTreeListener
+}
+
+// Summarizer is implemented by extractors that derive further reports from all of their findings once every sproc
+// has been analyzed. Each row passed to Summarize begins with the stored procedure name.
+type Summarizer interface {
+	Summarize(rows [][]string) error
+}
+
+// extractors holds the registered extractors in registration order
+var extractors []Extractor
+
+// extractorNames is the comma-separated list of extractors to run, as given to -extract
+var extractorNames string
+
+// RegisterExtractor makes an extractor available to -extract. It is intended to be called from init functions.
+func RegisterExtractor(e Extractor) {
+	for _, existing := range extractors {
+		if existing.Name() == e.Name() {
+			panic("extractor registered twice: " + e.Name())
+		}
+	}
+	extractors = append(extractors, e)
+}
+
+// selectExtractors returns the registered extractors named in the comma-separated list, or all of them when the
+// list is empty
+func selectExtractors(list string) ([]Extractor, error) {
+	if len(strings.TrimSpace(list)) == 0 {
+		return extractors, nil
+	}
+	var selected []Extractor
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		var found bool
+		for _, e := range extractors {
+			if e.Name() == name {
+				selected = append(selected, e)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown extractor %q", name)
+		}
+	}
+	return selected, nil
+}
+
+func extractorList() string {
+	names := make([]string, 0, len(extractors))
+	for _, e := range extractors {
+		names = append(names, e.Name())
+	}
+	return strings.Join(names, ", ")
+}
+
+func init() {
+	RegisterExtractor(tableExtractor{})
+	RegisterExtractor(codeExtractor{portfolios})
+}
+
+// tableExtractor reports the whitelisted tables each sproc reads from or writes to
+type tableExtractor struct{}
+
+func (tableExtractor) Name() string {
+	return "table_sources"
+}
+
+func (tableExtractor) Columns() []string {
+	return []string{"Table Used", "Access", "Control Flow", "Statement Type", "First Line", "Occurrences"}
+}
+
+func (tableExtractor) NewListener(sproc string, names *nameScope, emit func([]string)) antlr.ParseTreeListener {
+	return NewTableListener(sproc, names, emit)
+}
+
+// codeExtractor reports the keys of a dictionary each sproc refers to
+type codeExtractor struct {
+	dict *dictionary
+}
+
+func (e codeExtractor) Name() string {
+	return e.dict.name
+}
+
+func (e codeExtractor) Columns() []string {
+	if e.dict == portfolios {
+		return []string{"Account Master Column", "Account Master Value"}
+	}
+	return []string{"Dictionary Column", "Dictionary Value"}
+}
+
+func (e codeExtractor) NewListener(sproc string, names *nameScope, emit func([]string)) antlr.ParseTreeListener {
+	return NewCodeListener(sproc, e.dict, emit)
+}
+
+// TableListener handles events from a TSQL parser generated by Antlr to find the tables a sproc uses
+type TableListener struct {
+	*parser.BasetsqlListener
+	info  *SprocInfo
+	names *nameScope
+	emit  func([]string)
+	// uses holds the references to tables, in the order they appear; some may turn out to name an alias or a
+	// common table expression of their statement
+	uses []tableUse
+	// scopes holds, for each statement, the names it declares that are referenced like tables: the aliases of
+	// its tables and derived tables, and its common table expressions. They are upper-cased.
+	scopes map[int]map[string]bool
+	// targets maps, for each statement, the upper-cased aliases declared in its FROM clauses to the tables they
+	// stand for, as written
+	targets map[int]map[string]string
+	// missed, when set, is called with each table left out for not being in the whitelist
+	missed func(table, access string)
+	// qualified holds, for each table emitted, the schema-qualified names it is referenced by, as
+	// schemaTableName gives them. It is complete when the table is emitted.
+	qualified map[string]map[string]bool
+}
+
+// tableUse is a reference to a table in the statement starting at token scope
+type tableUse struct {
+	name string
+	// qualified is the schema-qualified name of the table, empty when the reference is an alias
+	qualified string
+	write     bool
+	scope     int
+	refs      *tableRefs
+}
+
+// tableRefs describes the references to a table in a sproc: their control flows and referenceTypes, the first
+// line referring to it and how many times it is referred to
+type tableRefs struct {
+	flows, kinds     map[string]bool
+	firstLine, count int
+}
+
+// add counts the references of r with those of t
+func (t *tableRefs) add(r *tableRefs) {
+	for f := range r.flows {
+		t.flows[f] = true
+	}
+	for k := range r.kinds {
+		t.kinds[k] = true
+	}
+	if t.count == 0 || r.firstLine < t.firstLine {
+		t.firstLine = r.firstLine
+	}
+	t.count += r.count
+}
+
+// NewTableListener returns an allocated TableListener
+func NewTableListener(sproc string, names *nameScope, emit func([]string)) *TableListener {
+	info := NewSprocInfo()
+	info.Name = sproc
+	return &TableListener{
+		&parser.BasetsqlListener{},
+		info,
+		names,
+		emit,
+		nil,
+		make(map[int]map[string]bool),
+		make(map[int]map[string]string),
+		nil,
+		make(map[string]map[string]bool),
+	}
+}
+
+// statementScope returns the index of the first token of the innermost statement holding node, which names the
+// scope of the aliases and common table expressions declared there, or -1 outside any statement
+func statementScope(node antlr.Tree) int {
+	for n := node; n != nil; n = n.GetParent() {
+		if _, ok := n.GetParent().(*parser.Sql_clauseContext); ok {
+			return n.(antlr.ParserRuleContext).GetStart().GetTokenIndex()
+		}
+	}
+	return -1
+}
+
+// note records a reference to a table
+func (l *TableListener) note(table string, node antlr.ParserRuleContext, write bool) {
+	var qualified string
+	if text := strings.TrimSpace(node.GetText()); strings.EqualFold(l.names.normalizeTableName(text), table) {
+		qualified = l.names.schemaTableName(text)
+	}
+	l.uses = append(l.uses, tableUse{table, qualified, write, statementScope(node), &tableRefs{
+		flows:     map[string]bool{controlFlow(node): true},
+		kinds:     map[string]bool{referenceType(node, write): true},
+		firstLine: node.GetStart().GetLine(),
+		count:     1,
+	}})
+}
+
+// declare records a name referenced like a table in the statement holding node
+func (l *TableListener) declare(name string, node antlr.Tree) {
+	scope := statementScope(node)
+	if l.scopes[scope] == nil {
+		l.scopes[scope] = make(map[string]bool)
+	}
+	l.scopes[scope][strings.ToUpper(name)] = true
+}
+
+// EnterTable_name is called when the parser enters a `table_name` node,
+// which includes the name of the table from whcih data is sourced, or the target of a SELECT ... INTO
+func (l *TableListener) EnterTable_name(ctx *parser.Table_nameContext) {
+	n := l.names.normalizeTableName(strings.TrimSpace(ctx.GetText()))
+	if len(n) == 0 {
+		return
+	}
+	switch p := ctx.GetParent().(type) {
+	case *parser.Full_column_nameContext, *parser.Select_list_elemContext, *parser.Output_column_nameContext:
+		// the qualifier of a column, naming a table or alias of the FROM clause rather than referencing a table
+		return
+	case *parser.Query_specificationContext:
+		l.note(n, ctx, p.INTO() != nil)
+		return
+	}
+	l.note(n, ctx, false)
+}
+
+// EnterDdl_object is called when the parser enters a `ddl_object` node,
+// which names the table modified by an INSERT, UPDATE or DELETE
+func (l *TableListener) EnterDdl_object(ctx *parser.Ddl_objectContext) {
+	switch ctx.GetParent().(type) {
+	case *parser.Insert_statementContext, *parser.Update_statementContext, *parser.Delete_statement_fromContext:
+		n := l.names.normalizeTableName(strings.TrimSpace(ctx.GetText()))
+		if len(n) > 0 && !strings.HasPrefix(n, "@") {
+			l.note(n, ctx, true)
+		}
+	}
+}
+
+// EnterTable_source_item is called when the parser enters a `table_source_item` node,
+// where an alias may be declared for a table
+func (l *TableListener) EnterTable_source_item(ctx *parser.Table_source_itemContext) {
+	if ctx.Table_name_with_hint() == nil || ctx.As_table_alias() == nil {
+		return
+	}
+	t := ctx.Table_name_with_hint().(*parser.Table_name_with_hintContext).Table_name()
+	a := ctx.As_table_alias().(*parser.As_table_aliasContext).Table_alias()
+	if t == nil || a == nil {
+		return
+	}
+	table := strings.TrimSpace(t.GetText())
+	alias := l.names.normalizeTableName(strings.TrimSpace(a.(*parser.Table_aliasContext).Id().GetText()))
+	if len(l.names.normalizeTableName(table)) > 0 && len(alias) > 0 {
+		scope := statementScope(ctx)
+		if l.targets[scope] == nil {
+			l.targets[scope] = make(map[string]string)
+		}
+		l.targets[scope][strings.ToUpper(alias)] = table
+	}
+}
+
+// EnterTable_alias is called when the parser enters a `table_alias` node, the alias of a table or derived table,
+// which is ignored as a table reference in its own statement unless it is the target of a DELETE
+func (l *TableListener) EnterTable_alias(ctx *parser.Table_aliasContext) {
+	n := l.names.normalizeTableName(strings.TrimSpace(ctx.GetText()))
+	if len(n) == 0 {
+		return
+	}
+	if _, ok := ctx.GetParent().(*parser.Delete_statement_fromContext); ok {
+		l.note(n, ctx, true)
+		return
+	}
+	l.declare(n, ctx)
+}
+
+// EnterCommon_table_expression is called when the parser enters a `common_table_expression` node, whose name is
+// referenced like a table in its statement
+func (l *TableListener) EnterCommon_table_expression(ctx *parser.Common_table_expressionContext) {
+	if id := ctx.GetExpression_name(); id != nil {
+		l.declare(removeBrackets(id.GetText()), ctx)
+	}
+}
+
+// ExitTsql_file is called when the parser reaches the end of the TSQL input,
+// at which point the table names used are analyzed and emitted along with whether each is read (R), written (W)
+// or both (RW), the control flows and types of statement it is used in, its first line and number of references
+func (l *TableListener) ExitTsql_file(ctx *parser.Tsql_fileContext) {
+	var order []string
+	access := make(map[string]string)
+	tables := make(map[string]string)
+	refs := make(map[string]*tableRefs)
+	for _, u := range l.uses {
+		table, qualified := u.name, u.qualified
+		if t, ok := l.targets[u.scope][strings.ToUpper(table)]; ok && (u.write || strings.EqualFold(l.names.normalizeTableName(t), table)) {
+			// resolve UPDATE p ... FROM dbo.Positions p to the aliased table, and keep a table aliased as its own
+			// name, as in FROM dbo.Positions positions
+			table, qualified = l.names.normalizeTableName(t), l.names.schemaTableName(t)
+		} else if l.scopes[u.scope][strings.ToUpper(table)] {
+			// skip it - it's an alias or common table expression of its statement
+			continue
+		}
+		if strings.HasPrefix(table, "#") {
+			continue
+		}
+		key := strings.ToUpper(table)
+		if _, ok := tables[key]; !ok {
+			tables[key] = table
+			order = append(order, key)
+			refs[key] = &tableRefs{flows: make(map[string]bool), kinds: make(map[string]bool)}
+		}
+		mode := "R"
+		if u.write {
+			mode = "W"
+		}
+		access[key] = mergeAccess(access[key], mode)
+		refs[key].add(u.refs)
+		if len(qualified) > 0 {
+			if l.qualified[key] == nil {
+				l.qualified[key] = make(map[string]bool)
+			}
+			l.qualified[key][qualified] = true
+		}
+	}
+	for _, key := range order {
+		table := tables[key]
+		if !strings.Contains(table, ".") && len(l.names.tables()) > 0 {
+			// check to see if the table is in the whitelist populated during getSprocs()
+			// tables qualified with a DB other than the sproc database need no check, and without a database
+			// connection (e.g. under `sprocs serve`) there is no whitelist to check against
+			if _, ok := l.names.tables()[key]; !ok {
+				// skip it -- it's not in the whitelist
+				if l.missed != nil {
+					l.missed(table, access[key])
+				}
+				continue
+			}
+		}
+		r := refs[key]
+		l.emit([]string{table, access[key], controlFlows(r.flows), joinSet(r.kinds), strconv.Itoa(r.firstLine), strconv.Itoa(r.count)})
+	}
+}
+
+// CodeListener handles events from a TSQL parser generated by Antlr to find the dictionary keys a sproc uses
+type CodeListener struct {
+	*parser.BasetsqlListener
+	info *SprocInfo
+	dict *dictionary
+	emit func([]string)
+}
+
+// NewCodeListener returns an allocated CodeListener
+func NewCodeListener(sproc string, dict *dictionary, emit func([]string)) *CodeListener {
+	info := NewSprocInfo()
+	info.Name = sproc
+	return &CodeListener{
+		&parser.BasetsqlListener{},
+		info,
+		dict,
+		emit,
+	}
+}
+
+// EnterSimple_id is called when the parser enters a `simple_id` node
+func (l *CodeListener) EnterSimple_id(ctx *parser.Simple_idContext) {
+	l.dict.lookup(strings.TrimSpace(ctx.GetText()), l.info.Codes)
+}
+
+// EnterId is called when the parser enters an `id` node. Bracketed and quoted identifiers, which may hold spaces
+// and other characters a plain identifier can't, are looked up without their delimiters.
+func (l *CodeListener) EnterId(ctx *parser.IdContext) {
+	switch {
+	case ctx.SQUARE_BRACKET_ID() != nil:
+		id := ctx.GetText()
+		l.dict.lookup(strings.Replace(id[1:len(id)-1], "]]", "]", -1), l.info.Codes)
+	case ctx.DOUBLE_QUOTE_ID() != nil:
+		id := ctx.GetText()
+		l.dict.lookup(strings.Replace(id[1:len(id)-1], `""`, `"`, -1), l.info.Codes)
+	}
+}
+
+// EnterConstant is called when the parser enters a `constant` node. String literals, N'abc' Unicode ones included,
+// are looked up by their value.
+func (l *CodeListener) EnterConstant(ctx *parser.ConstantContext) {
+	id := strings.TrimSpace(ctx.GetText())
+	if lit, ok := unquoteString(id); ok {
+		id = lit
+	}
+	l.dict.lookup(id, l.info.Codes)
+	l.dict.lookupWildcard(id, l.info.Codes)
+}
+
+// ExitTsql_file is called when the parser reaches the end of the TSQL input,
+// at which point the dictionary keys used are emitted
+func (l *CodeListener) ExitTsql_file(ctx *parser.Tsql_fileContext) {
+	for code := range l.info.Codes {
+		l.emit(strings.SplitN(code, ":", 2))
+	}
+}
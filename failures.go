@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+)
+
+// runFailures collects what went wrong during the current run without stopping it: reports or definitions that
+// couldn't be written, definitions that couldn't be read back, and sprocs whose analysis panicked. A run carries
+// on past each of them, so one bad file doesn't throw away the work of every other worker.
+var runFailures struct {
+	sync.Mutex
+	rows [][]string
+}
+
+// recordFailure logs a failure of the current run and keeps it for its summary. What is the sproc or file
+// affected, and step the work that failed, e.g. "writing report".
+func recordFailure(what, step string, err interface{}) {
+	log.Println("Failed", step, what+":", err)
+	runFailures.Lock()
+	runFailures.rows = append(runFailures.rows, []string{what, step, fmt.Sprint(err)})
+	runFailures.Unlock()
+}
+
+// resetFailures forgets the failures of the previous run
+func resetFailures() {
+	runFailures.Lock()
+	runFailures.rows = nil
+	runFailures.Unlock()
+}
+
+// summarizeFailures logs how many failures the run had and writes them to run_failures.csv, returning their number
+func summarizeFailures() int {
+	runFailures.Lock()
+	rows := runFailures.rows
+	runFailures.Unlock()
+	if len(rows) == 0 {
+		return 0
+	}
+	sortRows(rows)
+	log.Println(len(rows), "failures during the run, see run_failures.csv")
+	if err := writeCSVFile("run_failures.csv", []string{"Affected", "Step", "Error"}, rows); err != nil {
+		log.Println("error writing run_failures.csv:", err)
+	}
+	return len(rows)
+}
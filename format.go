@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/antlr/antlr4/runtime/Go/antlr"
+	parser "github.com/nycmonkey/sprocs/tsql"
+)
+
+const fmtIndent = "    "
+
+// clauseKeywords start a new line at the current indentation level
+var clauseKeywords = map[string]bool{
+	"ALTER": true, "BEGIN": true, "CLOSE": true, "CREATE": true, "CROSS": true, "DEALLOCATE": true, "DECLARE": true,
+	"DELETE": true, "ELSE": true, "END": true, "EXCEPT": true, "EXECUTE": true, "FETCH": true, "FROM": true,
+	"FULL": true, "GO": true, "GROUP": true, "HAVING": true, "IF": true, "INNER": true, "INSERT": true,
+	"INTERSECT": true, "JOIN": true, "LEFT": true, "MERGE": true, "OPEN": true, "OPTION": true, "ORDER": true,
+	"OUTER": true, "PRINT": true, "RAISERROR": true, "RETURN": true, "RIGHT": true, "SELECT": true, "SET": true,
+	"THROW": true, "UNION": true, "UPDATE": true, "USE": true, "VALUES": true, "WHERE": true, "WHILE": true,
+}
+
+// transactionKeywords follow BEGIN in statements that do not open a BEGIN/END block
+var transactionKeywords = map[string]bool{
+	"DISTRIBUTED": true, "TRAN": true, "TRANSACTION": true,
+}
+
+// joinModifiers are keywords that may precede JOIN (or OUTER, APPLY) without a line break between them
+var joinModifiers = map[string]bool{
+	"CROSS": true, "FULL": true, "INNER": true, "LEFT": true, "OUTER": true, "RIGHT": true,
+}
+
+// functionKeywords are keywords that take an argument list directly, e.g. CAST(x AS int)
+var functionKeywords = map[string]bool{
+	"AVG": true, "BINARY_CHECKSUM": true, "CAST": true, "CHECKSUM": true, "COALESCE": true, "CONVERT": true,
+	"COUNT": true, "COUNT_BIG": true, "DATEADD": true, "DATEDIFF": true, "DATENAME": true, "DATEPART": true,
+	"DENSE_RANK": true, "GROUPING": true, "IDENTITY": true, "MAX": true, "MIN": true, "NTILE": true,
+	"NULLIF": true, "OPENQUERY": true, "OPENROWSET": true, "RANK": true, "ROW_NUMBER": true, "STDEV": true,
+	"SUM": true, "VAR": true,
+}
+
+// nonKeywordTokens are the symbolic token types whose text must be emitted verbatim
+var nonKeywordTokens = map[string]bool{
+	"ID": true, "SQUARE_BRACKET_ID": true, "DOUBLE_QUOTE_ID": true, "LOCAL_ID": true, "STRING": true,
+	"DECIMAL": true, "FLOAT": true, "REAL": true, "BINARY": true, "COMMENT": true, "LINE_COMMENT": true,
+}
+
+func init() {
+	commands["fmt"] = command{
+		summary: "re-emit T-SQL files in a canonical style",
+		run:     fmtFiles,
+	}
+}
+
+func fmtFiles(args []string) error {
+	fs := flag.NewFlagSet("fmt", flag.ExitOnError)
+	write := fs.Bool("w", false, "write the result back to each file instead of stdout")
+	addParseFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: sprocs fmt [flags] <files...>\n\nFiles that don't parse are reported and left as they are.\n\nFlags:")
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	var failed int
+	for _, path := range fs.Args() {
+		def, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		out, errs := formatTSQL(path, string(def))
+		if len(errs) > 0 {
+			for _, e := range errs {
+				fmt.Fprintln(os.Stderr, path+":", e)
+			}
+			failed++
+			continue
+		}
+		if !*write {
+			fmt.Print(out)
+			continue
+		}
+		if err = ioutil.WriteFile(path, []byte(out), 0644); err != nil {
+			return err
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d files not formatted: syntax errors", failed, fs.NArg())
+	}
+	return nil
+}
+
+// lexTSQL returns every token in text, including comments on the hidden channel, along with the lexer that produced
+// them. Lexing errors are sent down errCh when it is non-nil.
+func lexTSQL(name, text string, errCh chan<- keyValue) ([]antlr.Token, antlr.Lexer) {
+	lexer := dialect.grammar().newLexer(antlr.NewInputStream(text))
+	lexer.RemoveErrorListeners()
+	if errCh != nil {
+		lexer.AddErrorListener(newErrorListener(errCh, name, false))
+	}
+	stream := antlr.NewCommonTokenStream(lexer, antlr.TokenDefaultChannel)
+	stream.Fill()
+	return stream.GetAllTokens(), lexer
+}
+
+// isKeyword reports whether a token is a reserved or contextual keyword rather than an identifier, literal or operator
+func isKeyword(typ, text string) bool {
+	if len(typ) == 0 || nonKeywordTokens[typ] {
+		return false
+	}
+	for _, r := range text {
+		if !(r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z')) {
+			return false
+		}
+	}
+	return true
+}
+
+// identifierListener collects the start offsets of the keyword tokens the parser took for identifiers, as in
+// a.name or a column named type, whose case formatTSQL leaves alone
+type identifierListener struct {
+	*parser.BasetsqlListener
+	starts map[int]bool
+}
+
+func (l *identifierListener) VisitTerminal(node antlr.TerminalNode) {
+	switch node.GetParent().(type) {
+	case *parser.IdContext, *parser.Simple_idContext:
+		l.starts[node.GetSymbol().GetStart()] = true
+	}
+}
+
+// formatTSQL parses a T-SQL definition and re-emits it with uppercase keywords, one clause per line, trailing
+// commas and four-space indentation for BEGIN/END blocks and subqueries. Comments are preserved; the token sequence
+// is unchanged. A definition that doesn't parse isn't formatted, and its syntax errors are returned instead.
+func formatTSQL(name, text string) (string, []string) {
+	ids := &identifierListener{&parser.BasetsqlListener{}, make(map[int]bool)}
+	if errs := parseTSQL(name, text, ids); len(errs) > 0 {
+		return "", errs
+	}
+	tokens, lexer := lexTSQL(name, text, nil)
+	var (
+		out       bytes.Buffer
+		indent    int
+		parens    []bool // whether each open parenthesis increased the indentation
+		prevType  string
+		lineStart = true
+	)
+	newline := func() {
+		if !lineStart {
+			out.WriteString("\n")
+			lineStart = true
+		}
+	}
+	for _, t := range tokens {
+		if t.GetTokenType() == antlr.TokenEOF {
+			break
+		}
+		typ := tokenName(lexer, t)
+		txt := t.GetText()
+		keyword := isKeyword(typ, txt) && !ids.starts[t.GetStart()] && prevType != "DOT"
+		if keyword {
+			txt = strings.ToUpper(txt)
+		}
+		if prevType == "BEGIN" && !transactionKeywords[typ] {
+			indent++
+			if typ != "TRY" && typ != "CATCH" {
+				newline()
+			}
+		}
+		if typ == "SELECT" && prevType == "LR_BRACKET" && len(parens) > 0 {
+			// subquery: indent its body and close it on its own line
+			parens[len(parens)-1] = true
+			indent++
+		}
+		switch {
+		case typ == "END" || (txt == ")" && len(parens) > 0 && parens[len(parens)-1]):
+			if indent > 0 {
+				indent--
+			}
+			newline()
+		case keyword && clauseKeywords[typ]:
+			if !(joinModifiers[prevType] && (typ == "JOIN" || typ == "OUTER" || typ == "APPLY")) &&
+				!(prevType == "INSERT" && typ == "INTO") {
+				newline()
+			}
+		}
+		if lineStart {
+			out.WriteString(strings.Repeat(fmtIndent, indent))
+		} else if needsSpace(prevType, typ) {
+			out.WriteString(" ")
+		}
+		out.WriteString(txt)
+		lineStart = false
+		switch {
+		case typ == "LINE_COMMENT", typ == "SEMI", typ == "GO":
+			newline()
+		case typ == "LR_BRACKET":
+			parens = append(parens, false)
+		case typ == "RR_BRACKET" && len(parens) > 0:
+			parens = parens[:len(parens)-1]
+		}
+		if typ != "COMMENT" {
+			prevType = typ
+		}
+	}
+	return strings.TrimSpace(trimTrailingSpace(out.String())) + "\n", nil
+}
+
+func needsSpace(prevType, typ string) bool {
+	switch {
+	case typ == "COMMA", typ == "SEMI", typ == "RR_BRACKET", typ == "DOT", prevType == "DOT", prevType == "LR_BRACKET":
+		return false
+	case typ == "LR_BRACKET":
+		return !(prevType == "ID" || prevType == "SQUARE_BRACKET_ID" || functionKeywords[prevType])
+	}
+	return true
+}
+
+func trimTrailingSpace(in string) string {
+	lines := strings.Split(in, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " \t\r")
+	}
+	return strings.Join(lines, "\n")
+}
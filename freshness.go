@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// staleReference is what a run does when the reference data it loaded looks stale: "warn", logging why and
+// carrying on, or "fail", stopping the run before any sproc is parsed
+var staleReference = staleFlag("warn")
+
+// maxKeyDrop is the fraction of the keys of a dictionary that may disappear since the previous run before it is
+// considered stale
+var maxKeyDrop = 0.5
+
+// staleFlag is a flag.Value for -stale-reference
+type staleFlag string
+
+func (s *staleFlag) String() string {
+	return string(*s)
+}
+
+func (s *staleFlag) Set(v string) error {
+	switch strings.ToLower(v) {
+	case "warn", "fail":
+		*s = staleFlag(strings.ToLower(v))
+		return nil
+	}
+	return fmt.Errorf("unknown action %q (available: warn, fail)", v)
+}
+
+// checkReferenceData looks for signs the dictionaries a run loaded are stale: no keys at all, a column without
+// keys, or far fewer keys than the snapshot of the previous run holds. Each is logged, and with -stale-reference
+// fail the run is stopped.
+func checkReferenceData(dicts []*dictionary) error {
+	var problems []string
+	prev := previousRun()
+	for _, d := range dicts {
+		name := snapshotFile(d)
+		if d.size() == 0 {
+			problems = append(problems, name+": no keys loaded")
+			continue
+		}
+		for _, col := range d.columns {
+			if len(d.keys[col]) == 0 {
+				problems = append(problems, name+": no keys in column "+col)
+			}
+		}
+		if len(prev) == 0 {
+			continue
+		}
+		records, err := readCSVFile(filepath.Join(prev, name))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if before := len(records) - 1; before > 0 && float64(d.size()) < float64(before)*(1-maxKeyDrop) {
+			problems = append(problems, name+": "+strconv.Itoa(d.size())+" keys, down from "+strconv.Itoa(before)+" in "+prev)
+		}
+	}
+	for _, p := range problems {
+		log.Println("Stale reference data?", p)
+	}
+	if len(problems) > 0 && staleReference == "fail" {
+		return fmt.Errorf("refusing to run with stale reference data (%d problems); see the log, or run with -stale-reference warn", len(problems))
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/antlr/antlr4/runtime/Go/antlr"
+	parser "github.com/nycmonkey/sprocs/tsql"
+)
+
+const (
+	// normalizedConfidence is reported for literals that equal a key once case and spaces are ignored
+	normalizedConfidence = 0.95
+)
+
+// fuzzyExtractor reports string literals within one edit of an account master key, ignoring case and spaces.
+// Exact matches are left to the codes report.
+type fuzzyExtractor struct {
+	dict  *dictionary
+	once  sync.Once
+	index map[int][]fuzzyKey
+}
+
+// fuzzyKey is a dictionary key along with its normalized form
+type fuzzyKey struct {
+	col, key, norm string
+}
+
+// fuzzyFlag is a flag.Value that registers a fuzzyExtractor over the account master when set
+type fuzzyFlag bool
+
+func (f *fuzzyFlag) String() string {
+	return strconv.FormatBool(bool(*f))
+}
+
+func (f *fuzzyFlag) IsBoolFlag() bool {
+	return true
+}
+
+func (f *fuzzyFlag) Set(v string) error {
+	on, err := strconv.ParseBool(v)
+	if err != nil {
+		return err
+	}
+	if on && !bool(*f) {
+		RegisterExtractor(&fuzzyExtractor{dict: portfolios})
+	}
+	*f = fuzzyFlag(on)
+	return nil
+}
+
+func (e *fuzzyExtractor) Name() string {
+	return "fuzzy_codes"
+}
+
+func (e *fuzzyExtractor) Columns() []string {
+	return []string{"Account Master Column", "Account Master Value", "Literal", "Confidence"}
+}
+
+func (e *fuzzyExtractor) NewListener(sproc string, names *nameScope, emit func([]string)) antlr.ParseTreeListener {
+	e.once.Do(e.buildIndex)
+	return &fuzzyListener{&parser.BasetsqlListener{}, e, emit, make(map[string]bool)}
+}
+
+// buildIndex buckets the normalized keys by length, so each literal is only compared with keys that could be
+// within one edit of it. It runs once the dictionary has been loaded, when the first listener is created.
+func (e *fuzzyExtractor) buildIndex() {
+	e.index = make(map[int][]fuzzyKey)
+	for _, col := range e.dict.columns {
+		for key := range e.dict.keys[col] {
+			norm := normalizeCode(key)
+			e.index[len(norm)] = append(e.index[len(norm)], fuzzyKey{col, key, norm})
+		}
+	}
+}
+
+// matches returns the keys lit is a near miss for, with the confidence of each match
+func (e *fuzzyExtractor) matches(lit string) (keys []fuzzyKey, confidence []float64) {
+	norm := normalizeCode(lit)
+	if len(norm) == 0 {
+		return
+	}
+	for n := len(norm) - 1; n <= len(norm)+1; n++ {
+		for _, k := range e.index[n] {
+			if k.key == lit {
+				continue
+			}
+			switch {
+			case k.norm == norm:
+				keys, confidence = append(keys, k), append(confidence, normalizedConfidence)
+			case withinOneEdit(k.norm, norm):
+				longest := len(norm)
+				if len(k.norm) > longest {
+					longest = len(k.norm)
+				}
+				keys, confidence = append(keys, k), append(confidence, 1-1/float64(longest))
+			}
+		}
+	}
+	return
+}
+
+type fuzzyListener struct {
+	*parser.BasetsqlListener
+	extractor *fuzzyExtractor
+	emit      func([]string)
+	seen      map[string]bool
+}
+
+// EnterConstant is called when the parser enters a `constant` node
+func (l *fuzzyListener) EnterConstant(ctx *parser.ConstantContext) {
+	lit, ok := unquoteString(strings.TrimSpace(ctx.GetText()))
+	if !ok || l.seen[lit] || l.extractor.dict.contains(lit) {
+		return
+	}
+	l.seen[lit] = true
+	keys, confidence := l.extractor.matches(lit)
+	for i, k := range keys {
+		l.emit([]string{k.col, k.key, lit, formatDecimal(confidence[i], 2)})
+	}
+}
+
+// normalizeCode folds case and drops spaces, so codes differing only in those respects compare equal
+func normalizeCode(in string) string {
+	return strings.ToUpper(strings.Join(strings.Fields(in), ""))
+}
+
+// withinOneEdit reports whether a and b differ by at most one insertion, deletion or substitution
+func withinOneEdit(a, b string) bool {
+	if len(a) > len(b) {
+		a, b = b, a
+	}
+	if len(b)-len(a) > 1 {
+		return false
+	}
+	var i, j, edits int
+	for i < len(a) && j < len(b) {
+		if a[i] == b[j] {
+			i++
+			j++
+			continue
+		}
+		if edits++; edits > 1 {
+			return false
+		}
+		if len(a) == len(b) {
+			i++
+		}
+		j++
+	}
+	return edits+(len(b)-j)+(len(a)-i) <= 1
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/antlr/antlr4/runtime/Go/antlr"
+)
+
+// gapKey is a construct the parser failed on: the rule it was in and the token it couldn't accept there
+type gapKey struct {
+	rule, token string
+}
+
+type gapCount struct {
+	errors int
+	sprocs map[string]struct{}
+}
+
+// grammarGaps aggregates the syntax errors of the current run by construct, for unparsed_constructs.csv
+var grammarGaps struct {
+	sync.Mutex
+	counts map[gapKey]*gapCount
+}
+
+// recordGrammarGap counts a syntax error reported by the parser against the construct it failed on. Only the
+// sprocs of a run are counted, so the counts don't grow with the documents POSTed to `sprocs serve`.
+func recordGrammarGap(sproc string, recognizer antlr.Recognizer, offendingSymbol interface{}) {
+	p, ok := recognizer.(antlr.Parser)
+	if !ok || p.GetParserRuleContext() == nil {
+		return
+	}
+	key := gapKey{rule: p.GetRuleNames()[p.GetParserRuleContext().GetRuleIndex()], token: "?"}
+	if t, ok := offendingSymbol.(antlr.Token); ok {
+		key.token = gapToken(t, p.GetSymbolicNames())
+	}
+	grammarGaps.Lock()
+	defer grammarGaps.Unlock()
+	if grammarGaps.counts == nil {
+		grammarGaps.counts = make(map[gapKey]*gapCount)
+	}
+	c, ok := grammarGaps.counts[key]
+	if !ok {
+		c = &gapCount{sprocs: make(map[string]struct{})}
+		grammarGaps.counts[key] = c
+	}
+	c.errors++
+	c.sprocs[sproc] = struct{}{}
+}
+
+// gapToken names the offending token of a syntax error: the upper-cased text of a word, which is most often a
+// keyword the grammar doesn't know, and the token type of anything else, so that every string or number in
+// the same place counts as one construct
+func gapToken(t antlr.Token, symbolicNames []string) string {
+	if t.GetTokenType() == antlr.TokenEOF {
+		return "<EOF>"
+	}
+	var name string
+	if typ := t.GetTokenType(); typ >= 0 && typ < len(symbolicNames) {
+		name = symbolicNames[typ]
+	}
+	switch name {
+	case "ID", "":
+		return strings.ToUpper(t.GetText())
+	case "STRING", "DECIMAL", "FLOAT", "REAL", "BINARY", "LOCAL_ID", "SQUARE_BRACKET_ID", "DOUBLE_QUOTE_ID":
+		return name
+	}
+	if isIdentifier(t.GetText()) {
+		return strings.ToUpper(t.GetText())
+	}
+	return t.GetText()
+}
+
+// resetGrammarGaps forgets the syntax errors of the previous run
+func resetGrammarGaps() {
+	grammarGaps.Lock()
+	grammarGaps.counts = nil
+	grammarGaps.Unlock()
+}
+
+// writeGrammarGaps writes unparsed_constructs.csv, the constructs the parser failed on most often first, to show
+// which grammar rules are most worth fixing next
+func writeGrammarGaps() error {
+	grammarGaps.Lock()
+	defer grammarGaps.Unlock()
+	keys := make([]gapKey, 0, len(grammarGaps.counts))
+	for k := range grammarGaps.counts {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := grammarGaps.counts[keys[i]], grammarGaps.counts[keys[j]]
+		if len(a.sprocs) != len(b.sprocs) {
+			return len(a.sprocs) > len(b.sprocs)
+		}
+		if a.errors != b.errors {
+			return a.errors > b.errors
+		}
+		if keys[i].rule != keys[j].rule {
+			return keys[i].rule < keys[j].rule
+		}
+		return keys[i].token < keys[j].token
+	})
+	rows := make([][]string, 0, len(keys))
+	for i, k := range keys {
+		c := grammarGaps.counts[k]
+		var example string
+		for sproc := range c.sprocs {
+			if len(example) == 0 || sproc < example {
+				example = sproc
+			}
+		}
+		rows = append(rows, []string{strconv.Itoa(i + 1), k.rule, k.token, strconv.Itoa(len(c.sprocs)), strconv.Itoa(c.errors), example})
+	}
+	return writeCSVFile("unparsed_constructs.csv", []string{"Rank", "Rule", "Token", "Sprocs", "Errors", "Example Sproc"}, rows)
+}
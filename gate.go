@@ -0,0 +1,163 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func init() {
+	commands["gate"] = command{
+		summary: "fail if changed sprocs add references to tables a policy forbids, or fail to parse",
+		run:     gate,
+	}
+}
+
+func gate(args []string) error {
+	fs := flag.NewFlagSet("gate", flag.ExitOnError)
+	policyFile := fs.String("policy", "", "YAML policy file of the tables sprocs may reference (required)")
+	baseline := fs.String("baseline", "", "run directory holding the production references (default: the latest run under -root)")
+	root := fs.String("root", ".", "directory holding run directories")
+	host := fs.String("host", "", "only consider runs of this host when picking the latest run")
+	addParseFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: sprocs gate -policy <file> [flags] <.sql files or project directories...>\n\nFlags:")
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	if fs.NArg() == 0 || len(*policyFile) == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	pol, err := loadPolicy(*policyFile)
+	if err != nil {
+		return err
+	}
+	if len(*baseline) == 0 {
+		dirs, err := runDirs(*root, *host)
+		if err != nil {
+			return err
+		}
+		if len(dirs) == 0 {
+			return fmt.Errorf("no runs found under %s", *root)
+		}
+		*baseline = dirs[len(dirs)-1]
+	}
+	existing, err := loadReferences(*baseline)
+	if err != nil {
+		return err
+	}
+	fmt.Println("comparing with", *baseline)
+	defs, err := readChangedSprocs(fs.Args())
+	if err != nil {
+		return err
+	}
+	var violations, unparsed int
+	for _, def := range defs {
+		// each table is checked against the policy by the schemas the sproc references it in, which its report
+		// leaves out
+		var refs []gateReference
+		var l *TableListener
+		l = NewTableListener(def.key, runNames, func(f []string) {
+			refs = append(refs, gateReference{f[0], f[1], pol.tableViolations(l, def.key, f[0], f[1])})
+		})
+		errors := parseTSQL(def.key, def.value, l)
+		// the references of a sproc that fails to parse may be missing, so it can't pass the gate
+		if len(errors) > 0 {
+			unparsed++
+		}
+		for _, e := range errors {
+			fmt.Printf("FAIL %s: %s\n", def.key, e)
+		}
+		for _, ref := range refs {
+			table, access, broken := ref.table, ref.access, ref.broken
+			before := existing[strings.ToUpper(def.key)][strings.ToUpper(table)]
+			added := len(before) == 0 || strings.Trim(access, before) != ""
+			for _, v := range broken {
+				if added {
+					violations++
+					fmt.Printf("FAIL %s: new reference to %s (%s): %s\n", def.key, table, access, v)
+				} else {
+					fmt.Printf("WARN %s: references %s (%s), as it did in production: %s\n", def.key, table, access, v)
+				}
+			}
+			if added && len(broken) == 0 {
+				fmt.Printf("NEW  %s: %s (%s)\n", def.key, table, access)
+			}
+		}
+	}
+	switch {
+	case violations > 0 && unparsed > 0:
+		return fmt.Errorf("gate failed: %d forbidden references added, %d sprocs failed to parse", violations, unparsed)
+	case violations > 0:
+		return fmt.Errorf("gate failed: %d forbidden references added", violations)
+	case unparsed > 0:
+		return fmt.Errorf("gate failed: %d sprocs failed to parse", unparsed)
+	}
+	fmt.Println("gate passed for", len(defs), "sprocs")
+	return nil
+}
+
+// gateReference is a table referenced by a changed sproc, with the ways it breaks the policy
+type gateReference struct {
+	table, access string
+	broken        []string
+}
+
+// loadReferences returns the access, R, W or RW, of each table referenced by each sproc of a run, keyed by the
+// upper-cased names of both
+func loadReferences(dir string) (map[string]map[string]string, error) {
+	records, err := readCSVFile(filepath.Join(dir, "table_sources.csv"))
+	if err != nil {
+		return nil, err
+	}
+	refs := make(map[string]map[string]string)
+	if len(records) == 0 {
+		return refs, nil
+	}
+	for _, rec := range records[1:] {
+		if len(rec) < 3 {
+			continue
+		}
+		sproc := strings.ToUpper(rec[0])
+		if refs[sproc] == nil {
+			refs[sproc] = make(map[string]string)
+		}
+		refs[sproc][strings.ToUpper(rec[1])] = rec[2]
+	}
+	return refs, nil
+}
+
+// readChangedSprocs returns the sprocs created by each .sql file, or each SSDT project directory, named in args.
+// A file not creating a procedure is taken to define the sproc its name names.
+func readChangedSprocs(args []string) ([]keyValue, error) {
+	var defs []keyValue
+	for _, arg := range args {
+		fi, err := os.Stat(arg)
+		if err != nil {
+			return nil, err
+		}
+		if fi.IsDir() {
+			found, _, err := readSSDTProject(arg)
+			if err != nil {
+				return nil, err
+			}
+			defs = append(defs, found...)
+			continue
+		}
+		b, err := ioutil.ReadFile(arg)
+		if err != nil {
+			return nil, err
+		}
+		name := strings.TrimSuffix(filepath.Base(arg), filepath.Ext(arg))
+		text := decodeSQLFile(b)
+		if m := createProcRe.FindStringSubmatch(text); m != nil {
+			name, _ = projectObjectName(m[1])
+		}
+		defs = append(defs, keyValue{key: name, value: text})
+	}
+	return defs, nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"strings"
+)
+
+// graphFilter prunes the graph exports, graph.json and the -plantuml diagrams, which are unreadable for a large
+// database. Sprocs, procedures and tables left out take their links with them.
+var graphFilter struct {
+	// sprocs and exclude are comma-separated globs matched without regard to case: sprocs the procedures to keep,
+	// all when empty, exclude the procedures and tables to leave out
+	sprocs, exclude string
+	// schemas is a comma-separated list of the schemas of the tables to keep, all when empty. Tables of the sproc
+	// database reported by name alone are in dbo.
+	schemas string
+	// root, when set, keeps only what is within depth links of this procedure, however far when depth is 0
+	root  string
+	depth int
+	// minDegree leaves out the procedures and tables with fewer links than this
+	minDegree int
+}
+
+// graphEdge links a sproc to a table it uses, or to a procedure it calls
+type graphEdge struct {
+	sproc, target string
+	call          bool
+}
+
+// splitList returns the trimmed, non-empty elements of a comma-separated list
+func splitList(list string) []string {
+	var elems []string
+	for _, e := range strings.Split(list, ",") {
+		if e = strings.TrimSpace(e); len(e) > 0 {
+			elems = append(elems, e)
+		}
+	}
+	return elems
+}
+
+// tableSchema returns the schema of a table as reported in table_sources.csv
+func tableSchema(table string) string {
+	if parts := strings.Split(table, "."); len(parts) >= 2 {
+		return parts[len(parts)-2]
+	}
+	return "dbo"
+}
+
+// matchesAny reports whether name matches any of the globs, without regard to case
+func matchesAny(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if matchFold(p, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// pruneGraph returns the edges left by the -graph filters
+func pruneGraph(edges []graphEdge) []graphEdge {
+	sprocs, exclude, schemas := splitList(graphFilter.sprocs), splitList(graphFilter.exclude), splitList(graphFilter.schemas)
+	procKey := func(name string) string { return "P\x00" + strings.ToUpper(name) }
+	targetKey := func(e graphEdge) string {
+		if e.call {
+			return procKey(e.target)
+		}
+		return "T\x00" + strings.ToUpper(e.target)
+	}
+	keepProc := func(name string) bool {
+		return (len(sprocs) == 0 || matchesAny(sprocs, name)) && !matchesAny(exclude, name)
+	}
+	keepTable := func(name string) bool {
+		if len(schemas) > 0 && !matchesAny(schemas, tableSchema(name)) {
+			return false
+		}
+		return !matchesAny(exclude, name)
+	}
+	var kept []graphEdge
+	for _, e := range edges {
+		if keepProc(e.sproc) && (e.call && keepProc(e.target) || !e.call && keepTable(e.target)) {
+			kept = append(kept, e)
+		}
+	}
+	if len(graphFilter.root) > 0 {
+		// breadth first from the root, following links either way
+		links := make(map[string][]string)
+		for _, e := range kept {
+			from, to := procKey(e.sproc), targetKey(e)
+			links[from] = append(links[from], to)
+			links[to] = append(links[to], from)
+		}
+		dist := map[string]int{procKey(graphFilter.root): 0}
+		queue := []string{procKey(graphFilter.root)}
+		for len(queue) > 0 {
+			n := queue[0]
+			queue = queue[1:]
+			if graphFilter.depth > 0 && dist[n] >= graphFilter.depth {
+				continue
+			}
+			for _, m := range links[n] {
+				if _, ok := dist[m]; !ok {
+					dist[m] = dist[n] + 1
+					queue = append(queue, m)
+				}
+			}
+		}
+		reached := kept[:0:0]
+		for _, e := range kept {
+			_, from := dist[procKey(e.sproc)]
+			_, to := dist[targetKey(e)]
+			if from && to {
+				reached = append(reached, e)
+			}
+		}
+		kept = reached
+	}
+	if graphFilter.minDegree > 1 {
+		degree := make(map[string]int)
+		for _, e := range kept {
+			degree[procKey(e.sproc)]++
+			degree[targetKey(e)]++
+		}
+		linked := kept[:0:0]
+		for _, e := range kept {
+			if degree[procKey(e.sproc)] >= graphFilter.minDegree && degree[targetKey(e)] >= graphFilter.minDegree {
+				linked = append(linked, e)
+			}
+		}
+		kept = linked
+	}
+	return kept
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// pingTimeout bounds the database connectivity check of /readyz
+const pingTimeout = 10 * time.Second
+
+// readiness is the state of one target, or of the served run directory when there are no targets
+type readiness struct {
+	Target      string `json:"target,omitempty"`
+	LastSuccess string `json:"lastSuccessfulRun,omitempty"`
+	Age         string `json:"age,omitempty"`
+	Database    string `json:"database,omitempty"`
+	Ready       bool   `json:"ready"`
+	Reason      string `json:"reason,omitempty"`
+}
+
+// handleHealthz reports that the server is up
+func handleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintln(w, "ok")
+}
+
+// handleReadyz reports whether every target can reach its database and has a successful run no older than
+// maxAge. Without targets, only the age of the latest run directory is checked.
+func (s *server) handleReadyz(maxAge time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var states []readiness
+		if len(s.targets) == 0 {
+			states = append(states, s.runReadiness(maxAge))
+		}
+		for _, t := range s.targets {
+			states = append(states, t.readiness(maxAge))
+		}
+		status := http.StatusOK
+		for _, st := range states {
+			if !st.Ready {
+				status = http.StatusServiceUnavailable
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(states)
+	}
+}
+
+func (s *server) runReadiness(maxAge time.Duration) readiness {
+	run, err := s.latest()
+	if err != nil {
+		return readiness{Reason: err.Error()}
+	}
+	fi, err := os.Stat(filepath.Join(run.dir, "definition_hashes.csv"))
+	if err != nil {
+		return readiness{Reason: err.Error()}
+	}
+	return ageReadiness(readiness{}, fi.ModTime(), maxAge)
+}
+
+func (t *target) readiness(maxAge time.Duration) readiness {
+	t.mu.Lock()
+	last := t.lastSuccess
+	t.mu.Unlock()
+	st := readiness{Target: t.Name, Database: "ok"}
+	if err := t.ping(); err != nil {
+		st.Database = err.Error()
+		st.Reason = "database unreachable"
+		return st
+	}
+	if last.IsZero() {
+		st.Reason = "no successful run yet"
+		return st
+	}
+	return ageReadiness(st, last, maxAge)
+}
+
+func ageReadiness(st readiness, last time.Time, maxAge time.Duration) readiness {
+	age := time.Since(last)
+	st.LastSuccess, st.Age = last.Format(time.RFC3339), age.Round(time.Second).String()
+	st.Ready = age <= maxAge
+	if !st.Ready {
+		st.Reason = "last successful run is older than " + maxAge.String()
+	}
+	return st
+}
+
+// ping checks that the target's database accepts connections
+func (t *target) ping() error {
+	dsn, err := connStringFor(t.Host, t.Database, t.User, t.Secret)
+	if err != nil {
+		return err
+	}
+	db, err := openDB(dsn)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	done := make(chan error, 1)
+	go func() { done <- db.Ping() }()
+	select {
+	case err = <-done:
+		return err
+	case <-time.After(pingTimeout):
+		return fmt.Errorf("no response within %s", pingTimeout)
+	}
+}
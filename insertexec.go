@@ -0,0 +1,109 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/antlr/antlr4/runtime/Go/antlr"
+	parser "github.com/nycmonkey/sprocs/tsql"
+)
+
+func init() {
+	RegisterExtractor(insertExecExtractor{})
+}
+
+// insertExecExtractor reports the tables each sproc fills with the result set of a procedure, by
+// INSERT INTO <table> EXEC <procedure>
+type insertExecExtractor struct{}
+
+func (insertExecExtractor) Name() string {
+	return "insert_exec"
+}
+
+func (insertExecExtractor) Columns() []string {
+	return []string{"Target Table", "Executed Procedure", "Line", "Control Flow"}
+}
+
+func (insertExecExtractor) NewListener(sproc string, names *nameScope, emit func([]string)) antlr.ParseTreeListener {
+	return &insertExecListener{&parser.BasetsqlListener{}, names, emit}
+}
+
+type insertExecListener struct {
+	*parser.BasetsqlListener
+	names *nameScope
+	emit  func([]string)
+}
+
+// EnterInsert_statement is called when the parser enters an `insert_statement` node. Temporary tables and table
+// variables are reported too, as staging a procedure's results in one is the usual way of reading them.
+func (l *insertExecListener) EnterInsert_statement(ctx *parser.Insert_statementContext) {
+	target, exec := insertExecTarget(l.names, ctx)
+	if exec == nil || exec.Func_proc_name() == nil {
+		// EXEC (@sql) runs dynamic SQL rather than a named procedure
+		return
+	}
+	flow := controlFlow(ctx)
+	if len(flow) == 0 {
+		flow = alwaysRuns
+	}
+	l.emit([]string{target, l.names.normalizeProcName(exec.Func_proc_name().GetText()), strconv.Itoa(ctx.GetStart().GetLine()), flow})
+}
+
+// insertExecTarget returns the table an INSERT writes to and, when it inserts the result set of EXEC, the
+// execute statement
+func insertExecTarget(names *nameScope, ctx *parser.Insert_statementContext) (string, *parser.Execute_statementContext) {
+	if ctx.Ddl_object() == nil || ctx.Insert_statement_value() == nil {
+		return "", nil
+	}
+	exec, _ := ctx.Insert_statement_value().(*parser.Insert_statement_valueContext).Execute_statement().(*parser.Execute_statementContext)
+	return names.normalizeTableName(strings.TrimSpace(ctx.Ddl_object().GetText())), exec
+}
+
+// recordInsertExecLineage writes insert_exec_lineage.csv, the tables each table filled by INSERT ... EXEC is
+// sourced from: those the executed procedure reads, itself ("direct") or through the procedures it calls
+// ("inherited"), from the insert_exec.csv and table_dependencies.csv of the current run
+func recordInsertExecLineage() error {
+	inserts, err := readCSVFile(filepath.Join(outDir, "insert_exec.csv"))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	deps, err := readCSVFile(filepath.Join(outDir, "table_dependencies.csv"))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if len(inserts) == 0 || len(deps) == 0 {
+		return nil
+	}
+	// reads maps each upper-cased procedure to the dependencies of the tables it reads
+	reads := make(map[string][][]string)
+	for _, rec := range deps[1:] {
+		if len(rec) >= 5 && strings.Contains(rec[2], "R") {
+			proc := strings.ToUpper(rec[0])
+			reads[proc] = append(reads[proc], rec)
+		}
+	}
+	var rows [][]string
+	seen := make(map[string]bool)
+	for _, rec := range inserts[1:] {
+		if len(rec) < 3 {
+			continue
+		}
+		for _, dep := range reads[strings.ToUpper(rec[2])] {
+			row := []string{rec[0], rec[1], rec[2], dep[1], dep[3], dep[4]}
+			if key := strings.ToUpper(strings.Join(row[:4], "\x00")); !seen[key] {
+				seen[key] = true
+				rows = append(rows, row)
+			}
+		}
+	}
+	sortRows(rows)
+	return writeCSVFile("insert_exec_lineage.csv", []string{"Stored Procedure", "Target Table", "Executed Procedure", "Source Table", "Dependency", "Via"}, rows)
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/antlr/antlr4/runtime/Go/antlr"
+	parser "github.com/nycmonkey/sprocs/tsql"
+)
+
+const (
+	severityOff     = `off`
+	severityWarning = `warning`
+	severityError   = `error`
+
+	ruleSyntax        = `syntax`
+	ruleNoCount       = `nocount`
+	ruleSelectStar    = `select-star`
+	ruleNoLock        = `nolock`
+	ruleErrorHandling = `error-handling`
+	ruleNaming        = `naming`
+)
+
+// defaultLintSeverities lists every lint rule and the severity it is reported at unless overridden by a lint config
+var defaultLintSeverities = map[string]string{
+	ruleSyntax:        severityError,
+	ruleNoCount:       severityWarning,
+	ruleSelectStar:    severityWarning,
+	ruleNoLock:        severityWarning,
+	ruleErrorHandling: severityWarning,
+	ruleNaming:        severityError,
+}
+
+// lintConfig is the JSON document accepted by `sprocs lint -config`
+type lintConfig struct {
+	// Rules maps a rule name to its severity (off, warning or error)
+	Rules map[string]string `json:"rules"`
+	// ProcNamePattern is a regular expression every procedure name must match
+	ProcNamePattern string `json:"procNamePattern"`
+}
+
+// lintFinding is a single rule violation
+type lintFinding struct {
+	File     string
+	Line     int
+	Column   int
+	Rule     string
+	Severity string
+	Message  string
+}
+
+func (f lintFinding) String() string {
+	return fmt.Sprintf("%s:%d:%d: %s [%s] %s", f.File, f.Line, f.Column, f.Severity, f.Rule, f.Message)
+}
+
+// lintListener walks a parse tree and records violations of the lint rules
+type lintListener struct {
+	*parser.BasetsqlListener
+	file        string
+	namePattern *regexp.Regexp
+	findings    []lintFinding
+	procs       []*lintProc
+}
+
+// lintProc is a procedure of a linted file along with what its body-wide rules look for in it, so each procedure
+// of a file is checked on its own
+type lintProc struct {
+	ctx         *parser.Create_or_alter_procedureContext
+	name        string
+	noCount     bool
+	errorChecks bool
+}
+
+// syntaxErrorPosition matches the position the error listener reports a syntax error at
+var syntaxErrorPosition = regexp.MustCompile(`^Line: (\d+), Column: (\d+), Error: (.*)$`)
+
+func init() {
+	commands["lint"] = command{
+		summary: "check T-SQL files against the lint rule set",
+		run:     lint,
+	}
+}
+
+func lint(args []string) error {
+	fs := flag.NewFlagSet("lint", flag.ExitOnError)
+	configPath := fs.String("config", "", "path to a JSON lint config overriding rule severities and the procedure naming pattern")
+	strict := fs.Bool("strict", false, "fail on warnings as well as errors")
+	addParseFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: sprocs lint [flags] <files...>\n\nRules:")
+		rules := make([]string, 0, len(defaultLintSeverities))
+		for rule := range defaultLintSeverities {
+			rules = append(rules, rule)
+		}
+		sort.Strings(rules)
+		for _, rule := range rules {
+			fmt.Fprintf(os.Stderr, "  %-16s (default %s)\n", rule, defaultLintSeverities[rule])
+		}
+		fmt.Fprintln(os.Stderr, "\nFlags:")
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	severities, namePattern, err := loadLintConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	var failed int
+	for _, path := range fs.Args() {
+		def, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		var fail bool
+		for _, f := range lintSproc(path, string(def), namePattern) {
+			f.Severity = severities[f.Rule]
+			if f.Severity == severityOff {
+				continue
+			}
+			fmt.Println(f)
+			if f.Severity == severityError || *strict {
+				fail = true
+			}
+		}
+		if fail {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("lint failed for %d of %d files", failed, fs.NArg())
+	}
+	fmt.Println("lint passed for", fs.NArg(), "files")
+	return nil
+}
+
+func loadLintConfig(path string) (severities map[string]string, namePattern *regexp.Regexp, err error) {
+	severities = make(map[string]string, len(defaultLintSeverities))
+	for rule, severity := range defaultLintSeverities {
+		severities[rule] = severity
+	}
+	if len(path) == 0 {
+		return
+	}
+	var cfg lintConfig
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return
+	}
+	if err = json.Unmarshal(b, &cfg); err != nil {
+		return nil, nil, fmt.Errorf("invalid lint config %s: %v", path, err)
+	}
+	for rule, severity := range cfg.Rules {
+		if _, ok := defaultLintSeverities[rule]; !ok {
+			return nil, nil, fmt.Errorf("invalid lint config %s: unknown rule %q", path, rule)
+		}
+		switch severity {
+		case severityOff, severityWarning, severityError:
+			severities[rule] = severity
+		default:
+			return nil, nil, fmt.Errorf("invalid lint config %s: unknown severity %q for rule %s", path, severity, rule)
+		}
+	}
+	if len(cfg.ProcNamePattern) > 0 {
+		if namePattern, err = regexp.Compile(cfg.ProcNamePattern); err != nil {
+			return nil, nil, fmt.Errorf("invalid lint config %s: %v", path, err)
+		}
+	}
+	return
+}
+
+// lintSproc parses a T-SQL definition and returns the rule violations found in it, with severities left unset
+func lintSproc(file, def string, namePattern *regexp.Regexp) []lintFinding {
+	l := &lintListener{
+		BasetsqlListener: &parser.BasetsqlListener{},
+		file:             file,
+		namePattern:      namePattern,
+	}
+	for _, e := range parseTSQL(file, def, l) {
+		f := lintFinding{File: file, Rule: ruleSyntax, Message: e}
+		if m := syntaxErrorPosition.FindStringSubmatch(e); m != nil {
+			f.Line, _ = strconv.Atoi(m[1])
+			f.Column, _ = strconv.Atoi(m[2])
+			f.Column++
+			f.Message = m[3]
+		}
+		l.findings = append(l.findings, f)
+	}
+	for _, proc := range l.procs {
+		name := proc.name
+		if !proc.noCount {
+			l.add(proc.ctx, ruleNoCount, "procedure "+name+" does not SET NOCOUNT ON")
+		}
+		if !proc.errorChecks {
+			l.add(proc.ctx, ruleErrorHandling, "procedure "+name+" has no TRY/CATCH block or @@ERROR check")
+		}
+	}
+	sort.SliceStable(l.findings, func(i, j int) bool {
+		return l.findings[i].Line < l.findings[j].Line
+	})
+	return l.findings
+}
+
+func (l *lintListener) add(ctx antlr.ParserRuleContext, rule, msg string) {
+	start := ctx.GetStart()
+	l.findings = append(l.findings, lintFinding{
+		File:    l.file,
+		Line:    start.GetLine(),
+		Column:  start.GetColumn() + 1,
+		Rule:    rule,
+		Message: msg,
+	})
+}
+
+// procOf returns the procedure whose definition holds ctx, or nil outside any
+func (l *lintListener) procOf(ctx antlr.Tree) *lintProc {
+	for p := ctx.GetParent(); p != nil; p = p.GetParent() {
+		if proc, ok := p.(*parser.Create_or_alter_procedureContext); ok {
+			for _, lp := range l.procs {
+				if lp.ctx == proc {
+					return lp
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// EnterCreate_or_alter_procedure checks the procedure name; body-wide rules are evaluated once the walk completes.
+// A procedure the parser recovered from a syntax error may have no name, which is reported instead.
+func (l *lintListener) EnterCreate_or_alter_procedure(ctx *parser.Create_or_alter_procedureContext) {
+	fn, _ := ctx.Func_proc_name().(*parser.Func_proc_nameContext)
+	if fn == nil || fn.GetProcedure() == nil {
+		l.add(ctx, ruleNaming, "procedure has no name")
+		return
+	}
+	l.procs = append(l.procs, &lintProc{ctx: ctx, name: fn.GetText()})
+	name := removeBrackets(fn.GetProcedure().GetText())
+	if strings.HasPrefix(strings.ToLower(name), "sp_") {
+		l.add(ctx, ruleNaming, "procedure "+name+" uses the sp_ prefix reserved for system procedures")
+	}
+	if l.namePattern != nil && !l.namePattern.MatchString(name) {
+		l.add(ctx, ruleNaming, "procedure "+name+" does not match naming pattern "+l.namePattern.String())
+	}
+}
+
+// EnterSet_special notes SET NOCOUNT ON in the procedure it is in
+func (l *lintListener) EnterSet_special(ctx *parser.Set_specialContext) {
+	if strings.TrimSuffix(strings.ToUpper(ctx.GetText()), ";") == "SETNOCOUNTON" {
+		if proc := l.procOf(ctx); proc != nil {
+			proc.noCount = true
+		}
+	}
+}
+
+// EnterTry_catch_statement notes structured error handling in the procedure it is in
+func (l *lintListener) EnterTry_catch_statement(ctx *parser.Try_catch_statementContext) {
+	if proc := l.procOf(ctx); proc != nil {
+		proc.errorChecks = true
+	}
+}
+
+// EnterPrimitive_expression notes @@ERROR checks in the procedure they are in
+func (l *lintListener) EnterPrimitive_expression(ctx *parser.Primitive_expressionContext) {
+	if strings.ToUpper(ctx.GetText()) == "@@ERROR" {
+		if proc := l.procOf(ctx); proc != nil {
+			proc.errorChecks = true
+		}
+	}
+}
+
+// EnterSelect_list_elem flags SELECT *, except inside EXISTS where the column list is irrelevant
+func (l *lintListener) EnterSelect_list_elem(ctx *parser.Select_list_elemContext) {
+	if text := ctx.GetText(); text != "*" && !strings.HasSuffix(text, ".*") {
+		return
+	}
+	for p := ctx.GetParent(); p != nil; p = p.GetParent() {
+		if pred, ok := p.(*parser.PredicateContext); ok && pred.EXISTS() != nil {
+			return
+		}
+	}
+	l.add(ctx, ruleSelectStar, "SELECT * couples the procedure to the column layout of its sources")
+}
+
+// EnterTable_hint flags dirty reads
+func (l *lintListener) EnterTable_hint(ctx *parser.Table_hintContext) {
+	switch strings.ToUpper(ctx.GetText()) {
+	case "NOLOCK", "READUNCOMMITTED":
+		l.add(ctx, ruleNoLock, ctx.GetText()+" hint allows dirty reads")
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/antlr/antlr4/runtime/Go/antlr"
+	parser "github.com/nycmonkey/sprocs/tsql"
+)
+
+// literalsFlag is a flag.Value that registers the literalExtractor when set
+type literalsFlag bool
+
+func (f *literalsFlag) String() string {
+	return strconv.FormatBool(bool(*f))
+}
+
+func (f *literalsFlag) IsBoolFlag() bool {
+	return true
+}
+
+func (f *literalsFlag) Set(v string) error {
+	on, err := strconv.ParseBool(v)
+	if err != nil {
+		return err
+	}
+	if on && !bool(*f) {
+		RegisterExtractor(literalExtractor{})
+	}
+	*f = literalsFlag(on)
+	return nil
+}
+
+// literalExtractor reports every string literal of each sproc along with the kind of statement it appears in
+type literalExtractor struct{}
+
+func (literalExtractor) Name() string {
+	return "literals"
+}
+
+func (literalExtractor) Columns() []string {
+	return []string{"Literal", "Line", "Statement"}
+}
+
+func (literalExtractor) NewListener(sproc string, names *nameScope, emit func([]string)) antlr.ParseTreeListener {
+	return &literalListener{&parser.BasetsqlListener{}, emit}
+}
+
+type literalListener struct {
+	*parser.BasetsqlListener
+	emit func([]string)
+}
+
+// VisitTerminal is called for each token of the parse tree
+func (l *literalListener) VisitTerminal(node antlr.TerminalNode) {
+	lit, ok := unquoteString(node.GetText())
+	if !ok {
+		return
+	}
+	l.emit([]string{lit, strconv.Itoa(node.GetSymbol().GetLine()), statementType(node)})
+}
+
+// statementType names the kind of statement a node belongs to, such as SELECT, UPDATE, IF or EXECUTE
+func statementType(node antlr.Tree) string {
+	for ; node != nil; node = node.GetParent() {
+		if _, ok := node.GetParent().(*parser.Sql_clauseContext); !ok {
+			continue
+		}
+		if dml, ok := node.(*parser.Dml_clauseContext); ok {
+			// a statement starting WITH is named by the statement the common table expressions belong to
+			switch dml.GetChild(0).(type) {
+			case *parser.Select_statementContext:
+				return "SELECT"
+			case *parser.Insert_statementContext:
+				return "INSERT"
+			case *parser.Update_statementContext:
+				return "UPDATE"
+			case *parser.Delete_statementContext:
+				return "DELETE"
+			}
+		}
+		ctx, ok := node.(antlr.ParserRuleContext)
+		if !ok || ctx.GetStart() == nil {
+			return ""
+		}
+		if kw := strings.ToUpper(ctx.GetStart().GetText()); kw != "EXEC" {
+			return kw
+		}
+		return "EXECUTE"
+	}
+	return ""
+}
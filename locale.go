@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// reportLocale is how reports write dates and decimal numbers, so the spreadsheets and loaders reading them
+// parse them as the dates and numbers they are
+type reportLocale struct {
+	// dateTime is the time.Format layout of timestamps
+	dateTime string
+	// decimal separates the integer part of a decimal number from its fraction
+	decimal string
+}
+
+// reportLocales holds the locales accepted by -locale
+var reportLocales = map[string]reportLocale{
+	"iso": {dateTime: "2006-01-02T15:04:05", decimal: "."},
+	"us":  {dateTime: "01/02/2006 15:04:05", decimal: "."},
+	"eu":  {dateTime: "02/01/2006 15:04:05", decimal: ","},
+}
+
+// locale is the reportLocale of the run's reports, ISO 8601 timestamps by default
+var locale = localeFlag("iso")
+
+// dateFormat, when set, is the time.Format layout of timestamps in place of the -locale's
+var dateFormat string
+
+// localeFlag is a flag.Value selecting a reportLocale by name
+type localeFlag string
+
+func (l *localeFlag) String() string {
+	return string(*l)
+}
+
+func (l *localeFlag) Set(v string) error {
+	if _, ok := reportLocales[strings.ToLower(v)]; !ok {
+		names := make([]string, 0, len(reportLocales))
+		for name := range reportLocales {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("unknown locale %q (available: %s)", v, strings.Join(names, ", "))
+	}
+	*l = localeFlag(strings.ToLower(v))
+	return nil
+}
+
+// formatTime writes a timestamp of a report
+func formatTime(t time.Time) string {
+	if len(dateFormat) > 0 {
+		return t.Format(dateFormat)
+	}
+	return t.Format(reportLocales[string(locale)].dateTime)
+}
+
+// formatDecimal writes a decimal number of a report, as strconv.FormatFloat does with the 'f' format
+func formatDecimal(f float64, prec int) string {
+	return strings.Replace(strconv.FormatFloat(f, 'f', prec, 64), ".", reportLocales[string(locale)].decimal, 1)
+}
+
+// parseDecimal reads a decimal number written by formatDecimal
+func parseDecimal(s string) (float64, error) {
+	return strconv.ParseFloat(strings.Replace(s, reportLocales[string(locale)].decimal, ".", 1), 64)
+}
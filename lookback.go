@@ -0,0 +1,157 @@
+package main
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/antlr/antlr4/runtime/Go/antlr"
+	parser "github.com/nycmonkey/sprocs/tsql"
+)
+
+func init() {
+	RegisterExtractor(lookbackExtractor{})
+}
+
+var (
+	// dateaddNow matches DATEADD(unit, -n, <current date>), the usual way of filtering to a recent window
+	dateaddNow = regexp.MustCompile(`(?i)DATEADD\s*\(\s*\[?(\w+)\]?\s*,\s*(-\s*\d+|\(\s*-\s*\d+\s*\))\s*,\s*(?:CAST\s*\(\s*|CONVERT\s*\(\s*\w+\s*,\s*)?(GETDATE\s*\(\s*\)|GETUTCDATE\s*\(\s*\)|SYSDATETIME\s*\(\s*\)|SYSUTCDATETIME\s*\(\s*\)|CURRENT_TIMESTAMP)`)
+	// nowMinus matches <current date> - n, which subtracts days
+	nowMinus = regexp.MustCompile(`(?i)(GETDATE\s*\(\s*\)|GETUTCDATE\s*\(\s*\)|CURRENT_TIMESTAMP)\s*-\s*(\d+)`)
+	localID  = regexp.MustCompile(`@\w+`)
+)
+
+// daysPerUnit approximates the length in days of each DATEADD datepart
+var daysPerUnit = map[string]float64{
+	"YEAR": 365, "YY": 365, "YYYY": 365,
+	"QUARTER": 91, "QQ": 91, "Q": 91,
+	"MONTH": 30, "MM": 30, "M": 30,
+	"WEEK": 7, "WK": 7, "WW": 7,
+	"DAY": 1, "DD": 1, "D": 1, "DAYOFYEAR": 1, "DY": 1, "Y": 1, "WEEKDAY": 1, "DW": 1, "W": 1,
+	"HOUR": 1.0 / 24, "HH": 1.0 / 24,
+	"MINUTE": 1.0 / 1440, "MI": 1.0 / 1440, "N": 1.0 / 1440,
+}
+
+// lookbackDays returns the number of days before now a relative date expression refers to
+func lookbackDays(expr string) (float64, bool) {
+	if m := dateaddNow.FindStringSubmatch(expr); m != nil {
+		unit, ok := daysPerUnit[strings.ToUpper(m[1])]
+		if !ok {
+			return 0, false
+		}
+		n, err := strconv.Atoi(strings.Trim(strings.Join(strings.Fields(m[2]), ""), "()-"))
+		if err != nil {
+			return 0, false
+		}
+		return float64(n) * unit, true
+	}
+	if m := nowMinus.FindStringSubmatch(expr); m != nil {
+		n, err := strconv.Atoi(m[2])
+		return float64(n), err == nil
+	}
+	return 0, false
+}
+
+// lookbackExtractor reports the filters restricting a column to a window relative to the current date, with the
+// length of the window in days. Variables set to such an expression are followed into the filters using them.
+type lookbackExtractor struct{}
+
+func (lookbackExtractor) Name() string {
+	return "lookback"
+}
+
+func (lookbackExtractor) Columns() []string {
+	return []string{"Table", "Column", "Operator", "Expression", "Lookback Days", "Line"}
+}
+
+func (lookbackExtractor) NewListener(sproc string, names *nameScope, emit func([]string)) antlr.ParseTreeListener {
+	l := &lookbackListener{emit: emit, vars: make(map[string]string)}
+	l.predicateListener = newPredicateListener(names, l.predicate)
+	return l
+}
+
+// Summarize writes lookback_summary.csv, the longest lookback window of each sproc
+func (lookbackExtractor) Summarize(rows [][]string) error {
+	longest := make(map[string]float64)
+	filters := make(map[string]int)
+	for _, row := range rows {
+		days, err := parseDecimal(row[5])
+		if err != nil {
+			continue
+		}
+		if days > longest[row[0]] || filters[row[0]] == 0 {
+			longest[row[0]] = days
+		}
+		filters[row[0]]++
+	}
+	sprocs := make([]string, 0, len(longest))
+	for sproc := range longest {
+		sprocs = append(sprocs, sproc)
+	}
+	sort.Strings(sprocs)
+	out := make([][]string, 0, len(sprocs))
+	for _, sproc := range sprocs {
+		out = append(out, []string{sproc, formatDays(longest[sproc]), strconv.Itoa(filters[sproc])})
+	}
+	return writeCSVFile("lookback_summary.csv", []string{"Stored Procedure", "Longest Lookback Days", "Relative Date Filters"}, out)
+}
+
+func formatDays(days float64) string {
+	return formatDecimal(days, -1)
+}
+
+// lookbackListener reads the filters found by a predicateListener, keeping those on a relative date
+type lookbackListener struct {
+	*predicateListener
+	emit func([]string)
+	// vars holds the relative date expressions assigned to each variable
+	vars map[string]string
+}
+
+// EnterDeclare_local is called when the parser enters a `declare_local` node
+func (l *lookbackListener) EnterDeclare_local(ctx *parser.Declare_localContext) {
+	if ctx.Expression() != nil {
+		l.assign(ctx.LOCAL_ID().GetText(), sourceText(ctx.Expression()))
+	}
+}
+
+// EnterSet_statement is called when the parser enters a `set_statement` node
+func (l *lookbackListener) EnterSet_statement(ctx *parser.Set_statementContext) {
+	if ctx.LOCAL_ID() != nil && ctx.Expression() != nil && ctx.Assignment_operator() == nil {
+		l.assign(ctx.LOCAL_ID().GetText(), sourceText(ctx.Expression()))
+	}
+}
+
+func (l *lookbackListener) assign(name, expr string) {
+	if _, ok := lookbackDays(expr); ok {
+		l.vars[strings.ToUpper(name)] = name + " = " + expr
+		return
+	}
+	delete(l.vars, strings.ToUpper(name))
+}
+
+// predicate receives each filter from the predicateListener: Table, Column, Operator, Value, Value Kind, Line
+func (l *lookbackListener) predicate(f []string) {
+	switch f[2] {
+	case ">", ">=", "!<", "BETWEEN":
+	default:
+		return
+	}
+	expr := f[3]
+	if f[2] == "BETWEEN" {
+		// only the lower bound sets the window
+		expr = strings.SplitN(expr, " AND ", 2)[0]
+	}
+	if days, ok := lookbackDays(expr); ok {
+		l.emit([]string{f[0], f[1], f[2], expr, formatDays(days), f[5]})
+		return
+	}
+	for _, v := range localID.FindAllString(expr, -1) {
+		if assigned, ok := l.vars[strings.ToUpper(v)]; ok {
+			days, _ := lookbackDays(assigned)
+			l.emit([]string{f[0], f[1], f[2], assigned, formatDays(days), f[5]})
+			return
+		}
+	}
+}
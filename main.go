@@ -6,18 +6,18 @@ import (
 	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
-	"strconv"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/antlr/antlr4/runtime/Go/antlr"
-	parser "github.com/nycmonkey/sprocs/tsql"
-	pb "gopkg.in/cheggaaa/pb.v1"
 
 	_ "github.com/denisenkom/go-mssqldb"
 )
@@ -32,55 +32,41 @@ const (
 )
 
 var (
-	dbHost       string
-	bar          *pb.ProgressBar
-	faster       bool
-	activeSprocQ = `
-select ROUTINE_NAME from BRS.information_schema.routines 
+	dbHost        string
+	dbName        string
+	dbUser        string
+	secretRef     string
+	logSQLEnabled bool
+	faster        bool
+	// deterministic buffers and sorts the rows of each report, so identical inputs give byte-identical reports
+	deterministic bool
+	activeSprocQ  = `
+select ROUTINE_SCHEMA, ROUTINE_NAME, 1 from information_schema.routines 
 where routine_type = 'PROCEDURE' 
 and Left(Routine_Name, 3) NOT IN ('sp_', 'xp_', 'ms_')
+union all
+select SCHEMA_NAME(o.schema_id), o.name, n.procedure_number from sys.numbered_procedures n
+join sys.objects o on o.object_id = n.object_id
+where n.procedure_number > 1
 `
 	sprocQ = `
 SELECT OBJECT_DEFINITION (OBJECT_ID(?))
 `
 	tableQ = `
-SELECT TABLE_NAME FROM BRS.INFORMATION_SCHEMA.Tables WHERE TABLE_SCHEMA = 'dbo'
+SELECT TABLE_NAME FROM INFORMATION_SCHEMA.Tables WHERE TABLE_SCHEMA = 'dbo'
 `
-	portfolioQ = `
-SELECT [PortfolioShortName]
-       ,[GuggenheimUnitShortName]
-       ,[RelationshipShortName]
-       ,[ClientShortName]
-       ,[AccountShortName]
-       ,[PortfolioCode]
-    
-  FROM [BRS].[dbo].[vw_AMPortfolioMaster]
-`
-	outDir                 string
-	whitelist              map[string]struct{}
-	portfolioShortNames    map[string]struct{}
-	clientShortNames       map[string]struct{}
-	businessUnitShortNames map[string]struct{}
-	relationshipShortNames map[string]struct{}
-	accountShortNames      map[string]struct{}
-	portfolioCodes         map[string]struct{}
+	outDir string
+	// logOutput is where log output goes; during a run it is also copied to the run's run.log
+	logOutput io.Writer = os.Stderr
+	// outRoot is the directory run directories are created in
+	outRoot   string
+	whitelist map[string]struct{}
 )
 
-// TreeShapeListener handles events from a TSQL parser generated by Antlr
-type TreeShapeListener struct {
-	*parser.BasetsqlListener
-	inProcDef    bool
-	info         *SprocInfo
-	tablesUsedCh chan<- string
-	idsUsedCh    chan<- string
-}
-
 // SprocInfo is a structure to record stored procedure metadata
 type SprocInfo struct {
-	Name    string
-	Tables  map[string]struct{}
-	Aliases map[string]struct{}
-	Codes   map[string]struct{}
+	Name  string
+	Codes map[string]struct{}
 }
 
 // emailAccount captures sproc report recipient details looked up by email address in CORP DB
@@ -95,6 +81,15 @@ type emailAccount struct {
 	DirectManager   string
 }
 
+// command is a subcommand of the sprocs executable, invoked as `sprocs <name> [args...]`
+type command struct {
+	summary string
+	run     func(args []string) error
+}
+
+// commands holds the registered subcommands, keyed by name
+var commands = make(map[string]command)
+
 type keyValue struct {
 	key, value string
 }
@@ -104,73 +99,358 @@ type errorListener struct {
 	*antlr.DefaultErrorListener
 	errCh     chan<- keyValue
 	sprocName string
+	// gaps is set when parsing the sprocs of a run, whose syntax errors are counted in unparsed_constructs.csv
+	gaps bool
 }
 
 func init() {
 	flag.StringVar(&dbHost, "host", "IL1TSTSQL10", "sproc database host server name")
-	flag.BoolVar(&faster, "fast", false, "use a faster but less error-tolerant parsing strategy")
+	flag.StringVar(&dbName, "database", "BRS", "sproc database name")
+	flag.StringVar(&snapshotName, "snapshot", "", "query this database snapshot of -database instead, checking first that it is one (implies -read-only)")
+	flag.BoolVar(&requireReadOnly, "read-only", false, "refuse to run unless the database is read-only, such as a readable secondary of an availability group or a snapshot")
+	flag.StringVar(&sprocListPath, "sprocs", "", "file of the sprocs to analyze instead of every active one, one [schema.]name[;number] per line")
+	flag.StringVar(&sourcePath, "source", "", "read sprocs and tables from this .dacpac file or SSDT project directory instead of the database")
+	flag.StringVar(&outRoot, "out", "", "directory in which the run directory is created (default: the working directory)")
+	flag.Var(&locale, "locale", "how reports write timestamps and decimal numbers: iso (2006-01-02T15:04:05, 1.5), us (01/02/2006 15:04:05, 1.5) or eu (02/01/2006 15:04:05, 1,5)")
+	flag.StringVar(&dateFormat, "date-format", "", "Go time layout of report timestamps, in place of the -locale's")
+	flag.StringVar(&dbUser, "user", "", "SQL login to connect as (default: integrated Windows authentication)")
+	flag.StringVar(&secretRef, "secret", "", "password for -user, fetched at runtime from vault://<path>#<field> or azurekv://<vault>/<secret>")
+	flag.Var(driverFlag{}, "driver", "database/sql driver to connect with: mssql, sqlserver (connecting by sqlserver:// URL), odbc, with -host naming an ODBC data source, or postgres, with -host as host[:port]")
+	flag.StringVar(&portfolioView, "portfolio-view", portfolioView, "[schema.]name of the account master view or table the account master keys are loaded from")
+	flag.Var(portfolioColumnsFlag{}, "portfolio-columns", "comma-separated account master columns to load keys from, each holding integers marked with :int")
+	flag.Var(&staleReference, "stale-reference", "what to do when the account master or a -dictionary looks stale (no keys, a column without keys, or far fewer keys than the previous run): warn or fail")
+	flag.Float64Var(&maxKeyDrop, "max-key-drop", maxKeyDrop, "fraction of a dictionary's keys that may disappear since the previous run before -stale-reference applies")
+	flag.Var(&connOptions.intent, "application-intent", "ApplicationIntent of the connection: ReadOnly, routed to a readable secondary when there is one, or ReadWrite")
+	flag.Var(&connOptions.isolation, "isolation", "transaction isolation level of the metadata queries: read-uncommitted, read-committed, repeatable-read, snapshot or serializable (default: the server's)")
+	flag.IntVar(&connOptions.lockTimeout, "lock-timeout", connOptions.lockTimeout, "milliseconds a query waits for a lock before failing; -1 waits indefinitely")
+	flag.Var(&connOptions.connectTimeout, "connect-timeout", "`seconds`, up to 65535, to wait for a connection to the server (default 15; not with -driver odbc)")
+	flag.Var(&connOptions.queryTimeout, "query-timeout", "`seconds`, up to 65535, a query waits for the server to send or accept data before failing, each time rather than in all; under -driver postgres, the most a whole query may take (default 30; not with -driver odbc)")
+	flag.BoolVar(&logSQLEnabled, "log-sql", false, "log executed SQL statements and parameters (credentials are redacted)")
+	flag.Var(&parseOrder, "order", "comma-separated order to fetch and parse sprocs in, each breaking the ties of the last, instead of by name: priority (of the -owners rule), executions (since cached), size")
+	flag.DurationVar(&maxDuration, "max-duration", 0, "stop fetching and parsing sprocs once a run has taken this long, e.g. 2h, and write the reports of those done; the manifest marks the rest (default no limit)")
+	flag.Uint64Var(&minFreeMB, "min-free-mb", minFreeMB, "refuse to start a run with less than this many megabytes free for the run directory")
+	flag.BoolVar(&deterministic, "deterministic", false, "sort the rows of every report so runs over identical inputs produce identical files")
+	addParseFlags(flag.CommandLine)
+	flag.Var(pluginFlag{}, "plugin", "register an external extractor as name=command [args...] (repeatable)")
+	flag.Var(dictionaryFlag{}, "dictionary", "match keys from name=query:<SQL> or name=file:<path.csv>, reported in <name>_codes.csv (repeatable)")
+	flag.Var(candidatePatternFlag{}, "candidate-pattern", "report string literals matching this regexp that are not account master keys in candidate_codes.csv")
+	flag.Var(new(fuzzyFlag), "fuzzy", "report near misses of account master keys (one edit, ignoring case and spaces) in fuzzy_codes.csv")
+	flag.Var(new(literalsFlag), "literals", "report every string literal with its line and enclosing statement type in literals.csv")
+	flag.Var(new(piiFlag), "pii", "scan string literals for email addresses, SSNs, card and account numbers, reported in pii_findings.csv")
+	flag.Var(piiPatternsFlag{}, "pii-patterns", "JSON file of {name, regex, severity} patterns to scan literals for instead of the -pii defaults")
+	flag.Var(new(showplanFlag), "showplan", "capture each sproc's estimated execution plan and compare its objects with the parser's in plan_objects.csv")
+	flag.Var(verifySampleFlag{}, "verify-sample", "check the tables found in this many sprocs against sys.dm_sql_referenced_entities, reporting agreement in reference_check.csv and reference_check_summary.csv")
+	flag.Var(new(diagnosticsFlag), "grammar-diagnostics", "record the parser's ambiguity and context sensitivity reports for each sproc in grammar_diagnostics.csv (slower; overrides -fast)")
+	flag.Var(policyFlag{}, "policy", "YAML policy file of the tables sprocs may reference; violations are reported in policy_violations.csv")
+	flag.BoolVar(&leastPrivilege, "least-privilege", false, "refuse to run with a login holding more than the permissions a run needs, such as db_owner or INSERT")
+	flag.StringVar(&graphFilter.sprocs, "graph-sprocs", "", "comma-separated globs of the procedures to keep in graph.json and -plantuml diagrams (default all)")
+	flag.StringVar(&graphFilter.schemas, "graph-schemas", "", "comma-separated schemas of the tables to keep in graph.json and -plantuml diagrams (default all)")
+	flag.StringVar(&graphFilter.exclude, "graph-exclude", "", "comma-separated globs of procedures and tables to leave out of graph.json and -plantuml diagrams")
+	flag.StringVar(&graphFilter.root, "graph-root", "", "keep only what is linked to this procedure in graph.json and -plantuml diagrams, within -graph-depth links")
+	flag.IntVar(&graphFilter.depth, "graph-depth", 0, "how many links from -graph-root to keep (default no limit)")
+	flag.IntVar(&graphFilter.minDegree, "graph-min-degree", 0, "leave procedures and tables with fewer links than this out of graph.json and -plantuml diagrams")
+	flag.BoolVar(&plantUML, "plantuml", false, "write a PlantUML component diagram of each top-level sproc, its called procedures and their tables to plantuml/<sproc>.puml")
+	flag.Var(suppressionsFlag{}, "suppressions", "JSON file of [{sproc, report, table, value, reason}] findings to suppress, reported in suppressed_findings.csv instead; sprocs may also suppress their own with -- sprocs:ignore-table <table> or -- sprocs:ignore <report> [value]")
+	flag.Var(watchlistFlag{}, "watchlist", "JSON file of {tables, portfolios, notify} to watch: watchlist.csv reports only the sprocs using them, marking and notifying those new since the previous run")
+	flag.BoolVar(&failOnPolicy, "policy-fail", false, "fail the run if any sproc violates the -policy")
+	flag.Var(ownersFlag{}, "owners", "JSON file of {sprocs, team, contact, businessUnit, priority} annotations adding the owner of each sproc to every per-sproc report, with rollups by business unit in business_units.csv")
+	flag.Var(rulesFlag{}, "rules", "JSON rules file of regex or token patterns to report in findings.csv")
+	flag.StringVar(&openLineageURL, "openlineage", "", "post an OpenLineage run event per sproc to this endpoint after the run, e.g. http://marquez:5000/api/v1/lineage")
+	flag.StringVar(&resultsDB.host, "results-host", "", "after the run, also write every report to a table of -results-database on this server, numbering the run in AnalysisRuns")
+	flag.StringVar(&resultsDB.database, "results-database", resultsDB.database, "database of the -results-host tables, logged into as -user")
+	flag.StringVar(&resultsDB.schema, "results-schema", resultsDB.schema, "schema of the -results-host tables, created if missing")
+	flag.StringVar(&openLineageNamespace, "openlineage-namespace", "sprocs", "OpenLineage namespace of the sproc jobs")
+	flag.StringVar(&extractorNames, "extract", "", "comma-separated extractors to run (default all): "+extractorList())
 	whitelist = make(map[string]struct{})
-	portfolioShortNames = make(map[string]struct{})
-	clientShortNames = make(map[string]struct{})
-	businessUnitShortNames = make(map[string]struct{})
-	relationshipShortNames = make(map[string]struct{})
-	accountShortNames = make(map[string]struct{})
-	portfolioCodes = make(map[string]struct{})
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		if cmd, ok := commands[os.Args[1]]; ok {
+			if err := cmd.run(os.Args[2:]); err != nil {
+				log.Fatalln(err)
+			}
+			return
+		}
+	}
+	flag.Usage = usage
 	flag.Parse()
+	if err := flagsFromEnv(flag.CommandLine, envPrefix); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		usage()
+		os.Exit(2)
+	}
+	if err := analyze(); err != nil {
+		log.Fatalln(err)
+	}
+}
+
+// analyze runs the extractors over every sproc of the database on dbHost, writing the reports to a new run
+// directory
+func analyze() (err error) {
 	outDir = outDirPath()
 	defDir := filepath.Join(outDir, `sproc_definitions`)
-	err := os.MkdirAll(defDir, os.ModeDir)
+	err = os.MkdirAll(defDir, os.ModeDir)
 	if err != nil {
-		log.Fatalln("Couldn't create output directory:", err)
+		return fmt.Errorf("couldn't create output directory: %v", err)
 	}
+	if err = preflightOutput(outDir); err != nil {
+		return err
+	}
+	// keep a copy of everything logged during the run with its results
+	runLog, err := os.OpenFile(filepath.Join(outDir, "run.log"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("couldn't create run log: %v", err)
+	}
+	log.SetOutput(io.MultiWriter(logOutput, runLog))
+	defer func() {
+		log.SetOutput(logOutput)
+		if err != nil {
+			log.New(runLog, "", log.LstdFlags).Println(err)
+		}
+		runLog.Close()
+	}()
 	log.Println("Writing output to", outDir)
+	atomic.StoreInt64(&policyViolations, 0)
+	resetFailures()
+	resetGrammarGaps()
+	resetSuppressedFindings()
+	if err = loadReviewed(); err != nil {
+		return fmt.Errorf("couldn't read the reviewed findings: %v", err)
+	}
+	startBudget()
+	parsing = nil
+	extractors, err := selectExtractors(extractorNames)
+	if err != nil {
+		return err
+	}
+	// plugins are started up front, so one that can't be fails the run rather than each of its sprocs
+	for _, e := range extractors {
+		if s, ok := e.(interface{ Start() error }); ok {
+			if err = s.Start(); err != nil {
+				return err
+			}
+		}
+	}
 	sprocCh := make(chan keyValue)
-	tablesCh := make(chan []string, 1)
-	codesCh := make(chan []string, 1)
-	tablesHandled := make(chan struct{})
-	portfoliosHandled := make(chan struct{})
-	errorsHandled := make(chan struct{})
-	errCh := make(chan []string, 1)
-	go handleTables(tablesCh, tablesHandled)
-	go handleCodes(codesCh, portfoliosHandled)
-	go handleErrors(errCh, errorsHandled)
+	results := make(chan sprocResult, resultBuffer)
+	reportsWritten := make(chan struct{})
+	stats := newRunStats(extractors)
+	go writeReports(extractors, results, stats, reportsWritten)
 	wg := new(sync.WaitGroup)
 	for i := 0; i < 6; i++ {
 		// spin up a bunch of concurrent sproc parsing routines, and watch the CPU burn
 		wg.Add(1)
-		go handleSprocDetails(defDir, sprocCh, tablesCh, codesCh, errCh, wg)
+		go handleSprocDetails(defDir, sprocCh, extractors, results, wg)
+	}
+	queryErr := runSource()(defDir, sprocCh)
+	wg.Wait() // this can take a while
+	parsing.Finish()
+	close(results)
+	<-reportsWritten
+	for _, e := range extractors {
+		if c, ok := e.(io.Closer); ok {
+			if err = c.Close(); err != nil {
+				recordFailure(e.Name(), "closing extractor", err)
+			}
+		}
+	}
+	failures := summarizeFailures()
+	if queryErr != nil {
+		discardOutputs()
+		return fmt.Errorf("error querying %s: %v", sourceName(), queryErr)
+	}
+	if err = writeSummary(stats, failures); err != nil {
+		log.Println("error writing summary.csv:", err)
+	}
+	if err = writeBusinessUnits(stats); err != nil {
+		log.Println("error writing business_units.csv:", err)
+	}
+	if err = writeGrammarGaps(); err != nil {
+		log.Println("error writing unparsed_constructs.csv:", err)
+	}
+	if err = writeSuppressedFindings(); err != nil {
+		log.Println("error writing suppressed_findings.csv:", err)
+	}
+	if err = commitOutputs(); err != nil {
+		return fmt.Errorf("error saving reports: %v", err)
+	}
+	if err = recordSchemaDrift(); err == nil {
+		err = recordSignatureChanges()
+	}
+	if err == nil {
+		err = commitOutputs()
 	}
-	err = getSprocs(defDir, sprocCh)
 	if err != nil {
-		log.Fatalln("error querying", dbHost+":", err)
+		log.Println("error comparing the schema with the previous run:", err)
 	}
-	wg.Wait() // this can take a while
-	close(tablesCh)
-	close(errCh)
-	close(codesCh)
-	<-tablesHandled
-	<-errorsHandled
-	<-portfoliosHandled
-	bar.FinishPrint("All sprocs parsed")
+	if err = recordTransitiveDependencies(); err == nil {
+		err = commitOutputs()
+	}
+	if err != nil {
+		log.Println("error writing table_dependencies.csv:", err)
+	}
+	if err = recordInsertExecLineage(); err == nil {
+		err = commitOutputs()
+	}
+	if err != nil {
+		log.Println("error writing insert_exec_lineage.csv:", err)
+	}
+	if err = writePlantUML(); err == nil {
+		err = commitOutputs()
+	}
+	if err != nil {
+		log.Println("error writing PlantUML diagrams:", err)
+	}
+	if err = recordWatchlist(); err == nil {
+		err = commitOutputs()
+	}
+	if err != nil {
+		log.Println("error writing watchlist.csv:", err)
+	}
+	if runBudget.reached {
+		log.Println("Run stopped at -max-duration; manifest.csv lists the sprocs left out")
+	} else {
+		log.Println("All sprocs parsed")
+	}
+	if len(resultsDB.host) > 0 {
+		if err = writeResultsDB(outDir); err != nil {
+			log.Println("error writing the reports to", resultsDB.host+":", err)
+		}
+	}
+	if len(openLineageURL) > 0 {
+		if err = emitOpenLineage(outDir); err != nil {
+			log.Println("error sending OpenLineage events:", err)
+		}
+	}
+	if failOnPolicy && atomic.LoadInt64(&policyViolations) > 0 {
+		return errPolicyViolated
+	}
+	return nil
 }
 
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s [flags]\n       %s <command> [args...]\n\nFlags:\n", os.Args[0], os.Args[0])
+	flag.PrintDefaults()
+	fmt.Fprintln(os.Stderr, "\nFlags not given may be set in the environment: SPROCS_HOST for -host, SPROCS_SERVE_ADDR for serve -addr.")
+	if len(commands) == 0 {
+		return
+	}
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Fprintln(os.Stderr, "\nCommands:")
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-12s %s\n", name, commands[name].summary)
+	}
+}
+
+// outDirPath names the directory of a run <date>T<time>_<source>, so runs of a target every few hours don't
+// overwrite each other
 func outDirPath() string {
-	return fmt.Sprintf("%s_%s", time.Now().Format(`2006-01-02`), dbHost)
+	return filepath.Join(outRoot, fmt.Sprintf("%s_%s", time.Now().Format(`2006-01-02T150405`), runSourceName()))
+}
+
+// runSourceName names the source in the directory of a run: <host>@<database> for a database, so runs of two databases
+// on one host are told apart, otherwise sourceName
+func runSourceName() string {
+	if len(sourcePath) == 0 && len(dbName) > 0 {
+		return dbHost + "@" + dbName
+	}
+	return sourceName()
+}
+
+// sourceName names where the sprocs of a run come from: the database host, or the dacpac or project read instead
+func sourceName() string {
+	if len(sourcePath) > 0 {
+		return strings.TrimSuffix(filepath.Base(filepath.Clean(sourcePath)), filepath.Ext(sourcePath))
+	}
+	return dbHost
+}
+
+// connString builds the DSN for the sproc database, resolving the login password from a secret backend when one is configured
+func connString() (string, error) {
+	return connStringFor(dbHost, connDatabase(dbName), dbUser, secretRef)
+}
+
+// connStringFor builds the DSN for a database on host, logging in as user when one is given
+func connStringFor(host, database, user, secret string) (string, error) {
+	if _, odbc := sqlDriver.(odbcDriver); odbc && (connOptions.connectTimeout > 0 || connOptions.queryTimeout > 0) {
+		return "", errors.New("-connect-timeout and -query-timeout can't be used with -driver odbc, whose connection strings have no keys for them")
+	}
+	var password string
+	if len(user) > 0 && len(secret) > 0 {
+		var err error
+		if password, err = resolveSecret(secret); err != nil {
+			return "", err
+		}
+	}
+	return sqlDriver.dsn(host, database, user, password), nil
+}
+
+// writeCSVFile writes a complete report to the named file in the output directory
+func writeCSVFile(name string, header []string, rows [][]string) error {
+	f, err := createOutput(name)
+	if err != nil {
+		return err
+	}
+	w := csv.NewWriter(f)
+	w.UseCRLF = true
+	w.Write(safeRow(ownerHeader(header)))
+	for _, row := range rows {
+		if len(owners) > 0 && header[0] == "Stored Procedure" {
+			row = ownerRow(row)
+		}
+		w.Write(safeRow(row))
+	}
+	w.Flush()
+	if err = w.Error(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// sortRows orders rows by their first column, then their second, and so on
+func sortRows(rows [][]string) {
+	sort.Slice(rows, func(i, j int) bool {
+		a, b := rows[i], rows[j]
+		for k := 0; k < len(a) && k < len(b); k++ {
+			if a[k] != b[k] {
+				return a[k] < b[k]
+			}
+		}
+		return len(a) < len(b)
+	})
 }
 
 func getSprocs(defDir string, outCh chan<- keyValue) error {
 	log.Println("Querying", dbHost)
 	defer close(outCh)
-	db, err := sql.Open("mssql", "server="+dbHost+";database=BRS;ApplicationIntent=ReadOnly")
+	dsn, err := connString()
 	if err != nil {
-		log.Fatalln(err)
+		return err
+	}
+	db, err := openSessionDB(dsn)
+	if err != nil {
+		return err
 	}
 	defer db.Close()
+	if err = checkReadOnly(db); err != nil {
+		return err
+	}
+	if err = checkPermissions(db); err != nil {
+		return err
+	}
+	if err = checkSourceQueries(db); err != nil {
+		return err
+	}
 	log.Println("Fetching list of known tables")
-	fmt.Println(tableQ)
+	// the whitelist is built aside and swapped in, as `sprocs serve` may be parsing with the previous one
+	known := make(map[string]struct{})
+	logSQL(tableQ)
 	rows, err := db.Query(tableQ)
 	if err != nil {
 		return err
@@ -181,474 +461,413 @@ func getSprocs(defDir string, outCh chan<- keyValue) error {
 			rows.Close()
 			return err
 		}
-		whitelist[strings.ToUpper(strings.TrimSpace(tableName))] = struct{}{}
+		known[strings.ToUpper(strings.TrimSpace(tableName))] = struct{}{}
 	}
 	rows.Close()
+	whitelist = known
 	log.Println("Loaded table whitelist with", len(whitelist), "values")
-
-	log.Println("Fetching account / portfolio identifiers")
-	{
-		rows, err := db.Query(portfolioQ)
+	err = loadReferenceData(func() error {
+		if err := loadCatalog(db); err != nil {
+			return err
+		}
+		log.Println("Fetching account / portfolio identifiers")
+		portfolios.reset()
+		portfolios.query = portfolioQuery()
+		if err := portfolios.loadQuery(db); err != nil {
+			return err
+		}
+		log.Println("Loaded account master with", portfolios.size(), "keys")
+		return loadDictionaries(db)
+	})
+	if err != nil {
+		return err
+	}
+	if err = checkReferenceData(append([]*dictionary{portfolios}, dictionaries...)); err != nil {
+		return err
+	}
+	var sprocNames []string
+	if len(sprocListPath) > 0 {
+		if sprocNames, err = readSprocList(sprocListPath, sprocListEntry); err != nil {
+			return err
+		}
+	} else {
+		log.Println("Looking up active stored procedures")
+		logSQL(metadataQuery(activeSprocQ))
+		rows, err = db.Query(metadataQuery(activeSprocQ))
 		if err != nil {
 			return err
 		}
-		var count int
-		var psn, gusn, rsn, csn, asn sql.NullString
-		var pc sql.NullInt64
+		defer rows.Close()
 		for rows.Next() {
-			if err = rows.Scan(&psn, &gusn, &rsn, &csn, &asn, &pc); err != nil {
+			var (
+				schema, sprocName sql.NullString
+				number            int
+			)
+			if err = rows.Scan(&schema, &sprocName, &number); err != nil {
 				rows.Close()
 				return err
 			}
-			if psn.Valid && len(strings.TrimSpace(psn.String)) > 0 {
-				portfolioShortNames[psn.String] = struct{}{}
-			}
-			if gusn.Valid && len(strings.TrimSpace(gusn.String)) > 0 {
-				businessUnitShortNames[gusn.String] = struct{}{}
-			}
-			if rsn.Valid && len(strings.TrimSpace(rsn.String)) > 0 {
-				relationshipShortNames[rsn.String] = struct{}{}
-			}
-			if csn.Valid && len(strings.TrimSpace(csn.String)) > 0 {
-				clientShortNames[csn.String] = struct{}{}
-			}
-			if asn.Valid && len(strings.TrimSpace(asn.String)) > 0 {
-				accountShortNames[asn.String] = struct{}{}
-			}
-			if pc.Valid {
-				portfolioCodes[fmt.Sprintf("%d", pc.Int64)] = struct{}{}
+			if sprocName.Valid {
+				sprocNames = append(sprocNames, sprocEntry(schema.String, sprocName.String, number))
 			}
-			count++
 		}
 		rows.Close()
-		log.Println("Loaded", count, "account master rows")
 	}
-	log.Println("Looking up active stored procedures")
-	fmt.Println(activeSprocQ)
-	rows, err = db.Query(activeSprocQ)
-	if err != nil {
+	log.Println("Found", len(sprocNames), "active stored procedures")
+	if sprocNames, err = skipExternalSprocs(db, sprocNames); err != nil {
 		return err
 	}
-	defer rows.Close()
-	var sprocNames []string
-	for rows.Next() {
-		var sprocName sql.NullString
-		if err = rows.Scan(&sprocName); err != nil {
-			rows.Close()
-			return err
-		}
-		if sprocName.Valid {
-			sprocNames = append(sprocNames, sprocName.String)
-		}
+	if err = writeSprocProperties(db, sprocNames); err != nil {
+		return err
+	}
+	if err = loadSprocDates(db); err != nil {
+		return err
+	}
+	if err = writeExecutePermissions(db, sprocNames); err != nil {
+		return err
 	}
-	rows.Close()
-	log.Println("Found", len(sprocNames), "active stored procedures")
 	var def sql.NullString
 
 	// fetch sproc definitions
 	log.Println("Fetching stored procedure definitions")
-	fmt.Println(sprocQ)
-	validIndices := make([]int, 0, len(sprocNames))
+	defs := make([]keyValue, 0, len(sprocNames))
+	fetching := startPhase("Fetching", len(sprocNames))
+	defer fetching.Finish()
+	loadExecutionCounts(db)
+	pending := make([]keyValue, len(sprocNames))
 	for i, sn := range sprocNames {
-		err := db.QueryRow(sprocQ, `BRS.dbo.`+sn).Scan(&def)
-		if err != nil {
+		pending[i].key = sn
+	}
+	prioritize(pending)
+	sample := sampleSprocs(sprocNames)
+	var unfetched []string
+	for i, p := range pending {
+		if outOfTime(len(pending) - i) {
+			for _, p := range pending[i:] {
+				unfetched = append(unfetched, p.key)
+			}
+			break
+		}
+		sn := p.key
+		name, number := sprocObject(sn)
+		if number > 1 {
+			logSQL(numberedSprocQ, name, number)
+			err = db.QueryRow(sqlDriver.bind(numberedSprocQ), name, number).Scan(&def)
+		} else {
+			logSQL(metadataQuery(sprocQ), name)
+			err = db.QueryRow(sqlDriver.bind(metadataQuery(sprocQ)), name).Scan(&def)
+		}
+		fetching.Increment()
+		if err == sql.ErrNoRows {
+			def.Valid = false
+		} else if err != nil {
 			return errors.New("error while querying definition of " + sn + ": " + err.Error())
 		}
 		if !def.Valid {
 			log.Println("No definition found for", sn)
 			continue
 		}
-		validIndices = append(validIndices, i)
-		var f *os.File
-		f, err = os.Create(filepath.Join(defDir, strings.Replace(sn, "/", "_", -1)+".sql"))
-		if err != nil {
-			return err
+		defs = append(defs, keyValue{key: sn, value: def.String})
+		if showplan.enabled {
+			if err = capturePlan(db, sn); err != nil {
+				log.Println("Couldn't capture plan for", sn+":", err)
+			}
 		}
-		_, err = f.WriteString(def.String)
-		f.Close()
-		if err != nil {
-			return err
+		if sample[sn] {
+			if err = captureReferences(db, sn); err != nil {
+				log.Println("Couldn't check the references of", sn+":", err)
+			}
 		}
 	}
 	db.Close()
-	log.Println("Found and saved defintions for", len(validIndices), "of", len(sprocNames), "active stored procedures")
-	log.Println("Starting parsing phase (this can take a while)...")
-
-	// initiate progress bar
-	bar = pb.New(len(validIndices))
-	bar.ShowFinalTime = true
-	bar.ShowBar = true
-	bar.SetMaxWidth(80)
-	bar.Start()
-
-	for _, i := range validIndices {
-		var def []byte
-		def, err = ioutil.ReadFile(filepath.Join(defDir, strings.Replace(sprocNames[i], "/", "_", -1)+".sql"))
-		if err != nil {
-			return err
-		}
-		outCh <- keyValue{key: sprocNames[i], value: string(def)}
-	}
-	return nil
+	fetching.Finish()
+	return sendDefinitions(defDir, defs, len(sprocNames), unfetched, outCh)
 }
 
-func handleTables(ch <-chan []string, done chan<- struct{}) {
-	f, err := os.Create(filepath.Join(outDir, "table_sources.csv"))
-	if err != nil {
-		log.Fatalln(err)
+// sendDefinitions snapshots the reference data the source loaded and saves the definitions of a run's sprocs,
+// along with their hashes, then sends each to be parsed until the run is out of time. The manifest of their files
+// records which were analyzed, and the sprocs left unfetched for lack of time.
+func sendDefinitions(defDir string, defs []keyValue, found int, unfetched []string, outCh chan<- keyValue) error {
+	if err := writeReferenceSnapshot(); err != nil {
+		return err
 	}
-	defer f.Close()
-	w := csv.NewWriter(f)
-	w.UseCRLF = true
-	w.Write([]string{"Stored Procedure", "Table Used"})
-	for row := range ch {
-		w.Write(row)
+	normDir := filepath.Join(outDir, `sproc_definitions_normalized`)
+	if err := os.MkdirAll(normDir, os.ModeDir); err != nil {
+		return err
 	}
-	w.Flush()
-	done <- struct{}{}
-}
-
-func handleCodes(ch <-chan []string, done chan<- struct{}) {
-	f, err := os.Create(filepath.Join(outDir, "codes.csv"))
-	if err != nil {
-		log.Fatalln(err)
+	sprocNames := make([]string, len(defs))
+	for i, d := range defs {
+		sprocNames[i] = d.key
+	}
+	// files are named in name order, whatever the order of parsing
+	sort.Strings(sprocNames)
+	files := assignDefFiles(sprocNames)
+	prioritize(defs)
+	for i, d := range defs {
+		sprocNames[i] = d.key
+	}
+	hashes := [][]string{}
+	saved := make(map[string]bool, len(defs))
+	for _, d := range defs {
+		if err := ioutil.WriteFile(filepath.Join(defDir, files[d.key]), []byte(d.value), 0644); err != nil {
+			recordFailure(d.key, "saving definition", err)
+		} else {
+			saved[d.key] = true
+		}
+		norm := normalizeTSQL(d.value)
+		if err := ioutil.WriteFile(filepath.Join(normDir, files[d.key]), []byte(norm), 0644); err != nil {
+			recordFailure(d.key, "saving normalized definition", err)
+		}
+		hashes = append(hashes, []string{d.key, hashText(d.value), hashText(norm)})
 	}
-	defer f.Close()
-	w := csv.NewWriter(f)
-	w.UseCRLF = true
-	w.Write([]string{"Stored Procedure", "Account Master Column", "Account Master Value"})
-	for row := range ch {
-		w.Write(row)
+	sortRows(hashes)
+	err := writeCSVFile("definition_hashes.csv", []string{"Stored Procedure", "Definition Hash", "Normalized Hash"}, hashes)
+	if err != nil {
+		return err
 	}
-	w.Flush()
-	done <- struct{}{}
-}
+	log.Println("Found and saved defintions for", len(defs), "of", found, "active stored procedures")
+	log.Println("Starting parsing phase (this can take a while)...")
 
-func handleErrors(ch <-chan []string, done chan<- struct{}) {
-	f, err := os.Create(filepath.Join(outDir, "parsing_errors.csv"))
-	if err != nil {
-		log.Fatalln(err)
+	parsing = startPhase("Parsing", len(defs))
+
+	sent := 0
+	for i, sn := range sprocNames {
+		if outOfTime(len(sprocNames) - i) {
+			break
+		}
+		sent++
+		if !saved[sn] {
+			// parse what was fetched, even though it couldn't be saved
+			outCh <- defs[i]
+			continue
+		}
+		def, err := ioutil.ReadFile(filepath.Join(defDir, files[sn]))
+		if err != nil {
+			recordFailure(sn, "reading saved definition", err)
+			def = []byte(defs[i].value)
+		}
+		outCh <- keyValue{key: sn, value: string(def)}
 	}
-	defer f.Close()
-	w := csv.NewWriter(f)
-	w.UseCRLF = true
-	w.Write([]string{"Stored Procedure", "Error Count"})
-	counts := make(map[string]int)
-	for row := range ch {
-		counts[row[0]]++
+	manifest := [][]string{}
+	for i, sn := range sprocNames {
+		status := statusAnalyzed
+		if i >= sent {
+			status = statusSkipped
+		}
+		if saved[sn] {
+			manifest = append(manifest, []string{sn, files[sn], status})
+		}
 	}
-	for proc, count := range counts {
-		w.Write([]string{proc, strconv.Itoa(count)})
+	for _, sn := range unfetched {
+		manifest = append(manifest, []string{sn, "", statusNotFetched})
 	}
-	w.Flush()
-	done <- struct{}{}
+	sortRows(manifest)
+	return writeCSVFile(manifestFile, []string{"Stored Procedure", "Definition File", "Status"}, manifest)
 }
 
-func handleSprocDetails(defDir string, inCh <-chan keyValue, outCh chan<- []string, idCh chan<- []string, errCh chan<- []string, done *sync.WaitGroup) {
+func handleSprocDetails(defDir string, inCh <-chan keyValue, extractors []Extractor, results chan<- sprocResult, done *sync.WaitGroup) {
 	for s := range inCh {
-		errors, tables, identifiers := parseSproc(s)
-		for _, e := range errors {
-			errCh <- []string{s.key, e}
-		}
-		for _, t := range tables {
-			outCh <- []string{s.key, t}
-		}
-		for _, id := range identifiers {
-			idCh <- []string{s.key, id.col, id.val}
-		}
-		bar.Increment()
+		errors, findings := analyzeSproc(s, extractors)
+		findings = suppressFindings(s, findings)
+		results <- sprocResult{sproc: s.key, errors: errors, findings: findings}
+		parsing.Increment()
 	}
 	done.Done()
 }
 
+// analyzeSproc parses a sproc with the extractors' listeners. A panic while doing so is recorded as a failure of
+// the run and a parsing error of the sproc, and none of its findings are reported, as they may be incomplete.
+func analyzeSproc(s keyValue, extractors []Extractor) (errors []string, findings map[string][][]string) {
+	defer func() {
+		if r := recover(); r != nil {
+			recordFailure(s.key, "analyzing", r)
+			errors, findings = append(errors, fmt.Sprint("panic: ", r)), nil
+		}
+	}()
+	return parseSproc(s, runNames, extractors, true)
+}
+
 func removeBrackets(in string) string {
 	return strings.TrimPrefix(strings.TrimSuffix(in, "]"), "[")
 }
 
-func normalizeTableName(in string) (out string) {
+// nameScope is what the table and procedure names of a sproc are resolved against: the sproc database, whose name
+// is left out of the names of its objects, and the whitelist of its tables, which lets every table through when
+// empty. A nil scope is that of the run, reading dbName and whitelist as they are when it is used.
+type nameScope struct {
+	database  string
+	whitelist map[string]struct{}
+}
+
+// runNames resolves names as the run does
+var runNames *nameScope
+
+// snapshotNames returns a scope holding the current sproc database and whitelist, which is swapped rather than
+// changed when a run loads another
+func snapshotNames() *nameScope {
+	return &nameScope{dbName, whitelist}
+}
+
+func (s *nameScope) db() string {
+	if s == nil {
+		return dbName
+	}
+	return s.database
+}
+
+func (s *nameScope) tables() map[string]struct{} {
+	if s == nil {
+		return whitelist
+	}
+	return s.whitelist
+}
+
+func (s *nameScope) normalizeTableName(in string) (out string) {
 	elems := strings.Split(strings.ToUpper(strings.TrimSpace(in)), ".")
 	switch len(elems) {
 	case 0:
 		// nothin' here, send back empty string
-		log.Fatalln("missing table name")
+		panic("missing table name")
 	case 1, 2:
 		// assumption: it's just the table name or dbo.table_name
 		out = removeBrackets(elems[len(elems)-1])
-	case 3:
+	case 3, 4:
+		// database.schema.table, or server.database.schema.table when written to through a linked server
 		var normalizedElems []string
 		for _, elem := range elems {
 			normalizedElems = append(normalizedElems, removeBrackets(elem))
 		}
-		if normalizedElems[0] == `BRS` {
+		if normalizedElems[0] == strings.ToUpper(s.db()) {
 			out = normalizedElems[2]
 		} else {
 			out = strings.Join(normalizedElems, ".")
 		}
 	default:
-		log.Fatalln("unhandled table name format:", in)
+		// a panic rather than an exit, so a caller parsing untrusted input can recover and carry on
+		panic("unhandled table name format: " + in)
 	}
 	return
 }
 
+// schemaTableName names a table with its schema, which normalizeTableName leaves out for the tables of the sproc
+// database: SCHEMA.TABLE for those, dbo when no schema is given, and the others as normalizeTableName names them
+func (s *nameScope) schemaTableName(in string) string {
+	elems := strings.Split(strings.ToUpper(strings.TrimSpace(in)), ".")
+	for i, elem := range elems {
+		elems[i] = removeBrackets(elem)
+	}
+	switch {
+	case len(elems) == 1:
+		return "DBO." + elems[0]
+	case len(elems) == 3 && elems[0] == strings.ToUpper(s.db()), len(elems) == 2:
+		schema := elems[len(elems)-2]
+		if len(schema) == 0 {
+			schema = "DBO"
+		}
+		return schema + "." + elems[len(elems)-1]
+	}
+	return s.normalizeTableName(in)
+}
+
 func (l *errorListener) SyntaxError(recognizer antlr.Recognizer, offendingSymbol interface{}, line, column int, msg string, e antlr.RecognitionException) {
+	if l.gaps {
+		recordGrammarGap(l.sprocName, recognizer, offendingSymbol)
+	}
 	l.errCh <- keyValue{key: l.sprocName, value: fmt.Sprintf("Line: %d, Column: %d, Error: %s", line, column, msg)}
 }
 
-func newErrorListener(ch chan<- keyValue, sprocName string) *errorListener {
+func newErrorListener(ch chan<- keyValue, sprocName string, gaps bool) *errorListener {
 	return &errorListener{
 		antlr.NewDefaultErrorListener(),
 		ch,
 		sprocName,
+		gaps,
 	}
 }
 
-type identifier struct {
-	col string
-	val string
-}
-
 // parse sproc runs the dumped sproc definition through a parser generated by antlr
 // using the TSQL grammar definition from https://github.com/antlr/grammars-v4/tree/master/tsql
-// The TreeShapeListener definied in this package extends the default listener generated by antlr to capture the
-// data we care about.  Similarly, the ErrorListener defined in this package receives and handles parsing errors.
-// The caller specifies channels to receive a stream of tables used and errors encountered during parsing. The key of
-// the sproc parameter is the (string) name of the stored procedure, and the value is the (string) text of the sproc
-// defintion
-func parseSproc(sproc keyValue) (errors, tables []string, identifiers []identifier) {
-	tCh := make(chan string)
-	idCh := make(chan string)
-	eCh := make(chan keyValue)
-	wg := new(sync.WaitGroup)
-	wg.Add(3)
-	go func(ch <-chan keyValue) {
-		for err := range ch {
-			errors = append(errors, err.value)
-		}
-		wg.Done()
-	}(eCh)
-	go func(ch <-chan string) {
-		for table := range ch {
-			tables = append(tables, table)
-		}
-		wg.Done()
-	}(tCh)
-	go func(ch <-chan string) {
-		for id := range ch {
-			kv := strings.Split(id, `:`)
-			identifiers = append(identifiers, identifier{col: kv[0], val: kv[1]})
-		}
-		wg.Done()
-	}(idCh)
-	input := antlr.NewInputStream(sproc.value)
-	lexer := parser.NewtsqlLexer(input)
-	stream := antlr.NewCommonTokenStream(lexer, 0)
-	p := parser.NewtsqlParser(stream)
-	p.RemoveErrorListeners()
-	errL := newErrorListener(eCh, sproc.key)
-	p.AddErrorListener(errL)
-	p.BuildParseTrees = true
-	if faster {
-		p.GetInterpreter().SetPredictionMode(antlr.PredictionModeSLL)
-	}
-	tree := p.Tsql_file()
-	antlr.ParseTreeWalkerDefault.Walk(NewTreeShapeListener(tCh, idCh), tree)
-	close(tCh)
-	close(idCh)
-	close(eCh)
-	wg.Wait()
+// Each Extractor contributes a listener that extends the default listener generated by antlr to capture the
+// data it cares about.  Similarly, the ErrorListener defined in this package receives and handles parsing errors.
+// The findings of each extractor are returned keyed by extractor name, along with the errors encountered during
+// parsing. The key of the sproc parameter is the (string) name of the stored procedure, and the value is the
+// (string) text of the sproc defintion. The names of tables and procedures are resolved against names, and the
+// syntax errors are counted in unparsed_constructs.csv when gaps is set.
+func parseSproc(sproc keyValue, names *nameScope, extractors []Extractor, gaps bool) (errors []string, findings map[string][][]string) {
+	findings = make(map[string][][]string, len(extractors))
+	listeners := make([]antlr.ParseTreeListener, 0, len(extractors))
+	for _, e := range extractors {
+		name := e.Name()
+		listeners = append(listeners, e.NewListener(sproc.key, names, func(finding []string) {
+			findings[name] = append(findings[name], finding)
+		}))
+	}
+	errors = walkTSQL(sproc.key, sproc.value, gaps, listeners)
 	return
 }
 
-// NewSprocInfo returns a data structure ready to record stored procedure metadata from a listener
-func NewSprocInfo() *SprocInfo {
-	return &SprocInfo{
-		Tables:  make(map[string]struct{}),
-		Aliases: make(map[string]struct{}),
-		Codes:   make(map[string]struct{}),
-	}
+// parseTSQL runs text through the generated TSQL parser, walks the resulting tree with each of the given listeners,
+// and returns the syntax errors reported along the way
+func parseTSQL(name, text string, listeners ...antlr.ParseTreeListener) (errors []string) {
+	return walkTSQL(name, text, false, listeners)
 }
 
-// NewTreeShapeListener returns an allocated TreeShapeListener
-func NewTreeShapeListener(tablesCh, identifiersCh chan<- string) *TreeShapeListener {
-	return &TreeShapeListener{
-		&parser.BasetsqlListener{},
-		false,
-		NewSprocInfo(),
-		tablesCh,
-		identifiersCh,
+// walkTSQL is parseTSQL, also counting the syntax errors in unparsed_constructs.csv when gaps is set
+func walkTSQL(name, text string, gaps bool, listeners []antlr.ParseTreeListener) (errors []string) {
+	tree, _, errors := parseTree(name, text, gaps)
+	if tree == nil {
+		return
 	}
-}
-
-// EnterTable_name is called when the parser enters a `table_name` node,
-// which includes the name of the table from whcih data is sourced
-func (l *TreeShapeListener) EnterTable_name(ctx *parser.Table_nameContext) {
-	n := normalizeTableName(strings.TrimSpace(ctx.GetText()))
-	if len(n) > 0 {
-		l.info.Tables[n] = struct{}{}
+	for _, l := range listeners {
+		antlr.ParseTreeWalkerDefault.Walk(l, tree)
 	}
+	return
 }
 
-// EnterSimple_id is called when the parser enters a `simple_id` node
-func (l *TreeShapeListener) EnterSimple_id(ctx *parser.Simple_idContext) {
-	id := strings.TrimSpace(ctx.GetText())
-	var ok bool
-	if _, ok = portfolioShortNames[id]; ok {
-		l.info.Codes[portfolioCode+":"+id] = struct{}{}
-	}
-	if _, ok = businessUnitShortNames[id]; ok {
-		l.info.Codes[guggenheimUnitShortName+":"+id] = struct{}{}
-	}
-	if _, ok = relationshipShortNames[id]; ok {
-		l.info.Codes[relationshipShortName+":"+id] = struct{}{}
-	}
-	if _, ok = clientShortNames[id]; ok {
-		l.info.Codes[clientShortName+":"+id] = struct{}{}
-	}
-	if _, ok = accountShortNames[id]; ok {
-		l.info.Codes[accountShortName+":"+id] = struct{}{}
-	}
-	if _, ok = portfolioCodes[id]; ok {
-		l.info.Codes[portfolioCode+":"+id] = struct{}{}
-	}
+// parserPanic starts the error reported when the generated parser panics
+const parserPanic = "Parser panic: "
+
+// parseTSQLTree runs text through the generated TSQL parser and returns the resulting parse tree, the parser
+// itself (for rule and token names when rendering the tree) and the syntax errors reported along the way. If the
+// parser panics, the tree is nil and the panic is the last error.
+func parseTSQLTree(name, text string) (tree antlr.ParseTree, recog antlr.Parser, errors []string) {
+	return parseTree(name, text, false)
 }
 
-// EnterConstant is called when the parser enters a `simple_id` node
-func (l *TreeShapeListener) EnterConstant(ctx *parser.ConstantContext) {
-	id := strings.TrimSpace(ctx.GetText())
-	id = strings.TrimPrefix(id, `'`)
-	id = strings.TrimSuffix(id, `'`)
-	var ok bool
-	if _, ok = portfolioShortNames[id]; ok {
-		l.info.Codes[portfolioCode+":"+id] = struct{}{}
-	}
-	if _, ok = businessUnitShortNames[id]; ok {
-		l.info.Codes[guggenheimUnitShortName+":"+id] = struct{}{}
-	}
-	if _, ok = relationshipShortNames[id]; ok {
-		l.info.Codes[relationshipShortName+":"+id] = struct{}{}
-	}
-	if _, ok = clientShortNames[id]; ok {
-		l.info.Codes[clientShortName+":"+id] = struct{}{}
-	}
-	if _, ok = accountShortNames[id]; ok {
-		l.info.Codes[accountShortName+":"+id] = struct{}{}
-	}
-	if _, ok = portfolioCodes[id]; ok {
-		l.info.Codes[portfolioCode+":"+id] = struct{}{}
-	}
-	// handle suffix wildcards
-	if strings.HasSuffix(id, "%") {
-		id = strings.TrimSuffix(id, "%")
-		for k := range portfolioShortNames {
-			if strings.HasPrefix(k, id) {
-				l.info.Codes[portfolioCode+":"+id] = struct{}{}
-			}
-		}
-		for k := range businessUnitShortNames {
-			if strings.HasPrefix(k, id) {
-				l.info.Codes[guggenheimUnitShortName+":"+id] = struct{}{}
-			}
-		}
-		for k := range relationshipShortNames {
-			if strings.HasPrefix(k, id) {
-				l.info.Codes[relationshipShortName+":"+id] = struct{}{}
-			}
-		}
-		for k := range clientShortNames {
-			if strings.HasPrefix(k, id) {
-				l.info.Codes[clientShortName+":"+id] = struct{}{}
-			}
-		}
-		for k := range accountShortNames {
-			if strings.HasPrefix(k, id) {
-				l.info.Codes[accountShortName+":"+id] = struct{}{}
-			}
-		}
-		for k := range portfolioCodes {
-			if strings.HasPrefix(k, id) {
-				l.info.Codes[portfolioCode+":"+id] = struct{}{}
-			}
-		}
-	}
-	// handle prefix wildcards
-	if strings.HasPrefix(id, "%") {
-		id = strings.TrimPrefix(id, "%")
-		for k := range portfolioShortNames {
-			if strings.HasSuffix(k, id) {
-				l.info.Codes[portfolioCode+":"+id] = struct{}{}
-			}
-		}
-		for k := range businessUnitShortNames {
-			if strings.HasSuffix(k, id) {
-				l.info.Codes[guggenheimUnitShortName+":"+id] = struct{}{}
-			}
-		}
-		for k := range relationshipShortNames {
-			if strings.HasSuffix(k, id) {
-				l.info.Codes[relationshipShortName+":"+id] = struct{}{}
-			}
-		}
-		for k := range clientShortNames {
-			if strings.HasSuffix(k, id) {
-				l.info.Codes[clientShortName+":"+id] = struct{}{}
-			}
-		}
-		for k := range accountShortNames {
-			if strings.HasSuffix(k, id) {
-				l.info.Codes[accountShortName+":"+id] = struct{}{}
-			}
+// parseTree is parseTSQLTree, also counting the syntax errors in unparsed_constructs.csv when gaps is set
+func parseTree(name, text string, gaps bool) (tree antlr.ParseTree, recog antlr.Parser, errors []string) {
+	eCh := make(chan keyValue)
+	done := make(chan struct{})
+	go func(ch <-chan keyValue) {
+		for err := range ch {
+			errors = append(errors, err.value)
 		}
-		for k := range portfolioCodes {
-			if strings.HasSuffix(k, id) {
-				l.info.Codes[portfolioCode+":"+id] = struct{}{}
+		close(done)
+	}(eCh)
+	g := dialect.grammar()
+	input := antlr.NewInputStream(text)
+	lexer := g.newLexer(input)
+	stream := antlr.NewCommonTokenStream(lexer, 0)
+	errL := newErrorListener(eCh, name, gaps)
+	func() {
+		// the generated parser and lexer can panic on malformed input, e.g. indexing out of range; that is a
+		// failure to parse this text, not a reason to stop parsing everything else
+		defer func() {
+			if r := recover(); r != nil {
+				tree = nil
+				eCh <- keyValue{key: name, value: fmt.Sprint(parserPanic, r)}
 			}
-		}
-	}
-}
-
-// EnterTable_alias is called when the parser enters a `table_alias` node,
-// which is pulled into a list of table references to ignore
-func (l *TreeShapeListener) EnterTable_alias(ctx *parser.Table_aliasContext) {
-	n := normalizeTableName(strings.TrimSpace(ctx.GetText()))
-	if len(n) > 0 {
-		l.info.Aliases[strings.ToUpper(n)] = struct{}{}
-	}
+		}()
+		tree, recog = g.parse(stream, errL)
+	}()
+	close(eCh)
+	<-done
+	return
 }
 
-// ExitTsql_file is called when the parser reaches the end of the TSQL input,
-// at which point the table names used are analyzed and sent down a channel
-func (l *TreeShapeListener) ExitTsql_file(ctx *parser.Tsql_fileContext) {
-	seen := make(map[string]struct{})
-	for table := range l.info.Tables {
-		if strings.HasPrefix(table, "#") {
-			continue
-		}
-		_, ok := l.info.Aliases[strings.ToUpper(table)]
-		if ok {
-			// skip it - it's an alias
-			continue
-		}
-		_, ok = seen[strings.ToUpper(table)]
-		if ok {
-			// skip it - it's a dupe
-			continue
-		}
-		seen[strings.ToUpper(table)] = struct{}{}
-		if strings.Contains(table, ".") {
-			// no need to check the whitelist -- this table refers to a DB other than BRS
-			l.tablesUsedCh <- table
-			continue
-		}
-
-		// check to see if the table is in the whitelist populated during getSprocs()
-		_, ok = whitelist[strings.ToUpper(table)]
-		if !ok {
-			// skip it -- it's not in the whitelist
-			continue
-		}
-		l.tablesUsedCh <- table
-	}
-	for code := range l.info.Codes {
-		l.idsUsedCh <- code
+// NewSprocInfo returns a data structure ready to record stored procedure metadata from a listener
+func NewSprocInfo() *SprocInfo {
+	return &SprocInfo{
+		Codes: make(map[string]struct{}),
 	}
 }
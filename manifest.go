@@ -0,0 +1,97 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// manifestFile maps each sproc of a run to the file in sproc_definitions holding its definition
+const manifestFile = "manifest.csv"
+
+// maxDefFileName caps the length in bytes of a definition file name, leaving room for the run directory in paths
+const maxDefFileName = 120
+
+// reservedFileNames can't be used as file names on Windows, whatever the extension
+var reservedFileNames = map[string]bool{"CON": true, "PRN": true, "AUX": true, "NUL": true}
+
+func init() {
+	for i := 1; i <= 9; i++ {
+		reservedFileNames["COM"+strconv.Itoa(i)] = true
+		reservedFileNames["LPT"+strconv.Itoa(i)] = true
+	}
+}
+
+// defFileName returns the name of the file a sproc definition is saved to: the sproc name with characters invalid
+// on Windows replaced, shortened with a hash of the full name if it is too long
+func defFileName(sprocName string) string {
+	name := strings.Map(func(r rune) rune {
+		if r < ' ' || strings.ContainsRune(`<>:"/\|?*`, r) {
+			return '_'
+		}
+		return r
+	}, sprocName)
+	name = strings.TrimRight(name, ". ")
+	if base := strings.ToUpper(strings.SplitN(name, ".", 2)[0]); len(name) == 0 || reservedFileNames[base] {
+		name = "_" + name
+	}
+	if len(name) > maxDefFileName {
+		cut := maxDefFileName - 9
+		for !utf8.RuneStart(name[cut]) {
+			cut--
+		}
+		name = name[:cut] + "~" + hashText(sprocName)[:8]
+	}
+	return name + ".sql"
+}
+
+// assignDefFiles gives each sproc a definition file name, numbering those that would otherwise collide on a
+// case-insensitive file system
+func assignDefFiles(sprocNames []string) map[string]string {
+	files := make(map[string]string, len(sprocNames))
+	taken := make(map[string]bool, len(sprocNames))
+	for _, sn := range sprocNames {
+		name := defFileName(sn)
+		for i := 2; taken[strings.ToUpper(name)]; i++ {
+			name = strings.TrimSuffix(defFileName(sn), ".sql") + "~" + strconv.Itoa(i) + ".sql"
+		}
+		taken[strings.ToUpper(name)] = true
+		files[sn] = name
+	}
+	return files
+}
+
+// readManifest returns the definition file of each sproc of the run in runDir, leaving out those a run stopped by
+// -max-duration didn't fetch. Runs made before the manifest was written have none, and an empty map is returned
+// for them, as it is for an empty manifest.
+func readManifest(runDir string) (map[string]string, error) {
+	files := make(map[string]string)
+	records, err := readCSVFile(filepath.Join(runDir, manifestFile))
+	if os.IsNotExist(err) {
+		return files, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return files, nil
+	}
+	for _, rec := range records[1:] {
+		if len(rec) >= 2 && len(rec[1]) > 0 {
+			files[rec[0]] = rec[1]
+		}
+	}
+	return files, nil
+}
+
+// definitionPath returns the path of the file in a run's definition directory holding a sproc's definition
+func definitionPath(defDir, sproc string) string {
+	if files, err := readManifest(filepath.Dir(defDir)); err == nil {
+		if name, ok := files[sproc]; ok {
+			return filepath.Join(defDir, name)
+		}
+	}
+	return filepath.Join(defDir, defFileName(sproc))
+}
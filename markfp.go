@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+func init() {
+	commands["mark-fp"] = command{
+		summary: "record a finding of a run as a false positive, suppressing it in later runs",
+		run:     markFP,
+	}
+}
+
+// reviewedFile records the verdicts of reviewers on findings, alongside the run directories, so every later run
+// under the same -out learns from them
+const reviewedFile = "reviewed_findings.csv"
+
+// reviewedHeader is the header of reviewedFile
+var reviewedHeader = []string{"Run", "Stored Procedure", "Report", "Finding", "Verdict", "Reason", "Reviewed"}
+
+const (
+	verdictFalsePositive = "false positive"
+	verdictTruePositive  = "true positive"
+)
+
+// reviewed holds the findings marked false positives, as suppressions, loaded at the start of each run
+var reviewed []suppression
+
+// markFP records a reviewer's verdict on a finding of a run: the finding is looked up in the run's report so
+// typos aren't recorded, then appended to reviewedFile
+func markFP(args []string) error {
+	fs := flag.NewFlagSet("mark-fp", flag.ExitOnError)
+	root := fs.String("root", ".", "directory holding run directories, where the verdicts are kept")
+	report := fs.String("report", portfolios.name, "report the finding is in")
+	reason := fs.String("reason", "", "why the finding is a false positive")
+	undo := fs.Bool("undo", false, "record the finding as a true positive after all, so later runs report it again")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, `Usage: sprocs mark-fp [flags] <run> <sproc> <finding>
+
+Records a finding of a run as a false positive. Later runs under -root leave it out of its report, listing it in
+suppressed_findings.csv instead. The run is the name of its directory, and the finding a value of the report's
+row, or its values joined by " | " as suppressed_findings.csv shows them. For example:
+
+  sprocs mark-fp -reason "a ticker, not a portfolio" 2024-03-01T060000_IL1TSTSQL10@Positions dbo.usp_Load ABC
+
+Flags:`)
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	if fs.NArg() != 3 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	run, sproc, finding := fs.Arg(0), fs.Arg(1), fs.Arg(2)
+	dir := filepath.Join(*root, run)
+	if !isRunDir(dir) {
+		return fmt.Errorf("no run %s in %s", run, *root)
+	}
+	name := strings.TrimSuffix(*report, ".csv")
+	records, err := readCSVFile(filepath.Join(dir, name+".csv"))
+	if err != nil {
+		return err
+	}
+	if len(records) > 0 {
+		records = records[1:]
+	}
+	// findings already marked were left out of the report, so are looked up among the suppressed ones too
+	suppressed, err := readCSVFile(filepath.Join(dir, "suppressed_findings.csv"))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for i, rec := range suppressed {
+		if i > 0 && len(rec) >= 3 && strings.EqualFold(rec[1], name) {
+			records = append(records, append([]string{rec[0]}, strings.Split(rec[2], " | ")...))
+		}
+	}
+	found := ""
+	for _, rec := range records {
+		if len(rec) < 2 || !strings.EqualFold(rec[0], sproc) {
+			continue
+		}
+		if strings.EqualFold(strings.Join(rec[1:], " | "), finding) {
+			sproc, found = rec[0], strings.Join(rec[1:], " | ")
+			break
+		}
+		for _, cell := range rec[1:] {
+			if strings.EqualFold(cell, finding) {
+				sproc, found = rec[0], cell
+			}
+		}
+	}
+	if len(found) == 0 {
+		return fmt.Errorf("run %s has no finding %q for %s in %s.csv", run, finding, sproc, name)
+	}
+	verdict := verdictFalsePositive
+	if *undo {
+		verdict = verdictTruePositive
+	}
+	if err = appendReviewed(*root, []string{run, sproc, name, found, verdict, *reason, formatTime(time.Now())}); err != nil {
+		return err
+	}
+	fmt.Println("marked", found, "of", sproc, "in", name+".csv a", verdict)
+	return nil
+}
+
+// appendReviewed adds a verdict to the reviewedFile of root, creating it with its header
+func appendReviewed(root string, row []string) error {
+	path := filepath.Join(root, reviewedFile)
+	_, err := os.Stat(path)
+	isNew := os.IsNotExist(err)
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	w := csv.NewWriter(f)
+	w.UseCRLF = true
+	if isNew {
+		w.Write(reviewedHeader)
+	}
+	w.Write(safeRow(row))
+	w.Flush()
+	if err = w.Error(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// loadReviewed reads the verdicts kept alongside the run directories into reviewed, the latest verdict on each
+// finding deciding whether it is a false positive
+func loadReviewed() error {
+	records, err := readCSVFile(filepath.Join(outRoot, reviewedFile))
+	if os.IsNotExist(err) {
+		reviewed = nil
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	latest := make(map[string][]string)
+	var keys []string
+	for i, rec := range records {
+		if i == 0 || len(rec) < 6 {
+			continue
+		}
+		key := strings.ToUpper(rec[1] + "\x00" + rec[2] + "\x00" + rec[3])
+		if _, ok := latest[key]; !ok {
+			keys = append(keys, key)
+		}
+		latest[key] = rec
+	}
+	var loaded []suppression
+	for _, key := range keys {
+		rec := latest[key]
+		if rec[4] != verdictFalsePositive {
+			continue
+		}
+		s := suppression{Sproc: rec[1], Report: rec[2], Reason: rec[5], by: "marked false positive in " + rec[0]}
+		if strings.Contains(rec[3], " | ") {
+			s.row = rec[3]
+		} else {
+			s.Value = rec[3]
+		}
+		loaded = append(loaded, s)
+	}
+	reviewed = loaded
+	return nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/antlr/antlr4/runtime/Go/antlr"
+)
+
+func init() {
+	commands["hash"] = command{
+		summary: "print the normalized definition hash of T-SQL files",
+		run:     hashFiles,
+	}
+}
+
+func hashFiles(args []string) error {
+	fs := flag.NewFlagSet("hash", flag.ExitOnError)
+	show := fs.Bool("n", false, "print the normalized form instead of its hash")
+	addParseFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: sprocs hash [flags] <files...>\n\nFlags:")
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	for _, path := range fs.Args() {
+		def, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		norm := normalizeTSQL(string(def))
+		if *show {
+			fmt.Println(norm)
+			continue
+		}
+		fmt.Printf("%s  %s\n", hashText(norm), path)
+	}
+	return nil
+}
+
+// normalizeTSQL reduces a definition to its token sequence: comments are dropped, keywords are uppercased
+// and tokens are separated by a single space, so definitions differing only in layout normalize identically
+func normalizeTSQL(text string) string {
+	tokens, lexer := lexTSQL("", text, nil)
+	parts := make([]string, 0, len(tokens))
+	for _, t := range tokens {
+		if t.GetTokenType() == antlr.TokenEOF {
+			break
+		}
+		if t.GetChannel() != antlr.TokenDefaultChannel {
+			continue
+		}
+		txt := t.GetText()
+		if isKeyword(tokenName(lexer, t), txt) {
+			txt = strings.ToUpper(txt)
+		}
+		parts = append(parts, txt)
+	}
+	return strings.Join(parts, " ")
+}
+
+// hashText returns the hex-encoded SHA-256 digest of text
+func hashText(text string) string {
+	sum := sha256.Sum256([]byte(text))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,7 @@
+// +build odbc
+
+package main
+
+// the ODBC driver is only needed where servers accept nothing but ODBC data sources. It calls odbc32.dll on
+// Windows; elsewhere it is built with cgo against unixODBC, so needs its headers (unixodbc-dev) to build.
+import _ "github.com/alexbrainman/odbc"
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"log"
+	"net/url"
+	"time"
+)
+
+const (
+	openLineageProducer  = "https://github.com/nycmonkey/sprocs"
+	openLineageSchemaURL = "https://openlineage.io/spec/2-0-2/OpenLineage.json#/definitions/RunEvent"
+)
+
+var (
+	// openLineageURL is the endpoint lineage events are posted to after a run, e.g. Marquez's /api/v1/lineage
+	openLineageURL string
+	// openLineageNamespace is the namespace of the jobs, one per sproc, in the events
+	openLineageNamespace string
+)
+
+type olDataset struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+}
+
+type olRunEvent struct {
+	EventType string `json:"eventType"`
+	EventTime string `json:"eventTime"`
+	Run       struct {
+		RunID string `json:"runId"`
+	} `json:"run"`
+	Job struct {
+		Namespace string `json:"namespace"`
+		Name      string `json:"name"`
+	} `json:"job"`
+	Inputs    []olDataset `json:"inputs"`
+	Outputs   []olDataset `json:"outputs"`
+	Producer  string      `json:"producer"`
+	SchemaURL string      `json:"schemaURL"`
+}
+
+// emitOpenLineage posts a COMPLETE run event for every sproc of the run in dir, with the tables it reads as inputs
+// and the tables it writes as outputs
+func emitOpenLineage(dir string) error {
+	lin, err := loadLineage(dir)
+	if err != nil {
+		return err
+	}
+	u, err := url.Parse(openLineageURL)
+	if err != nil {
+		return err
+	}
+	tableNamespace := "sqlserver://" + runHost(dir) + ":1433"
+	now := time.Now().UTC().Format(time.RFC3339)
+	for _, sproc := range lin.sprocs {
+		var e olRunEvent
+		e.EventType, e.EventTime, e.Producer, e.SchemaURL = "COMPLETE", now, openLineageProducer, openLineageSchemaURL
+		if e.Run.RunID, err = newUUID(); err != nil {
+			return err
+		}
+		e.Job.Namespace, e.Job.Name = openLineageNamespace, sproc
+		e.Inputs, e.Outputs = []olDataset{}, []olDataset{}
+		for _, t := range lin.reads[sproc] {
+			e.Inputs = append(e.Inputs, olDataset{tableNamespace, t})
+		}
+		for _, t := range lin.writes[sproc] {
+			e.Outputs = append(e.Outputs, olDataset{tableNamespace, t})
+		}
+		if err = postJSON(u.String(), nil, e); err != nil {
+			return err
+		}
+	}
+	log.Println("Sent", len(lin.sprocs), "OpenLineage events to", u.Host)
+	return nil
+}
+
+// newUUID returns a random (version 4) UUID
+func newUUID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
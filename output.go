@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// minFreeMB is the space, in megabytes, the volume of the run directory must have free for a run to start
+var minFreeMB uint64 = 500
+
+// tmpSuffix marks a report file of a run that has not completed yet
+const tmpSuffix = ".tmp"
+
+// pendingOutputs are the paths of the report files of the current run, written under a temporary name until
+// commitOutputs renames them, so nothing reading the run directory sees a report before it is complete
+var pendingOutputs struct {
+	sync.Mutex
+	paths []string
+}
+
+// createOutput creates the named report file in the run directory under its temporary name
+func createOutput(name string) (*os.File, error) {
+	path := filepath.Join(outDir, name)
+	f, err := os.Create(path + tmpSuffix)
+	if err != nil {
+		return nil, err
+	}
+	pendingOutputs.Lock()
+	pendingOutputs.paths = append(pendingOutputs.paths, path)
+	pendingOutputs.Unlock()
+	return f, nil
+}
+
+// closeOutput closes a report file created by createOutput, recording a failure to do so
+func closeOutput(f *os.File) {
+	if err := f.Close(); err != nil {
+		recordFailure(filepath.Base(strings.TrimSuffix(f.Name(), tmpSuffix)), "closing report", err)
+	}
+}
+
+// commitOutputs flushes the report files of the run to disk, then renames each to its final name
+func commitOutputs() error {
+	pendingOutputs.Lock()
+	defer pendingOutputs.Unlock()
+	for _, path := range pendingOutputs.paths {
+		if err := syncFile(path + tmpSuffix); err != nil {
+			return err
+		}
+	}
+	for _, path := range pendingOutputs.paths {
+		if err := os.Rename(path+tmpSuffix, path); err != nil {
+			return err
+		}
+	}
+	pendingOutputs.paths = nil
+	// make the renames durable too; directories can't be synced on Windows, where this fails harmlessly
+	syncFile(outDir)
+	return nil
+}
+
+// discardOutputs removes the report files of a failed run
+func discardOutputs() {
+	pendingOutputs.Lock()
+	defer pendingOutputs.Unlock()
+	for _, path := range pendingOutputs.paths {
+		os.Remove(path + tmpSuffix)
+	}
+	pendingOutputs.paths = nil
+}
+
+func syncFile(path string) error {
+	flag := os.O_WRONLY
+	if !strings.HasSuffix(path, tmpSuffix) {
+		flag = os.O_RDONLY
+	}
+	f, err := os.OpenFile(path, flag, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return f.Sync()
+}
+
+// preflightOutput checks a run can save its reports in dir before any work is done: that a file can be written
+// there and its volume has at least -min-free-mb free. A full disk otherwise shows up only as truncated reports.
+func preflightOutput(dir string) error {
+	probe := filepath.Join(dir, ".preflight"+tmpSuffix)
+	if err := ioutil.WriteFile(probe, []byte("sprocs"), 0644); err != nil {
+		return fmt.Errorf("%s is not writable: %v", dir, err)
+	}
+	os.Remove(probe)
+	free, err := freeSpace(dir)
+	if err != nil {
+		return fmt.Errorf("couldn't check the free space of %s: %v", dir, err)
+	}
+	if free < minFreeMB<<20 {
+		return fmt.Errorf("only %d MB free for %s, less than -min-free-mb %d", free>>20, dir, minFreeMB)
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+)
+
+// ownerRule is one entry of the annotations file given to -owners
+type ownerRule struct {
+	// Sprocs is a case-insensitive glob pattern of the sprocs the team owns, e.g. "Report_*"
+	Sprocs  string `json:"sprocs"`
+	Team    string `json:"team"`
+	Contact string `json:"contact"`
+	// BusinessUnit is the unit the team belongs to, as named in the ETL configuration or CORP DB
+	BusinessUnit string `json:"businessUnit"`
+	// Priority orders the sprocs of the rule ahead of those with a lower one when -order includes priority
+	Priority int `json:"priority"`
+}
+
+// owners holds the -owners rules in file order; the first rule matching a sproc names its owner
+var owners []*ownerRule
+
+// ownersFlag is a flag.Value that loads the owners of sprocs from a JSON annotations file
+type ownersFlag struct{}
+
+func (ownersFlag) String() string {
+	return ""
+}
+
+func (ownersFlag) Set(path string) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var rules []*ownerRule
+	if err = json.Unmarshal(b, &rules); err != nil {
+		return fmt.Errorf("invalid owners file %s: %v", path, err)
+	}
+	for i, r := range rules {
+		if len(r.Sprocs) == 0 || len(r.Team) == 0 {
+			return fmt.Errorf("invalid owners file %s: entry %d needs sprocs and a team", path, i+1)
+		}
+	}
+	owners = rules
+	return nil
+}
+
+// ownerRuleOf returns the first rule matching a sproc, or nil if none does
+func ownerRuleOf(sproc string) *ownerRule {
+	for _, r := range owners {
+		if matchFold(r.Sprocs, sproc) {
+			return r
+		}
+	}
+	return nil
+}
+
+// ownerOf returns the team owning a sproc and how to contact it, or empty strings if no rule matches
+func ownerOf(sproc string) (team, contact string) {
+	if r := ownerRuleOf(sproc); r != nil {
+		return r.Team, r.Contact
+	}
+	return "", ""
+}
+
+// businessUnitOf returns the business unit owning a sproc, or an empty string if it isn't known
+func businessUnitOf(sproc string) string {
+	if r := ownerRuleOf(sproc); r != nil {
+		return r.BusinessUnit
+	}
+	return ""
+}
+
+// ownersHaveBusinessUnits reports whether any -owners rule names a business unit
+func ownersHaveBusinessUnits() bool {
+	for _, r := range owners {
+		if len(r.BusinessUnit) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// ownerHeader adds the owner columns to the header of a report with a row per sproc, when -owners is set, along
+// with the business unit when the owners name any
+func ownerHeader(header []string) []string {
+	if len(owners) == 0 || len(header) == 0 || header[0] != "Stored Procedure" {
+		return header
+	}
+	header = append(header[:len(header):len(header)], "Owner", "Owner Contact")
+	if ownersHaveBusinessUnits() {
+		header = append(header, "Business Unit")
+	}
+	return header
+}
+
+// ownerRow adds the owner of the sproc in the first column to a row of a report given to ownerHeader
+func ownerRow(row []string) []string {
+	if len(owners) == 0 || len(row) == 0 {
+		return row
+	}
+	r := ownerRuleOf(row[0])
+	if r == nil {
+		r = &ownerRule{}
+	}
+	row = append(row[:len(row):len(row)], r.Team, r.Contact)
+	if ownersHaveBusinessUnits() {
+		row = append(row, r.BusinessUnit)
+	}
+	return row
+}
+
+// errorNotice is posted to a target's Notify URL for each team owning sprocs that failed to parse
+type errorNotice struct {
+	Target  string   `json:"target"`
+	Run     string   `json:"run"`
+	Team    string   `json:"team"`
+	Contact string   `json:"contact"`
+	Sprocs  []string `json:"sprocs"`
+}
+
+// lastParseErrors holds the number of parsing errors of each sproc in the most recent run
+var lastParseErrors map[string]int
+
+// errorNotices groups the sprocs that failed to parse in the most recent run by owner. Sprocs without an owner
+// are grouped under an empty team.
+func errorNotices(target, run string) []errorNotice {
+	byTeam := make(map[string]*errorNotice)
+	var teams []string
+	for sproc := range lastParseErrors {
+		team, contact := ownerOf(sproc)
+		n, ok := byTeam[team]
+		if !ok {
+			n = &errorNotice{Target: target, Run: run, Team: team, Contact: contact}
+			byTeam[team] = n
+			teams = append(teams, team)
+		}
+		n.Sprocs = append(n.Sprocs, sproc)
+	}
+	sort.Strings(teams)
+	notices := make([]errorNotice, 0, len(teams))
+	for _, team := range teams {
+		sort.Strings(byTeam[team].Sprocs)
+		notices = append(notices, *byTeam[team])
+	}
+	return notices
+}
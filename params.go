@@ -0,0 +1,206 @@
+package main
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/antlr/antlr4/runtime/Go/antlr"
+	parser "github.com/nycmonkey/sprocs/tsql"
+)
+
+func init() {
+	RegisterExtractor(parameterExtractor{})
+}
+
+// parameterExtractor reports the parameters each sproc declares, in order
+type parameterExtractor struct{}
+
+func (parameterExtractor) Name() string {
+	return "parameters"
+}
+
+func (parameterExtractor) Columns() []string {
+	return []string{"Parameter", "Position", "Type", "Default", "Mode"}
+}
+
+func (parameterExtractor) NewListener(sproc string, names *nameScope, emit func([]string)) antlr.ParseTreeListener {
+	return &parameterListener{BasetsqlListener: &parser.BasetsqlListener{}, emit: emit}
+}
+
+type parameterListener struct {
+	*parser.BasetsqlListener
+	emit     func([]string)
+	position int
+}
+
+// EnterProcedure_param is called when the parser enters a `procedure_param` node
+func (l *parameterListener) EnterProcedure_param(ctx *parser.Procedure_paramContext) {
+	if _, ok := ctx.GetParent().(*parser.Create_or_alter_procedureContext); !ok {
+		return
+	}
+	// a malformed parameter list, such as one ending in a comma, leaves a parameter without a name or type
+	if ctx.LOCAL_ID() == nil || ctx.Data_type() == nil {
+		return
+	}
+	l.position++
+	typ := sourceText(ctx.Data_type())
+	if ctx.Id() != nil {
+		typ = sourceText(ctx.Id()) + "." + typ
+	}
+	var def, mode string
+	if ctx.GetDefault_val() != nil {
+		def = sourceText(ctx.GetDefault_val())
+	}
+	switch {
+	case ctx.OUT() != nil, ctx.OUTPUT() != nil:
+		mode = "OUTPUT"
+	case ctx.READONLY() != nil:
+		mode = "READONLY"
+	}
+	l.emit([]string{ctx.LOCAL_ID().GetText(), strconv.Itoa(l.position), normalizeType(typ), def, mode})
+}
+
+// normalizeType upper-cases a data type and drops its spaces, so decimal(18, 2) and DECIMAL(18,2) compare equal
+func normalizeType(t string) string {
+	return strings.ToUpper(strings.Join(strings.Fields(t), ""))
+}
+
+// sprocParam is a row of parameters.csv
+type sprocParam struct {
+	name, position, typ, def, mode string
+}
+
+// runParameters returns the parameters in the parameters.csv of a run keyed by sproc and upper-cased parameter
+// name, or nil if the run has none
+func runParameters(dir string) (map[string]map[string]sprocParam, error) {
+	records, err := readCSVFile(filepath.Join(dir, "parameters.csv"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	params := make(map[string]map[string]sprocParam)
+	if len(records) == 0 {
+		return params, nil
+	}
+	for _, rec := range records[1:] {
+		if len(rec) < 6 {
+			continue
+		}
+		if params[rec[0]] == nil {
+			params[rec[0]] = make(map[string]sprocParam)
+		}
+		params[rec[0]][strings.ToUpper(rec[1])] = sprocParam{rec[1], rec[2], rec[3], rec[4], rec[5]}
+	}
+	return params, nil
+}
+
+// parsedSignatures returns the sprocs of a run whose parameters it knows: those it analyzed without syntax errors
+// or a panic. The parameters of the others are missing or incomplete, rather than removed.
+func parsedSignatures(dir string) (map[string]bool, error) {
+	sprocs, err := runSprocs(dir)
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := readCSVFile(filepath.Join(dir, manifestFile))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for i, rec := range manifest {
+		if i > 0 && len(rec) >= 3 && rec[2] != statusAnalyzed {
+			delete(sprocs, rec[0])
+		}
+	}
+	for _, file := range []string{"parsing_errors.csv", "run_failures.csv"} {
+		records, err := readCSVFile(filepath.Join(dir, file))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for i, rec := range records {
+			if i > 0 && len(rec) >= 2 && (file == "parsing_errors.csv" || rec[1] == "analyzing") {
+				delete(sprocs, rec[0])
+			}
+		}
+	}
+	return sprocs, nil
+}
+
+// recordSignatureChanges compares the parameters of each sproc saved by both the current and the previous run,
+// writing the parameters added, removed or changed to signature_changes.csv. Callers passing parameters by
+// position, or without the ones added, break on most of these silently.
+func recordSignatureChanges() error {
+	prev := previousRun()
+	if len(prev) == 0 {
+		return nil
+	}
+	before, err := runParameters(prev)
+	if err != nil || before == nil {
+		return err
+	}
+	after, err := runParameters(outDir)
+	if err != nil || after == nil {
+		return err
+	}
+	sprocsBefore, err := parsedSignatures(prev)
+	if err != nil {
+		return err
+	}
+	sprocsAfter, err := parsedSignatures(outDir)
+	if err != nil {
+		return err
+	}
+	var rows [][]string
+	change := func(sproc, param, kind, was, is string, breaking bool) {
+		rows = append(rows, []string{sproc, param, kind, was, is, yesNo(breaking)})
+	}
+	for sproc := range sprocsAfter {
+		// a sproc that failed to parse or was skipped in either run has no signature to compare
+		if !sprocsBefore[sproc] {
+			continue
+		}
+		for key, p := range before[sproc] {
+			if _, ok := after[sproc][key]; !ok {
+				change(sproc, p.name, "parameter removed", p.typ, "", true)
+			}
+		}
+		for key, p := range after[sproc] {
+			was, ok := before[sproc][key]
+			if !ok {
+				change(sproc, p.name, "parameter added", "", p.typ, len(p.def) == 0)
+				continue
+			}
+			if was.typ != p.typ {
+				change(sproc, p.name, "type changed", was.typ, p.typ, true)
+			}
+			if was.position != p.position {
+				change(sproc, p.name, "position changed", was.position, p.position, true)
+			}
+			if was.mode != p.mode {
+				change(sproc, p.name, "mode changed", was.mode, p.mode, true)
+			}
+			if was.def != p.def {
+				change(sproc, p.name, "default changed", was.def, p.def, len(p.def) == 0)
+			}
+		}
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		for k := 0; k < 3; k++ {
+			if rows[i][k] != rows[j][k] {
+				return rows[i][k] < rows[j][k]
+			}
+		}
+		return false
+	})
+	for _, row := range rows {
+		log.Println("Signature change since", filepath.Base(prev)+":", row[0], row[1], row[2])
+	}
+	return writeCSVFile("signature_changes.csv", []string{"Stored Procedure", "Parameter", "Change", "Before", "After", "Breaking"}, rows)
+}
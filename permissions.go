@@ -0,0 +1,133 @@
+package main
+
+import (
+	"database/sql"
+	"strings"
+)
+
+const (
+	// executePermQ lists the EXECUTE permissions granted or denied on procedures, schemas and the database itself
+	executePermQ = `
+SELECT p.class, COALESCE(SCHEMA_NAME(o.schema_id), s.name, ''), COALESCE(o.name, ''), pr.name, p.state_desc
+FROM sys.database_permissions p
+JOIN sys.database_principals pr ON pr.principal_id = p.grantee_principal_id
+LEFT JOIN sys.objects o ON p.class = 1 AND o.object_id = p.major_id
+LEFT JOIN sys.schemas s ON p.class = 3 AND s.schema_id = p.major_id
+WHERE p.permission_name = 'EXECUTE' AND p.class IN (0, 1, 3)
+`
+	principalQ = `
+SELECT name, type_desc FROM sys.database_principals
+`
+	roleMemberQ = `
+SELECT r.name, m.name FROM sys.database_role_members rm
+JOIN sys.database_principals r ON r.principal_id = rm.role_principal_id
+JOIN sys.database_principals m ON m.principal_id = rm.member_principal_id
+`
+)
+
+// executeGrant is an EXECUTE permission on a procedure (scope "object"), a schema or the database
+type executeGrant struct {
+	scope, schema, name, grantee, state string
+}
+
+// writeExecutePermissions writes execute_permissions.csv, the principals able to execute, or denied executing,
+// each sproc to be analyzed, whether granted directly, through its schema or the database, or through membership
+// of a role, however deeply nested. Members of db_owner can execute every sproc.
+func writeExecutePermissions(db *sql.DB, sprocNames []string) error {
+	var grants []executeGrant
+	logSQL(executePermQ)
+	rows, err := db.Query(executePermQ)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var (
+			g     executeGrant
+			class int
+		)
+		if err = rows.Scan(&class, &g.schema, &g.name, &g.grantee, &g.state); err != nil {
+			rows.Close()
+			return err
+		}
+		switch class {
+		case 0:
+			g.scope = "database"
+		case 1:
+			g.scope = "object"
+		case 3:
+			g.scope = "schema"
+		}
+		grants = append(grants, g)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	types := make(map[string]string)
+	if err = queryPairs(db, principalQ, func(name, kind string) { types[name] = kind }); err != nil {
+		return err
+	}
+	members := make(map[string][]string)
+	if err = queryPairs(db, roleMemberQ, func(role, member string) { members[role] = append(members[role], member) }); err != nil {
+		return err
+	}
+	// holders expands a principal into itself and every member of it as a role, describing how each holds it
+	var holders func(principal, via string, seen map[string]bool, emit func(principal, via string))
+	holders = func(principal, via string, seen map[string]bool, emit func(principal, via string)) {
+		if seen[principal] {
+			return
+		}
+		seen[principal] = true
+		emit(principal, via)
+		for _, m := range members[principal] {
+			holders(m, via+", member of "+principal, seen, emit)
+		}
+	}
+	var out [][]string
+	for _, sn := range sprocNames {
+		schema, name := "dbo", strings.SplitN(sn, ";", 2)[0]
+		if i := strings.Index(name, "."); i >= 0 {
+			schema, name = name[:i], name[i+1:]
+		}
+		emit := func(state string) func(principal, via string) {
+			return func(principal, via string) {
+				out = append(out, []string{sn, principal, types[principal], state, via})
+			}
+		}
+		for _, g := range grants {
+			var via string
+			switch {
+			case g.scope == "object" && strings.EqualFold(g.schema, schema) && strings.EqualFold(g.name, name):
+				via = "granted on the procedure"
+			case g.scope == "schema" && strings.EqualFold(g.schema, schema):
+				via = "granted on schema " + g.schema
+			case g.scope == "database":
+				via = "granted on the database"
+			default:
+				continue
+			}
+			holders(g.grantee, via, make(map[string]bool), emit(g.state))
+		}
+		holders("db_owner", "db_owner role", make(map[string]bool), emit("GRANT"))
+	}
+	sortRows(out)
+	return writeCSVFile("execute_permissions.csv", []string{"Stored Procedure", "Principal", "Principal Type", "State", "Via"}, out)
+}
+
+// queryPairs runs a query of two string columns, passing each row to fn
+func queryPairs(db *sql.DB, q string, fn func(a, b string)) error {
+	logSQL(q)
+	rows, err := db.Query(q)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var a, b string
+		if err = rows.Scan(&a, &b); err != nil {
+			return err
+		}
+		fn(a, b)
+	}
+	return rows.Err()
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/antlr/antlr4/runtime/Go/antlr"
+	parser "github.com/nycmonkey/sprocs/tsql"
+)
+
+// piiPattern is one entry of the patterns file given to -pii-patterns
+type piiPattern struct {
+	Name     string `json:"name"`
+	Regex    string `json:"regex"`
+	Severity string `json:"severity"`
+
+	re *regexp.Regexp
+}
+
+// defaultPIIPatterns are scanned for by -pii unless -pii-patterns replaces them
+var defaultPIIPatterns = []*piiPattern{
+	{Name: "email address", Severity: severityWarning, re: regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)},
+	{Name: "SSN", Severity: severityError, re: regexp.MustCompile(`\b\d{3}-\d{2}-\d{4}\b`)},
+	{Name: "card number", Severity: severityError, re: regexp.MustCompile(`\b\d{4}[ -]?\d{4}[ -]?\d{4}[ -]?\d{4}\b`)},
+	{Name: "IBAN", Severity: severityWarning, re: regexp.MustCompile(`\b[A-Z]{2}\d{2}[A-Z0-9]{11,30}\b`)},
+	{Name: "account number", Severity: severityWarning, re: regexp.MustCompile(`\b\d{9,18}\b`)},
+}
+
+// pii holds the patterns string literals are scanned for once -pii or -pii-patterns is set
+var pii struct {
+	patterns   []*piiPattern
+	registered bool
+}
+
+// enablePII registers the piiExtractor, once
+func enablePII() {
+	if !pii.registered {
+		pii.registered = true
+		RegisterExtractor(piiExtractor{})
+	}
+	if pii.patterns == nil {
+		pii.patterns = defaultPIIPatterns
+	}
+}
+
+// piiFlag is a flag.Value that turns on scanning literals for the default PII patterns
+type piiFlag bool
+
+func (f *piiFlag) String() string {
+	return strconv.FormatBool(bool(*f))
+}
+
+func (f *piiFlag) IsBoolFlag() bool {
+	return true
+}
+
+func (f *piiFlag) Set(v string) error {
+	on, err := strconv.ParseBool(v)
+	if err != nil {
+		return err
+	}
+	if on {
+		enablePII()
+	}
+	*f = piiFlag(on)
+	return nil
+}
+
+// piiPatternsFlag is a flag.Value that loads the PII patterns from a JSON file and turns on scanning for them
+type piiPatternsFlag struct{}
+
+func (piiPatternsFlag) String() string {
+	return ""
+}
+
+func (piiPatternsFlag) Set(path string) error {
+	patterns, err := loadPIIPatterns(path)
+	if err != nil {
+		return err
+	}
+	pii.patterns = patterns
+	enablePII()
+	return nil
+}
+
+func loadPIIPatterns(path string) ([]*piiPattern, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var patterns []*piiPattern
+	if err = json.Unmarshal(b, &patterns); err != nil {
+		return nil, fmt.Errorf("invalid PII patterns file %s: %v", path, err)
+	}
+	for i, p := range patterns {
+		if len(p.Name) == 0 {
+			return nil, fmt.Errorf("invalid PII patterns file %s: pattern %d has no name", path, i+1)
+		}
+		if p.re, err = regexp.Compile(p.Regex); err != nil {
+			return nil, fmt.Errorf("invalid PII patterns file %s: pattern %s: %v", path, p.Name, err)
+		}
+		switch p.Severity {
+		case "":
+			p.Severity = severityWarning
+		case severityWarning, severityError:
+		default:
+			return nil, fmt.Errorf("invalid PII patterns file %s: pattern %s has unknown severity %q, not %s or %s", path, p.Name, p.Severity, severityWarning, severityError)
+		}
+	}
+	if len(patterns) == 0 {
+		return nil, errors.New("PII patterns file " + path + " has no patterns")
+	}
+	return patterns, nil
+}
+
+// piiExtractor reports the string literals of each sproc that look like personal or account data
+type piiExtractor struct{}
+
+func (piiExtractor) Name() string {
+	return "pii_findings"
+}
+
+func (piiExtractor) Columns() []string {
+	return []string{"Pattern", "Severity", "Line", "Statement", "Masked Value"}
+}
+
+func (piiExtractor) NewListener(sproc string, names *nameScope, emit func([]string)) antlr.ParseTreeListener {
+	return &piiListener{&parser.BasetsqlListener{}, emit}
+}
+
+type piiListener struct {
+	*parser.BasetsqlListener
+	emit func([]string)
+}
+
+// VisitTerminal is called for each token of the parse tree
+func (l *piiListener) VisitTerminal(node antlr.TerminalNode) {
+	lit, ok := unquoteString(node.GetText())
+	if !ok {
+		return
+	}
+	// a match is reported for the first pattern it meets, so a card number is not also an account number
+	var found [][]int
+	for _, p := range pii.patterns {
+	matches:
+		for _, loc := range p.re.FindAllStringIndex(lit, -1) {
+			for _, f := range found {
+				if loc[0] < f[1] && f[0] < loc[1] {
+					continue matches
+				}
+			}
+			found = append(found, loc)
+			l.emit([]string{p.Name, p.Severity, strconv.Itoa(node.GetSymbol().GetLine()), statementType(node), maskValue(lit[loc[0]:loc[1]])})
+		}
+	}
+}
+
+// maskValue hides all but the last four characters of a match, so the report does not spread the data it flags
+func maskValue(v string) string {
+	if len(v) <= 4 {
+		return strings.Repeat("*", len(v))
+	}
+	return strings.Repeat("*", len(v)-4) + v[len(v)-4:]
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/xml"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/antlr/antlr4/runtime/Go/antlr"
+	parser "github.com/nycmonkey/sprocs/tsql"
+)
+
+const paramQ = `
+SELECT name FROM sys.parameters WHERE object_id = OBJECT_ID(?) ORDER BY parameter_id
+`
+
+// planObject is a table accessed by an operator of an estimated execution plan
+type planObject struct {
+	table, index, operator string
+	cost                   float64
+}
+
+// showplan holds the estimated plans captured when -showplan is set, keyed by sproc
+var showplan = struct {
+	sync.Mutex
+	enabled bool
+	plans   map[string][]planObject
+}{plans: make(map[string][]planObject)}
+
+// showplanFlag is a flag.Value that turns on plan capture and registers the planExtractor reporting it
+type showplanFlag bool
+
+func (f *showplanFlag) String() string {
+	return strconv.FormatBool(bool(*f))
+}
+
+func (f *showplanFlag) IsBoolFlag() bool {
+	return true
+}
+
+func (f *showplanFlag) Set(v string) error {
+	on, err := strconv.ParseBool(v)
+	if err != nil {
+		return err
+	}
+	if on && !bool(*f) {
+		showplan.enabled = true
+		RegisterExtractor(planExtractor{})
+	}
+	*f = showplanFlag(on)
+	return nil
+}
+
+// capturePlan compiles a call of the sproc, passing NULL for every parameter, under SHOWPLAN_XML and records the
+// objects the estimated plan touches. Nothing is executed.
+func capturePlan(db *sql.DB, sproc string) error {
+	name, number := sprocObject(sproc)
+	if number > 1 {
+		// the procedures of a numbered group after the first have no parameters of their own in sys.parameters
+		return errors.New("plans of numbered procedures are not captured")
+	}
+	logSQL(paramQ, name)
+	rows, err := db.Query(sqlDriver.bind(paramQ), name)
+	if err != nil {
+		return err
+	}
+	var args []string
+	for rows.Next() {
+		var param string
+		if err = rows.Scan(&param); err != nil {
+			rows.Close()
+			return err
+		}
+		args = append(args, param+" = NULL")
+	}
+	rows.Close()
+	// SHOWPLAN_XML applies to the session, so the statements must share a connection, which is never handed back
+	// to the pool with it on: the next query on it would get a plan instead of its rows
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	logSQL("SET SHOWPLAN_XML ON")
+	if _, err = conn.ExecContext(ctx, "SET SHOWPLAN_XML ON"); err != nil {
+		return err
+	}
+	defer func() {
+		logSQL("SET SHOWPLAN_XML OFF")
+		if _, err := conn.ExecContext(ctx, "SET SHOWPLAN_XML OFF"); err != nil {
+			// returning driver.ErrBadConn from Raw closes the connection rather than pooling it
+			conn.Raw(func(interface{}) error { return driver.ErrBadConn })
+		}
+	}()
+	call := "EXEC " + name + " " + strings.Join(args, ", ")
+	logSQL(call)
+	rows, err = conn.QueryContext(ctx, call)
+	if err != nil {
+		return err
+	}
+	var objects []planObject
+	for rows.Next() {
+		var plan string
+		if err = rows.Scan(&plan); err != nil {
+			rows.Close()
+			return err
+		}
+		found, err := parsePlan(strings.NewReader(plan))
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		objects = append(objects, found...)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	showplan.Lock()
+	showplan.plans[sproc] = objects
+	showplan.Unlock()
+	return nil
+}
+
+// parsePlan returns the objects of every RelOp in a showplan XML document, attributed to the innermost operator
+func parsePlan(r io.Reader) ([]planObject, error) {
+	type relOp struct {
+		operator string
+		cost     float64
+	}
+	var (
+		objects []planObject
+		ops     []relOp
+	)
+	dec := xml.NewDecoder(r)
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return objects, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "RelOp":
+				op := relOp{operator: attr(t, "PhysicalOp")}
+				op.cost, _ = strconv.ParseFloat(attr(t, "EstimatedTotalSubtreeCost"), 64)
+				ops = append(ops, op)
+			case "Object":
+				table := attr(t, "Table")
+				if len(ops) == 0 || len(table) == 0 || strings.HasPrefix(table, "[#") || strings.HasPrefix(table, "[@") {
+					continue
+				}
+				name := table
+				if db := attr(t, "Database"); len(db) > 0 {
+					name = db + "." + attr(t, "Schema") + "." + table
+				}
+				op := ops[len(ops)-1]
+				objects = append(objects, planObject{runNames.normalizeTableName(name), removeBrackets(attr(t, "Index")), op.operator, op.cost})
+			}
+		case xml.EndElement:
+			if t.Name.Local == "RelOp" && len(ops) > 0 {
+				ops = ops[:len(ops)-1]
+			}
+		}
+	}
+}
+
+func attr(e xml.StartElement, name string) string {
+	for _, a := range e.Attr {
+		if a.Name.Local == name {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// planExtractor reports the tables in each sproc's estimated plan next to those found by the parser
+type planExtractor struct{}
+
+func (planExtractor) Name() string {
+	return "plan_objects"
+}
+
+func (planExtractor) Columns() []string {
+	return []string{"Table", "Index", "Operator", "Estimated Cost", "In Plan", "Found By Parser"}
+}
+
+func (planExtractor) NewListener(sproc string, names *nameScope, emit func([]string)) antlr.ParseTreeListener {
+	l := &planListener{sproc: sproc, emit: emit, parsed: make(map[string]bool)}
+	l.TableListener = NewTableListener(sproc, names, func(f []string) {
+		l.parsed[strings.ToUpper(f[0])] = true
+	})
+	return l
+}
+
+// planListener finds the tables a sproc uses like a TableListener, and compares them with its captured plan
+type planListener struct {
+	*TableListener
+	sproc  string
+	emit   func([]string)
+	parsed map[string]bool
+}
+
+// ExitTsql_file is called when the parser reaches the end of the TSQL input,
+// at which point the plan objects are emitted, followed by the parsed tables missing from the plan
+func (l *planListener) ExitTsql_file(ctx *parser.Tsql_fileContext) {
+	l.TableListener.ExitTsql_file(ctx)
+	showplan.Lock()
+	objects, captured := showplan.plans[l.sproc]
+	showplan.Unlock()
+	if !captured {
+		return
+	}
+	inPlan := make(map[string]bool)
+	for _, o := range objects {
+		inPlan[strings.ToUpper(o.table)] = true
+		l.emit([]string{o.table, o.index, o.operator, formatDecimal(o.cost, -1), "Y", yesNo(l.parsed[strings.ToUpper(o.table)])})
+	}
+	for table := range l.parsed {
+		if !inPlan[table] {
+			l.emit([]string{table, "", "", "", "N", "Y"})
+		}
+	}
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "Y"
+	}
+	return "N"
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// plantUML writes a PlantUML component diagram of each top-level sproc to the run's plantuml directory
+var plantUML bool
+
+// writePlantUML writes plantuml/<sproc>.puml for each top-level sproc of the run, one calling other procedures
+// without being called itself, from calls.csv and table_sources.csv. Each diagram shows the sproc, every procedure
+// it calls however deeply nested, and the tables each of them reads and writes, as left by the -graph filters.
+func writePlantUML() error {
+	if !plantUML {
+		return nil
+	}
+	calls, err := readCSVFile(filepath.Join(outDir, "calls.csv"))
+	if os.IsNotExist(err) || err == nil && len(calls) == 0 {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	sources, err := readCSVFile(filepath.Join(outDir, "table_sources.csv"))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	// procedures are keyed upper-cased, as they are called case-insensitively
+	names := make(map[string]string)
+	var edges []graphEdge
+	for _, rec := range calls[1:] {
+		if len(rec) < 2 {
+			continue
+		}
+		if p := strings.ToLower(rec[1]); strings.HasPrefix(p, "sp_") || strings.HasPrefix(p, "xp_") {
+			continue
+		}
+		edges = append(edges, graphEdge{rec[0], rec[1], true})
+	}
+	access := make(map[graphEdge]string)
+	if len(sources) > 0 {
+		for _, rec := range sources[1:] {
+			if len(rec) < 3 {
+				continue
+			}
+			e := graphEdge{rec[0], rec[1], false}
+			if _, ok := access[e]; !ok {
+				edges = append(edges, e)
+			}
+			access[e] = mergeAccess(access[e], rec[2])
+		}
+	}
+	callees := make(map[string][]string)
+	called := make(map[string]bool)
+	seen := make(map[string]bool)
+	tables := make(map[string]map[string]string)
+	for _, e := range pruneGraph(edges) {
+		proc := strings.ToUpper(e.sproc)
+		names[proc] = e.sproc
+		if !e.call {
+			if tables[proc] == nil {
+				tables[proc] = make(map[string]string)
+			}
+			tables[proc][e.target] = mergeAccess(tables[proc][e.target], access[e])
+			continue
+		}
+		callee := strings.ToUpper(e.target)
+		if _, ok := names[callee]; !ok {
+			names[callee] = e.target
+		}
+		if !seen[proc+"\x00"+callee] {
+			seen[proc+"\x00"+callee] = true
+			callees[proc] = append(callees[proc], callee)
+		}
+		called[callee] = true
+	}
+	var roots []string
+	for proc := range callees {
+		if !called[proc] {
+			roots = append(roots, proc)
+		}
+	}
+	sort.Strings(roots)
+	dir := filepath.Join(outDir, "plantuml")
+	if err = os.MkdirAll(dir, os.ModeDir); err != nil {
+		return err
+	}
+	for _, root := range roots {
+		name := strings.TrimSuffix(defFileName(names[root]), ".sql") + ".puml"
+		if err = writeDiagram(filepath.Join("plantuml", name), root, names, callees, tables); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeDiagram writes the component diagram of a top-level sproc to the named report file
+func writeDiagram(file, root string, names map[string]string, callees map[string][]string, tables map[string]map[string]string) error {
+	f, err := createOutput(file)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	quote := func(s string) string {
+		return `"` + strings.Replace(s, `"`, `'`, -1) + `"`
+	}
+	w.WriteString("@startuml\ntitle " + names[root] + "\n")
+	// procedures in the order they are reached from the root, each with an alias, as are the tables they use
+	procs := map[string]string{root: "P1"}
+	order := []string{root}
+	for i := 0; i < len(order); i++ {
+		for _, callee := range callees[order[i]] {
+			if _, ok := procs[callee]; !ok {
+				procs[callee] = "P" + strconv.Itoa(len(procs)+1)
+				order = append(order, callee)
+			}
+		}
+	}
+	tableAliases := make(map[string]string)
+	var tableNames []string
+	for _, proc := range order {
+		for table := range tables[proc] {
+			if _, ok := tableAliases[table]; !ok {
+				tableAliases[table] = ""
+				tableNames = append(tableNames, table)
+			}
+		}
+	}
+	sort.Strings(tableNames)
+	for i, table := range tableNames {
+		tableAliases[table] = "T" + strconv.Itoa(i+1)
+	}
+	for _, proc := range order {
+		stereotype := ""
+		if proc == root {
+			stereotype = " <<top-level>>"
+		}
+		w.WriteString("component " + quote(names[proc]) + " as " + procs[proc] + stereotype + "\n")
+	}
+	for _, table := range tableNames {
+		w.WriteString("database " + quote(table) + " as " + tableAliases[table] + "\n")
+	}
+	for _, proc := range order {
+		for _, callee := range callees[proc] {
+			w.WriteString(procs[proc] + " --> " + procs[callee] + " : calls\n")
+		}
+		used := make([]string, 0, len(tables[proc]))
+		for table := range tables[proc] {
+			used = append(used, table)
+		}
+		sort.Strings(used)
+		for _, table := range used {
+			access := tables[proc][table]
+			if strings.Contains(access, "R") {
+				w.WriteString(tableAliases[table] + " --> " + procs[proc] + " : reads\n")
+			}
+			if strings.Contains(access, "W") {
+				w.WriteString(procs[proc] + " --> " + tableAliases[table] + " : writes\n")
+			}
+		}
+	}
+	w.WriteString("@enduml\n")
+	if err = w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
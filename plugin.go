@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"github.com/antlr/antlr4/runtime/Go/antlr"
+	parser "github.com/nycmonkey/sprocs/tsql"
+)
+
+// pluginExtractor is an Extractor implemented by an external process speaking newline-delimited JSON over its
+// stdin and stdout. On startup the process describes its report:
+//
+//	{"columns": ["Path", "Line"], "ast": false}
+//
+// and then answers one request per sproc, in order:
+//
+//	-> {"sproc": "usp_Load", "definition": "CREATE PROCEDURE ...", "ast": {...}}
+//	<- {"findings": [["\\\\share\\ftp", "12"]], "error": ""}
+//
+// The parse tree is only sent when the plugin asks for it, in the format produced by `sprocs ast`. WASM modules
+// can be used by running them under a WASI runtime, e.g. `-plugin ftp=wasmtime ftp.wasm`.
+type pluginExtractor struct {
+	name string
+	argv []string
+
+	// mu serializes the requests to the process, and its starting and stopping; it runs from the first use of
+	// the extractor in a run to the end of the run
+	mu      sync.Mutex
+	cmd     *exec.Cmd
+	stdin   io.WriteCloser
+	stdout  *bufio.Reader
+	columns []string
+	wantAST bool
+}
+
+type pluginHello struct {
+	Columns []string `json:"columns"`
+	AST     bool     `json:"ast"`
+}
+
+type pluginRequest struct {
+	Sproc      string   `json:"sproc"`
+	Definition string   `json:"definition"`
+	AST        *astNode `json:"ast,omitempty"`
+}
+
+type pluginResponse struct {
+	Findings [][]string `json:"findings"`
+	Error    string     `json:"error"`
+}
+
+// pluginFlag is a flag.Value registering a pluginExtractor for each `name=command [args...]` it is given
+type pluginFlag struct{}
+
+func (pluginFlag) String() string {
+	return ""
+}
+
+func (pluginFlag) Set(v string) error {
+	kv := strings.SplitN(v, "=", 2)
+	if len(kv) != 2 || len(strings.TrimSpace(kv[0])) == 0 || len(strings.Fields(kv[1])) == 0 {
+		return errors.New("expected name=command [args...]")
+	}
+	argv := strings.Fields(kv[1])
+	if strings.HasSuffix(strings.ToLower(argv[0]), ".wasm") {
+		return errors.New("WASM modules must be run under a WASI runtime, e.g. " + kv[0] + "=wasmtime " + kv[1])
+	}
+	for _, e := range extractors {
+		if e.Name() == strings.TrimSpace(kv[0]) {
+			return fmt.Errorf("extractor %s is already registered", e.Name())
+		}
+	}
+	RegisterExtractor(&pluginExtractor{name: strings.TrimSpace(kv[0]), argv: argv})
+	return nil
+}
+
+func (p *pluginExtractor) Name() string {
+	return p.name
+}
+
+func (p *pluginExtractor) Columns() []string {
+	if err := p.Start(); err != nil {
+		log.Println(err)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.columns
+}
+
+func (p *pluginExtractor) NewListener(sproc string, names *nameScope, emit func([]string)) antlr.ParseTreeListener {
+	if err := p.Start(); err != nil {
+		log.Println(err)
+	}
+	return &pluginListener{&parser.BasetsqlListener{}, p, sproc, emit}
+}
+
+// Start launches the plugin process, unless it is running, and reads its description
+func (p *pluginExtractor) Start() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.start()
+}
+
+// start is Start with p.mu held
+func (p *pluginExtractor) start() error {
+	if p.cmd != nil {
+		return nil
+	}
+	cmd := exec.Command(p.argv[0], p.argv[1:]...)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return fmt.Errorf("plugin %s: %v", p.name, err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("plugin %s: %v", p.name, err)
+	}
+	if err = cmd.Start(); err != nil {
+		return fmt.Errorf("plugin %s: %v", p.name, err)
+	}
+	p.stdout = bufio.NewReader(stdout)
+	var hello pluginHello
+	if err = p.read(&hello); err != nil {
+		stdin.Close()
+		cmd.Process.Kill()
+		cmd.Wait()
+		return fmt.Errorf("plugin %s did not describe its report: %v", p.name, err)
+	}
+	p.cmd, p.stdin, p.columns, p.wantAST = cmd, stdin, hello.Columns, hello.AST
+	return nil
+}
+
+func (p *pluginExtractor) read(v interface{}) error {
+	line, err := p.stdout.ReadBytes('\n')
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(line, v)
+}
+
+// analyze sends one sproc to the plugin and waits for its findings. Requests are serialized because the plugin
+// answers them in order over a single pipe.
+func (p *pluginExtractor) analyze(req pluginRequest) ([][]string, error) {
+	b, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err = p.start(); err != nil {
+		return nil, err
+	}
+	if _, err = p.stdin.Write(append(b, '\n')); err != nil {
+		return nil, err
+	}
+	var resp pluginResponse
+	if err = p.read(&resp); err != nil {
+		return nil, err
+	}
+	if len(resp.Error) > 0 {
+		return resp.Findings, errors.New(resp.Error)
+	}
+	return resp.Findings, nil
+}
+
+// Close ends the plugin's input and waits for it to exit, so the next run starts it afresh
+func (p *pluginExtractor) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.cmd == nil {
+		return nil
+	}
+	p.stdin.Close()
+	err := p.cmd.Wait()
+	p.cmd = nil
+	return err
+}
+
+// pluginListener hands each sproc to its plugin once the parse tree is complete
+type pluginListener struct {
+	*parser.BasetsqlListener
+	plugin *pluginExtractor
+	sproc  string
+	emit   func([]string)
+}
+
+// ExitTsql_file is called when the parser reaches the end of the TSQL input,
+// at which point the definition (and, if requested, its parse tree) is sent to the plugin
+func (l *pluginListener) ExitTsql_file(ctx *parser.Tsql_fileContext) {
+	input := ctx.GetStart().GetInputStream()
+	req := pluginRequest{Sproc: l.sproc, Definition: input.GetText(0, input.Size()-1)}
+	if l.plugin.wantAST {
+		req.AST = newASTNode(ctx, ctx.GetParser())
+	}
+	findings, err := l.plugin.analyze(req)
+	if err != nil {
+		log.Println("plugin", l.plugin.name, "failed on", l.sproc+":", err)
+	}
+	for _, f := range findings {
+		l.emit(f)
+	}
+}
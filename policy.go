@@ -0,0 +1,194 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"github.com/antlr/antlr4/runtime/Go/antlr"
+	"gopkg.in/yaml.v2"
+)
+
+// policy declares the tables sprocs may reference. Table patterns are globs matched without regard to case
+// against the names reported in table_sources.csv: tables of the sproc database by name alone, others as
+// DATABASE.SCHEMA.TABLE, so `HR.*` matches a whole database. Policy files are YAML, of which JSON is a subset, so
+// the JSON policies of earlier versions still load. For example:
+//
+//	forbidden: ["PAYROLL"]
+//	rules:
+//	  - name: reporting is read-only
+//	    sprocs: "rpt_*"
+//	    forbid: ["POSITIONS", "TRADES"]
+//	    access: W
+type policy struct {
+	// Forbidden tables may not be referenced by any sproc
+	Forbidden []string      `yaml:"forbidden"`
+	Rules     []*policyRule `yaml:"rules"`
+}
+
+// policyRule restricts the references of the sprocs whose names match its pattern
+type policyRule struct {
+	Name string `yaml:"name"`
+	// Sprocs is a glob matched against sproc names without regard to case; empty matches every sproc
+	Sprocs string `yaml:"sprocs"`
+	// Databases and Schemas, when given, are the only ones the sprocs may reference. Tables are checked by the
+	// schema they are referenced in, dbo when none is given, though the reports name those of the sproc database
+	// without it.
+	Databases []string `yaml:"databases"`
+	Schemas   []string `yaml:"schemas"`
+	// Forbid lists table patterns the sprocs may not reference
+	Forbid []string `yaml:"forbid"`
+	// Access limits Forbid to reads (R) or writes (W); empty forbids both
+	Access string `yaml:"access"`
+}
+
+func loadPolicy(file string) (*policy, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	p := new(policy)
+	if err = yaml.Unmarshal(b, p); err != nil {
+		return nil, fmt.Errorf("invalid policy file %s: %v", file, err)
+	}
+	if len(p.Forbidden) > 0 {
+		p.Rules = append(p.Rules, &policyRule{Name: "all sprocs", Forbid: p.Forbidden})
+	}
+	for i, r := range p.Rules {
+		if len(r.Name) == 0 {
+			r.Name = fmt.Sprintf("rule %d", i+1)
+		}
+		r.Access = strings.ToUpper(r.Access)
+		if strings.Trim(r.Access, "RW") != "" {
+			return nil, fmt.Errorf("invalid policy file %s: %s: access must be R, W or RW", file, r.Name)
+		}
+		for _, pattern := range append([]string{r.Sprocs}, r.Forbid...) {
+			if _, err = path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("invalid policy file %s: %s: pattern %q: %v", file, r.Name, pattern, err)
+			}
+		}
+	}
+	return p, nil
+}
+
+func matchFold(pattern, name string) bool {
+	ok, _ := path.Match(strings.ToUpper(pattern), strings.ToUpper(name))
+	return ok
+}
+
+// tableViolations describes each way a sproc's access to a table found by l breaks the policy, checking each
+// schema the table is referenced in
+func (p *policy) tableViolations(l *TableListener, sproc, table, access string) (found []string) {
+	names := l.qualified[strings.ToUpper(table)]
+	if len(names) == 0 {
+		names = map[string]bool{l.names.schemaTableName(table): true}
+	}
+	seen := make(map[string]bool)
+	for name := range names {
+		for _, v := range p.violations(l.names, sproc, table, name, access) {
+			if !seen[v] {
+				seen[v] = true
+				found = append(found, v)
+			}
+		}
+	}
+	sort.Strings(found)
+	return
+}
+
+// violations describes each way a sproc's access to a table breaks the policy. Table is the name reported and
+// qualified its name with the schema it is referenced in, as schemaTableName gives it; patterns are matched
+// against both.
+func (p *policy) violations(names *nameScope, sproc, table, qualified, access string) (found []string) {
+	db, schema := strings.ToUpper(names.db()), "DBO"
+	switch parts := strings.Split(qualified, "."); {
+	case len(parts) >= 3:
+		db, schema = parts[len(parts)-3], parts[len(parts)-2]
+	case len(parts) == 2:
+		schema = parts[0]
+	}
+	for _, r := range p.Rules {
+		if len(r.Sprocs) > 0 && !matchFold(r.Sprocs, sproc) {
+			continue
+		}
+		if len(r.Databases) > 0 && !containsFold(r.Databases, db) {
+			found = append(found, r.Name+": database "+db+" is not allowed")
+		}
+		if len(r.Schemas) > 0 && !containsFold(r.Schemas, schema) {
+			found = append(found, r.Name+": schema "+schema+" is not allowed")
+		}
+		if len(r.Access) > 0 && strings.Trim(access, r.Access) == access {
+			continue
+		}
+		for _, pattern := range r.Forbid {
+			if matchFold(pattern, table) || matchFold(pattern, qualified) {
+				found = append(found, r.Name+": matches forbidden pattern "+pattern)
+				break
+			}
+		}
+	}
+	return
+}
+
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// policyFlag is a flag.Value that loads a policy file and registers a policyExtractor reporting violations of it
+type policyFlag struct{}
+
+func (policyFlag) String() string {
+	return ""
+}
+
+func (policyFlag) Set(file string) error {
+	p, err := loadPolicy(file)
+	if err != nil {
+		return err
+	}
+	RegisterExtractor(&policyExtractor{policy: p})
+	return nil
+}
+
+var (
+	// failOnPolicy makes a run fail when any sproc violates the policy given to -policy
+	failOnPolicy bool
+	// policyViolations counts the violations found by the current run
+	policyViolations int64
+)
+
+// errPolicyViolated is returned by a run that found policy violations when -policy-fail is set
+var errPolicyViolated = errors.New("sprocs violate the policy; see policy_violations.csv")
+
+// policyExtractor finds the tables a sproc uses like the tableExtractor, and reports those the policy forbids
+type policyExtractor struct {
+	policy *policy
+}
+
+func (e *policyExtractor) Name() string {
+	return "policy_violations"
+}
+
+func (e *policyExtractor) Columns() []string {
+	return []string{"Table", "Access", "Violation"}
+}
+
+func (e *policyExtractor) NewListener(sproc string, names *nameScope, emit func([]string)) antlr.ParseTreeListener {
+	var l *TableListener
+	l = NewTableListener(sproc, names, func(f []string) {
+		for _, v := range e.policy.tableViolations(l, sproc, f[0], f[1]) {
+			atomic.AddInt64(&policyViolations, 1)
+			emit([]string{f[0], f[1], v})
+		}
+	})
+	return l
+}
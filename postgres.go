@@ -0,0 +1,775 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/antlr/antlr4/runtime/Go/antlr"
+	_ "github.com/lib/pq"
+	parser "github.com/nycmonkey/sprocs/tsql"
+)
+
+// postgresDialect is the -dialect of PostgreSQL functions and procedures, which reads them from pg_catalog and
+// rewrites their PL/pgSQL into the T-SQL it does the same reads and writes as before parsing, so the listeners
+// and reports are those of SQL Server sprocs
+const postgresDialect = "postgres"
+
+func init() {
+	grammars[postgresDialect] = grammar{
+		description: "PostgreSQL: the " + defaultDialect + " grammar over PL/pgSQL and SQL functions rewritten as T-SQL procedures, read from pg_proc",
+		newLexer: func(input antlr.CharStream) antlr.Lexer {
+			return parser.NewtsqlLexer(antlr.NewInputStream(pgToTSQL(input.GetText(0, input.Size()-1))))
+		},
+		parse: grammars[defaultDialect].parse,
+	}
+	dbDrivers["postgres"] = postgresDriver{}
+}
+
+const (
+	// pgTableQ lists the tables of the user schemas of the database, like tableQ
+	pgTableQ = `
+SELECT table_name FROM information_schema.tables
+WHERE table_type = 'BASE TABLE' AND table_schema NOT IN ('pg_catalog', 'information_schema')
+`
+	// pgSprocQ lists the PL/pgSQL and SQL functions and procedures of the user schemas, leaving out those of
+	// extensions, numbering the overloads of a name like the procedures of a numbered group
+	pgSprocQ = `
+SELECT n.nspname, p.proname, ROW_NUMBER() OVER (PARTITION BY n.nspname, p.proname ORDER BY p.oid)
+FROM pg_proc p
+JOIN pg_namespace n ON n.oid = p.pronamespace
+JOIN pg_language l ON l.oid = p.prolang
+WHERE l.lanname IN ('plpgsql', 'sql') AND p.prokind IN ('f', 'p')
+AND n.nspname NOT IN ('pg_catalog', 'information_schema')
+AND NOT EXISTS (SELECT 1 FROM pg_depend d WHERE d.objid = p.oid AND d.deptype = 'e')
+`
+	// pgDefinitionQ returns the CREATE statement of an overload of a function or procedure
+	pgDefinitionQ = `
+SELECT pg_get_functiondef(p.oid) FROM pg_proc p
+JOIN pg_namespace n ON n.oid = p.pronamespace
+WHERE n.nspname = ? AND p.proname = ?
+ORDER BY p.oid LIMIT 1 OFFSET ?
+`
+)
+
+// postgresDriver is lib/pq, taking space-separated key=value connection strings. The connection flags are passed
+// as the run-time parameters of the session, as PostgreSQL has no application intent or LOCK_TIMEOUT statement.
+type postgresDriver struct{}
+
+func (postgresDriver) name() string {
+	return "postgres"
+}
+
+func (postgresDriver) dsn(host, database, user, password string) string {
+	params := [][2]string{{"host", host}, {"dbname", database}}
+	if i := strings.LastIndex(host, ":"); i > 0 {
+		params = [][2]string{{"host", host[:i]}, {"port", host[i+1:]}, {"dbname", database}}
+	}
+	if len(user) > 0 {
+		params = append(params, [2]string{"user", user})
+	}
+	if len(password) > 0 {
+		params = append(params, [2]string{"password", password})
+	}
+	if connOptions.intent == "ReadOnly" {
+		params = append(params, [2]string{"default_transaction_read_only", "on"})
+	}
+	if connOptions.connectTimeout > 0 {
+		params = append(params, [2]string{"connect_timeout", strconv.FormatUint(uint64(connOptions.connectTimeout), 10)})
+	}
+	if connOptions.queryTimeout > 0 {
+		params = append(params, [2]string{"statement_timeout", strconv.FormatUint(uint64(connOptions.queryTimeout)*1000, 10)})
+	}
+	if connOptions.lockTimeout >= 0 {
+		params = append(params, [2]string{"lock_timeout", strconv.Itoa(connOptions.lockTimeout)})
+	}
+	if len(connOptions.isolation) > 0 {
+		params = append(params, [2]string{"default_transaction_isolation", strings.ToLower(isolationLevels[string(connOptions.isolation)])})
+	}
+	kv := make([]string, len(params))
+	for i, p := range params {
+		kv[i] = p[0] + "='" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(p[1]) + "'"
+	}
+	return strings.Join(kv, " ")
+}
+
+func (postgresDriver) bind(query string) string {
+	return numberPlaceholders(query, "$")
+}
+
+// pgEntry names a function as runs report it: by name alone in public, the default schema of PostgreSQL, otherwise
+// as schema.name, followed by ;number for overloads after the first
+func pgEntry(schema, name string, number int) string {
+	entry := sprocEntry("dbo", name, number)
+	if !strings.EqualFold(schema, "public") {
+		entry = schema + "." + entry
+	}
+	return entry
+}
+
+// pgObjectName splits a sproc entry into the schema and name pg_proc has it under, public by default, along with
+// the overload it numbers
+func pgObjectName(entry string) (schema, name string, number int) {
+	_, number = sprocObject(entry)
+	if number == 0 {
+		number = 1
+	}
+	name = strings.TrimSpace(entry)
+	if i := strings.LastIndex(name, ";"); i >= 0 {
+		name = name[:i]
+	}
+	schema = "public"
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		schema, name = strings.Trim(name[:i], `"`), name[i+1:]
+	}
+	return schema, strings.Trim(name, `"`), number
+}
+
+// getPostgresSprocs loads the functions, procedures and tables of the PostgreSQL database on dbHost and sends the
+// definitions to be parsed, like getSprocs does for SQL Server. Its catalog has no account master, so only
+// -dictionary keys are matched.
+func getPostgresSprocs(defDir string, outCh chan<- keyValue) error {
+	log.Println("Querying", dbHost, "(PostgreSQL)")
+	defer close(outCh)
+	if sqlDriver.name() != "postgres" {
+		return errors.New("PostgreSQL databases are reached with the postgres driver; add -driver postgres")
+	}
+	dsn, err := connString()
+	if err != nil {
+		return err
+	}
+	db, err := openDB(dsn)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	log.Println("Fetching list of known tables")
+	known := make(map[string]struct{})
+	logSQL(pgTableQ)
+	rows, err := db.Query(pgTableQ)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var tableName string
+		if err = rows.Scan(&tableName); err != nil {
+			rows.Close()
+			return err
+		}
+		known[strings.ToUpper(tableName)] = struct{}{}
+	}
+	rows.Close()
+	whitelist = known
+	log.Println("Loaded table whitelist with", len(whitelist), "values")
+	err = loadReferenceData(func() error {
+		catalog, historyTables, sprocDates, executionCounts = nil, nil, nil, nil
+		portfolios.reset()
+		return loadDictionaries(db)
+	})
+	if err != nil {
+		return err
+	}
+	if err = checkReferenceData(dictionaries); err != nil {
+		return err
+	}
+	var sprocNames []string
+	if len(sprocListPath) > 0 {
+		if sprocNames, err = readSprocList(sprocListPath, func(entry string) string { return pgEntry(pgObjectName(entry)) }); err != nil {
+			return err
+		}
+	} else {
+		log.Println("Looking up functions and procedures")
+		logSQL(pgSprocQ)
+		if rows, err = db.Query(pgSprocQ); err != nil {
+			return err
+		}
+		for rows.Next() {
+			var (
+				schema, name string
+				number       int
+			)
+			if err = rows.Scan(&schema, &name, &number); err != nil {
+				rows.Close()
+				return err
+			}
+			if !excludedSproc(name) {
+				sprocNames = append(sprocNames, pgEntry(schema, name, number))
+			}
+		}
+		rows.Close()
+		sort.Strings(sprocNames)
+	}
+	log.Println("Found", len(sprocNames), "functions and procedures")
+	log.Println("Fetching function and procedure definitions")
+	defs := make([]keyValue, 0, len(sprocNames))
+	fetching := startPhase("Fetching", len(sprocNames))
+	defer fetching.Finish()
+	for _, sn := range sprocNames {
+		schema, name, number := pgObjectName(sn)
+		logSQL(pgDefinitionQ, schema, name, number-1)
+		var def sql.NullString
+		err := db.QueryRow(sqlDriver.bind(pgDefinitionQ), schema, name, number-1).Scan(&def)
+		fetching.Increment()
+		if err != nil && err != sql.ErrNoRows {
+			return errors.New("error while querying definition of " + sn + ": " + err.Error())
+		}
+		if len(def.String) == 0 {
+			log.Println("No definition found for", sn)
+			continue
+		}
+		defs = append(defs, keyValue{key: sn, value: def.String})
+	}
+	db.Close()
+	fetching.Finish()
+	return sendDefinitions(defDir, defs, len(sprocNames), nil, outCh)
+}
+
+var (
+	// pgCreate matches the start of a CREATE FUNCTION or PROCEDURE, and its name
+	pgCreate = regexp.MustCompile(`(?i)\bCREATE\s+(OR\s+REPLACE\s+)?(FUNCTION|PROCEDURE)\s+((?:"[^"]*"|[^\s("])+)`)
+	// pgDollarQuote matches the $tag$ quoting a function body
+	pgDollarQuote = regexp.MustCompile(`\$\w*\$`)
+	// pgAtomic matches the BEGIN ATOMIC of a SQL-standard function body
+	pgAtomic = regexp.MustCompile(`(?i)\bBEGIN\s+(ATOMIC)\b`)
+
+	// pgCast matches a :: type cast, with the modifiers and array brackets of the type
+	pgCast = regexp.MustCompile(`(?i)::\s*("[^"]*"|[\w.]+)(\s+(precision|varying|without\s+time\s+zone|with\s+time\s+zone))?(\s*\([^)]*\))?(\s*\[\s*\])*`)
+	// pgBlanked matches syntax that is blanked out as it has no T-SQL counterpart and names no tables: labels,
+	// escape string prefixes, INTERVAL, RECURSIVE, STRICT, LIMIT, OFFSET, ON COMMIT, CASCADE and IF [NOT] EXISTS
+	pgBlanked = regexp.MustCompile(`(?i)<<\s*\w+\s*>>|\bE(')|\bINTERVAL\b|\bRECURSIVE\b|\bINTO\s+(STRICT)\b|\bLIMIT\s+(\w+|ALL)\b|\bOFFSET\s+\w+(\s+ROWS?\b)?|\bON\s+COMMIT\s+(DROP|DELETE\s+ROWS|PRESERVE\s+ROWS)\b|\bCASCADE\b|\bIF\s+(NOT\s+)?EXISTS\s+([\w."]+)`)
+	// pgReplaced matches the operators with a T-SQL counterpart of another spelling
+	pgReplaced = regexp.MustCompile(`(?i)\|\||\bILIKE\b|\bIS\s+NOT\s+DISTINCT\s+FROM\b|\bIS\s+DISTINCT\s+FROM\b|\$(\d+)\b`)
+	// pgToStatementEnd matches the clauses blanked out to the end of their statement: ON CONFLICT, whose updates
+	// are of the table inserted into, and RETURNING
+	pgToStatementEnd = regexp.MustCompile(`(?i)\bON\s+CONFLICT\b|\bRETURNING\b`)
+	// pgTempTable matches CREATE TEMP TABLE, and the AS of CREATE TABLE AS SELECT
+	pgTempTable = regexp.MustCompile(`(?i)\bCREATE\s+((TEMP|TEMPORARY|UNLOGGED)\s+)?TABLE\s+([\w."]+)(\s+AS\b)?`)
+	// pgDistinctOn matches DISTINCT ON, whose expressions are blanked out
+	pgDistinctOn = regexp.MustCompile(`(?i)\bDISTINCT\s+(ON)\s*\(`)
+	// pgDeleteUsing matches a DELETE with USING, which T-SQL spells FROM, naming the table deleted from first when
+	// it has an alias
+	pgDeleteUsing = regexp.MustCompile(`(?i)\bDELETE\s+(FROM)\s+[\w."]+(\s+(AS\s+)?(\w+))?\s+(USING)\b`)
+	// pgQualified matches a qualified name, whose parts may be T-SQL keywords such as PUBLIC
+	pgQualified = regexp.MustCompile(`("[^"]*"|[A-Za-z_][\w$]*)(\s*\.\s*("[^"]*"|[A-Za-z_][\w$]*|\*))+`)
+)
+
+// pgEdit replaces text[start:end] with text
+type pgEdit struct {
+	start, end int
+	text       string
+}
+
+// pgToTSQL rewrites the PostgreSQL functions and procedures of a definition as T-SQL procedures doing the same
+// reads, writes and calls, keeping every line where it is so reported lines still match the definition; columns
+// move where a keyword is spelled differently. Each header becomes CREATE PROCEDURE and its PL/pgSQL the T-SQL
+// statement or block of the same shape; PERFORM becomes SELECT, CALL EXEC and a FOR loop over a query the query
+// followed by a loop. Declarations, casts and clauses naming no tables are blanked out, and statements the
+// grammar can't parse that use none become a PRINT. Qualified names are bracketed, as their parts, public above
+// all, may be T-SQL keywords. String literals and comments are left alone.
+func pgToTSQL(text string) string {
+	code := []byte(maskLiterals(text))
+	var edits []pgEdit
+	var bodies [][2]int
+	creates := pgCreate.FindAllSubmatchIndex(code, -1)
+	end := 0
+	for i, m := range creates {
+		if m[0] < end {
+			continue
+		}
+		limit := len(code)
+		if i+1 < len(creates) {
+			limit = creates[i+1][0]
+		}
+		header := "CREATE PROCEDURE " + pgBracketName(text[m[6]:m[7]]) + " AS"
+		if q := pgDollarQuote.FindIndex(code[m[1]:]); q != nil {
+			tag := string(code[m[1]+q[0] : m[1]+q[1]])
+			start := m[1] + q[1]
+			closing := strings.Index(string(code[start:]), tag)
+			if closing < 0 {
+				continue
+			}
+			end = start + closing
+			edits = append(edits, pgEdit{m[0], start, header})
+			bodies = append(bodies, [2]int{start, end})
+			// the LANGUAGE and other options following the body are blanked out, ending the batch of the procedure
+			tail := end + len(tag)
+			for tail < len(code) && tail < limit && code[tail] != '\n' {
+				tail++
+			}
+			if i+1 < len(creates) {
+				edits = append(edits, pgEdit{end, tail, "GO"})
+			} else {
+				edits = append(edits, pgEdit{end, len(code), ""})
+			}
+			continue
+		}
+		if a := pgAtomic.FindSubmatchIndex(code[m[1]:limit]); a != nil {
+			edits = append(edits, pgEdit{m[0], m[1] + a[0], header})
+			edits = append(edits, pgEdit{m[1] + a[2], m[1] + a[3], ""})
+			bodies = append(bodies, [2]int{m[1] + a[0], limit})
+			end = limit
+		}
+	}
+	if len(creates) == 0 {
+		// a bare block of statements, as in a DO block or a script
+		bodies = append(bodies, [2]int{0, len(code)})
+	}
+	for _, b := range bodies {
+		edits = append(edits, pgBodyEdits(code, b[0], b[1])...)
+	}
+	for _, b := range bodies {
+		// after every other edit, so those rewriting a name along with what surrounds it take precedence
+		edits = append(edits, pgNameEdits(text, code, b[0], b[1])...)
+	}
+	return applyEdits(text, edits)
+}
+
+// pgBracketName brackets each part of a name, unquoting those in double quotes: the public of public.name is a
+// T-SQL keyword, as may be other schemas and names
+func pgBracketName(name string) string {
+	var parts []string
+	quoted := false
+	part := ""
+	for _, r := range name {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case r == '.' && !quoted:
+			parts = append(parts, part)
+			part = ""
+		case quoted || !unicode.IsSpace(r):
+			part += string(r)
+		}
+	}
+	parts = append(parts, part)
+	for i, p := range parts {
+		if p != "*" {
+			parts[i] = "[" + strings.Replace(p, "]", "]]", -1) + "]"
+		}
+	}
+	return strings.Join(parts, ".")
+}
+
+// pgNameEdits returns the edits bracketing the qualified names of the body of a function, code[start:end]
+func pgNameEdits(text string, code []byte, start, end int) []pgEdit {
+	var edits []pgEdit
+	for _, m := range pgQualified.FindAllIndex(code[start:end], -1) {
+		s, e := start+m[0], start+m[1]
+		if s > 0 {
+			// not the tail of a word, variable, parameter or longer name
+			if c := code[s-1]; c == '_' || c == '$' || c == '@' || c == '#' || c == '.' || c == ':' || c >= '0' && c <= '9' || c|0x20 >= 'a' && c|0x20 <= 'z' {
+				continue
+			}
+		}
+		edits = append(edits, pgEdit{s, e, pgBracketName(text[s:e])})
+	}
+	return edits
+}
+
+// pgBodyEdits returns the edits rewriting the body of a function, code[start:end]
+func pgBodyEdits(code []byte, start, end int) []pgEdit {
+	body := code[start:end]
+	var edits []pgEdit
+	add := func(s, e int, text string) {
+		edits = append(edits, pgEdit{start + s, start + e, text})
+	}
+	for _, m := range pgCast.FindAllIndex(body, -1) {
+		add(m[0], m[1], "")
+	}
+	for _, m := range pgBlanked.FindAllSubmatchIndex(body, -1) {
+		switch {
+		case m[2] >= 0:
+			// the E of E'...', leaving the literal
+			add(m[0], m[2], "")
+		case m[4] >= 0:
+			add(m[4], m[5], "")
+		case m[14] >= 0:
+			// IF [NOT] EXISTS, leaving the table
+			add(m[0], m[14], "")
+		default:
+			add(m[0], m[1], "")
+		}
+	}
+	for _, m := range pgReplaced.FindAllSubmatchIndex(body, -1) {
+		op := strings.ToUpper(strings.Join(strings.Fields(string(body[m[0]:m[1]])), " "))
+		switch {
+		case op == "||":
+			add(m[0], m[1], "+")
+		case op == "ILIKE":
+			add(m[0], m[1], "LIKE")
+		case op == "IS NOT DISTINCT FROM":
+			add(m[0], m[1], "=")
+		case op == "IS DISTINCT FROM":
+			add(m[0], m[1], "<>")
+		default:
+			// a positional parameter, $1, is a variable of T-SQL
+			add(m[0], m[0]+1, "@")
+		}
+	}
+	for _, m := range pgToStatementEnd.FindAllIndex(body, -1) {
+		add(m[0], statementEnd(body, m[1]), "")
+	}
+	for _, m := range pgTempTable.FindAllSubmatchIndex(body, -1) {
+		if m[8] >= 0 {
+			// CREATE TABLE AS SELECT inserts the result of the query into the table
+			add(m[0], m[1], "INSERT INTO "+string(body[m[6]:m[7]]))
+		} else if m[2] >= 0 {
+			add(m[2], m[3], "")
+		}
+	}
+	for _, m := range pgDistinctOn.FindAllSubmatchIndex(body, -1) {
+		if e := closingParen(body, m[1]-1); e > 0 {
+			add(m[2], e, "")
+		}
+	}
+	for _, m := range pgDeleteUsing.FindAllSubmatchIndex(body, -1) {
+		if m[8] < 0 {
+			add(m[10], m[11], "FROM")
+			continue
+		}
+		// DELETE FROM t a USING u becomes DELETE a FROM t a, u
+		add(m[2], m[3], string(body[m[8]:m[9]])+" FROM")
+		add(m[10], m[11], ",")
+	}
+	return append(edits, pgStatementEdits(body, start)...)
+}
+
+// pgToken is a word, literal or symbol of a masked body, at code[start:end]
+type pgToken struct {
+	start, end int
+	word       string
+}
+
+// pgTokens splits a masked body into tokens, upper-casing words; a string literal or quoted name is one token
+func pgTokens(code []byte) []pgToken {
+	var tokens []pgToken
+	for i := 0; i < len(code); {
+		c := code[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			i++
+		case c == '\'' || c == '"':
+			j := i + 1
+			for j < len(code) && code[j] != c {
+				j++
+			}
+			if j < len(code) {
+				j++
+			}
+			tokens = append(tokens, pgToken{i, j, string(code[i:j])})
+			i = j
+		case c == '_' || c == '$' || c == '@' || c == '#' || (c|0x20 >= 'a' && c|0x20 <= 'z') || (c >= '0' && c <= '9') || c >= 0x80:
+			j := i + 1
+			for j < len(code) && (code[j] == '_' || code[j] == '$' || (code[j]|0x20 >= 'a' && code[j]|0x20 <= 'z') || (code[j] >= '0' && code[j] <= '9') || code[j] >= 0x80) {
+				j++
+			}
+			tokens = append(tokens, pgToken{i, j, strings.ToUpper(string(code[i:j]))})
+			i = j
+		case i+1 < len(code) && (string(code[i:i+2]) == ":=" || string(code[i:i+2]) == ".."):
+			tokens = append(tokens, pgToken{i, i + 2, string(code[i : i+2])})
+			i += 2
+		default:
+			tokens = append(tokens, pgToken{i, i + 1, string(code[i : i+1])})
+			i++
+		}
+	}
+	return tokens
+}
+
+// pgBlock is a block of PL/pgSQL open at a point of its body
+type pgBlock struct {
+	kind string
+	// begin is the token of the BEGIN opening a block; handler is set once its EXCEPTION is reached
+	begin   int
+	handler bool
+}
+
+// pgStatementEdits returns the edits rewriting the PL/pgSQL statements of a masked body as T-SQL, offset by start
+func pgStatementEdits(body []byte, start int) []pgEdit {
+	tokens := pgTokens(body)
+	var edits []pgEdit
+	add := func(from, to int, text string) {
+		edits = append(edits, pgEdit{start + tokens[from].start, start + tokens[to].end, text})
+	}
+	// find returns the first of words after token i outside parentheses and CASE expressions, stopping at the
+	// end of the statement
+	find := func(i int, words ...string) int {
+		depth := 0
+		for j := i + 1; j < len(tokens); j++ {
+			w := tokens[j].word
+			if depth == 0 {
+				for _, want := range words {
+					if w == want {
+						return j
+					}
+				}
+			}
+			switch {
+			case w == "(" || w == "CASE":
+				depth++
+			case w == ")" || (w == "END" && depth > 0):
+				depth--
+			case w == ";" && depth <= 0:
+				return -1
+			}
+		}
+		return -1
+	}
+	noop := func(i int) int {
+		j := find(i, ";")
+		if j < 0 {
+			j = len(tokens) - 1
+		} else {
+			j--
+		}
+		add(i, j, noopStatement)
+		return j
+	}
+	var stack []pgBlock
+	top := func() *pgBlock {
+		if len(stack) == 0 {
+			return &pgBlock{}
+		}
+		return &stack[len(stack)-1]
+	}
+	pop := func() pgBlock {
+		b := *top()
+		if len(stack) > 0 {
+			stack = stack[:len(stack)-1]
+		}
+		return b
+	}
+	atStart := true
+	for i := 0; i < len(tokens); i++ {
+		w := tokens[i].word
+		if !atStart {
+			atStart = w == ";"
+			continue
+		}
+		next := ""
+		if i+1 < len(tokens) {
+			next = tokens[i+1].word
+		}
+		atStart = false
+		switch {
+		case w == ";":
+			atStart = true
+		case w == "<" && next == "<":
+			// a label, blanked out already
+			for i < len(tokens)-1 && tokens[i].word != ">" {
+				i++
+			}
+			i, atStart = i+1, true
+		case w == "DECLARE":
+			// variables needn't be declared in T-SQL, as assignments become SELECTs
+			j := i + 1
+			for j < len(tokens) && tokens[j].word != "BEGIN" {
+				j++
+			}
+			add(i, j-1, "")
+			i, atStart = j-1, true
+		case w == "BEGIN":
+			stack = append(stack, pgBlock{kind: "BEGIN", begin: i})
+			atStart = true
+		case w == "END":
+			switch next {
+			case "IF", "LOOP":
+				pop()
+				add(i+1, i+1, "")
+				i++
+			case "CASE":
+				pop()
+				i++
+			default:
+				if b := pop(); b.handler {
+					add(i, i, "END CATCH")
+				}
+			}
+		case w == "IF" || w == "ELSIF" || w == "ELSEIF":
+			then := find(i, "THEN")
+			if then < 0 {
+				break
+			}
+			if w == "IF" {
+				stack = append(stack, pgBlock{kind: "IF"})
+			} else {
+				add(i, i, "END ELSE IF")
+			}
+			add(then, then, "BEGIN")
+			i, atStart = then, true
+		case w == "ELSE" && top().kind == "IF":
+			add(i, i, "END ELSE BEGIN")
+			atStart = true
+		case w == "CASE":
+			stack = append(stack, pgBlock{kind: "CASE"})
+		case w == "LOOP":
+			stack = append(stack, pgBlock{kind: "LOOP"})
+			add(i, i, "WHILE 1 = 1 BEGIN")
+			atStart = true
+		case w == "WHILE":
+			loop := find(i, "LOOP")
+			if loop < 0 {
+				break
+			}
+			stack = append(stack, pgBlock{kind: "LOOP"})
+			add(loop, loop, "BEGIN")
+			i, atStart = loop, true
+		case w == "FOR" || w == "FOREACH":
+			in, loop := find(i, "IN"), find(i, "LOOP")
+			if in < 0 || loop < 0 {
+				break
+			}
+			stack = append(stack, pgBlock{kind: "LOOP"})
+			query := tokens[in+1].word
+			if query == "(" && in+2 < loop {
+				query = tokens[in+2].word
+			}
+			if query == "SELECT" || query == "WITH" {
+				// the query is read once, then the loop runs
+				add(i, in, "")
+				add(loop, loop, "; WHILE 1 = 1 BEGIN")
+			} else {
+				add(i, loop, "WHILE 1 = 1 BEGIN")
+			}
+			i, atStart = loop, true
+		case w == "EXIT" || w == "CONTINUE":
+			stmt := "BREAK"
+			if w == "CONTINUE" {
+				stmt = "CONTINUE"
+			}
+			semi := find(i, ";")
+			if when := find(i, "WHEN"); when > 0 && semi > 0 {
+				add(i, when, "IF")
+				edits = append(edits, pgEdit{start + tokens[semi].start, start + tokens[semi].start, " " + stmt})
+				i = semi - 1
+			} else if semi > 0 {
+				add(i, semi-1, stmt)
+				i = semi - 1
+			}
+		case w == "EXCEPTION" && top().kind == "BEGIN":
+			b := top()
+			b.handler = true
+			add(b.begin, b.begin, "BEGIN TRY")
+			add(i, i, "END TRY BEGIN CATCH")
+			atStart = true
+		case w == "WHEN" && top().handler:
+			// each handler runs in the CATCH block, whatever it catches
+			if then := find(i, "THEN"); then > 0 {
+				add(i, then, "")
+				i = then
+			}
+			atStart = true
+		case w == "RETURN" && (next == "QUERY" || next == "NEXT"):
+			if next == "QUERY" && i+2 < len(tokens) && tokens[i+2].word != "EXECUTE" {
+				add(i, i+1, "")
+				atStart = true
+				i++
+			} else {
+				i = noop(i)
+			}
+		case w == "PERFORM":
+			add(i, i, "SELECT")
+		case w == "CALL":
+			add(i, i, "EXEC")
+			open := i + 1
+			for open < len(tokens) && (tokens[open].word == "." || isPgName(tokens[open].word)) {
+				open++
+			}
+			if open < len(tokens) && tokens[open].word == "(" {
+				depth := 0
+				for j := open; j < len(tokens); j++ {
+					if tokens[j].word == "(" {
+						depth++
+					} else if tokens[j].word == ")" {
+						if depth--; depth == 0 {
+							add(open, open, " ")
+							add(j, j, "")
+							break
+						}
+					}
+				}
+			}
+		case w == "OPEN" && find(i, "FOR") > 0:
+			// the query of a cursor is read where it is opened
+			i = find(i, "FOR")
+			add(i-2, i, "")
+			atStart = true
+		case w == "RAISE" || w == "ASSERT" || w == "GET" || w == "NULL" || w == "EXECUTE" || w == "FETCH" || w == "MOVE":
+			i = noop(i)
+		case next == ":=" || (next == "=" && tokens[i].word != "SET") || next == "." || next == "[":
+			// an assignment, whose expression is still read: x := (SELECT ...) becomes SELECT (SELECT ...)
+			if op := find(i, ":=", "="); op > 0 {
+				assignment := isPgName(w)
+				for j := i + 1; j < op; j++ {
+					t := tokens[j].word
+					assignment = assignment && (t == "." || t == "[" || t == "]" || isPgName(t))
+				}
+				if assignment {
+					add(i, op, "SELECT")
+					i = op
+				}
+			}
+		}
+	}
+	return edits
+}
+
+// isPgName reports whether a token is a name or number, rather than a symbol
+func isPgName(word string) bool {
+	c := word[0]
+	return c == '_' || c == '"' || c == '$' || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c >= 0x80
+}
+
+// statementEnd returns the index of the ; ending the statement of code at i, outside parentheses, or len(code)
+func statementEnd(code []byte, i int) int {
+	depth := 0
+	for ; i < len(code); i++ {
+		switch code[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ';':
+			if depth <= 0 {
+				return i
+			}
+		}
+	}
+	return len(code)
+}
+
+// applyEdits applies edits to text, the first of any that overlap winning. The line breaks of the text an edit
+// replaces follow its replacement, so the lines after it stay where they are.
+func applyEdits(text string, edits []pgEdit) string {
+	sort.SliceStable(edits, func(i, j int) bool {
+		return edits[i].start < edits[j].start
+	})
+	var b strings.Builder
+	pos := 0
+	for _, e := range edits {
+		if e.start < pos || e.end > len(text) {
+			continue
+		}
+		b.WriteString(text[pos:e.start])
+		replaced := []byte(text[e.start:e.end])
+		lines := strings.Count(string(replaced), "\n")
+		switch {
+		case len(e.text) == 0:
+			blankOut(replaced, 0, len(replaced))
+			b.Write(replaced)
+		case lines == 0 && len(e.text) <= len(replaced):
+			b.WriteString(e.text + strings.Repeat(" ", len(replaced)-len(e.text)))
+		default:
+			// a longer replacement is kept apart from what follows it
+			b.WriteString(e.text + " " + strings.Repeat("\n", lines))
+		}
+		pos = e.end
+	}
+	b.WriteString(text[pos:])
+	return b.String()
+}
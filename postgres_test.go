@@ -0,0 +1,99 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// pgCatalogDefinition is a function as pg_get_functiondef returns it: schema-qualified, options on lines of their
+// own and the body quoted by $function$
+const pgCatalogDefinition = `CREATE OR REPLACE FUNCTION public.load_positions(p_date date)
+ RETURNS integer
+ LANGUAGE plpgsql
+AS $function$
+DECLARE
+  n integer;
+BEGIN
+  DELETE FROM public.positions WHERE as_of = p_date;
+  INSERT INTO public.positions (account_id, qty)
+  SELECT s.account_id, sum(s.qty)::numeric(18,2)
+  FROM staging."Trades" s
+  JOIN public.accounts a ON a.id = s.account_id
+  WHERE s.trade_date <= p_date
+  GROUP BY s.account_id;
+  GET DIAGNOSTICS n = ROW_COUNT;
+  PERFORM audit.log_load('positions', n);
+  RETURN n;
+END;
+$function$
+`
+
+// squeezeSpaces collapses the runs of blanks on each line of s, which pgToTSQL pads its rewrites with to keep
+// columns, leaving the lines themselves
+func squeezeSpaces(s string) string {
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		lines[i] = strings.Join(strings.Fields(line), " ")
+	}
+	return strings.Join(lines, "\n")
+}
+
+func TestPgToTSQL(t *testing.T) {
+	for _, c := range []struct {
+		name, in, want string
+	}{
+		{"cast", "SELECT x::numeric(18,2), y::text[] FROM t;", "SELECT x , y FROM t;"},
+		{"public schema", "SELECT a.id FROM public.accounts a;", "SELECT [a].[id] FROM [public].[accounts] a;"},
+		{"quoted name", `SELECT 1 FROM staging."Trade Log";`, "SELECT 1 FROM [staging].[Trade Log] ;"},
+		{"string literal", "SELECT 'public.accounts' FROM t;", "SELECT 'public.accounts' FROM t;"},
+		{"operators", "SELECT a || b FROM t WHERE n ILIKE 'x%' AND m IS DISTINCT FROM o;", "SELECT a + b FROM t WHERE n LIKE 'x%' AND m <> o;"},
+		{"positional parameter", "SELECT c FROM t WHERE id = $1;", "SELECT c FROM t WHERE id = @1;"},
+		{"limit", "SELECT c FROM t ORDER BY c LIMIT 10;", "SELECT c FROM t ORDER BY c ;"},
+		{"call", "CALL audit.log_load('positions');", "EXEC [audit].[log_load] 'positions' ;"},
+		{"catalog definition", pgCatalogDefinition, `CREATE PROCEDURE [public].[load_positions] AS
+
+
+
+
+
+BEGIN
+DELETE FROM [public].[positions] WHERE as_of = p_date;
+INSERT INTO [public].[positions] (account_id, qty)
+SELECT [s].[account_id] , sum([s].[qty] )
+FROM [staging].[Trades] s
+JOIN [public].[accounts] a ON [a].[id] = [s].[account_id]
+WHERE [s].[trade_date] <= p_date
+GROUP BY [s].[account_id] ;
+PRINT 0 ;
+SELECT [audit].[log_load] ('positions', n);
+RETURN n;
+END;
+
+`},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			got := pgToTSQL(c.in)
+			if strings.Count(got, "\n") != strings.Count(c.in, "\n") {
+				t.Errorf("got %d lines, want %d:\n%s", strings.Count(got, "\n"), strings.Count(c.in, "\n"), got)
+			}
+			if squeezeSpaces(got) != c.want {
+				t.Errorf("got\n%s\nwant\n%s", squeezeSpaces(got), c.want)
+			}
+		})
+	}
+}
+
+// TestPgToTSQLParses checks that definitions as the catalog returns them parse under -dialect postgres
+func TestPgToTSQLParses(t *testing.T) {
+	defer func(d dialectFlag) { dialect = d }(dialect)
+	dialect = postgresDialect
+	for _, def := range []string{
+		pgCatalogDefinition,
+		strings.Replace(pgCatalogDefinition, "public.load_positions", "reporting.load_positions", 1),
+		strings.Replace(pgCatalogDefinition, "public.load_positions", `public."Load Positions"`, 1),
+	} {
+		if errs := parseTSQL("load_positions", def); len(errs) > 0 {
+			t.Errorf("%s\n%s", strings.Join(errs, "\n"), pgToTSQL(def))
+		}
+	}
+}
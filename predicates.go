@@ -0,0 +1,310 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/antlr/antlr4/runtime/Go/antlr"
+	parser "github.com/nycmonkey/sprocs/tsql"
+)
+
+func init() {
+	RegisterExtractor(predicateExtractor{})
+}
+
+// predicateExtractor reports the WHERE and JOIN ... ON filters each sproc applies to the columns of its tables
+type predicateExtractor struct{}
+
+func (predicateExtractor) Name() string {
+	return "predicates"
+}
+
+func (predicateExtractor) Columns() []string {
+	return []string{"Table", "Column", "Operator", "Value", "Value Kind", "Line"}
+}
+
+func (predicateExtractor) NewListener(sproc string, names *nameScope, emit func([]string)) antlr.ParseTreeListener {
+	return newPredicateListener(names, emit)
+}
+
+// tableScope holds the tables, keyed by upper-cased name and alias, visible to the predicates of one statement
+type tableScope struct {
+	tables map[string]string
+}
+
+type predicateListener struct {
+	*parser.BasetsqlListener
+	names  *nameScope
+	emit   func([]string)
+	params map[string]bool
+	scopes []*tableScope
+}
+
+func newPredicateListener(names *nameScope, emit func([]string)) *predicateListener {
+	return &predicateListener{BasetsqlListener: &parser.BasetsqlListener{}, names: names, emit: emit, params: make(map[string]bool)}
+}
+
+// EnterProcedure_param is called when the parser enters a `procedure_param` node
+func (l *predicateListener) EnterProcedure_param(ctx *parser.Procedure_paramContext) {
+	// a malformed parameter list, such as one ending in a comma, leaves a parameter without a name
+	if id := ctx.LOCAL_ID(); id != nil {
+		l.params[strings.ToUpper(id.GetText())] = true
+	}
+}
+
+func (l *predicateListener) push() {
+	l.scopes = append(l.scopes, &tableScope{tables: make(map[string]string)})
+}
+
+func (l *predicateListener) pop() {
+	if len(l.scopes) > 0 {
+		l.scopes = l.scopes[:len(l.scopes)-1]
+	}
+}
+
+// EnterQuery_specification is called when the parser enters a `query_specification` node
+func (l *predicateListener) EnterQuery_specification(ctx *parser.Query_specificationContext) {
+	l.push()
+}
+
+// ExitQuery_specification is called when the parser exits a `query_specification` node
+func (l *predicateListener) ExitQuery_specification(ctx *parser.Query_specificationContext) { l.pop() }
+
+// EnterUpdate_statement is called when the parser enters an `update_statement` node
+func (l *predicateListener) EnterUpdate_statement(ctx *parser.Update_statementContext) { l.push() }
+
+// ExitUpdate_statement is called when the parser exits an `update_statement` node
+func (l *predicateListener) ExitUpdate_statement(ctx *parser.Update_statementContext) { l.pop() }
+
+// EnterDelete_statement is called when the parser enters a `delete_statement` node
+func (l *predicateListener) EnterDelete_statement(ctx *parser.Delete_statementContext) { l.push() }
+
+// ExitDelete_statement is called when the parser exits a `delete_statement` node
+func (l *predicateListener) ExitDelete_statement(ctx *parser.Delete_statementContext) { l.pop() }
+
+// add makes a table visible to the current statement under its own name and its alias, if any
+func (l *predicateListener) add(table, alias string) {
+	if len(l.scopes) == 0 || len(table) == 0 {
+		return
+	}
+	s := l.scopes[len(l.scopes)-1]
+	s.tables[strings.ToUpper(table)] = table
+	if len(alias) > 0 {
+		s.tables[strings.ToUpper(alias)] = table
+	}
+}
+
+// EnterTable_source_item is called when the parser enters a `table_source_item` node
+func (l *predicateListener) EnterTable_source_item(ctx *parser.Table_source_itemContext) {
+	if ctx.Table_name_with_hint() == nil {
+		return
+	}
+	table := l.names.normalizeTableName(ctx.Table_name_with_hint().(*parser.Table_name_with_hintContext).Table_name().GetText())
+	var alias string
+	if a := ctx.As_table_alias(); a != nil {
+		alias = l.names.normalizeTableName(a.(*parser.As_table_aliasContext).Table_alias().(*parser.Table_aliasContext).Id().GetText())
+	}
+	l.add(table, alias)
+}
+
+// EnterDdl_object is called when the parser enters a `ddl_object` node, the target of an UPDATE or DELETE
+func (l *predicateListener) EnterDdl_object(ctx *parser.Ddl_objectContext) {
+	switch ctx.GetParent().(type) {
+	case *parser.Update_statementContext, *parser.Delete_statement_fromContext:
+		if ctx.LOCAL_ID() == nil {
+			l.add(l.names.normalizeTableName(ctx.GetText()), "")
+		}
+	}
+}
+
+// EnterDelete_statement_from is called when the parser enters a `delete_statement_from` node
+func (l *predicateListener) EnterDelete_statement_from(ctx *parser.Delete_statement_fromContext) {
+	if a := ctx.Table_alias(); a != nil {
+		l.add(l.names.normalizeTableName(a.(*parser.Table_aliasContext).Id().GetText()), "")
+	}
+}
+
+// resolve returns the table a column qualifier refers to, or for an unqualified column, the only table of the
+// innermost statement
+func (l *predicateListener) resolve(qualifier string) string {
+	if len(qualifier) == 0 {
+		var only string
+		if len(l.scopes) > 0 {
+			for _, table := range l.scopes[len(l.scopes)-1].tables {
+				if len(only) > 0 && table != only {
+					return ""
+				}
+				only = table
+			}
+		}
+		return only
+	}
+	key := strings.ToUpper(l.names.normalizeTableName(qualifier))
+	for i := len(l.scopes) - 1; i >= 0; i-- {
+		if table, ok := l.scopes[i].tables[key]; ok {
+			return table
+		}
+	}
+	return ""
+}
+
+// EnterPredicate is called when the parser enters a `predicate` node
+func (l *predicateListener) EnterPredicate(ctx *parser.PredicateContext) {
+	if ctx.EXISTS() != nil || ctx.Search_condition() != nil || !isFilter(ctx) {
+		return
+	}
+	exprs := ctx.AllExpression()
+	if len(exprs) == 0 {
+		return
+	}
+	var op string
+	if n, ok := ctx.GetParent().(*parser.Search_condition_notContext); ok && n.NOT() != nil {
+		op = "NOT "
+	}
+	col, values := unwrapExpression(exprs[0]), exprs[1:]
+	switch {
+	case ctx.Comparison_operator() != nil:
+		cmp := ctx.Comparison_operator().GetText()
+		if _, ok := col.(*parser.Column_ref_expressionContext); !ok && len(values) == 1 {
+			// literal on the left: flip it around so the column comes first
+			col, values = unwrapExpression(values[0]), exprs[:1]
+			cmp = flipComparison(cmp)
+		}
+		op += cmp
+		if ctx.Subquery() != nil {
+			op += " " + ctx.GetChild(2).(antlr.TerminalNode).GetText()
+		}
+	case ctx.BETWEEN() != nil:
+		op += notPrefix(ctx.NOT()) + "BETWEEN"
+	case ctx.IN() != nil:
+		op += notPrefix(ctx.NOT()) + "IN"
+		if ctx.Expression_list() != nil {
+			values = ctx.Expression_list().(*parser.Expression_listContext).AllExpression()
+		}
+	case ctx.LIKE() != nil:
+		op += notPrefix(ctx.NOT()) + "LIKE"
+		values = values[:1]
+	case ctx.IS() != nil:
+		op += "IS " + strings.ToUpper(sourceText(ctx.Null_notnull()))
+	}
+	ref, ok := col.(*parser.Column_ref_expressionContext)
+	if !ok {
+		return
+	}
+	name := ref.Full_column_name().(*parser.Full_column_nameContext)
+	var qualifier string
+	if name.Table_name() != nil {
+		qualifier = name.Table_name().GetText()
+	}
+	texts := make([]string, 0, len(values))
+	kind := ""
+	for i, v := range values {
+		texts = append(texts, sourceText(v))
+		k := l.valueKind(unwrapExpression(v))
+		if i > 0 && k != kind {
+			k = "expression"
+		}
+		kind = k
+	}
+	if ctx.Subquery() != nil {
+		texts, kind = append(texts, "("+sourceText(ctx.Subquery())+")"), "subquery"
+	}
+	if kind == "column" {
+		// a join condition rather than a filter
+		return
+	}
+	sep := ", "
+	if ctx.BETWEEN() != nil {
+		sep = " AND "
+	}
+	value := strings.Join(texts, sep)
+	if ctx.IN() != nil && ctx.Subquery() == nil {
+		value = "(" + value + ")"
+	}
+	l.emit([]string{l.resolve(qualifier), removeBrackets(name.Id().GetText()), op, value, kind, strconv.Itoa(ctx.GetStart().GetLine())})
+}
+
+// valueKind classifies the value side of a predicate
+func (l *predicateListener) valueKind(e antlr.ParseTree) string {
+	switch v := e.(type) {
+	case *parser.Column_ref_expressionContext:
+		return "column"
+	case *parser.Subquery_expressionContext:
+		return "subquery"
+	case *parser.Primitive_expressionContext:
+		if v.LOCAL_ID() != nil {
+			if l.params[strings.ToUpper(v.LOCAL_ID().GetText())] {
+				return "parameter"
+			}
+			return "variable"
+		}
+		return "literal"
+	}
+	return "expression"
+}
+
+// isFilter reports whether a predicate belongs to a WHERE or JOIN ... ON clause
+func isFilter(ctx antlr.Tree) bool {
+	for node := ctx; node.GetParent() != nil; node = node.GetParent() {
+		switch p := node.GetParent().(type) {
+		case *parser.Query_specificationContext:
+			return p.GetWhere() != nil && node == p.GetWhere().(antlr.Tree)
+		case *parser.Update_statementContext, *parser.Delete_statementContext, *parser.Join_partContext:
+			_, ok := node.(*parser.Search_conditionContext)
+			_, list := node.(*parser.Search_condition_listContext)
+			return ok || list
+		case *parser.Sql_clauseContext:
+			return false
+		}
+	}
+	return false
+}
+
+// unwrapExpression strips redundant parentheses from an expression
+func unwrapExpression(e antlr.ParseTree) antlr.ParseTree {
+	for {
+		b, ok := e.(*parser.Bracket_expressionContext)
+		if !ok {
+			return e
+		}
+		e = b.Expression()
+	}
+}
+
+func notPrefix(not antlr.TerminalNode) string {
+	if not != nil {
+		return "NOT "
+	}
+	return ""
+}
+
+// flipComparison returns the operator that keeps a comparison true when its operands are swapped
+func flipComparison(op string) string {
+	switch op {
+	case "<":
+		return ">"
+	case ">":
+		return "<"
+	case "<=":
+		return ">="
+	case ">=":
+		return "<="
+	case "!<":
+		return "!>"
+	case "!>":
+		return "!<"
+	}
+	return op
+}
+
+// sourceText returns the text of a parse tree node as written, including whitespace and comments
+func sourceText(node antlr.Tree) string {
+	ctx, ok := node.(antlr.ParserRuleContext)
+	if !ok || ctx.GetStart() == nil || ctx.GetStop() == nil || ctx.GetStop().GetStop() < ctx.GetStart().GetStart() {
+		if t, ok := node.(antlr.TerminalNode); ok {
+			return t.GetText()
+		}
+		return ""
+	}
+	return ctx.GetStart().GetInputStream().GetText(ctx.GetStart().GetStart(), ctx.GetStop().GetStop())
+}
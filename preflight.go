@@ -0,0 +1,116 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// leastPrivilege refuses to run with a login holding more than the permissions a run needs, so a run can't
+// write to the database even by mistake
+var leastPrivilege bool
+
+// catalogViews are the catalog views the metadata queries of a run read
+var catalogViews = []string{
+	"INFORMATION_SCHEMA.ROUTINES", "INFORMATION_SCHEMA.TABLES", "sys.objects", "sys.numbered_procedures",
+	"sys.tables", "sys.parameters", "sys.extended_properties", "sys.assembly_modules", "sys.assemblies",
+	"sys.extended_procedures", "sys.database_permissions", "sys.database_principals",
+	"sys.database_role_members", "sys.databases",
+}
+
+// permissionCheck is a permission a run needs, or with -least-privilege one it must not have. Its query returns
+// 1 when the login holds the permission, 0 when it doesn't and NULL when the securable doesn't exist.
+type permissionCheck struct {
+	what, query string
+	excess      bool
+}
+
+// hasPerms returns a query of whether the login holds a permission on a securable, the database when none is named
+func hasPerms(securable, class, permission string) string {
+	if len(securable) > 0 {
+		securable = "'" + strings.Replace(securable, "'", "''", -1) + "'"
+	} else {
+		securable = "DB_NAME()"
+	}
+	return "SELECT HAS_PERMS_BY_NAME(" + securable + ", '" + class + "', '" + permission + "')"
+}
+
+// permissionChecks returns the permissions to check for the flags of the run
+func permissionChecks() []permissionCheck {
+	checks := []permissionCheck{{what: "VIEW DEFINITION on the database", query: hasPerms("", "DATABASE", "VIEW DEFINITION")}}
+	for _, v := range catalogViews {
+		if dialect == synapseDialect && synapseMissingViews[v] {
+			continue
+		}
+		checks = append(checks, permissionCheck{what: "SELECT on " + v, query: hasPerms(v, "OBJECT", "SELECT")})
+	}
+	checks = append(checks, permissionCheck{what: "SELECT on " + portfolioView, query: hasPerms(portfolioView, "OBJECT", "SELECT")})
+	if showplan.enabled {
+		checks = append(checks, permissionCheck{what: "SHOWPLAN on the database, for -showplan", query: hasPerms("", "DATABASE", "SHOWPLAN")})
+	}
+	if leastPrivilege {
+		checks = append(checks,
+			permissionCheck{what: "membership of sysadmin", query: "SELECT IS_SRVROLEMEMBER('sysadmin')", excess: true},
+			permissionCheck{what: "membership of db_owner", query: "SELECT IS_MEMBER('db_owner')", excess: true})
+		for _, p := range []string{"INSERT", "UPDATE", "DELETE", "ALTER", "EXECUTE", "CREATE TABLE", "CREATE PROCEDURE"} {
+			checks = append(checks, permissionCheck{what: p + " on the database", query: hasPerms("", "DATABASE", p), excess: true})
+		}
+	}
+	return checks
+}
+
+// checkPermissions verifies the login holds every permission the run needs, and with -least-privilege nothing
+// more, before any work starts. Every problem is logged, so a single attempt says all there is to fix.
+func checkPermissions(db *sql.DB) error {
+	var login string
+	logSQL("SELECT SUSER_SNAME()")
+	if err := db.QueryRow("SELECT SUSER_SNAME()").Scan(&login); err != nil {
+		return fmt.Errorf("couldn't check permissions: %v", err)
+	}
+	var missing, excess []string
+	for _, c := range permissionChecks() {
+		var held sql.NullInt64
+		logSQL(c.query)
+		if err := db.QueryRow(c.query).Scan(&held); err != nil {
+			return fmt.Errorf("couldn't check %s: %v", c.what, err)
+		}
+		switch {
+		case c.excess && held.Int64 == 1:
+			excess = append(excess, c.what)
+		case c.excess:
+		case !held.Valid:
+			missing = append(missing, c.what+" (not found, or not visible to "+login+")")
+		case held.Int64 != 1:
+			missing = append(missing, c.what)
+		}
+	}
+	// dictionary queries may read any table, so they are run for no rows to find out
+	for _, d := range dictionaries {
+		if len(d.query) == 0 {
+			continue
+		}
+		q := "SELECT TOP 0 * FROM (" + d.query + ") q"
+		logSQL(q)
+		rows, err := db.Query(q)
+		if err != nil {
+			missing = append(missing, "the query of dictionary "+d.name+": "+err.Error())
+			continue
+		}
+		rows.Close()
+	}
+	for _, m := range missing {
+		log.Println("Permission missing for", login+":", m)
+	}
+	for _, e := range excess {
+		log.Println("Permission beyond what is needed held by", login+":", e)
+	}
+	switch {
+	case len(missing) > 0:
+		return fmt.Errorf("%s lacks %d permissions a run needs on %s; see the log for the list", login, len(missing), connDatabase(dbName))
+	case len(excess) > 0:
+		return fmt.Errorf("refusing to run with -least-privilege: %s holds %d permissions a run doesn't need on %s; see the log for the list", login, len(excess), connDatabase(dbName))
+	}
+	log.Println("Checked the permissions of", login)
+	return nil
+}
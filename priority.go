@@ -0,0 +1,136 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+)
+
+// parseOrder are the -order criteria sprocs are fetched and parsed by, most important first, so a run stopped by
+// -max-duration covers the sprocs that matter most. Each criterion breaks the ties of the previous one, and name
+// order the ties of the last.
+var parseOrder orderFlag
+
+// orderCriteria are the criteria -order accepts: the priority of the -owners rule matching the sproc, highest
+// first; its executions since the server cached its plan, most first; and the length of its definition, longest
+// first
+var orderCriteria = map[string]bool{"priority": true, "executions": true, "size": true}
+
+// executionCounts holds the number of executions of each sproc of the current run, keyed by upper-cased sproc
+// entry. It is nil unless -order includes executions and the run reads a database.
+var executionCounts map[string]int64
+
+// executionCountsQ sums the executions of each sproc of the database still in the plan cache, which needs VIEW
+// SERVER STATE
+const executionCountsQ = `
+SELECT SCHEMA_NAME(o.schema_id), o.name, SUM(ps.execution_count)
+FROM sys.dm_exec_procedure_stats ps
+JOIN sys.objects o ON o.object_id = ps.object_id
+WHERE ps.database_id = DB_ID()
+GROUP BY o.schema_id, o.name
+`
+
+// orderFlag is a flag.Value for a comma-separated list of orderCriteria
+type orderFlag []string
+
+func (o *orderFlag) String() string {
+	return strings.Join(*o, ",")
+}
+
+func (o *orderFlag) Set(v string) error {
+	var criteria []string
+	for _, c := range strings.Split(v, ",") {
+		c = strings.ToLower(strings.TrimSpace(c))
+		if !orderCriteria[c] {
+			return fmt.Errorf("unknown order %q (available: priority, executions, size)", c)
+		}
+		criteria = append(criteria, c)
+	}
+	*o = criteria
+	return nil
+}
+
+// orders reports whether -order includes a criterion
+func (o orderFlag) orders(criterion string) bool {
+	for _, c := range o {
+		if c == criterion {
+			return true
+		}
+	}
+	return false
+}
+
+// loadExecutionCounts fetches how often each sproc of the database was executed, when -order needs it. Only a
+// warning is logged if the server won't tell, leaving the sprocs in the order of the other criteria.
+func loadExecutionCounts(db *sql.DB) {
+	executionCounts = nil
+	if !parseOrder.orders("executions") {
+		return
+	}
+	logSQL(executionCountsQ)
+	rows, err := db.Query(executionCountsQ)
+	if err != nil {
+		log.Println("Couldn't read execution counts, which needs VIEW SERVER STATE:", err)
+		return
+	}
+	defer rows.Close()
+	counts := make(map[string]int64)
+	for rows.Next() {
+		var (
+			schema, name string
+			count        int64
+		)
+		if err = rows.Scan(&schema, &name, &count); err != nil {
+			log.Println("Couldn't read execution counts:", err)
+			return
+		}
+		counts[strings.ToUpper(sprocEntry(schema, name, 0))] = count
+	}
+	if err = rows.Err(); err != nil {
+		log.Println("Couldn't read execution counts:", err)
+		return
+	}
+	executionCounts = counts
+}
+
+// orderValue returns the value of a sproc for an -order criterion, greater for the more important
+func orderValue(criterion string, sproc keyValue) int64 {
+	switch criterion {
+	case "priority":
+		if r := ownerRuleOf(sproc.key); r != nil {
+			return int64(r.Priority)
+		}
+	case "executions":
+		return executionCounts[strings.ToUpper(strings.SplitN(sproc.key, ";", 2)[0])]
+	case "size":
+		return int64(len(sproc.value))
+	}
+	return 0
+}
+
+// prioritize sorts sprocs by the -order criteria, leaving them in the order given when there are none. Sprocs
+// yet to be fetched have no definition, so size doesn't order them.
+func prioritize(sprocs []keyValue) {
+	if len(parseOrder) == 0 {
+		return
+	}
+	values := make(map[string][]int64, len(sprocs))
+	for _, s := range sprocs {
+		v := make([]int64, len(parseOrder))
+		for i, c := range parseOrder {
+			v[i] = orderValue(c, s)
+		}
+		values[s.key] = v
+	}
+	sort.SliceStable(sprocs, func(i, j int) bool {
+		a, b := values[sprocs[i].key], values[sprocs[j].key]
+		for k := range a {
+			if a[k] != b[k] {
+				return a[k] > b[k]
+			}
+		}
+		return false
+	})
+}
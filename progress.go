@@ -0,0 +1,52 @@
+package main
+
+import (
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	pb "gopkg.in/cheggaaa/pb.v1"
+)
+
+// phase tracks the progress of one phase of a run, such as fetching the definitions or parsing them, with a
+// progress bar of its own. A nil phase ignores progress, so workers needn't care whether one was started.
+type phase struct {
+	name   string
+	start  time.Time
+	bar    *pb.ProgressBar
+	finish sync.Once
+}
+
+// parsing is the parsing phase of the current run, started once the definitions have been saved
+var parsing *phase
+
+// startPhase starts a phase of a run over total items
+func startPhase(name string, total int) *phase {
+	p := &phase{name: name, start: time.Now(), bar: pb.New(total).Prefix(name + " ")}
+	p.bar.ShowFinalTime = true
+	p.bar.ShowBar = true
+	p.bar.SetMaxWidth(80)
+	// redrawing the bar only makes sense on a terminal; logs collected from a container or scheduler would fill up
+	p.bar.NotPrint = !isTerminal(os.Stdout)
+	p.bar.Start()
+	return p
+}
+
+// Increment records an item of the phase as done
+func (p *phase) Increment() {
+	if p != nil {
+		p.bar.Increment()
+	}
+}
+
+// Finish ends the phase, logging how much of it was done and how long it took. Only the first call counts.
+func (p *phase) Finish() {
+	if p == nil {
+		return
+	}
+	p.finish.Do(func() {
+		p.bar.Finish()
+		log.Printf("%s: %d of %d done in %s", p.name, p.bar.Get(), p.bar.Total, time.Since(p.start).Round(time.Second))
+	})
+}
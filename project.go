@@ -0,0 +1,206 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// sourcePath is a .dacpac file or SSDT project directory read instead of the database when set
+var sourcePath string
+
+var (
+	createProcRe  = regexp.MustCompile(`(?im)^\s*CREATE\s+(?:OR\s+ALTER\s+)?PROC(?:EDURE)?\s+((?:\[[^\]]+\]|[\w@#$]+)(?:\s*\.\s*(?:\[[^\]]+\]|[\w@#$]+))*)`)
+	createTableRe = regexp.MustCompile(`(?im)^\s*CREATE\s+(?:TABLE|VIEW)\s+((?:\[[^\]]+\]|[\w@#$]+)(?:\s*\.\s*(?:\[[^\]]+\]|[\w@#$]+))*)`)
+	goRe          = regexp.MustCompile(`(?im)^\s*GO\s*$`)
+)
+
+// projectObjectName turns a schema-qualified name from a project into the name a database run would report:
+// objects of dbo by their name alone, others as schema.name
+func projectObjectName(qualified string) (name string, dbo bool) {
+	var parts []string
+	for _, p := range strings.Split(qualified, ".") {
+		parts = append(parts, removeBrackets(strings.TrimSpace(p)))
+	}
+	if len(parts) == 1 || strings.EqualFold(parts[len(parts)-2], "dbo") {
+		return parts[len(parts)-1], true
+	}
+	return strings.Join(parts[len(parts)-2:], "."), false
+}
+
+// excludedSproc reports whether a sproc is one of the system procedures the database query leaves out
+func excludedSproc(name string) bool {
+	lower := strings.ToLower(name)
+	return strings.HasPrefix(lower, "sp_") || strings.HasPrefix(lower, "xp_") || strings.HasPrefix(lower, "ms_")
+}
+
+// getProjectSprocs loads the sprocs and tables of the dacpac or SSDT project at sourcePath and sends the sprocs
+// to be parsed, like getSprocs does for a database. There is no account master or dictionary to load.
+func getProjectSprocs(defDir string, outCh chan<- keyValue) error {
+	log.Println("Reading", sourcePath)
+	defer close(outCh)
+	var (
+		defs   []keyValue
+		tables map[string]struct{}
+		err    error
+	)
+	if strings.EqualFold(filepath.Ext(sourcePath), ".dacpac") {
+		defs, tables, err = readDacpac(sourcePath)
+	} else {
+		defs, tables, err = readSSDTProject(sourcePath)
+	}
+	if err != nil {
+		return err
+	}
+	whitelist = tables
+	log.Println("Loaded table whitelist with", len(whitelist), "values")
+	loadReferenceData(func() error {
+		// a project has no catalog of the database it deploys to, so missing objects can't be told apart
+		catalog, historyTables, sprocDates, executionCounts = nil, nil, nil, nil
+		portfolios.reset()
+		return nil
+	})
+	sort.Slice(defs, func(i, j int) bool { return defs[i].key < defs[j].key })
+	return sendDefinitions(defDir, defs, len(defs), nil, outCh)
+}
+
+// dacpacElement is an object of a dacpac's model.xml
+type dacpacElement struct {
+	Type        string           `xml:"Type,attr"`
+	Name        string           `xml:"Name,attr"`
+	Properties  []dacpacProperty `xml:"Property"`
+	Annotations []struct {
+		Type       string           `xml:"Type,attr"`
+		Properties []dacpacProperty `xml:"Property"`
+	} `xml:"Annotation"`
+}
+
+type dacpacProperty struct {
+	Name  string `xml:"Name,attr"`
+	Value string `xml:"Value,attr"`
+	Text  string `xml:"Value"`
+}
+
+func (e dacpacElement) property(name string) string {
+	for _, p := range e.Properties {
+		if p.Name == name {
+			return p.Value + p.Text
+		}
+	}
+	return ""
+}
+
+// readDacpac returns the sprocs and dbo tables in a dacpac's model. A procedure's definition is rebuilt from the
+// header the model keeps in its SysCommentsObjectAnnotation followed by its BodyScript.
+func readDacpac(path string) (defs []keyValue, tables map[string]struct{}, err error) {
+	z, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer z.Close()
+	var model struct {
+		Elements []dacpacElement `xml:"Model>Element"`
+	}
+	for _, f := range z.File {
+		if f.Name != "model.xml" {
+			continue
+		}
+		r, err := f.Open()
+		if err != nil {
+			return nil, nil, err
+		}
+		err = xml.NewDecoder(r).Decode(&model)
+		r.Close()
+		if err != nil {
+			return nil, nil, fmt.Errorf("reading model.xml of %s: %v", path, err)
+		}
+	}
+	if model.Elements == nil {
+		return nil, nil, fmt.Errorf("%s has no model.xml", path)
+	}
+	tables = make(map[string]struct{})
+	for _, e := range model.Elements {
+		name, dbo := projectObjectName(e.Name)
+		switch e.Type {
+		case "SqlTable", "SqlView":
+			if dbo {
+				tables[strings.ToUpper(name)] = struct{}{}
+			}
+		case "SqlProcedure":
+			if excludedSproc(name) {
+				continue
+			}
+			header := "CREATE PROCEDURE " + e.Name + "\nAS"
+			for _, a := range e.Annotations {
+				if a.Type != "SysCommentsObjectAnnotation" {
+					continue
+				}
+				for _, p := range a.Properties {
+					if p.Name == "HeaderContents" {
+						header = p.Value + p.Text
+					}
+				}
+			}
+			body := e.property("BodyScript")
+			if len(body) > 0 && !strings.ContainsAny(body[:1], " \t\r\n") {
+				body = "\n" + body
+			}
+			defs = append(defs, keyValue{key: name, value: header + body})
+		}
+	}
+	return defs, tables, nil
+}
+
+// readSSDTProject returns the sprocs and dbo tables created by the .sql files of an SSDT project directory,
+// leaving out its build output
+func readSSDTProject(dir string) (defs []keyValue, tables map[string]struct{}, err error) {
+	tables = make(map[string]struct{})
+	err = filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			if n := strings.ToLower(fi.Name()); n == "bin" || n == "obj" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.EqualFold(filepath.Ext(path), ".sql") {
+			return nil
+		}
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		text := decodeSQLFile(b)
+		for _, m := range createTableRe.FindAllStringSubmatch(text, -1) {
+			if name, dbo := projectObjectName(m[1]); dbo {
+				tables[strings.ToUpper(name)] = struct{}{}
+			}
+		}
+		for _, loc := range createProcRe.FindAllStringSubmatchIndex(text, -1) {
+			name, _ := projectObjectName(text[loc[2]:loc[3]])
+			if excludedSproc(name) {
+				continue
+			}
+			// the procedure runs to the end of its batch
+			def := text[loc[0]:]
+			if end := goRe.FindStringIndex(def); end != nil {
+				def = def[:end[0]]
+			}
+			defs = append(defs, keyValue{key: name, value: strings.TrimSpace(def)})
+		}
+		return nil
+	})
+	if err == nil && len(defs) == 0 {
+		err = fmt.Errorf("no stored procedures found in %s", dir)
+	}
+	return defs, tables, err
+}
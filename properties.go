@@ -0,0 +1,95 @@
+package main
+
+import (
+	"database/sql"
+	"sort"
+	"strings"
+	"time"
+)
+
+const sprocPropertiesQ = `
+SELECT SCHEMA_NAME(o.schema_id), o.name, ep.name, CAST(ep.value AS nvarchar(4000))
+FROM sys.extended_properties ep
+JOIN sys.objects o ON o.object_id = ep.major_id
+WHERE ep.class = 1 AND ep.minor_id = 0 AND o.type IN ('P', 'PC', 'X')
+`
+
+const sprocDatesQ = `
+SELECT SCHEMA_NAME(schema_id), name, create_date, modify_date FROM sys.objects WHERE type IN ('P', 'PC', 'X')
+`
+
+// sprocDates holds when each sproc of the current run was created and last modified, keyed by upper-cased sproc
+// entry. It is nil when the run does not read a database.
+var sprocDates map[string][2]string
+
+// loadSprocDates fetches the create and modify dates of every sproc of the sproc database
+func loadSprocDates(db *sql.DB) error {
+	logSQL(sprocDatesQ)
+	rows, err := db.Query(sprocDatesQ)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	dates := make(map[string][2]string)
+	for rows.Next() {
+		var (
+			schema, name      string
+			created, modified time.Time
+		)
+		if err = rows.Scan(&schema, &name, &created, &modified); err != nil {
+			return err
+		}
+		dates[strings.ToUpper(sprocEntry(schema, name, 0))] = [2]string{
+			formatTime(created), formatTime(modified)}
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	sprocDates = dates
+	return nil
+}
+
+// sprocDate returns when a sproc was created and last modified, or empty strings if that is not known. The
+// procedures of a numbered group share the dates of the group.
+func sprocDate(sproc string) (created, modified string) {
+	d := sprocDates[strings.ToUpper(strings.SplitN(sproc, ";", 2)[0])]
+	return d[0], d[1]
+}
+
+// writeSprocProperties writes sproc_properties.csv, the extended properties, such as MS_Description or ownership
+// tags, of each sproc to be analyzed
+func writeSprocProperties(db *sql.DB, sprocNames []string) error {
+	logSQL(sprocPropertiesQ)
+	rows, err := db.Query(sprocPropertiesQ)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	analyzed := make(map[string]bool, len(sprocNames))
+	for _, sn := range sprocNames {
+		analyzed[strings.ToUpper(sn)] = true
+	}
+	var out [][]string
+	for rows.Next() {
+		var (
+			schema, name, property string
+			value                  sql.NullString
+		)
+		if err = rows.Scan(&schema, &name, &property, &value); err != nil {
+			return err
+		}
+		if sn := sprocEntry(schema, name, 0); analyzed[strings.ToUpper(sn)] {
+			out = append(out, []string{sn, property, value.String})
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i][0] != out[j][0] {
+			return out[i][0] < out[j][0]
+		}
+		return out[i][1] < out[j][1]
+	})
+	return writeCSVFile("sproc_properties.csv", []string{"Stored Procedure", "Property", "Value"}, out)
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	commands["query"] = command{
+		summary: "search a report across the history of runs",
+		run:     query,
+	}
+}
+
+// runDirs returns the run directories under root in chronological order, optionally only those for one host.
+// Run directories are named <date>T<time>_<source> by outDirPath, those of earlier versions <date>_<host>.
+func runDirs(root, host string) ([]string, error) {
+	infos, err := ioutil.ReadDir(root)
+	if err != nil {
+		return nil, err
+	}
+	var dirs []string
+	for _, fi := range infos {
+		parts := strings.SplitN(fi.Name(), "_", 2)
+		if !fi.IsDir() || len(parts) != 2 || len(host) > 0 && !strings.EqualFold(runHost(fi.Name()), host) {
+			continue
+		}
+		if !isRunDir(filepath.Join(root, fi.Name())) {
+			continue
+		}
+		dirs = append(dirs, filepath.Join(root, fi.Name()))
+	}
+	sort.Slice(dirs, func(i, j int) bool {
+		return filepath.Base(dirs[i]) < filepath.Base(dirs[j])
+	})
+	return dirs, nil
+}
+
+// isRunDir reports whether dir holds a run that got as far as fetching the sproc definitions
+func isRunDir(dir string) bool {
+	_, err := os.Stat(filepath.Join(dir, "definition_hashes.csv"))
+	return err == nil
+}
+
+// history is a report row along with the runs it appeared in
+type history struct {
+	row  []string
+	runs []string
+}
+
+func query(args []string) error {
+	fs := flag.NewFlagSet("query", flag.ExitOnError)
+	root := fs.String("root", ".", "directory holding run directories")
+	host := fs.String("host", "", "only search runs against this host")
+	all := fs.Bool("all", false, "print every matching row of every run instead of when each was first and last seen")
+	fs.StringVar(&resultsDB.host, "results-host", "", "search the runs written to the tables of -results-database on this server instead of -root")
+	fs.StringVar(&resultsDB.database, "results-database", resultsDB.database, "database of the -results-host tables")
+	fs.StringVar(&resultsDB.schema, "results-schema", resultsDB.schema, "schema of the -results-host tables")
+	fs.StringVar(&dbUser, "user", "", "SQL login to connect to -results-host as (default: integrated Windows authentication)")
+	fs.StringVar(&secretRef, "secret", "", "password for -user, fetched at runtime from vault://<path>#<field> or azurekv://<vault>/<secret>")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, `Usage: sprocs query [flags] <report> [column=value...]
+
+Prints the rows of a report (e.g. table_sources) matching every column=value filter, ignoring case, with the
+first and last runs each appeared in. For example, to find when usp_Load started reading Positions:
+
+  sprocs query table_sources "Stored Procedure=usp_Load" "Table Used=POSITIONS"
+
+The runs searched are the run directories under -root or, with -results-host, the runs written to the results
+tables by -results-host, which keep them after their directories are gone.
+
+Flags:`)
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	name := strings.TrimSuffix(fs.Arg(0), ".csv")
+	filters := make(map[string]string)
+	for _, arg := range fs.Args()[1:] {
+		kv := strings.SplitN(arg, "=", 2)
+		if len(kv) != 2 {
+			fs.Usage()
+			os.Exit(2)
+		}
+		filters[kv[0]] = kv[1]
+	}
+	var (
+		header []string
+		rows   []*history
+		byKey  = make(map[string]*history)
+		runs   []string
+	)
+	// add records the rows of the report of a run matching the filters, its first record being the header
+	add := func(run string, records [][]string) error {
+		if len(records) == 0 {
+			return nil
+		}
+		runs = append(runs, run)
+		if header == nil {
+			header = records[0]
+			for col := range filters {
+				if indexOf(header, col) < 0 {
+					return fmt.Errorf("%s has no column %q; its columns are %s", name, col, strings.Join(header, ", "))
+				}
+			}
+		}
+	rows:
+		for _, rec := range records[1:] {
+			for col, value := range filters {
+				if i := indexOf(records[0], col); i < 0 || i >= len(rec) || !strings.EqualFold(rec[i], value) {
+					continue rows
+				}
+			}
+			key := strings.Join(rec, "\x00")
+			h, ok := byKey[key]
+			if !ok {
+				h = &history{row: rec}
+				byKey[key] = h
+				rows = append(rows, h)
+			}
+			h.runs = append(h.runs, run)
+		}
+		return nil
+	}
+	var err error
+	if len(resultsDB.host) > 0 {
+		err = queryResultsDB(name, *host, filters, add)
+	} else {
+		err = queryRunDirs(*root, *host, name, add)
+	}
+	if err != nil {
+		return err
+	}
+	if header == nil {
+		return fmt.Errorf("no run contains %s.csv", name)
+	}
+	w := csv.NewWriter(os.Stdout)
+	if *all {
+		w.Write(safeRow(append([]string{"Run"}, header...)))
+		for _, run := range runs {
+			for _, h := range rows {
+				if indexOf(h.runs, run) >= 0 {
+					w.Write(safeRow(append([]string{run}, h.row...)))
+				}
+			}
+		}
+	} else {
+		w.Write(safeRow(append([]string{"First Seen", "Last Seen", "Runs"}, header...)))
+		for _, h := range rows {
+			w.Write(safeRow(append([]string{h.runs[0], h.runs[len(h.runs)-1], strconv.Itoa(len(h.runs))}, h.row...)))
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// queryRunDirs passes add the report of each run directory under root, oldest first, optionally only those of
+// one host
+func queryRunDirs(root, host, report string, add func(run string, records [][]string) error) error {
+	dirs, err := runDirs(root, host)
+	if err != nil {
+		return err
+	}
+	if len(dirs) == 0 {
+		return errors.New("no runs found in " + root)
+	}
+	for _, dir := range dirs {
+		records, err := readCSVFile(filepath.Join(dir, report+".csv"))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err = add(filepath.Base(dir), records); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// queryResultsDB passes add the rows of a report matching filters from each run numbered in the AnalysisRuns of
+// -results-host, oldest first, optionally only those of one host. Runs are named after their directories, as
+// they are when searched under -root.
+func queryResultsDB(report, host string, filters map[string]string, add func(run string, records [][]string) error) error {
+	dsn, err := connStringFor(resultsDB.host, resultsDB.database, dbUser, secretRef)
+	if err != nil {
+		return err
+	}
+	db, err := openDB(dsn)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	schema := quoteName(resultsDB.schema)
+	table := schema + "." + quoteName(resultTableName(report))
+	q := "SELECT name FROM sys.columns WHERE object_id = OBJECT_ID(?) AND name <> N'RunID' ORDER BY column_id"
+	logSQL(q)
+	rows, err := db.Query(sqlDriver.bind(q), table)
+	if err != nil {
+		return err
+	}
+	var header []string
+	for rows.Next() {
+		var col string
+		if err = rows.Scan(&col); err != nil {
+			rows.Close()
+			return err
+		}
+		header = append(header, col)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	if len(header) == 0 {
+		// no run has written the report
+		return nil
+	}
+	q = "SELECT RunID, RunDirectory FROM " + schema + "." + quoteName("AnalysisRuns") + " ORDER BY RunID"
+	logSQL(q)
+	if rows, err = db.Query(q); err != nil {
+		return err
+	}
+	names := make(map[int64]string)
+	for rows.Next() {
+		var (
+			id  int64
+			dir string
+		)
+		if err = rows.Scan(&id, &dir); err != nil {
+			rows.Close()
+			return err
+		}
+		// the directory is as the run named it, on Windows with backslashes
+		if name := filepath.Base(strings.Replace(dir, `\`, "/", -1)); len(host) == 0 || strings.EqualFold(runHost(name), host) {
+			names[id] = name
+		}
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	cols := make([]string, len(header))
+	for i, h := range header {
+		cols[i] = quoteName(h)
+	}
+	q = "SELECT RunID, " + strings.Join(cols, ", ") + " FROM " + table
+	// the filters are checked again as the runs are added, so only those naming a column need be applied here
+	var (
+		conds []string
+		args  []interface{}
+	)
+	for col, value := range filters {
+		if i := indexOf(header, col); i >= 0 {
+			conds = append(conds, "UPPER("+cols[i]+") = UPPER(?)")
+			args = append(args, value)
+		}
+	}
+	if len(conds) > 0 {
+		q += " WHERE " + strings.Join(conds, " AND ")
+	}
+	q += " ORDER BY RunID"
+	logSQL(q)
+	if rows, err = db.Query(sqlDriver.bind(q), args...); err != nil {
+		return err
+	}
+	defer rows.Close()
+	var (
+		run     int64 = -1
+		records [][]string
+	)
+	// flush adds the rows read of the current run, sorted as its report file was
+	flush := func() error {
+		if len(records) == 0 {
+			return nil
+		}
+		sortRows(records)
+		err := add(names[run], append([][]string{header}, records...))
+		records = nil
+		return err
+	}
+	values := make([]sql.NullString, len(header))
+	dest := make([]interface{}, len(header)+1)
+	var id int64
+	dest[0] = &id
+	for i := range values {
+		dest[i+1] = &values[i]
+	}
+	for rows.Next() {
+		if err = rows.Scan(dest...); err != nil {
+			return err
+		}
+		if id != run {
+			if err = flush(); err != nil {
+				return err
+			}
+			run = id
+		}
+		if _, ok := names[id]; !ok {
+			continue
+		}
+		rec := make([]string, len(values))
+		for i, v := range values {
+			rec[i] = v.String
+		}
+		records = append(records, rec)
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	return flush()
+}
+
+func indexOf(list []string, s string) int {
+	for i, v := range list {
+		if strings.EqualFold(v, s) {
+			return i
+		}
+	}
+	return -1
+}
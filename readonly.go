@@ -0,0 +1,75 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+)
+
+var (
+	// snapshotName is a database snapshot of -database to query instead of the database itself
+	snapshotName string
+	// requireReadOnly refuses to run against a database that can be written to
+	requireReadOnly bool
+)
+
+const (
+	// readOnlyQ describes the database connected to: whether it can be written to, and the database it is a
+	// snapshot of, if any
+	readOnlyQ = `
+SELECT CAST(DATABASEPROPERTYEX(DB_NAME(), 'Updateability') AS nvarchar(128)), COALESCE(DB_NAME(source_database_id), '')
+FROM sys.databases WHERE database_id = DB_ID()
+`
+	// secondaryQ counts the local secondary replicas of the database connected to, which needs VIEW SERVER STATE
+	secondaryQ = `
+SELECT COUNT(*) FROM sys.dm_hadr_database_replica_states rs
+JOIN sys.dm_hadr_availability_replica_states ars ON ars.replica_id = rs.replica_id
+WHERE rs.database_id = DB_ID() AND rs.is_local = 1 AND ars.role_desc = 'SECONDARY'
+`
+)
+
+// connDatabase returns the database to connect to: the -snapshot of -database when one is named
+func connDatabase(database string) string {
+	if len(snapshotName) > 0 {
+		return snapshotName
+	}
+	return database
+}
+
+// checkReadOnly verifies the database connected to can't be written to when -read-only or -snapshot is set, so
+// a run is guaranteed to have no effect on it, and that a -snapshot is a snapshot of -database
+func checkReadOnly(db *sql.DB) error {
+	if !requireReadOnly && len(snapshotName) == 0 {
+		return nil
+	}
+	var updateability, source string
+	logSQL(readOnlyQ)
+	if err := db.QueryRow(readOnlyQ).Scan(&updateability, &source); err != nil {
+		return fmt.Errorf("couldn't check the database is read-only: %v", err)
+	}
+	if len(snapshotName) > 0 && !strings.EqualFold(source, dbName) {
+		if len(source) == 0 {
+			return fmt.Errorf("%s is not a database snapshot", snapshotName)
+		}
+		return fmt.Errorf("%s is a snapshot of %s, not of %s", snapshotName, source, dbName)
+	}
+	if updateability != "READ_ONLY" {
+		return fmt.Errorf("refusing to run: %s on %s can be written to (%s); connect to a readable secondary or a database snapshot", connDatabase(dbName), dbHost, updateability)
+	}
+	var secondaries int
+	if len(source) == 0 {
+		// only to say what kind of read-only database this is, so not being allowed to tell is no reason to stop
+		logSQL(secondaryQ)
+		db.QueryRow(secondaryQ).Scan(&secondaries)
+	}
+	switch {
+	case len(source) > 0:
+		log.Println("Connected to", connDatabase(dbName)+", a read-only snapshot of", source)
+	case secondaries > 0:
+		log.Println("Connected to", connDatabase(dbName)+", on a read-only secondary replica")
+	default:
+		log.Println("Connected to", connDatabase(dbName)+", a read-only database")
+	}
+	return nil
+}
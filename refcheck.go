@@ -0,0 +1,209 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"hash/fnv"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/antlr/antlr4/runtime/Go/antlr"
+	parser "github.com/nycmonkey/sprocs/tsql"
+)
+
+// referencedTablesQ lists the tables of the database a sproc depends on, as SQL Server recorded them when the sproc
+// was created. Tables in other databases have no object_id here, so are left out, as are the columns referenced.
+const referencedTablesQ = `
+SELECT DISTINCT OBJECT_SCHEMA_NAME(r.referenced_id), OBJECT_NAME(r.referenced_id)
+FROM sys.dm_sql_referenced_entities(?, 'OBJECT') r
+JOIN sys.objects o ON o.object_id = r.referenced_id
+WHERE r.referenced_minor_id = 0 AND o.type = 'U'
+`
+
+// referenceCheck holds the tables SQL Server says each sampled sproc depends on, captured when -verify-sample
+// is set
+var referenceCheck = struct {
+	sync.Mutex
+	sample int
+	tables map[string]map[string]bool
+}{tables: make(map[string]map[string]bool)}
+
+// verifySampleFlag is a flag.Value setting the number of sprocs whose tables are checked against
+// sys.dm_sql_referenced_entities, and registering the referenceCheckExtractor reporting them
+type verifySampleFlag struct{}
+
+func (verifySampleFlag) String() string {
+	return strconv.Itoa(referenceCheck.sample)
+}
+
+func (verifySampleFlag) Set(v string) error {
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return errors.New("expected a number of sprocs")
+	}
+	if n > 0 && referenceCheck.sample == 0 {
+		RegisterExtractor(referenceCheckExtractor{})
+	}
+	referenceCheck.sample = n
+	return nil
+}
+
+// sampleSprocs picks the sprocs whose tables are checked: the -verify-sample of them with the lowest hashes of
+// their names, so runs check the same sprocs while they exist and a change in agreement means a change in the
+// parser or the sprocs
+func sampleSprocs(sprocs []string) map[string]bool {
+	n := referenceCheck.sample
+	if n == 0 {
+		return nil
+	}
+	hashes := make(map[string]uint32, len(sprocs))
+	for _, sp := range sprocs {
+		h := fnv.New32a()
+		h.Write([]byte(strings.ToUpper(sp)))
+		hashes[sp] = h.Sum32()
+	}
+	sorted := append([]string(nil), sprocs...)
+	sort.Slice(sorted, func(i, j int) bool {
+		if hashes[sorted[i]] != hashes[sorted[j]] {
+			return hashes[sorted[i]] < hashes[sorted[j]]
+		}
+		return sorted[i] < sorted[j]
+	})
+	if n > len(sorted) {
+		n = len(sorted)
+	}
+	sample := make(map[string]bool, n)
+	for _, sp := range sorted[:n] {
+		sample[sp] = true
+	}
+	return sample
+}
+
+// captureReferences records the tables of the database SQL Server says a sproc depends on
+func captureReferences(db *sql.DB, sproc string) error {
+	name, number := sprocObject(sproc)
+	if number > 1 {
+		// sys.dm_sql_referenced_entities describes a numbered group as a whole
+		return errors.New("references of numbered procedures are not checked")
+	}
+	logSQL(referencedTablesQ, name)
+	rows, err := db.Query(sqlDriver.bind(referencedTablesQ), name)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	tables := make(map[string]bool)
+	for rows.Next() {
+		var schema, table sql.NullString
+		if err = rows.Scan(&schema, &table); err != nil {
+			return err
+		}
+		if !table.Valid {
+			continue
+		}
+		// the parser reports only the tables of the whitelist, so those are all that are compared
+		t := runNames.normalizeTableName(table.String)
+		if _, ok := whitelist[t]; ok {
+			tables[t] = true
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	referenceCheck.Lock()
+	referenceCheck.tables[sproc] = tables
+	referenceCheck.Unlock()
+	return nil
+}
+
+// referenceCheckExtractor reports the tables of each sampled sproc found by the parser or by SQL Server, and
+// which found each
+type referenceCheckExtractor struct{}
+
+func (referenceCheckExtractor) Name() string {
+	return "reference_check"
+}
+
+func (referenceCheckExtractor) Columns() []string {
+	return []string{"Table", "In Catalog", "Found By Parser"}
+}
+
+func (referenceCheckExtractor) NewListener(sproc string, names *nameScope, emit func([]string)) antlr.ParseTreeListener {
+	l := &referenceCheckListener{sproc: sproc, emit: emit, parsed: make(map[string]bool)}
+	l.TableListener = NewTableListener(sproc, names, func(f []string) {
+		l.parsed[strings.ToUpper(f[0])] = true
+	})
+	return l
+}
+
+// Summarize writes reference_check_summary.csv, the agreement between the parser and SQL Server for each sampled
+// sproc, and logs it for the whole sample
+func (referenceCheckExtractor) Summarize(rows [][]string) error {
+	type counts struct{ catalog, parsed, agreed, tables int }
+	bySproc := make(map[string]*counts)
+	var total counts
+	for _, row := range rows {
+		c, ok := bySproc[row[0]]
+		if !ok {
+			c = new(counts)
+			bySproc[row[0]] = c
+		}
+		inCatalog, parsed := row[2] == "Y", row[3] == "Y"
+		for _, t := range []*counts{c, &total} {
+			t.tables++
+			if inCatalog {
+				t.catalog++
+			}
+			if parsed {
+				t.parsed++
+			}
+			if inCatalog && parsed {
+				t.agreed++
+			}
+		}
+	}
+	out := make([][]string, 0, len(bySproc))
+	for sproc, c := range bySproc {
+		out = append(out, []string{sproc, strconv.Itoa(c.catalog), strconv.Itoa(c.parsed), strconv.Itoa(c.agreed),
+			yesNo(c.agreed == c.tables), formatDecimal(100*float64(c.agreed)/float64(c.tables), 1) + "%"})
+	}
+	sortRows(out)
+	if total.tables > 0 {
+		log.Printf("Reference check: the parser and SQL Server agree on %d of %d tables of %d sampled sprocs",
+			total.agreed, total.tables, len(bySproc))
+	}
+	return writeCSVFile("reference_check_summary.csv", []string{"Stored Procedure", "Catalog Tables", "Parsed Tables",
+		"Agreed Tables", "Agree", "Agreement"}, out)
+}
+
+// referenceCheckListener finds the tables a sproc uses like a TableListener, and compares them with those SQL
+// Server says it depends on
+type referenceCheckListener struct {
+	*TableListener
+	sproc  string
+	emit   func([]string)
+	parsed map[string]bool
+}
+
+// ExitTsql_file is called when the parser reaches the end of the TSQL input, at which point every table found by
+// either the parser or SQL Server is emitted
+func (l *referenceCheckListener) ExitTsql_file(ctx *parser.Tsql_fileContext) {
+	l.TableListener.ExitTsql_file(ctx)
+	referenceCheck.Lock()
+	tables, captured := referenceCheck.tables[l.sproc]
+	referenceCheck.Unlock()
+	if !captured {
+		return
+	}
+	for table := range tables {
+		l.emit([]string{table, "Y", yesNo(l.parsed[table])})
+	}
+	for table := range l.parsed {
+		if !tables[table] {
+			l.emit([]string{table, "N", "Y"})
+		}
+	}
+}
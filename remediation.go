@@ -0,0 +1,150 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/antlr/antlr4/runtime/Go/antlr"
+)
+
+// maxSuggestions is how many likely intended tables are suggested for each table missing from the whitelist
+const maxSuggestions = 3
+
+func init() {
+	RegisterExtractor(&remediationExtractor{cache: make(map[string][]tableSuggestion)})
+}
+
+// remediationExtractor reports the tables a sproc uses that are left out of table_sources.csv for not being in the
+// whitelist, with the whitelisted tables most likely meant instead: a typo away, or the same name with a prefix
+// or suffix added or dropped, as a renamed or versioned table often has
+type remediationExtractor struct {
+	mu sync.Mutex
+	// cache holds the suggestions for each upper-cased missing table of the run, as many sprocs miss the same ones
+	cache map[string][]tableSuggestion
+}
+
+// tableSuggestion is a whitelisted table suggested for a missing one, and how far its name is from it
+type tableSuggestion struct {
+	table    string
+	distance int
+	reason   string
+}
+
+func (e *remediationExtractor) Name() string {
+	return "whitelist_misses"
+}
+
+func (e *remediationExtractor) Columns() []string {
+	return []string{"Table", "Access", "Suggestion", "Edit Distance", "Reason"}
+}
+
+func (e *remediationExtractor) NewListener(sproc string, names *nameScope, emit func([]string)) antlr.ParseTreeListener {
+	l := NewTableListener(sproc, names, func([]string) {})
+	l.missed = func(table, access string) {
+		suggestions := e.suggest(names, table)
+		if len(suggestions) == 0 {
+			emit([]string{table, access, "", "", e.unknownReason(table)})
+			return
+		}
+		for _, s := range suggestions {
+			emit([]string{table, access, s.table, strconv.Itoa(s.distance), s.reason})
+		}
+	}
+	return l
+}
+
+// Close forgets the suggestions of the run, as the next one may have another whitelist
+func (e *remediationExtractor) Close() error {
+	e.mu.Lock()
+	e.cache = make(map[string][]tableSuggestion)
+	e.mu.Unlock()
+	return nil
+}
+
+// unknownReason says what is known of a table with no likely replacement
+func (e *remediationExtractor) unknownReason(table string) string {
+	if last, ok := lastCatalog[strings.ToUpper(table)]; ok {
+		return "dropped or renamed since " + last.run
+	}
+	return "no similar table"
+}
+
+// suggest returns the tables of the whitelist of names most likely meant by a table missing from it, closest
+// first. Only the suggestions of the run are cached.
+func (e *remediationExtractor) suggest(names *nameScope, table string) []tableSuggestion {
+	key := strings.ToUpper(table)
+	if names == runNames {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		if s, ok := e.cache[key]; ok {
+			return s
+		}
+	}
+	// allow about one edit per four characters, so short names don't match everything
+	limit := len(key) / 4
+	if limit < 1 {
+		limit = 1
+	}
+	var suggestions []tableSuggestion
+	for name := range names.tables() {
+		var s tableSuggestion
+		switch d := editDistance(key, name); {
+		case d <= limit:
+			s = tableSuggestion{name, d, "similar name"}
+		case len(key) >= 4 && (strings.Contains(name, key) || strings.Contains(key, name) && len(name) >= 4):
+			s = tableSuggestion{name, d, "name with prefix or suffix"}
+		default:
+			continue
+		}
+		if _, ok := lastCatalog[key]; ok {
+			s.reason += ", and " + table + " was dropped or renamed since " + lastCatalog[key].run
+		}
+		suggestions = append(suggestions, s)
+	}
+	sort.Slice(suggestions, func(i, j int) bool {
+		if suggestions[i].distance != suggestions[j].distance {
+			return suggestions[i].distance < suggestions[j].distance
+		}
+		return suggestions[i].table < suggestions[j].table
+	})
+	if len(suggestions) > maxSuggestions {
+		suggestions = suggestions[:maxSuggestions]
+	}
+	if names == runNames {
+		e.cache[key] = suggestions
+	}
+	return suggestions
+}
+
+// editDistance returns the number of single-character insertions, deletions and substitutions turning a into b
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/antlr/antlr4/runtime/Go/antlr"
+)
+
+func init() {
+	commands["simulate-rename"] = command{
+		summary: "list the sprocs a table rename would affect, optionally rewriting their definitions",
+		run:     simulateRename,
+	}
+}
+
+// renameRef is a reference to the renamed table found in a definition
+type renameRef struct {
+	line, column int
+	text         string
+	// start and stop are the rune offsets of the reference in the definition; a reference inside a string
+	// literal isn't rewritten, as dynamic SQL may build the name in pieces
+	start, stop int
+	literal     bool
+}
+
+func simulateRename(args []string) error {
+	fs := flag.NewFlagSet("simulate-rename", flag.ExitOnError)
+	from := fs.String("from", "", "table being renamed, [schema.]name")
+	to := fs.String("to", "", "new name of the table, [schema.]name")
+	run := fs.String("run", "", "run directory whose definitions are searched (default: the latest under -root)")
+	root := fs.String("root", ".", "directory holding run directories")
+	rewrite := fs.String("rewrite", "", "write each affected definition, with the references replaced, to this directory")
+	addParseFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, `Usage: sprocs simulate-rename [flags] -from dbo.OldTable -to dbo.NewTable
+
+Writes Stored Procedure,Impact,Line,Column,Reference,Via rows as CSV to stdout: each reference to the table in a
+saved definition ("direct", or "dynamic SQL" inside a string literal, which -rewrite leaves alone), and each sproc
+using the table through the procedures it calls ("inherited"), from the run's table_dependencies.csv.
+
+Flags:`)
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	if len(*from) == 0 || len(*to) == 0 || fs.NArg() > 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	dir := *run
+	if len(dir) == 0 {
+		dirs, err := runDirs(*root, "")
+		if err != nil {
+			return err
+		}
+		if len(dirs) == 0 {
+			return errors.New("no runs found in " + *root)
+		}
+		dir = dirs[len(dirs)-1]
+	}
+	files, err := readManifest(dir)
+	if err != nil {
+		return err
+	}
+	sprocs := make([]string, 0, len(files))
+	for sproc := range files {
+		sprocs = append(sprocs, sproc)
+	}
+	sort.Strings(sprocs)
+	if len(*rewrite) > 0 {
+		if err = os.MkdirAll(*rewrite, 0755); err != nil {
+			return err
+		}
+	}
+	w := csv.NewWriter(os.Stdout)
+	w.UseCRLF = true
+	w.Write([]string{"Stored Procedure", "Impact", "Line", "Column", "Reference", "Via"})
+	fromSchema, fromTable := splitTableName(*from)
+	direct := make(map[string]bool)
+	for _, sproc := range sprocs {
+		b, err := ioutil.ReadFile(filepath.Join(dir, "sproc_definitions", files[sproc]))
+		if err != nil {
+			return err
+		}
+		def := string(b)
+		refs := findTableRefs(def, fromSchema, fromTable)
+		var rewritable []renameRef
+		for _, ref := range refs {
+			impact := "direct"
+			if ref.literal {
+				impact = "dynamic SQL"
+			} else {
+				rewritable = append(rewritable, ref)
+				direct[strings.ToUpper(sproc)] = true
+			}
+			w.Write(safeRow([]string{sproc, impact, strconv.Itoa(ref.line), strconv.Itoa(ref.column), ref.text, ""}))
+		}
+		if len(*rewrite) > 0 && len(rewritable) > 0 {
+			out := renameRefs(def, rewritable, *to)
+			if err = ioutil.WriteFile(filepath.Join(*rewrite, files[sproc]), []byte(out), 0644); err != nil {
+				return err
+			}
+		}
+	}
+	deps, err := readCSVFile(filepath.Join(dir, "table_dependencies.csv"))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if len(deps) > 0 {
+		table := runNames.normalizeTableName(*from)
+		for _, rec := range deps[1:] {
+			if len(rec) >= 5 && rec[3] == "inherited" && strings.EqualFold(rec[1], table) && !direct[strings.ToUpper(rec[0])] {
+				w.Write(safeRow([]string{rec[0], "inherited", "", "", rec[1], rec[4]}))
+			}
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// splitTableName returns the unbracketed schema, dbo when none is given, and name of a table
+func splitTableName(name string) (schema, table string) {
+	parts := strings.Split(name, ".")
+	table = removeBrackets(parts[len(parts)-1])
+	schema = "dbo"
+	if len(parts) > 1 {
+		schema = removeBrackets(parts[len(parts)-2])
+	}
+	return
+}
+
+// findTableRefs returns the references to a table in a definition: dotted names ending in the table, or naming it
+// as the qualifier of a column, with its schema unless the schema is dbo. Names in string literals are reported too.
+func findTableRefs(def, schema, table string) []renameRef {
+	tokens, _ := lexTSQL("", def, nil)
+	var refs []renameRef
+	for i := 0; i < len(tokens); i++ {
+		t := tokens[i]
+		if t.GetTokenType() == antlr.TokenEOF || t.GetChannel() != antlr.TokenDefaultChannel {
+			continue
+		}
+		if strings.HasPrefix(t.GetText(), "'") || strings.HasPrefix(t.GetText(), "N'") {
+			refs = append(refs, literalRefs(t, schema, table)...)
+			continue
+		}
+		if !isIdentifier(t.GetText()) {
+			continue
+		}
+		// collect the dotted name starting here
+		parts := []antlr.Token{t}
+		for i+2 < len(tokens) && tokens[i+1].GetText() == "." && isIdentifier(tokens[i+2].GetText()) {
+			parts = append(parts, tokens[i+2])
+			i += 2
+		}
+		for k, p := range parts {
+			if !strings.EqualFold(removeBrackets(p.GetText()), table) || k < len(parts)-2 {
+				continue
+			}
+			first := k
+			if k > 0 {
+				if !strings.EqualFold(removeBrackets(parts[k-1].GetText()), schema) {
+					continue
+				}
+				first = k - 1
+			} else if !strings.EqualFold(schema, "dbo") {
+				continue
+			}
+			refs = append(refs, renameRef{
+				line:   parts[first].GetLine(),
+				column: parts[first].GetColumn() + 1,
+				text:   def[byteOffset(def, parts[first].GetStart()):byteOffset(def, p.GetStop()+1)],
+				start:  parts[first].GetStart(),
+				stop:   p.GetStop(),
+			})
+			break
+		}
+	}
+	return refs
+}
+
+// literalRefs returns the mentions of a table inside a string literal token
+func literalRefs(t antlr.Token, schema, table string) []renameRef {
+	text := strings.ToUpper(t.GetText())
+	var refs []renameRef
+	for _, name := range []string{schema + "." + table, table} {
+		if strings.Contains(text, strings.ToUpper(name)) {
+			refs = append(refs, renameRef{line: t.GetLine(), column: t.GetColumn() + 1, text: t.GetText(), literal: true})
+			break
+		}
+	}
+	return refs
+}
+
+// renameRefs replaces each reference in a definition with the new name, bracketed if the old one was
+func renameRefs(def string, refs []renameRef, to string) string {
+	toSchema, toTable := splitTableName(to)
+	runes := []rune(def)
+	var out []rune
+	last := 0
+	for _, ref := range refs {
+		qualified := strings.Contains(ref.text, ".") || !strings.EqualFold(toSchema, "dbo")
+		bracket := func(s string) string {
+			if strings.Contains(ref.text, "[") {
+				return "[" + s + "]"
+			}
+			return s
+		}
+		name := bracket(toTable)
+		if qualified {
+			name = bracket(toSchema) + "." + name
+		}
+		out = append(out, runes[last:ref.start]...)
+		out = append(out, []rune(name)...)
+		last = ref.stop + 1
+	}
+	out = append(out, runes[last:]...)
+	return string(out)
+}
+
+// byteOffset converts a rune offset in s, as antlr reports positions, to a byte offset
+func byteOffset(s string, runeOffset int) int {
+	n := 0
+	for i := range s {
+		if n == runeOffset {
+			return i
+		}
+		n++
+	}
+	return len(s)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/antlr/antlr4/runtime/Go/antlr"
+)
+
+// benchExtractor stands in for an extractor whose findings the benchmark makes up
+type benchExtractor int
+
+func (e benchExtractor) Name() string { return "bench_" + strconv.Itoa(int(e)) }
+
+func (benchExtractor) Columns() []string {
+	return []string{"Table", "Column", "Operator", "Value", "Line"}
+}
+
+func (benchExtractor) NewListener(string, *nameScope, func([]string)) antlr.ParseTreeListener {
+	return nil
+}
+
+// BenchmarkWriteReports streams the results of 10,000 sprocs of 300 findings each, 50 for each of 6 reports,
+// through writeReports, reporting how far the heap in use grows above where it started while it runs
+func BenchmarkWriteReports(b *testing.B) {
+	const sprocs, reports, rowsPerReport = 10000, 6, 50
+	dir, err := ioutil.TempDir("", "sprocs-bench")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(old string) { outDir = old }(outDir)
+	outDir = dir
+	exts := make([]Extractor, reports)
+	for i := range exts {
+		exts[i] = benchExtractor(i)
+	}
+	var base runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&base)
+	var peak uint64
+	stop := make(chan struct{})
+	sampled := make(chan struct{})
+	go func() {
+		defer close(sampled)
+		var m runtime.MemStats
+		for {
+			runtime.ReadMemStats(&m)
+			if m.HeapInuse > peak {
+				peak = m.HeapInuse
+			}
+			select {
+			case <-stop:
+				return
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	}()
+	b.ResetTimer()
+	start := time.Now()
+	for n := 0; n < b.N; n++ {
+		results := make(chan sprocResult, resultBuffer)
+		done := make(chan struct{})
+		go writeReports(exts, results, newRunStats(exts), done)
+		for s := 0; s < sprocs; s++ {
+			res := sprocResult{sproc: fmt.Sprintf("dbo.usp_Load_%05d", s), findings: make(map[string][][]string)}
+			for _, e := range exts {
+				rows := make([][]string, rowsPerReport)
+				for r := range rows {
+					rows[r] = []string{fmt.Sprintf("dbo.Positions_%d", r), "AccountID", "=", "@account_" + strconv.Itoa(s),
+						strconv.Itoa(r + 1)}
+				}
+				res.findings[e.Name()] = rows
+			}
+			results <- res
+		}
+		close(results)
+		<-done
+	}
+	b.StopTimer()
+	elapsed := time.Since(start)
+	close(stop)
+	<-sampled
+	b.ReportMetric(float64(peak-base.HeapInuse)/(1<<20), "peak-heap-MB")
+	b.ReportMetric(float64(sprocs*reports*rowsPerReport)*float64(b.N)/elapsed.Seconds(), "rows/s")
+}
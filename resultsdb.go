@@ -0,0 +1,205 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// resultsDB is where -results-host writes the reports of each run, as tables rather than files
+var resultsDB = struct {
+	host, database, schema string
+}{database: "BRS_Analysis", schema: "dbo"}
+
+// resultTables names the tables of the reports whose names say less than they should in SQL; other reports are
+// written to a table named after the file, e.g. TableDependencies for table_dependencies.csv
+var resultTables = map[string]string{
+	"table_sources": "SprocTableUsage",
+	"calls":         "SprocCalls",
+}
+
+// maxParams is how many parameters a query may have, fewer than SQL Server's limit of 2100
+const maxParams = 2000
+
+// resultTableName returns the table the rows of a report are written to
+func resultTableName(file string) string {
+	name := strings.TrimSuffix(file, filepath.Ext(file))
+	if t, ok := resultTables[name]; ok {
+		return t
+	}
+	var b strings.Builder
+	for _, word := range strings.FieldsFunc(name, func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) }) {
+		b.WriteString(strings.ToUpper(word[:1]) + word[1:])
+	}
+	return b.String()
+}
+
+// quoteName brackets an identifier, as QUOTENAME does
+func quoteName(name string) string {
+	return "[" + strings.Replace(name, "]", "]]", -1) + "]"
+}
+
+// quoteString returns s as a Unicode string literal
+func quoteString(s string) string {
+	return "N'" + strings.Replace(s, "'", "''", -1) + "'"
+}
+
+// writeResultsDB copies every report of the run in dir to a table of -results-database, creating the schema, the
+// tables and any columns reports have gained since they were created. Each run is numbered in AnalysisRuns and its
+// rows carry the number, so earlier runs stay queryable.
+func writeResultsDB(dir string) error {
+	dsn, err := connStringFor(resultsDB.host, resultsDB.database, dbUser, secretRef)
+	if err != nil {
+		return err
+	}
+	// the results are written, so the connection can't be routed to a read-only secondary
+	dsn = strings.Replace(dsn, "ApplicationIntent=ReadOnly", "ApplicationIntent=ReadWrite", 1)
+	db, err := openDB(dsn)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	files, err := filepath.Glob(filepath.Join(dir, "*.csv"))
+	if err != nil {
+		return err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	schema := quoteName(resultsDB.schema)
+	runs := schema + "." + quoteName("AnalysisRuns")
+	stmts := []string{
+		// CREATE SCHEMA must be alone in its batch
+		fmt.Sprintf("IF SCHEMA_ID(%s) IS NULL EXEC(%s)", quoteString(resultsDB.schema), quoteString("CREATE SCHEMA "+schema)),
+		fmt.Sprintf(`IF OBJECT_ID(%s, N'U') IS NULL CREATE TABLE %s (
+	RunID int IDENTITY PRIMARY KEY,
+	RunTime datetime2 NOT NULL,
+	SourceHost nvarchar(128) NOT NULL,
+	SourceDatabase nvarchar(128) NOT NULL,
+	RunDirectory nvarchar(400) NOT NULL,
+	PartialRun bit NOT NULL
+)`, quoteString(runs), runs),
+	}
+	for _, q := range stmts {
+		logSQL(q)
+		if _, err = tx.Exec(q); err != nil {
+			return err
+		}
+	}
+	var runID int64
+	q := "INSERT INTO " + runs + " (RunTime, SourceHost, SourceDatabase, RunDirectory, PartialRun) OUTPUT INSERTED.RunID VALUES (?, ?, ?, ?, ?)"
+	logSQL(q)
+	if err = tx.QueryRow(sqlDriver.bind(q), time.Now(), sourceName(), dbName, dir, runBudget.reached).Scan(&runID); err != nil {
+		return err
+	}
+	for _, file := range files {
+		records, err := readCSVFile(file)
+		if err != nil {
+			return err
+		}
+		if len(records) == 0 {
+			continue
+		}
+		table := schema + "." + quoteName(resultTableName(filepath.Base(file)))
+		if err = ensureResultTable(tx, table, records[0]); err != nil {
+			return fmt.Errorf("%s: %v", filepath.Base(file), err)
+		}
+		if err = insertResults(tx, table, runID, records[0], records[1:]); err != nil {
+			return fmt.Errorf("%s: %v", filepath.Base(file), err)
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+	log.Printf("Wrote %d reports to %s.%s on %s as run %d", len(files), resultsDB.database, resultsDB.schema, resultsDB.host, runID)
+	return nil
+}
+
+// ensureResultTable creates the table of a report, or adds the columns of the header it lacks
+func ensureResultTable(tx *sql.Tx, table string, header []string) error {
+	q := "SELECT name FROM sys.columns WHERE object_id = OBJECT_ID(?)"
+	logSQL(q)
+	rows, err := tx.Query(sqlDriver.bind(q), table)
+	if err != nil {
+		return err
+	}
+	existing := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err = rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+		existing[strings.ToUpper(name)] = true
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	var stmts []string
+	if len(existing) == 0 {
+		cols := []string{"RunID int NOT NULL"}
+		for _, h := range header {
+			cols = append(cols, quoteName(h)+" nvarchar(max) NULL")
+		}
+		stmts = append(stmts, "CREATE TABLE "+table+" ("+strings.Join(cols, ", ")+")", "CREATE INDEX "+quoteName("IX_RunID")+" ON "+table+" (RunID)")
+	} else {
+		for _, h := range header {
+			if !existing[strings.ToUpper(h)] {
+				stmts = append(stmts, "ALTER TABLE "+table+" ADD "+quoteName(h)+" nvarchar(max) NULL")
+			}
+		}
+	}
+	for _, q := range stmts {
+		logSQL(q)
+		if _, err = tx.Exec(q); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// insertResults inserts the rows of a report, as many to a statement as the parameter limit allows
+func insertResults(tx *sql.Tx, table string, runID int64, header []string, rows [][]string) error {
+	cols := []string{"RunID"}
+	for _, h := range header {
+		cols = append(cols, quoteName(h))
+	}
+	prefix := "INSERT INTO " + table + " (" + strings.Join(cols, ", ") + ") VALUES "
+	tuple := "(" + strings.TrimSuffix(strings.Repeat("?, ", len(cols)), ", ") + ")"
+	batch := maxParams / len(cols)
+	if batch > 1000 {
+		// the most rows a VALUES list may have
+		batch = 1000
+	}
+	for len(rows) > 0 {
+		n := batch
+		if n > len(rows) {
+			n = len(rows)
+		}
+		tuples := make([]string, n)
+		args := make([]interface{}, 0, n*len(cols))
+		for i, row := range rows[:n] {
+			tuples[i] = tuple
+			args = append(args, runID)
+			for j := range header {
+				if j < len(row) {
+					args = append(args, row[j])
+				} else {
+					args = append(args, nil)
+				}
+			}
+		}
+		if _, err := tx.Exec(sqlDriver.bind(prefix+strings.Join(tuples, ", ")), args...); err != nil {
+			return err
+		}
+		rows = rows[n:]
+	}
+	return nil
+}
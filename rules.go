@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/antlr/antlr4/runtime/Go/antlr"
+	parser "github.com/nycmonkey/sprocs/tsql"
+)
+
+// patternRule is one entry of the rules file given to -rules. Exactly one of Regex and Tokens must be set.
+type patternRule struct {
+	Name string `json:"name"`
+	// Regex is matched against the raw definition text
+	Regex string `json:"regex"`
+	// Tokens is a whitespace-separated token pattern: each element matches a token whose type (e.g. STRING) or
+	// case-insensitive text equals it, and `*` matches any single token. Comments are not considered.
+	Tokens   string `json:"tokens"`
+	Severity string `json:"severity"`
+	// Column is the report column that matches of this rule are written to
+	Column string `json:"column"`
+
+	re      *regexp.Regexp
+	pattern []string
+}
+
+// ruleExtractor applies the user-defined pattern rules to every definition, consolidating matches into one report
+type ruleExtractor struct {
+	rules   []*patternRule
+	columns []string
+}
+
+// rulesFlag is a flag.Value that loads a rules file and registers a ruleExtractor for it
+type rulesFlag struct{}
+
+func (rulesFlag) String() string {
+	return ""
+}
+
+func (rulesFlag) Set(path string) error {
+	e, err := loadRules(path)
+	if err != nil {
+		return err
+	}
+	RegisterExtractor(e)
+	return nil
+}
+
+func loadRules(path string) (*ruleExtractor, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var rules []*patternRule
+	if err = json.Unmarshal(b, &rules); err != nil {
+		return nil, fmt.Errorf("invalid rules file %s: %v", path, err)
+	}
+	e := &ruleExtractor{rules: rules}
+	seen := make(map[string]bool)
+	for i, r := range rules {
+		if len(r.Name) == 0 {
+			return nil, fmt.Errorf("invalid rules file %s: rule %d has no name", path, i+1)
+		}
+		switch {
+		case len(r.Regex) > 0 && len(r.Tokens) == 0:
+			if r.re, err = regexp.Compile(r.Regex); err != nil {
+				return nil, fmt.Errorf("invalid rules file %s: rule %s: %v", path, r.Name, err)
+			}
+		case len(r.Tokens) > 0 && len(r.Regex) == 0:
+			r.pattern = strings.Fields(r.Tokens)
+		default:
+			return nil, fmt.Errorf("invalid rules file %s: rule %s must have exactly one of regex and tokens", path, r.Name)
+		}
+		switch r.Severity {
+		case "":
+			r.Severity = severityWarning
+		case severityWarning, severityError:
+		default:
+			return nil, fmt.Errorf("invalid rules file %s: rule %s has unknown severity %q, not %s or %s", path, r.Name, r.Severity, severityWarning, severityError)
+		}
+		if len(r.Column) == 0 {
+			r.Column = "Match"
+		}
+		if !seen[r.Column] {
+			seen[r.Column] = true
+			e.columns = append(e.columns, r.Column)
+		}
+	}
+	if len(rules) == 0 {
+		return nil, errors.New("rules file " + path + " has no rules")
+	}
+	return e, nil
+}
+
+func (e *ruleExtractor) Name() string {
+	return "findings"
+}
+
+func (e *ruleExtractor) Columns() []string {
+	return append([]string{"Rule", "Severity", "Line"}, e.columns...)
+}
+
+func (e *ruleExtractor) NewListener(sproc string, names *nameScope, emit func([]string)) antlr.ParseTreeListener {
+	return &ruleListener{&parser.BasetsqlListener{}, e, emit}
+}
+
+// row lays out a match of r in the report, placing the matched text under the rule's output column
+func (e *ruleExtractor) row(r *patternRule, line int, match string) []string {
+	row := []string{r.Name, r.Severity, strconv.Itoa(line)}
+	for _, col := range e.columns {
+		if col == r.Column {
+			row = append(row, match)
+		} else {
+			row = append(row, "")
+		}
+	}
+	return row
+}
+
+type ruleListener struct {
+	*parser.BasetsqlListener
+	extractor *ruleExtractor
+	emit      func([]string)
+}
+
+// ExitTsql_file is called when the parser reaches the end of the TSQL input,
+// at which point every rule is applied to the definition text and token stream
+func (l *ruleListener) ExitTsql_file(ctx *parser.Tsql_fileContext) {
+	input := ctx.GetStart().GetInputStream()
+	text := input.GetText(0, input.Size()-1)
+	var tokens []antlr.Token
+	if stream, ok := ctx.GetParser().GetTokenStream().(*antlr.CommonTokenStream); ok {
+		for _, t := range stream.GetAllTokens() {
+			if t.GetChannel() == antlr.TokenDefaultChannel && t.GetTokenType() != antlr.TokenEOF {
+				tokens = append(tokens, t)
+			}
+		}
+	}
+	for _, r := range l.extractor.rules {
+		if r.re != nil {
+			for _, loc := range r.re.FindAllStringIndex(text, -1) {
+				line := strings.Count(text[:loc[0]], "\n") + 1
+				l.emit(l.extractor.row(r, line, text[loc[0]:loc[1]]))
+			}
+			continue
+		}
+		for i := 0; i+len(r.pattern) <= len(tokens); i++ {
+			if !matchTokens(ctx.GetParser(), r.pattern, tokens[i:i+len(r.pattern)]) {
+				continue
+			}
+			texts := make([]string, 0, len(r.pattern))
+			for _, t := range tokens[i : i+len(r.pattern)] {
+				texts = append(texts, t.GetText())
+			}
+			l.emit(l.extractor.row(r, tokens[i].GetLine(), strings.Join(texts, " ")))
+		}
+	}
+}
+
+func matchTokens(recog antlr.Recognizer, pattern []string, tokens []antlr.Token) bool {
+	for i, elem := range pattern {
+		if elem == "*" || elem == tokenName(recog, tokens[i]) || strings.EqualFold(elem, tokens[i].GetText()) {
+			continue
+		}
+		return false
+	}
+	return true
+}
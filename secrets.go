@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	vaultScheme   = `vault`
+	azureKVScheme = `azurekv`
+	azureKVAPI    = `7.4`
+	azureIMDSURL  = `http://169.254.169.254/metadata/identity/oauth2/token?api-version=2018-02-01&resource=https%3A%2F%2Fvault.azure.net`
+)
+
+var secretClient = &http.Client{Timeout: 30 * time.Second}
+
+// resolveSecret fetches the secret identified by ref at runtime. Two backends are supported:
+//
+//	vault://<path>#<field>      HashiCorp Vault, addressed by VAULT_ADDR and authenticated with VAULT_TOKEN
+//	azurekv://<vault>/<secret>  Azure Key Vault, authenticated with AZURE_KEYVAULT_TOKEN or the managed identity of the host
+//
+// Both KV v1 and KV v2 Vault mounts are understood; for v2 the path must include the `data/` segment
+func resolveSecret(ref string) (string, error) {
+	u, err := url.Parse(ref)
+	if err != nil {
+		return "", fmt.Errorf("invalid secret reference %q: %v", ref, err)
+	}
+	switch u.Scheme {
+	case vaultScheme:
+		return vaultSecret(strings.Trim(u.Host+u.Path, "/"), u.Fragment)
+	case azureKVScheme:
+		return azureKVSecret(u.Host, strings.Trim(u.Path, "/"))
+	default:
+		return "", fmt.Errorf("unsupported secret backend %q (expected %s:// or %s://)", u.Scheme, vaultScheme, azureKVScheme)
+	}
+}
+
+func vaultSecret(path, field string) (string, error) {
+	addr := os.Getenv("VAULT_ADDR")
+	if len(addr) == 0 {
+		return "", errors.New("VAULT_ADDR is not set")
+	}
+	token := os.Getenv("VAULT_TOKEN")
+	if len(token) == 0 {
+		return "", errors.New("VAULT_TOKEN is not set")
+	}
+	if len(field) == 0 {
+		field = "password"
+	}
+	req, err := http.NewRequest("GET", strings.TrimSuffix(addr, "/")+"/v1/"+path, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", token)
+	var body struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err = getJSON(req, &body); err != nil {
+		return "", fmt.Errorf("reading vault secret %s: %v", path, err)
+	}
+	data := body.Data
+	if nested, ok := data["data"].(map[string]interface{}); ok {
+		// KV v2 wraps the secret in a second data envelope alongside its metadata
+		data = nested
+	}
+	v, ok := data[field].(string)
+	if !ok {
+		return "", fmt.Errorf("vault secret %s has no string field %q", path, field)
+	}
+	return v, nil
+}
+
+func azureKVSecret(vault, name string) (string, error) {
+	if len(vault) == 0 || len(name) == 0 {
+		return "", errors.New("azure key vault reference must look like azurekv://<vault>/<secret>")
+	}
+	token := os.Getenv("AZURE_KEYVAULT_TOKEN")
+	if len(token) == 0 {
+		var err error
+		if token, err = azureIMDSToken(); err != nil {
+			return "", fmt.Errorf("acquiring managed identity token: %v", err)
+		}
+	}
+	host := vault
+	if !strings.Contains(host, ".") {
+		host += ".vault.azure.net"
+	}
+	req, err := http.NewRequest("GET", "https://"+host+"/secrets/"+name+"?api-version="+azureKVAPI, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	var body struct {
+		Value string `json:"value"`
+	}
+	if err = getJSON(req, &body); err != nil {
+		return "", fmt.Errorf("reading key vault secret %s/%s: %v", vault, name, err)
+	}
+	return body.Value, nil
+}
+
+func azureIMDSToken() (string, error) {
+	req, err := http.NewRequest("GET", azureIMDSURL, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata", "true")
+	var body struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err = getJSON(req, &body); err != nil {
+		return "", err
+	}
+	return body.AccessToken, nil
+}
+
+func getJSON(req *http.Request, v interface{}) error {
+	resp, err := secretClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.New("unexpected response: " + resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
@@ -0,0 +1,379 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+func init() {
+	commands["serve"] = command{
+		summary: "host a web UI over the latest run",
+		run:     serve,
+	}
+}
+
+// server hosts the results of the most recent run found under root
+type server struct {
+	root string
+	// base is the path the server's routes are mounted under
+	base    string
+	targets []*target
+	// names are what POST /analyze resolves names against when no target is given
+	names *nameScope
+
+	keys    []apiKey
+	limiter *rateLimiter
+	audit   *log.Logger
+
+	mu      sync.Mutex
+	run     *runResults
+	runTime time.Time
+}
+
+func serve(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := fs.String("addr", ":8080", "address to listen on")
+	root := fs.String("root", ".", "directory holding run directories; the most recently modified is served")
+	keysFile := fs.String("api-keys", "", "CSV file of Client,Key rows; when set, every request must present a key")
+	rate := fs.Int("rate", 0, "requests per minute allowed each client (0 for no limit)")
+	auditFile := fs.String("audit-log", "", "file to which every request is logged with the client that made it")
+	maxAge := fs.Duration("max-age", 48*time.Hour, "age beyond which the last successful run makes /readyz fail")
+	targetsFile := fs.String("targets", "", "JSON file of named databases to analyze on a schedule, each served under /t/<name>/ from <root>/<name>")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: sprocs serve [flags]\n\nFlags:")
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	if fs.NArg() != 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	// taken before any target is analyzed, which changes the sproc database
+	s := &server{root: *root, names: snapshotNames()}
+	var err error
+	if len(*keysFile) > 0 {
+		if s.keys, err = loadAPIKeys(*keysFile); err != nil {
+			return err
+		}
+	}
+	if *rate > 0 {
+		s.limiter = newRateLimiter(*rate)
+	}
+	if len(*auditFile) > 0 {
+		if s.audit, err = openAuditLog(*auditFile); err != nil {
+			return err
+		}
+	}
+	if len(*targetsFile) > 0 {
+		if s.targets, err = loadTargets(*targetsFile); err != nil {
+			return err
+		}
+		for _, t := range s.targets {
+			go t.schedule(*root)
+		}
+	} else if _, err = s.latest(); err != nil {
+		return err
+	}
+	log.Println("Serving", *root, "on", *addr)
+	mux := http.NewServeMux()
+	// probes are answered without authentication, rate limiting or auditing
+	mux.HandleFunc("/healthz", handleHealthz)
+	mux.HandleFunc("/readyz", s.handleReadyz(*maxAge))
+	mux.Handle("/", s.protect(s.routes()))
+	return http.ListenAndServe(*addr, mux)
+}
+
+func (s *server) routes() *http.ServeMux {
+	mux := http.NewServeMux()
+	if len(s.base) == 0 {
+		mux.HandleFunc("/analyze", s.handleAnalyze)
+	}
+	if len(s.targets) > 0 {
+		mux.HandleFunc("/", s.handleTargets)
+		for _, t := range s.targets {
+			sub := &server{root: filepath.Join(s.root, t.Name), base: "/t/" + t.Name}
+			mux.Handle(sub.base+"/", http.StripPrefix(sub.base, sub.routes()))
+		}
+		return mux
+	}
+	mux.HandleFunc("/", s.handleIndex)
+	mux.HandleFunc("/sproc", s.handleSproc)
+	mux.HandleFunc("/value", s.handleValue)
+	mux.HandleFunc("/tables", s.handleTables)
+	mux.HandleFunc("/codes", s.handleCodes)
+	mux.HandleFunc("/graph", s.handleGraph)
+	mux.HandleFunc("/graph.json", s.handleGraphJSON)
+	return mux
+}
+
+// latest returns the results of the most recently modified run directory, reloading them when a newer run appears
+func (s *server) latest() (*runResults, error) {
+	dirs, err := ioutil.ReadDir(s.root)
+	if err != nil {
+		return nil, err
+	}
+	var newest os.FileInfo
+	for _, fi := range dirs {
+		if !fi.IsDir() {
+			continue
+		}
+		if !isRunDir(filepath.Join(s.root, fi.Name())) {
+			continue
+		}
+		if newest == nil || fi.ModTime().After(newest.ModTime()) {
+			newest = fi
+		}
+	}
+	if newest == nil {
+		return nil, fmt.Errorf("no run directories found in %s", s.root)
+	}
+	dir := filepath.Join(s.root, newest.Name())
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.run != nil && s.run.dir == dir && !newest.ModTime().After(s.runTime) {
+		return s.run, nil
+	}
+	run, err := loadRun(dir)
+	if err != nil {
+		return nil, err
+	}
+	log.Println("Loaded run", dir)
+	s.run, s.runTime = run, newest.ModTime()
+	return run, nil
+}
+
+// page runs a handler against the latest run, rendering what it returns with the named template
+func (s *server) page(w http.ResponseWriter, name string, data func(run *runResults) interface{}) {
+	run, err := s.latest()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err = pages.ExecuteTemplate(w, name, struct {
+		Run  string
+		Base string
+		Data interface{}
+	}{filepath.Base(run.dir), s.base, data(run)}); err != nil {
+		log.Println("rendering", name+":", err)
+	}
+}
+
+func (s *server) handleIndex(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	q := r.FormValue("q")
+	s.page(w, "index", func(run *runResults) interface{} {
+		return struct {
+			Query  string
+			Sprocs []string
+		}{q, run.filter(q)}
+	})
+}
+
+// reportRows is a report's header and the rows selected from it for a page
+type reportRows struct {
+	Name   string
+	Header []string
+	Rows   [][]string
+}
+
+func (s *server) handleSproc(w http.ResponseWriter, r *http.Request) {
+	name := r.FormValue("name")
+	s.page(w, "sproc", func(run *runResults) interface{} {
+		var reports []reportRows
+		for _, rep := range run.reportNames() {
+			rr := reportRows{Name: rep, Header: run.reports[rep].header[1:]}
+			for _, row := range run.reports[rep].rows {
+				if row[0] == name {
+					rr.Rows = append(rr.Rows, row[1:])
+				}
+			}
+			if len(rr.Rows) > 0 {
+				reports = append(reports, rr)
+			}
+		}
+		def, err := run.definition(name)
+		if err != nil {
+			def = err.Error()
+		}
+		return struct {
+			Name       string
+			Reports    []reportRows
+			Definition string
+		}{name, reports, def}
+	})
+}
+
+// handleValue is the table- and code-centric view: every report row naming the value
+func (s *server) handleValue(w http.ResponseWriter, r *http.Request) {
+	value := r.FormValue("v")
+	s.page(w, "value", func(run *runResults) interface{} {
+		found := run.referencing(value)
+		var reports []reportRows
+		for _, rep := range run.reportNames() {
+			if len(found[rep]) > 0 {
+				reports = append(reports, reportRows{rep, run.reports[rep].header, found[rep]})
+			}
+		}
+		return struct {
+			Value   string
+			Reports []reportRows
+		}{value, reports}
+	})
+}
+
+// valueCounts lists the distinct values of one column of a report, with the number of sprocs naming each
+type valueCounts struct {
+	Title  string
+	Values []valueCount
+}
+
+type valueCount struct {
+	Value  string
+	Sprocs int
+}
+
+func (s *server) handleTables(w http.ResponseWriter, r *http.Request) {
+	s.page(w, "values", func(run *runResults) interface{} {
+		return valueCounts{"Tables", run.countValues("table_sources", 1)}
+	})
+}
+
+func (s *server) handleCodes(w http.ResponseWriter, r *http.Request) {
+	s.page(w, "values", func(run *runResults) interface{} {
+		return valueCounts{"Portfolio codes", run.countValues(portfolios.name, 2)}
+	})
+}
+
+// countValues counts the distinct sprocs naming each value of a report column, most referenced first
+func (r *runResults) countValues(report string, col int) []valueCount {
+	rep, ok := r.reports[report]
+	if !ok {
+		return nil
+	}
+	sprocs := make(map[string]map[string]bool)
+	for _, row := range rep.rows {
+		if col >= len(row) {
+			continue
+		}
+		if sprocs[row[col]] == nil {
+			sprocs[row[col]] = make(map[string]bool)
+		}
+		sprocs[row[col]][row[0]] = true
+	}
+	counts := make([]valueCount, 0, len(sprocs))
+	for v, s := range sprocs {
+		counts = append(counts, valueCount{v, len(s)})
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Sprocs != counts[j].Sprocs {
+			return counts[i].Sprocs > counts[j].Sprocs
+		}
+		return counts[i].Value < counts[j].Value
+	})
+	return counts
+}
+
+func (s *server) handleGraph(w http.ResponseWriter, r *http.Request) {
+	s.page(w, "graph", func(run *runResults) interface{} { return nil })
+}
+
+func (s *server) handleGraphJSON(w http.ResponseWriter, r *http.Request) {
+	run, err := s.latest()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	http.ServeFile(w, r, filepath.Join(run.dir, "graph.json"))
+}
+
+var pages = template.Must(template.New("").Parse(`
+{{define "header"}}<!DOCTYPE html><html><head><meta charset="utf-8"><title>sprocs: {{.Run}}</title><style>
+body{font-family:sans-serif;margin:1em 2em} table{border-collapse:collapse} td,th{border:1px solid #ccc;padding:2px 6px;text-align:left}
+pre{background:#f6f6f6;padding:1em;overflow:auto} nav a{margin-right:1em}
+</style></head><body><nav><b>{{.Run}}</b> <a href="{{$.Base}}/">Sprocs</a><a href="{{$.Base}}/tables">Tables</a><a href="{{$.Base}}/codes">Portfolio codes</a><a href="{{$.Base}}/graph">Graph</a></nav>{{end}}
+{{define "footer"}}</body></html>{{end}}
+
+{{define "targets"}}<!DOCTYPE html><html><head><meta charset="utf-8"><title>sprocs</title></head><body><h1>Targets</h1>
+<table><tr><th>Target</th><th>Host</th><th>Database</th><th>Last run</th><th>Status</th></tr>
+{{range .}}<tr><td><a href="/t/{{.Name}}/">{{.Name}}</a></td><td>{{.Host}}</td><td>{{.Database}}</td><td>{{.LastRun}}</td><td>{{.Status}}</td></tr>{{end}}</table>
+</body></html>{{end}}
+
+{{define "index"}}{{template "header" .}}
+<form><input name="q" value="{{.Data.Query}}" placeholder="filter by name"> <input type="submit" value="Filter"></form>
+<p>{{len .Data.Sprocs}} sprocs</p><ul>{{range .Data.Sprocs}}<li><a href="{{$.Base}}/sproc?name={{.}}">{{.}}</a></li>{{end}}</ul>
+{{template "footer"}}{{end}}
+
+{{define "sproc"}}{{template "header" .}}<h1>{{.Data.Name}}</h1>
+{{range .Data.Reports}}<h2>{{.Name}}</h2><table><tr>{{range .Header}}<th>{{.}}</th>{{end}}</tr>
+{{range .Rows}}<tr>{{range .}}<td><a href="{{$.Base}}/value?v={{.}}">{{.}}</a></td>{{end}}</tr>{{end}}</table>{{end}}
+<h2>Definition</h2><pre>{{.Data.Definition}}</pre>
+{{template "footer"}}{{end}}
+
+{{define "value"}}{{template "header" .}}<h1>{{.Data.Value}}</h1>
+{{range .Data.Reports}}<h2>{{.Name}}</h2><table><tr>{{range .Header}}<th>{{.}}</th>{{end}}</tr>
+{{range .Rows}}<tr>{{range $i, $c := .}}{{if eq $i 0}}<td><a href="{{$.Base}}/sproc?name={{$c}}">{{$c}}</a></td>{{else}}<td>{{$c}}</td>{{end}}{{end}}</tr>{{end}}</table>{{else}}<p>Not referenced.</p>{{end}}
+{{template "footer"}}{{end}}
+
+{{define "values"}}{{template "header" .}}<h1>{{.Data.Title}}</h1>
+<table><tr><th>Value</th><th>Sprocs</th></tr>{{range .Data.Values}}<tr><td><a href="{{$.Base}}/value?v={{.Value}}">{{.Value}}</a></td><td>{{.Sprocs}}</td></tr>{{end}}</table>
+{{template "footer"}}{{end}}
+
+{{define "graph"}}{{template "header" .}}
+<p>Tables read (blue) and written (red) by each sproc (grey). Drag to rearrange; double-click a node for details.</p>
+<canvas id="g" width="1200" height="800" style="border:1px solid #ccc"></canvas>
+<script>
+fetch({{.Base}} + "/graph.json").then(r => r.json()).then(g => {
+  const c = document.getElementById("g"), ctx = c.getContext("2d"), byId = {};
+  g.nodes.forEach(n => { n.x = Math.random() * c.width; n.y = Math.random() * c.height; n.vx = n.vy = 0; byId[n.id] = n; });
+  g.links.forEach(l => { l.s = byId[l.source]; l.t = byId[l.target]; });
+  let drag = null;
+  function step() {
+    for (const a of g.nodes) for (const b of g.nodes) {
+      if (a === b) continue;
+      const dx = a.x - b.x, dy = a.y - b.y, d2 = dx * dx + dy * dy + 0.01;
+      a.vx += dx / d2 * 20; a.vy += dy / d2 * 20;
+    }
+    for (const l of g.links) {
+      const dx = l.t.x - l.s.x, dy = l.t.y - l.s.y;
+      l.s.vx += dx * 0.005; l.s.vy += dy * 0.005; l.t.vx -= dx * 0.005; l.t.vy -= dy * 0.005;
+    }
+    for (const n of g.nodes) {
+      if (n === drag) continue;
+      n.vx += (c.width / 2 - n.x) * 0.001; n.vy += (c.height / 2 - n.y) * 0.001;
+      n.x += n.vx *= 0.6; n.y += n.vy *= 0.6;
+    }
+    ctx.clearRect(0, 0, c.width, c.height);
+    for (const l of g.links) {
+      ctx.strokeStyle = l.type === "write" ? "#c33" : "#36c";
+      ctx.beginPath(); ctx.moveTo(l.s.x, l.s.y); ctx.lineTo(l.t.x, l.t.y); ctx.stroke();
+    }
+    for (const n of g.nodes) {
+      ctx.fillStyle = n.group === 1 ? "#999" : "#000";
+      ctx.beginPath(); ctx.arc(n.x, n.y, 4, 0, 2 * Math.PI); ctx.fill();
+      ctx.fillText(n.name, n.x + 6, n.y + 3);
+    }
+    requestAnimationFrame(step);
+  }
+  const at = e => g.nodes.find(n => Math.hypot(n.x - e.offsetX, n.y - e.offsetY) < 6);
+  c.onmousedown = e => { drag = at(e); };
+  c.onmousemove = e => { if (drag) { drag.x = e.offsetX; drag.y = e.offsetY; } };
+  c.onmouseup = () => { drag = null; };
+  c.ondblclick = e => { const n = at(e); if (n) location = {{.Base}} + (n.group === 1 ? "/sproc?name=" : "/value?v=") + encodeURIComponent(n.name); };
+  step();
+});
+</script>
+{{template "footer"}}{{end}}
+`))
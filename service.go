@@ -0,0 +1,50 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+)
+
+func init() {
+	commands["service"] = command{
+		summary: "install, uninstall or run `sprocs serve` as a Windows service",
+		run:     service,
+	}
+}
+
+func service(args []string) error {
+	fs := flag.NewFlagSet("service", flag.ExitOnError)
+	name := fs.String("name", "sprocs", "name of the Windows service and of its event log source")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, `Usage: sprocs service [-name sprocs] install|uninstall|run [serve flags]
+
+install registers a service, started automatically at boot, that runs `+"`sprocs serve`"+` with the given flags,
+and an event log source to which its log output is written. As services start in the system directory,
+-root, -targets and the other paths should be absolute. uninstall removes both; run is invoked by the service
+control manager.
+
+Flags:`)
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	if fs.NArg() < 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	switch fs.Arg(0) {
+	case "install":
+		return installService(*name, fs.Args()[1:])
+	case "uninstall":
+		if fs.NArg() != 1 {
+			fs.Usage()
+			os.Exit(2)
+		}
+		return removeService(*name)
+	case "run":
+		return runService(*name, fs.Args()[1:])
+	}
+	fs.Usage()
+	os.Exit(2)
+	return nil
+}
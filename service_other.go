@@ -0,0 +1,19 @@
+// +build !windows
+
+package main
+
+import "errors"
+
+var errNoService = errors.New("services are only supported on Windows; run `sprocs serve` under the system's service manager instead")
+
+func installService(name string, args []string) error {
+	return errNoService
+}
+
+func removeService(name string) error {
+	return errNoService
+}
+
+func runService(name string, args []string) error {
+	return errNoService
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	advapi32                         = syscall.NewLazyDLL("advapi32.dll")
+	procOpenSCManager                = advapi32.NewProc("OpenSCManagerW")
+	procCreateService                = advapi32.NewProc("CreateServiceW")
+	procOpenService                  = advapi32.NewProc("OpenServiceW")
+	procDeleteService                = advapi32.NewProc("DeleteService")
+	procCloseServiceHandle           = advapi32.NewProc("CloseServiceHandle")
+	procStartServiceCtrlDispatcher   = advapi32.NewProc("StartServiceCtrlDispatcherW")
+	procRegisterServiceCtrlHandlerEx = advapi32.NewProc("RegisterServiceCtrlHandlerExW")
+	procSetServiceStatus             = advapi32.NewProc("SetServiceStatus")
+	procRegisterEventSource          = advapi32.NewProc("RegisterEventSourceW")
+	procReportEvent                  = advapi32.NewProc("ReportEventW")
+	procRegCreateKeyEx               = advapi32.NewProc("RegCreateKeyExW")
+	procRegSetValueEx                = advapi32.NewProc("RegSetValueExW")
+	procRegDeleteKey                 = advapi32.NewProc("RegDeleteKeyW")
+)
+
+const (
+	scManagerAllAccess     = 0xF003F
+	serviceAllAccess       = 0xF01FF
+	serviceDelete          = 0x10000
+	serviceWin32OwnProcess = 0x10
+	serviceAutoStart       = 2
+	serviceErrorNormal     = 1
+
+	serviceStopped     = 1
+	serviceStopPending = 3
+	serviceRunning     = 4
+	serviceAcceptStop  = 1
+	serviceAcceptShut  = 4
+	controlStop        = 1
+	controlShutdown    = 5
+
+	errorServiceSpecific = 1066
+
+	eventError       = 1
+	eventInformation = 4
+
+	// eventSourceKey is the registry key under HKEY_LOCAL_MACHINE holding event log sources
+	eventSourceKey = `SYSTEM\CurrentControlSet\Services\EventLog\Application\`
+)
+
+// serviceStatus is a SERVICE_STATUS structure
+type serviceStatus struct {
+	ServiceType             uint32
+	CurrentState            uint32
+	ControlsAccepted        uint32
+	Win32ExitCode           uint32
+	ServiceSpecificExitCode uint32
+	CheckPoint              uint32
+	WaitHint                uint32
+}
+
+// installService registers a service running `sprocs serve` with the given flags, and an event log source of the
+// same name whose messages are formatted by EventCreate.exe
+func installService(name string, args []string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if exe, err = filepath.Abs(exe); err != nil {
+		return err
+	}
+	cmd := []string{syscall.EscapeArg(exe), "service", "-name", syscall.EscapeArg(name), "run"}
+	for _, arg := range args {
+		cmd = append(cmd, syscall.EscapeArg(arg))
+	}
+	scm, err := openSCManager()
+	if err != nil {
+		return err
+	}
+	defer procCloseServiceHandle.Call(scm)
+	svcName, binPath := syscall.StringToUTF16Ptr(name), syscall.StringToUTF16Ptr(strings.Join(cmd, " "))
+	h, _, err := procCreateService.Call(scm, uintptr(unsafe.Pointer(svcName)), uintptr(unsafe.Pointer(svcName)),
+		serviceAllAccess, serviceWin32OwnProcess, serviceAutoStart, serviceErrorNormal, uintptr(unsafe.Pointer(binPath)),
+		0, 0, 0, 0, 0)
+	if h == 0 {
+		return fmt.Errorf("creating service %s: %v", name, err)
+	}
+	procCloseServiceHandle.Call(h)
+	if err = installEventSource(name); err != nil {
+		return err
+	}
+	log.Println("Installed service", name, "to run", strings.Join(cmd, " "))
+	return nil
+}
+
+func installEventSource(name string) error {
+	var (
+		key         syscall.Handle
+		disposition uint32
+	)
+	path := syscall.StringToUTF16Ptr(eventSourceKey + name)
+	if r, _, _ := procRegCreateKeyEx.Call(uintptr(syscall.HKEY_LOCAL_MACHINE), uintptr(unsafe.Pointer(path)), 0, 0, 0,
+		syscall.KEY_ALL_ACCESS, 0, uintptr(unsafe.Pointer(&key)), uintptr(unsafe.Pointer(&disposition))); r != 0 {
+		return fmt.Errorf("creating event log source %s: %v", name, syscall.Errno(r))
+	}
+	defer syscall.RegCloseKey(key)
+	msgFile := syscall.StringToUTF16(`%SystemRoot%\System32\EventCreate.exe`)
+	if r, _, _ := procRegSetValueEx.Call(uintptr(key), uintptr(unsafe.Pointer(syscall.StringToUTF16Ptr("EventMessageFile"))), 0,
+		syscall.REG_EXPAND_SZ, uintptr(unsafe.Pointer(&msgFile[0])), uintptr(len(msgFile)*2)); r != 0 {
+		return fmt.Errorf("creating event log source %s: %v", name, syscall.Errno(r))
+	}
+	types := uint32(7) // error, warning and information
+	if r, _, _ := procRegSetValueEx.Call(uintptr(key), uintptr(unsafe.Pointer(syscall.StringToUTF16Ptr("TypesSupported"))), 0,
+		syscall.REG_DWORD, uintptr(unsafe.Pointer(&types)), 4); r != 0 {
+		return fmt.Errorf("creating event log source %s: %v", name, syscall.Errno(r))
+	}
+	return nil
+}
+
+// removeService deletes the service and its event log source
+func removeService(name string) error {
+	scm, err := openSCManager()
+	if err != nil {
+		return err
+	}
+	defer procCloseServiceHandle.Call(scm)
+	h, _, err := procOpenService.Call(scm, uintptr(unsafe.Pointer(syscall.StringToUTF16Ptr(name))), serviceDelete)
+	if h == 0 {
+		return fmt.Errorf("opening service %s: %v", name, err)
+	}
+	defer procCloseServiceHandle.Call(h)
+	if r, _, err := procDeleteService.Call(h); r == 0 {
+		return fmt.Errorf("deleting service %s: %v", name, err)
+	}
+	if r, _, _ := procRegDeleteKey.Call(uintptr(syscall.HKEY_LOCAL_MACHINE), uintptr(unsafe.Pointer(syscall.StringToUTF16Ptr(eventSourceKey+name)))); r != 0 {
+		return fmt.Errorf("removing event log source %s: %v", name, syscall.Errno(r))
+	}
+	log.Println("Removed service", name)
+	return nil
+}
+
+func openSCManager() (uintptr, error) {
+	h, _, err := procOpenSCManager.Call(0, 0, scManagerAllAccess)
+	if h == 0 {
+		return 0, fmt.Errorf("connecting to the service control manager: %v", err)
+	}
+	return h, nil
+}
+
+// running is the state of the service while the control manager drives it
+var running struct {
+	name   *uint16
+	args   []string
+	status uintptr
+	events eventLog
+	stop   chan struct{}
+	err    error
+}
+
+// runService hands the process to the service control manager, which calls serviceMain to start `sprocs serve`.
+// Log output goes to the event log from then on.
+func runService(name string, args []string) error {
+	running.name, running.args, running.stop = syscall.StringToUTF16Ptr(name), args, make(chan struct{}, 1)
+	h, _, err := procRegisterEventSource.Call(0, uintptr(unsafe.Pointer(running.name)))
+	if h == 0 {
+		return fmt.Errorf("opening event log source %s: %v", name, err)
+	}
+	running.events = eventLog(h)
+	table := []struct {
+		name *uint16
+		proc uintptr
+	}{{running.name, syscall.NewCallback(serviceMain)}, {nil, 0}}
+	if r, _, err := procStartServiceCtrlDispatcher.Call(uintptr(unsafe.Pointer(&table[0]))); r == 0 {
+		return fmt.Errorf("connecting to the service control manager (use `sprocs serve` outside of a service): %v", err)
+	}
+	return running.err
+}
+
+func serviceMain(argc, argv uintptr) uintptr {
+	h, _, err := procRegisterServiceCtrlHandlerEx.Call(uintptr(unsafe.Pointer(running.name)), syscall.NewCallback(serviceHandler), 0)
+	if h == 0 {
+		running.err = err
+		running.events.report(eventError, err.Error())
+		return 0
+	}
+	running.status = h
+	logOutput = running.events
+	log.SetOutput(logOutput)
+	setServiceStatus(serviceRunning, serviceAcceptStop|serviceAcceptShut, 0)
+	done := make(chan error, 1)
+	go func() {
+		done <- serve(running.args)
+	}()
+	var exitCode uint32
+	select {
+	case <-running.stop:
+		log.Println("Stopping service")
+	case running.err = <-done:
+		running.events.report(eventError, fmt.Sprint("sprocs serve exited: ", running.err))
+		exitCode = 1
+	}
+	setServiceStatus(serviceStopped, 0, exitCode)
+	return 0
+}
+
+// serviceHandler is called by the service control manager with each control request
+func serviceHandler(control, eventType, eventData, context uintptr) uintptr {
+	switch control {
+	case controlStop, controlShutdown:
+		setServiceStatus(serviceStopPending, 0, 0)
+		select {
+		case running.stop <- struct{}{}:
+		default:
+		}
+	}
+	return 0
+}
+
+func setServiceStatus(state, accepts, exitCode uint32) {
+	s := serviceStatus{ServiceType: serviceWin32OwnProcess, CurrentState: state, ControlsAccepted: accepts}
+	if exitCode != 0 {
+		s.Win32ExitCode, s.ServiceSpecificExitCode = errorServiceSpecific, exitCode
+	}
+	procSetServiceStatus.Call(running.status, uintptr(unsafe.Pointer(&s)))
+}
+
+// eventLog is an io.Writer that reports each log line as an information event
+type eventLog uintptr
+
+func (h eventLog) Write(p []byte) (int, error) {
+	if err := h.report(eventInformation, strings.TrimRight(string(p), "\r\n")); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (h eventLog) report(kind uintptr, msg string) error {
+	s, err := syscall.UTF16PtrFromString(strings.Replace(msg, "\x00", "", -1))
+	if err != nil {
+		return err
+	}
+	if r, _, err := procReportEvent.Call(uintptr(h), kind, 0, 1, 0, 1, 0, uintptr(unsafe.Pointer(&s)), 0); r == 0 {
+		return err
+	}
+	return nil
+}
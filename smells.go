@@ -0,0 +1,103 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/antlr/antlr4/runtime/Go/antlr"
+	parser "github.com/nycmonkey/sprocs/tsql"
+)
+
+func init() {
+	RegisterExtractor(smellExtractor{})
+}
+
+const (
+	smellLocalParameter  = "parameter-copied-to-local"
+	smellOptionRecompile = "option-recompile"
+	smellWithRecompile   = "with-recompile"
+	smellOptimizeUnknown = "optimize-for-unknown"
+)
+
+// smellExtractor reports patterns associated with parameter sniffing problems and their usual workarounds: filters
+// on local copies of parameters (which hide the parameter values from the optimizer), and recompilation hints
+type smellExtractor struct{}
+
+func (smellExtractor) Name() string {
+	return "performance_smells"
+}
+
+func (smellExtractor) Columns() []string {
+	return []string{"Smell", "Detail", "Line"}
+}
+
+func (smellExtractor) NewListener(sproc string, names *nameScope, emit func([]string)) antlr.ParseTreeListener {
+	l := &smellListener{emit: emit, copies: make(map[string]string), reported: make(map[string]bool)}
+	l.predicateListener = newPredicateListener(names, l.predicate)
+	return l
+}
+
+type smellListener struct {
+	*predicateListener
+	emit func([]string)
+	// copies maps local variables assigned a parameter to the assignment
+	copies   map[string]string
+	reported map[string]bool
+}
+
+// EnterDeclare_local is called when the parser enters a `declare_local` node
+func (l *smellListener) EnterDeclare_local(ctx *parser.Declare_localContext) {
+	if ctx.Expression() != nil {
+		l.assign(ctx.LOCAL_ID().GetText(), ctx.Expression())
+	}
+}
+
+// EnterSet_statement is called when the parser enters a `set_statement` node
+func (l *smellListener) EnterSet_statement(ctx *parser.Set_statementContext) {
+	if ctx.LOCAL_ID() != nil && ctx.Expression() != nil && ctx.Assignment_operator() == nil {
+		l.assign(ctx.LOCAL_ID().GetText(), ctx.Expression())
+	}
+}
+
+func (l *smellListener) assign(name string, expr antlr.ParseTree) {
+	if p, ok := unwrapExpression(expr).(*parser.Primitive_expressionContext); ok && p.LOCAL_ID() != nil &&
+		l.params[strings.ToUpper(p.LOCAL_ID().GetText())] {
+		l.copies[strings.ToUpper(name)] = name + " = " + p.LOCAL_ID().GetText()
+		return
+	}
+	delete(l.copies, strings.ToUpper(name))
+}
+
+// predicate receives each filter from the predicateListener: Table, Column, Operator, Value, Value Kind, Line
+func (l *smellListener) predicate(f []string) {
+	for _, v := range localID.FindAllString(f[3], -1) {
+		assigned, ok := l.copies[strings.ToUpper(v)]
+		if !ok || l.reported[strings.ToUpper(v)] {
+			continue
+		}
+		l.reported[strings.ToUpper(v)] = true
+		target := f[1]
+		if len(f[0]) > 0 {
+			target = f[0] + "." + f[1]
+		}
+		l.emit([]string{smellLocalParameter, assigned + ", then filtered on " + target + " " + f[2] + " " + v, f[5]})
+	}
+}
+
+// EnterProcedure_option is called when the parser enters a `procedure_option` node
+func (l *smellListener) EnterProcedure_option(ctx *parser.Procedure_optionContext) {
+	if ctx.RECOMPILE() != nil {
+		l.emit([]string{smellWithRecompile, "procedure recompiled on every execution", strconv.Itoa(ctx.GetStart().GetLine())})
+	}
+}
+
+// EnterOption is called when the parser enters an `option` node of an OPTION clause
+func (l *smellListener) EnterOption(ctx *parser.OptionContext) {
+	line := strconv.Itoa(ctx.GetStart().GetLine())
+	switch {
+	case ctx.RECOMPILE() != nil:
+		l.emit([]string{smellOptionRecompile, "statement recompiled on every execution", line})
+	case ctx.OPTIMIZE() != nil && ctx.UNKNOWN() != nil:
+		l.emit([]string{smellOptimizeUnknown, "statement optimized for average parameter values", line})
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+// writeReferenceSnapshot writes the reference data a run matches sprocs against to its run directory, so its
+// reports can be checked against the data of the time long after it has changed: whitelist.csv, the tables
+// of the whitelist, portfolio_keys.csv, the keys of the account master, and <name>_keys.csv for each -dictionary
+func writeReferenceSnapshot() error {
+	tables := make([][]string, 0, len(whitelist))
+	for t := range whitelist {
+		tables = append(tables, []string{t})
+	}
+	sortRows(tables)
+	if err := writeCSVFile("whitelist.csv", []string{"Table"}, tables); err != nil {
+		return err
+	}
+	if err := writeCSVFile(snapshotFile(portfolios), []string{"Account Master Column", "Account Master Value"}, portfolios.rows()); err != nil {
+		return err
+	}
+	for _, d := range dictionaries {
+		if err := writeCSVFile(snapshotFile(d), []string{"Dictionary Column", "Dictionary Value"}, d.rows()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// snapshotFile names the file of the run directory holding the keys of a dictionary
+func snapshotFile(d *dictionary) string {
+	if d == portfolios {
+		return "portfolio_keys.csv"
+	}
+	return d.name + "_keys.csv"
+}
@@ -0,0 +1,21 @@
+package main
+
+// sprocSource loads the sprocs of a run from where they are kept, along with what is known of the objects they
+// use: it sets the whitelist and whichever catalogs the source has, leaving the rest nil as a project does, then
+// sends each definition to be parsed and closes outCh. A source for another kind of database plugs in here and
+// keeps the reports the same, as long as its definitions can be parsed by a -dialect grammar.
+type sprocSource func(defDir string, outCh chan<- keyValue) error
+
+// runSource returns the source of the sprocs of a run: the dacpac or SSDT project of -source, or else the database
+// of -host and -database, a Sybase ASE one under -dialect ase and a PostgreSQL one under -dialect postgres
+func runSource() sprocSource {
+	switch {
+	case len(sourcePath) > 0:
+		return getProjectSprocs
+	case dialect == aseDialect:
+		return getASESprocs
+	case dialect == postgresDialect:
+		return getPostgresSprocs
+	}
+	return getSprocs
+}
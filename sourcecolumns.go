@@ -0,0 +1,180 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// columnKind is the kind of value a source query column is scanned into
+type columnKind string
+
+const (
+	textColumn    columnKind = "text"
+	integerColumn columnKind = "integer"
+)
+
+// columnKinds holds the SQL Server types of each kind of column, as sp_describe_first_result_set names them
+var columnKinds = map[columnKind][]string{
+	textColumn:    {"char", "varchar", "nchar", "nvarchar", "sysname"},
+	integerColumn: {"tinyint", "smallint", "int", "bigint"},
+}
+
+// sourceColumn is a column a source query must return; a blank name matches a column of any name
+type sourceColumn struct {
+	name string
+	kind columnKind
+}
+
+// sourceQuery is a query a run loads its tables, reference data or sprocs with, along with the columns it is
+// scanned into in order
+type sourceQuery struct {
+	what, query string
+	columns     []sourceColumn
+}
+
+// describeQ returns the columns of the first result set of a query without running it, or fails with the error
+// compiling it gives, such as an invalid column name
+const describeQ = `
+EXEC sp_describe_first_result_set @tsql = ?
+`
+
+// sourceQueries returns the queries getSprocs scans by position, with the columns it expects of each
+func sourceQueries() []sourceQuery {
+	queries := []sourceQuery{
+		{what: "the table list", query: tableQ, columns: []sourceColumn{{"TABLE_NAME", textColumn}}},
+		{what: "the account master " + portfolioView, query: portfolioQuery(), columns: portfolioColumns()},
+	}
+	if len(sprocListPath) == 0 {
+		queries = append(queries, sourceQuery{what: "the sproc list", query: metadataQuery(activeSprocQ),
+			columns: []sourceColumn{{"ROUTINE_SCHEMA", textColumn}, {"ROUTINE_NAME", textColumn}, {"", integerColumn}}})
+	}
+	return queries
+}
+
+// portfolioColumns returns the columns of the account master, integers for its numeric columns
+func portfolioColumns() []sourceColumn {
+	cols := make([]sourceColumn, len(portfolios.columns))
+	for i, col := range portfolios.columns {
+		cols[i] = sourceColumn{col, textColumn}
+		if portfolios.numeric[col] {
+			cols[i].kind = integerColumn
+		}
+	}
+	return cols
+}
+
+// describedColumn is a column of a result set as sp_describe_first_result_set describes it
+type describedColumn struct {
+	name, systemType string
+}
+
+// describeQuery returns the columns of the first result set of q, other than those hidden from SELECT *
+func describeQuery(db *sql.DB, q string) ([]describedColumn, error) {
+	logSQL(describeQ, q)
+	rows, err := db.Query(sqlDriver.bind(describeQ), q)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	names, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	vals := make([]sql.NullString, len(names))
+	dest := make([]interface{}, len(names))
+	for i := range vals {
+		dest[i] = &vals[i]
+	}
+	var described []describedColumn
+	for rows.Next() {
+		if err = rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		var c describedColumn
+		hidden := false
+		for i, name := range names {
+			switch name {
+			case "name":
+				c.name = vals[i].String
+			case "system_type_name":
+				c.systemType = vals[i].String
+			case "is_hidden":
+				hidden = vals[i].String == "1" || strings.EqualFold(vals[i].String, "true")
+			}
+		}
+		if !hidden {
+			described = append(described, c)
+		}
+	}
+	return described, rows.Err()
+}
+
+// hasKind reports whether a SQL Server type, such as nvarchar(50), is of a kind of column
+func hasKind(systemType string, kind columnKind) bool {
+	base := strings.ToLower(strings.TrimSpace(systemType))
+	if i := strings.Index(base, "("); i >= 0 {
+		base = base[:i]
+	}
+	for _, t := range columnKinds[kind] {
+		if base == t {
+			return true
+		}
+	}
+	return false
+}
+
+// checkSourceQueries verifies at startup that each source query returns the columns it is scanned into, of the
+// kinds it is scanned as, so a view whose columns were renamed, dropped or retyped is named along with the
+// columns, rather than failing a Scan partway through the run. Every problem is logged.
+func checkSourceQueries(db *sql.DB) error {
+	var problems []string
+	for _, sq := range sourceQueries() {
+		got, err := describeQuery(db, sq.query)
+		if err != nil {
+			problems = append(problems, sq.what+": "+err.Error())
+			continue
+		}
+		for i, want := range sq.columns {
+			if i >= len(got) {
+				problems = append(problems, sq.what+": missing column "+columnLabel(want, i))
+				continue
+			}
+			if len(want.name) > 0 && !strings.EqualFold(got[i].name, want.name) {
+				found := false
+				for _, g := range got {
+					found = found || strings.EqualFold(g.name, want.name)
+				}
+				if !found {
+					problems = append(problems, sq.what+": missing column "+want.name+" (found "+got[i].name+" in its place)")
+					continue
+				}
+				problems = append(problems, sq.what+": column "+want.name+" is out of place (found "+got[i].name+" in column "+strconv.Itoa(i+1)+")")
+				continue
+			}
+			if !hasKind(got[i].systemType, want.kind) {
+				problems = append(problems, sq.what+": column "+columnLabel(want, i)+" is "+got[i].systemType+", expected "+string(want.kind))
+			}
+		}
+		if len(got) > len(sq.columns) {
+			problems = append(problems, sq.what+": "+strconv.Itoa(len(got))+" columns, expected "+strconv.Itoa(len(sq.columns)))
+		}
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	for _, p := range problems {
+		log.Println("Source query:", p)
+	}
+	return errors.New("the source queries don't return the columns expected (" + strings.Join(problems, "; ") + ")")
+}
+
+// columnLabel names a source column for a message, by its position when it may have any name
+func columnLabel(c sourceColumn, i int) string {
+	if len(c.name) > 0 {
+		return c.name
+	}
+	return strconv.Itoa(i + 1)
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const numberedSprocQ = `
+SELECT definition FROM sys.numbered_procedures WHERE object_id = OBJECT_ID(?) AND procedure_number = ?
+`
+
+// sprocListPath is a file naming the sprocs to analyze, one per line, instead of every active sproc
+var sprocListPath string
+
+// sprocParts splits a sproc entry of the form [[database.]schema.]name[;number] into its unbracketed parts,
+// leaving those not given empty and the procedure number 0
+func sprocParts(entry string) (database, schema, name string, number int) {
+	entry = strings.TrimSpace(entry)
+	if i := strings.LastIndex(entry, ";"); i >= 0 {
+		if n, err := strconv.Atoi(strings.TrimSpace(entry[i+1:])); err == nil && n > 0 {
+			entry, number = entry[:i], n
+		}
+	}
+	parts := strings.Split(entry, ".")
+	for i, p := range parts {
+		parts[i] = removeBrackets(strings.TrimSpace(p))
+	}
+	for len(parts) < 3 {
+		parts = append([]string{""}, parts...)
+	}
+	parts = parts[len(parts)-3:]
+	return parts[0], parts[1], parts[2], number
+}
+
+// sprocObject splits a sproc entry of the form [[database.]schema.]name[;number] into the bracketed three-part
+// name OBJECT_ID resolves, defaulting to the sproc database and dbo, and the procedure number, 0 if none is given
+func sprocObject(entry string) (name string, number int) {
+	database, schema, name, number := sprocParts(entry)
+	if len(database) == 0 {
+		database = dbName
+	}
+	if len(schema) == 0 {
+		// name alone, or database..name
+		schema = "dbo"
+	}
+	parts := []string{database, schema, name}
+	for i, p := range parts {
+		parts[i] = "[" + strings.Replace(p, "]", "]]", -1) + "]"
+	}
+	return strings.Join(parts, "."), number
+}
+
+// sprocEntry names a sproc as runs report it: by name alone in dbo, otherwise as schema.name, followed by
+// ;number for the procedures of a numbered group after the first
+func sprocEntry(schema, name string, number int) string {
+	if !strings.EqualFold(schema, "dbo") {
+		name = schema + "." + name
+	}
+	if number > 1 {
+		name += ";" + strconv.Itoa(number)
+	}
+	return name
+}
+
+// sprocListEntry names a sproc of a -sprocs file as runs report it, so bracketed, dbo- or database-qualified
+// names have the same key as in runs of every sproc. Those of another database are left as written.
+func sprocListEntry(entry string) string {
+	database, schema, name, number := sprocParts(entry)
+	if len(database) > 0 && !strings.EqualFold(database, dbName) {
+		return strings.TrimSpace(entry)
+	}
+	if len(schema) == 0 {
+		schema = "dbo"
+	}
+	return sprocEntry(schema, name, number)
+}
+
+// readSprocList returns the sproc entries of the file given to -sprocs, named by entry as runs of every sproc
+// name them, skipping blank lines and -- comments
+func readSprocList(path string, entry func(string) string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var entries []string
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if len(line) > 0 && !strings.HasPrefix(line, "--") {
+			entries = append(entries, entry(line))
+		}
+	}
+	return entries, s.Err()
+}
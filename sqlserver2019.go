@@ -0,0 +1,76 @@
+package main
+
+import (
+	"regexp"
+
+	"github.com/antlr/antlr4/runtime/Go/antlr"
+	parser "github.com/nycmonkey/sprocs/tsql"
+)
+
+// sqlserver2019Dialect is the -dialect of SQL Server 2016 to 2019, whose syntax the bundled grammar predates
+const sqlserver2019Dialect = "sqlserver2019"
+
+func init() {
+	grammars[sqlserver2019Dialect] = grammar{
+		description: "SQL Server 2016-2019: the " + defaultDialect + " grammar over definitions with CREATE OR ALTER, IF EXISTS, WITHIN GROUP, OPENJSON schemas, AT TIME ZONE and TRY_CONVERT rewritten",
+		newLexer: func(input antlr.CharStream) antlr.Lexer {
+			return parser.NewtsqlLexer(antlr.NewInputStream(sqlserver2019ToTSQL(input.GetText(0, input.Size()-1))))
+		},
+		parse: grammars[defaultDialect].parse,
+	}
+}
+
+var (
+	// sql2019OrAlter matches the OR ALTER of CREATE OR ALTER
+	sql2019OrAlter = regexp.MustCompile(`(?i)\bCREATE\s+(OR\s+ALTER)\s+(PROC|PROCEDURE|FUNCTION|VIEW|TRIGGER)\b`)
+	// sql2019IfExists matches the IF EXISTS of DROP ... IF EXISTS
+	sql2019IfExists = regexp.MustCompile(`(?i)\bDROP\s+(TABLE|VIEW|PROC|PROCEDURE|FUNCTION|TRIGGER|INDEX|SEQUENCE|SYNONYM|TYPE|SCHEMA)\s+(IF\s+EXISTS)\b`)
+	// sql2019WithinGroup matches the WITHIN GROUP ordering the values of STRING_AGG
+	sql2019WithinGroup = regexp.MustCompile(`(?i)\bWITHIN\s+GROUP\s*\(`)
+	// sql2019OpenJSON matches the start of OPENJSON, whose WITH clause gives the columns of its rows
+	sql2019OpenJSON = regexp.MustCompile(`(?i)\bOPENJSON\s*\(`)
+	// sql2019With matches the WITH following the arguments of OPENJSON
+	sql2019With = regexp.MustCompile(`(?i)^\s*WITH\s*\(`)
+	// sql2019AtTimeZone matches AT TIME ZONE and the zone it converts to
+	sql2019AtTimeZone = regexp.MustCompile(`(?i)\bAT\s+TIME\s+ZONE\s+(N?'[^']*'|@\w+)`)
+	// sql2019Try matches the TRY_ of TRY_CONVERT and TRY_CAST, which take the arguments of CONVERT and CAST
+	sql2019Try = regexp.MustCompile(`(?i)\b(TRY_)(CONVERT|CAST)\s*\(`)
+)
+
+// sqlserver2019ToTSQL rewrites the syntax SQL Server added after 2012 that the grammar can't parse, keeping every
+// other character where it is, as aseToTSQL does. OR ALTER and IF EXISTS are blanked out, leaving CREATE and DROP,
+// as are the WITHIN GROUP of STRING_AGG, the schema of OPENJSON and AT TIME ZONE, none of which name tables, and
+// TRY_CONVERT and TRY_CAST become CONVERT and CAST.
+func sqlserver2019ToTSQL(text string) string {
+	code := []byte(maskLiterals(text))
+	out := []byte(text)
+	for _, m := range sql2019OrAlter.FindAllSubmatchIndex(code, -1) {
+		blankOut(out, m[2], m[3])
+	}
+	for _, m := range sql2019IfExists.FindAllSubmatchIndex(code, -1) {
+		blankOut(out, m[4], m[5])
+	}
+	for _, m := range sql2019WithinGroup.FindAllIndex(code, -1) {
+		if end := closingParen(code, m[1]-1); end > 0 {
+			blankOut(out, m[0], end)
+		}
+	}
+	for _, m := range sql2019OpenJSON.FindAllIndex(code, -1) {
+		end := closingParen(code, m[1]-1)
+		if end < 0 {
+			continue
+		}
+		if w := sql2019With.FindIndex(code[end:]); w != nil {
+			if with := closingParen(code, end+w[1]-1); with > 0 {
+				blankOut(out, end, with)
+			}
+		}
+	}
+	for _, m := range sql2019AtTimeZone.FindAllIndex(code, -1) {
+		blankOut(out, m[0], m[1])
+	}
+	for _, m := range sql2019Try.FindAllSubmatchIndex(code, -1) {
+		blankOut(out, m[2], m[3])
+	}
+	return string(out)
+}
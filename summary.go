@@ -0,0 +1,148 @@
+package main
+
+import (
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// topErrorSprocs is how many of the sprocs with the most parse errors summary.csv names
+const topErrorSprocs = 10
+
+// runStats are the totals of a run reported in summary.csv, gathered by writeReports as results arrive
+type runStats struct {
+	sprocs     int
+	parseErrs  int
+	errorCount map[string]int
+	// errorCategories counts the parse errors of each sproc by classifyParseError category
+	errorCategories map[string]map[string]int
+	// tables and portfolioKeys are nil when their extractor didn't run
+	tables        map[string]struct{}
+	portfolioKeys map[string]struct{}
+	// units are the totals of each business unit, nil unless the -owners name business units
+	units map[string]*unitStats
+}
+
+// unitStats are the totals of the sprocs of a business unit
+type unitStats struct {
+	sprocs, errorSprocs int
+	tables              map[string]struct{}
+}
+
+// unassignedUnit groups the sprocs no -owners rule assigns a business unit
+const unassignedUnit = "(unassigned)"
+
+func newRunStats(extractors []Extractor) *runStats {
+	s := &runStats{errorCount: make(map[string]int), errorCategories: make(map[string]map[string]int)}
+	if ownersHaveBusinessUnits() {
+		s.units = make(map[string]*unitStats)
+	}
+	for _, e := range extractors {
+		switch e.Name() {
+		case tableExtractor{}.Name():
+			s.tables = make(map[string]struct{})
+		case portfolios.name:
+			s.portfolioKeys = make(map[string]struct{})
+		}
+	}
+	return s
+}
+
+// add counts the result of a sproc
+func (s *runStats) add(res sprocResult) {
+	s.sprocs++
+	if len(res.errors) > 0 {
+		s.errorCount[res.sproc] += len(res.errors)
+		s.parseErrs += len(res.errors)
+		categories := make(map[string]int)
+		for _, e := range res.errors {
+			categories[classifyParseError(e, len(sourcePath) == 0)]++
+		}
+		s.errorCategories[res.sproc] = categories
+	}
+	if s.tables != nil {
+		for _, f := range res.findings[tableExtractor{}.Name()] {
+			s.tables[strings.ToUpper(f[0])] = struct{}{}
+		}
+	}
+	if s.portfolioKeys != nil {
+		for _, f := range res.findings[portfolios.name] {
+			s.portfolioKeys[f[0]+"\x00"+strings.ToUpper(f[1])] = struct{}{}
+		}
+	}
+	if s.units != nil {
+		name := businessUnitOf(res.sproc)
+		if len(name) == 0 {
+			name = unassignedUnit
+		}
+		u, ok := s.units[name]
+		if !ok {
+			u = &unitStats{tables: make(map[string]struct{})}
+			s.units[name] = u
+		}
+		u.sprocs++
+		if len(res.errors) > 0 {
+			u.errorSprocs++
+		}
+		for _, f := range res.findings[tableExtractor{}.Name()] {
+			u.tables[strings.ToUpper(f[0])] = struct{}{}
+		}
+	}
+}
+
+// writeBusinessUnits writes business_units.csv, the number of sprocs each business unit owns, the tables they
+// use between them and the share of them that failed to parse, when the -owners name business units
+func writeBusinessUnits(s *runStats) error {
+	if s.units == nil {
+		return nil
+	}
+	rows := make([][]string, 0, len(s.units))
+	for name, u := range s.units {
+		rate := float64(u.errorSprocs) / float64(u.sprocs)
+		rows = append(rows, []string{name, strconv.Itoa(u.sprocs), strconv.Itoa(len(u.tables)), strconv.Itoa(u.errorSprocs),
+			formatDecimal(100*rate, 1) + "%"})
+	}
+	sortRows(rows)
+	return writeCSVFile("business_units.csv", []string{"Business Unit", "Sprocs", "Tables Used", "Sprocs With Parse Errors", "Error Rate"}, rows)
+}
+
+// writeSummary writes summary.csv, the totals of the run and the sprocs with the most parse errors, and logs
+// them too so the end of the log says whether the run was healthy
+func writeSummary(s *runStats, failures int) error {
+	rows := [][]string{
+		{"Sprocs Analyzed", strconv.Itoa(s.sprocs)},
+		{"Sprocs With Parse Errors", strconv.Itoa(len(s.errorCount))},
+		{"Parse Errors", strconv.Itoa(s.parseErrs)},
+	}
+	if s.tables != nil {
+		rows = append(rows, []string{"Distinct Tables Referenced", strconv.Itoa(len(s.tables))})
+	}
+	if s.portfolioKeys != nil {
+		rows = append(rows, []string{"Distinct Portfolio Keys Referenced", strconv.Itoa(len(s.portfolioKeys))})
+	}
+	rows = append(rows, []string{"Run Failures", strconv.Itoa(failures)})
+	if runBudget.reached {
+		rows = append(rows, []string{"Partial Run", "stopped at -max-duration " + maxDuration.String()})
+	}
+	procs := make([]string, 0, len(s.errorCount))
+	for proc := range s.errorCount {
+		procs = append(procs, proc)
+	}
+	sort.Slice(procs, func(i, j int) bool {
+		if s.errorCount[procs[i]] != s.errorCount[procs[j]] {
+			return s.errorCount[procs[i]] > s.errorCount[procs[j]]
+		}
+		return procs[i] < procs[j]
+	})
+	if len(procs) > topErrorSprocs {
+		procs = procs[:topErrorSprocs]
+	}
+	for _, proc := range procs {
+		rows = append(rows, []string{"Parse Errors In " + proc, strconv.Itoa(s.errorCount[proc])})
+	}
+	for _, row := range rows {
+		log.Printf("%-40s %s", row[0]+":", row[1])
+	}
+	return writeCSVFile("summary.csv", []string{"Statistic", "Value"}, rows)
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// suppression silences the findings known to be false positives, so they needn't be gone over again every run.
+// A finding is suppressed when each of the fields given matches: Sproc, a glob matched without regard to case,
+// against the sproc; Report against the name of the extractor; Table, a glob too, against any cell naming a
+// table; and Value against any cell, without regard to case.
+type suppression struct {
+	Sproc  string `json:"sproc"`
+	Report string `json:"report"`
+	Table  string `json:"table"`
+	Value  string `json:"value"`
+	Reason string `json:"reason"`
+	// row, when set, must be the cells of the finding joined by " | ", as suppressed_findings.csv shows them
+	row string
+	// by is where the suppression comes from: the -suppressions file, the line of an inline one, or the run a
+	// reviewer marked the finding a false positive in
+	by string
+}
+
+// suppressions holds those of the -suppressions file
+var suppressions []suppression
+
+// suppressionsFlag is a flag.Value that loads a JSON file of suppressions
+type suppressionsFlag struct{}
+
+func (suppressionsFlag) String() string {
+	return ""
+}
+
+func (suppressionsFlag) Set(file string) error {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	var loaded []suppression
+	if err = json.Unmarshal(b, &loaded); err != nil {
+		return fmt.Errorf("invalid suppressions file %s: %v", file, err)
+	}
+	for i, s := range loaded {
+		if len(s.Sproc)+len(s.Report)+len(s.Table)+len(s.Value) == 0 {
+			return fmt.Errorf("invalid suppressions file %s: suppression %d matches every finding", file, i+1)
+		}
+		for _, pattern := range []string{s.Sproc, s.Table} {
+			if _, err = path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("invalid suppressions file %s: pattern %q: %v", file, pattern, err)
+			}
+		}
+		loaded[i].by = file
+	}
+	suppressions = loaded
+	return nil
+}
+
+// inlineSuppression matches a suppression written in a line comment of a sproc: sprocs:ignore-table <table> to
+// suppress the findings naming a table, or sprocs:ignore <report> [value] those of a report, all of them or those
+// with a cell holding the value. Anything after them is the reason.
+var inlineSuppression = regexp.MustCompile(`(?i)--\s*sprocs:(ignore-table|ignore)\s+(\S+)([^\r\n]*)`)
+
+// inlineSuppressions returns the suppressions written in the comments of a sproc's definition
+func inlineSuppressions(text string) []suppression {
+	var found []suppression
+	for _, m := range inlineSuppression.FindAllStringSubmatchIndex(text, -1) {
+		s := suppression{by: "line " + strconv.Itoa(strings.Count(text[:m[0]], "\n")+1)}
+		rest := strings.TrimSpace(text[m[6]:m[7]])
+		if strings.EqualFold(text[m[2]:m[3]], "ignore-table") {
+			s.Table = text[m[4]:m[5]]
+		} else {
+			s.Report = text[m[4]:m[5]]
+			if fields := strings.Fields(rest); len(fields) > 0 {
+				s.Value, rest = fields[0], strings.TrimSpace(strings.TrimPrefix(rest, fields[0]))
+			}
+		}
+		s.Reason = rest
+		found = append(found, s)
+	}
+	return found
+}
+
+// matches reports whether the suppression silences a finding of a report on a sproc
+func (s suppression) matches(sproc, report string, finding []string) bool {
+	if len(s.Sproc) > 0 && !matchFold(s.Sproc, sproc) {
+		return false
+	}
+	if len(s.Report) > 0 && !strings.EqualFold(s.Report, report) {
+		return false
+	}
+	if len(s.Table) > 0 && !anyCell(finding, func(cell string) bool { return matchFold(suppressedTable(s.Table), cell) }) {
+		return false
+	}
+	if len(s.Value) > 0 && !anyCell(finding, func(cell string) bool { return strings.EqualFold(s.Value, cell) }) {
+		return false
+	}
+	if len(s.row) > 0 && !strings.EqualFold(s.row, strings.Join(finding, " | ")) {
+		return false
+	}
+	return true
+}
+
+// suppressedTable normalizes the table of a suppression as the reports name tables, leaving names that aren't
+// table names as they are
+func suppressedTable(table string) string {
+	if strings.Count(table, ".") > 3 {
+		return table
+	}
+	return runNames.normalizeTableName(table)
+}
+
+func anyCell(finding []string, match func(string) bool) bool {
+	for _, cell := range finding {
+		if match(cell) {
+			return true
+		}
+	}
+	return false
+}
+
+// suppressedFindings holds the findings of the current run that were suppressed, for suppressed_findings.csv
+var suppressedFindings struct {
+	sync.Mutex
+	rows [][]string
+}
+
+// suppressFindings removes the findings of a sproc silenced by the -suppressions file, its own comments or the
+// verdicts of reviewers, recording them to be reported separately
+func suppressFindings(s keyValue, findings map[string][][]string) map[string][][]string {
+	active := inlineSuppressions(s.value)
+	for _, from := range [][]suppression{suppressions, reviewed} {
+		for _, sup := range from {
+			if len(sup.Sproc) == 0 || matchFold(sup.Sproc, s.key) {
+				active = append(active, sup)
+			}
+		}
+	}
+	if len(active) == 0 {
+		return findings
+	}
+	var suppressed [][]string
+	for report, rows := range findings {
+		kept := rows[:0]
+		for _, finding := range rows {
+			matched := false
+			for _, sup := range active {
+				if sup.matches(s.key, report, finding) {
+					suppressed = append(suppressed, []string{s.key, report, strings.Join(finding, " | "), sup.by, sup.Reason})
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				kept = append(kept, finding)
+			}
+		}
+		findings[report] = kept
+	}
+	if len(suppressed) > 0 {
+		suppressedFindings.Lock()
+		suppressedFindings.rows = append(suppressedFindings.rows, suppressed...)
+		suppressedFindings.Unlock()
+	}
+	return findings
+}
+
+// resetSuppressedFindings forgets the suppressed findings of the previous run
+func resetSuppressedFindings() {
+	suppressedFindings.Lock()
+	suppressedFindings.rows = nil
+	suppressedFindings.Unlock()
+}
+
+// writeSuppressedFindings writes suppressed_findings.csv, the findings left out of the other reports and what
+// suppressed each
+func writeSuppressedFindings() error {
+	suppressedFindings.Lock()
+	defer suppressedFindings.Unlock()
+	sortRows(suppressedFindings.rows)
+	return writeCSVFile("suppressed_findings.csv", []string{"Stored Procedure", "Report", "Finding", "Suppressed By", "Reason"}, suppressedFindings.rows)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"regexp"
+
+	"github.com/antlr/antlr4/runtime/Go/antlr"
+	parser "github.com/nycmonkey/sprocs/tsql"
+)
+
+// synapseDialect is the -dialect of Azure Synapse dedicated SQL pools, whose catalog lacks some of the views the
+// metadata queries read and whose procedures create tables with distribution options and CREATE TABLE AS SELECT
+const synapseDialect = "synapse"
+
+func init() {
+	grammars[synapseDialect] = grammar{
+		description: "Azure Synapse dedicated SQL pool: the " + defaultDialect + " grammar over definitions with CTAS, distribution options and query labels rewritten",
+		newLexer: func(input antlr.CharStream) antlr.Lexer {
+			return parser.NewtsqlLexer(antlr.NewInputStream(synapseToTSQL(input.GetText(0, input.Size()-1))))
+		},
+		parse: grammars[defaultDialect].parse,
+	}
+}
+
+const (
+	// synapseSprocQ lists the procedures of a dedicated SQL pool, which has no numbered procedures
+	synapseSprocQ = `
+select ROUTINE_SCHEMA, ROUTINE_NAME, 1 from information_schema.routines
+where routine_type = 'PROCEDURE'
+and Left(Routine_Name, 3) NOT IN ('sp_', 'xp_', 'ms_')
+`
+	// synapseDefinitionQ returns the definition of a procedure from sys.sql_modules rather than OBJECT_DEFINITION
+	synapseDefinitionQ = `
+SELECT definition FROM sys.sql_modules WHERE object_id = OBJECT_ID(?)
+`
+)
+
+// synapseQueries replaces the metadata queries a dedicated SQL pool can't run
+var synapseQueries = map[string]string{
+	activeSprocQ: synapseSprocQ,
+	sprocQ:       synapseDefinitionQ,
+}
+
+// synapseMissingViews are the catalog views of SQL Server a dedicated SQL pool doesn't have, along with the
+// CLR and extended procedures they describe
+var synapseMissingViews = map[string]bool{
+	"sys.numbered_procedures": true,
+	"sys.assembly_modules":    true,
+	"sys.assemblies":          true,
+	"sys.extended_procedures": true,
+}
+
+// metadataQuery returns the query of the -dialect's database to run in place of a SQL Server metadata query
+func metadataQuery(q string) string {
+	if s, ok := synapseQueries[q]; ok && dialect == synapseDialect {
+		return s
+	}
+	return q
+}
+
+var (
+	// synapseCreateTable matches the start of CREATE TABLE up to its column list or WITH clause
+	synapseCreateTable = regexp.MustCompile(`(?i)\bCREATE(\s+)TABLE\s+([^\s(]+)\s*(\(|WITH\s*\()`)
+	// synapseWith matches the WITH clause following the column list of CREATE TABLE
+	synapseWith = regexp.MustCompile(`(?i)^\s*WITH\s*\(`)
+	// synapseAs matches the AS of CREATE TABLE AS SELECT following its WITH clause
+	synapseAs = regexp.MustCompile(`(?i)^\s*AS\b`)
+	// synapseLabel matches the OPTION (LABEL = '...') clause tagging a query
+	synapseLabel = regexp.MustCompile(`(?i)\bOPTION\s*\(\s*LABEL\s*=\s*N?'[^']*'\s*\)`)
+	// synapseRename matches RENAME OBJECT
+	synapseRename = regexp.MustCompile(`(?i)\bRENAME\s+OBJECT\s+(::\s*)?[^\s]+\s+TO\s+[^\s;]+`)
+)
+
+// synapseToTSQL rewrites the dedicated SQL pool syntax of a definition the T-SQL grammar can't parse, keeping every
+// other character where it is, as aseToTSQL does. CREATE TABLE AS SELECT becomes INSERT INTO ... SELECT, which
+// writes and reads the same tables; the distribution options of CREATE TABLE and query labels are blanked out, and
+// RENAME OBJECT becomes a PRINT.
+func synapseToTSQL(text string) string {
+	code := []byte(maskLiterals(text))
+	out := []byte(text)
+	for _, m := range synapseCreateTable.FindAllSubmatchIndex(code, -1) {
+		// m[2]:m[3] is the space between CREATE and TABLE, m[4]:m[5] the table name and m[6]:m[7] the parenthesis
+		// opening the column list or WITH clause
+		end := closingParen(code, m[7]-1)
+		if end < 0 {
+			continue
+		}
+		if code[m[6]] == '(' {
+			if w := synapseWith.FindIndex(code[end:]); w != nil {
+				if with := closingParen(code, end+w[1]-1); with > 0 {
+					blankOut(out, end, with)
+				}
+			}
+			continue
+		}
+		as := synapseAs.FindIndex(code[end:])
+		if as == nil {
+			continue
+		}
+		copy(out[m[0]:], "INSERT")
+		copy(out[m[3]:], "INTO ")
+		blankOut(out, m[5], end+as[1])
+	}
+	for _, m := range synapseLabel.FindAllIndex(code, -1) {
+		blankOut(out, m[0], m[1])
+	}
+	for _, m := range synapseRename.FindAllIndex(code, -1) {
+		blankOut(out, m[0], m[1])
+		copy(out[m[0]:], noopStatement)
+	}
+	return string(out)
+}
+
+// closingParen returns the index just past the parenthesis closing the one at open in code, or -1
+func closingParen(code []byte, open int) int {
+	depth := 0
+	for i := open; i < len(code); i++ {
+		switch code[i] {
+		case '(':
+			depth++
+		case ')':
+			if depth--; depth == 0 {
+				return i + 1
+			}
+		}
+	}
+	return -1
+}
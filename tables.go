@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// tableUsage counts the distinct sprocs reading and writing a table
+type tableUsage struct {
+	table            string
+	readers, writers map[string]struct{}
+}
+
+// Summarize writes the reports derived from table_sources.csv once every sproc has been analyzed
+func (tableExtractor) Summarize(rows [][]string) error {
+	if err := writeHotTables(rows); err != nil {
+		return err
+	}
+	if err := writeTableMatrix(rows); err != nil {
+		return err
+	}
+	return writeGraphJSON(rows)
+}
+
+// writeHotTables writes hot_tables.csv, which ranks tables by the number of distinct sprocs referencing them
+func writeHotTables(rows [][]string) error {
+	usage := make(map[string]*tableUsage)
+	for _, row := range rows {
+		sproc, table, access := row[0], row[1], row[2]
+		u, ok := usage[table]
+		if !ok {
+			u = &tableUsage{table, make(map[string]struct{}), make(map[string]struct{})}
+			usage[table] = u
+		}
+		if strings.Contains(access, "R") {
+			u.readers[sproc] = struct{}{}
+		}
+		if strings.Contains(access, "W") {
+			u.writers[sproc] = struct{}{}
+		}
+	}
+	ranked := make([]*tableUsage, 0, len(usage))
+	for _, u := range usage {
+		ranked = append(ranked, u)
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		if a, b := ranked[i].sprocs(), ranked[j].sprocs(); a != b {
+			return a > b
+		}
+		return ranked[i].table < ranked[j].table
+	})
+	out := make([][]string, 0, len(ranked))
+	for i, u := range ranked {
+		out = append(out, []string{strconv.Itoa(i + 1), u.table, strconv.Itoa(u.sprocs()),
+			strconv.Itoa(len(u.readers)), strconv.Itoa(len(u.writers))})
+	}
+	return writeCSVFile("hot_tables.csv", []string{"Rank", "Table", "Sprocs", "Sprocs Reading", "Sprocs Writing"}, out)
+}
+
+// sprocs returns the number of distinct sprocs reading or writing the table
+func (u *tableUsage) sprocs() int {
+	n := len(u.readers)
+	for sproc := range u.writers {
+		if _, ok := u.readers[sproc]; !ok {
+			n++
+		}
+	}
+	return n
+}
+
+// writeTableMatrix writes table_matrix.csv, the pivot of table_sources.csv with a row per sproc, a column per table
+// and the sproc's access to the table (R, W or RW) in each cell. Each row starts with when the sproc was created
+// and last modified, so stale sprocs stand out from recently changed ones.
+func writeTableMatrix(rows [][]string) error {
+	var sprocs, tables []string
+	cells := make(map[string]map[string]string)
+	seen := make(map[string]bool)
+	for _, row := range rows {
+		sproc, table, access := row[0], row[1], row[2]
+		if _, ok := cells[sproc]; !ok {
+			cells[sproc] = make(map[string]string)
+			sprocs = append(sprocs, sproc)
+		}
+		if !seen[table] {
+			seen[table] = true
+			tables = append(tables, table)
+		}
+		cells[sproc][table] = access
+	}
+	sort.Strings(sprocs)
+	sort.Strings(tables)
+	out := make([][]string, 0, len(sprocs))
+	for _, sproc := range sprocs {
+		row := make([]string, 0, len(tables)+3)
+		created, modified := sprocDate(sproc)
+		row = append(row, sproc, created, modified)
+		for _, table := range tables {
+			row = append(row, cells[sproc][table])
+		}
+		out = append(out, row)
+	}
+	return writeCSVFile("table_matrix.csv", append([]string{"Stored Procedure", "Created", "Modified"}, tables...), out)
+}
+
+// graphNode and graphLink follow the shape used by the D3 force-directed graph examples. Node ids are prefixed
+// sproc: or table: so a sproc and a table of the same name are different nodes, and Name is the name alone.
+type graphNode struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Group int    `json:"group"`
+}
+
+type graphLink struct {
+	Source string `json:"source"`
+	Target string `json:"target"`
+	Value  int    `json:"value"`
+	Type   string `json:"type"`
+}
+
+const (
+	sprocGroup = 1
+	tableGroup = 2
+)
+
+// writeGraphJSON writes graph.json, the lineage as nodes (sprocs in group 1, tables in group 2) and links flowing
+// from each table read to the sproc, and from the sproc to each table written, as left by the -graph filters
+func writeGraphJSON(rows [][]string) error {
+	graph := struct {
+		Nodes []graphNode `json:"nodes"`
+		Links []graphLink `json:"links"`
+	}{[]graphNode{}, []graphLink{}}
+	seen := make(map[string]bool)
+	node := func(name string, group int) string {
+		id := "table:" + name
+		if group == sprocGroup {
+			id = "sproc:" + name
+		}
+		if !seen[id] {
+			seen[id] = true
+			graph.Nodes = append(graph.Nodes, graphNode{id, name, group})
+		}
+		return id
+	}
+	edges := make([]graphEdge, 0, len(rows))
+	access := make(map[graphEdge]string, len(rows))
+	for _, row := range rows {
+		e := graphEdge{sproc: row[0], target: row[1]}
+		if _, ok := access[e]; !ok {
+			edges = append(edges, e)
+		}
+		access[e] = mergeAccess(access[e], row[2])
+	}
+	for _, e := range pruneGraph(edges) {
+		sproc, table := node(e.sproc, sprocGroup), node(e.target, tableGroup)
+		if strings.Contains(access[e], "R") {
+			graph.Links = append(graph.Links, graphLink{table, sproc, 1, "read"})
+		}
+		if strings.Contains(access[e], "W") {
+			graph.Links = append(graph.Links, graphLink{sproc, table, 1, "write"})
+		}
+	}
+	f, err := createOutput("graph.json")
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	if err = enc.Encode(graph); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// analysisMu serializes analyses, which share the connection settings and whitelist held in globals
+var analysisMu sync.Mutex
+
+// referenceMu guards the catalogs and dictionaries loaded by a run, which POST /analyze parses with too. A run
+// holds it only while replacing them, and POST /analyze for reading while parsing.
+var referenceMu sync.RWMutex
+
+// loadReferenceData runs load, which replaces the catalogs and dictionaries of the previous run, while no POST
+// /analyze request is parsing with them
+func loadReferenceData(load func() error) error {
+	referenceMu.Lock()
+	defer referenceMu.Unlock()
+	return load()
+}
+
+// target is a named database analyzed on a schedule by `sprocs serve -targets`
+type target struct {
+	Name     string `json:"name"`
+	Host     string `json:"host"`
+	Database string `json:"database"`
+	User     string `json:"user"`
+	// Secret is a vault:// or azurekv:// reference to the password of User
+	Secret string `json:"secret"`
+	// Every is how often the target is analyzed, e.g. "24h"
+	Every string `json:"every"`
+	// Notify is a URL to which the schema changes found by each run are posted as JSON, along with the sprocs that
+	// failed to parse grouped by owning team when -owners is set
+	Notify string `json:"notify"`
+
+	interval    time.Duration
+	mu          sync.Mutex
+	lastRun     time.Time
+	lastErr     error
+	lastSuccess time.Time
+	lastDrift   []schemaChange
+	// names are the database and whitelist of the last successful run, which POST /analyze?target=<name>
+	// resolves names against
+	names *nameScope
+}
+
+// driftNotice is posted to a target's Notify URL when a run finds tables or sprocs added or dropped
+type driftNotice struct {
+	Target  string         `json:"target"`
+	Run     string         `json:"run"`
+	Changes []schemaChange `json:"changes"`
+}
+
+func loadTargets(path string) ([]*target, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var targets []*target
+	if err = json.Unmarshal(b, &targets); err != nil {
+		return nil, fmt.Errorf("invalid targets file %s: %v", path, err)
+	}
+	seen := make(map[string]bool)
+	for _, t := range targets {
+		if len(t.Name) == 0 || len(t.Host) == 0 || seen[t.Name] {
+			return nil, fmt.Errorf("invalid targets file %s: every target needs a unique name and a host", path)
+		}
+		seen[t.Name] = true
+		if len(t.Database) == 0 {
+			t.Database = "BRS"
+		}
+		if len(t.Every) == 0 {
+			t.Every = "24h"
+		}
+		if t.interval, err = time.ParseDuration(t.Every); err != nil {
+			return nil, fmt.Errorf("invalid targets file %s: target %s: %v", path, t.Name, err)
+		}
+	}
+	return targets, nil
+}
+
+// schedule analyzes the target every interval, writing runs to <root>/<name>
+func (t *target) schedule(root string) {
+	for {
+		drift, notices, run, err := t.analyze(filepath.Join(root, t.Name))
+		if err != nil {
+			log.Println("analysis of", t.Name, "failed:", err)
+		}
+		t.mu.Lock()
+		t.lastRun, t.lastErr = time.Now(), err
+		if err == nil {
+			t.lastSuccess, t.lastDrift = t.lastRun, drift
+		}
+		t.mu.Unlock()
+		if len(drift) > 0 && len(t.Notify) > 0 {
+			if err = postJSON(t.Notify, nil, driftNotice{t.Name, run, drift}); err != nil {
+				log.Println("notifying schema drift of", t.Name, "failed:", err)
+			}
+		}
+		if len(t.Notify) > 0 {
+			for _, n := range notices {
+				if err = postJSON(t.Notify, nil, n); err != nil {
+					log.Println("notifying parsing errors of", t.Name, "to", n.Team, "failed:", err)
+				}
+			}
+		}
+		time.Sleep(t.interval)
+	}
+}
+
+// analyze runs an analysis of the target, returning the schema changes since its previous run, the notices of
+// sprocs failing to parse by owner when -owners is set, and the name of the new run
+func (t *target) analyze(dir string) ([]schemaChange, []errorNotice, string, error) {
+	analysisMu.Lock()
+	defer analysisMu.Unlock()
+	if err := os.MkdirAll(dir, os.ModeDir); err != nil {
+		return nil, nil, "", err
+	}
+	dbHost, dbName, dbUser, secretRef, outRoot = t.Host, t.Database, t.User, t.Secret, dir
+	log.Println("Analyzing", t.Name)
+	lastParseErrors = nil
+	err := analyze()
+	if err == nil {
+		t.mu.Lock()
+		t.names = snapshotNames()
+		t.mu.Unlock()
+	}
+	var notices []errorNotice
+	if len(owners) > 0 {
+		notices = errorNotices(t.Name, filepath.Base(outDir))
+	}
+	return lastDrift, notices, filepath.Base(outDir), err
+}
+
+// LastRun and Status describe the most recent analysis for the targets page
+func (t *target) LastRun() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.lastRun.IsZero() {
+		return "never"
+	}
+	return t.lastRun.Format(time.RFC3339)
+}
+
+func (t *target) Status() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	switch {
+	case t.lastRun.IsZero():
+		return "pending"
+	case t.lastErr != nil:
+		return "failed: " + t.lastErr.Error()
+	case len(t.lastDrift) > 0:
+		return fmt.Sprintf("ok, %d schema changes since the previous run", len(t.lastDrift))
+	}
+	return "ok"
+}
+
+func (s *server) handleTargets(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := pages.ExecuteTemplate(w, "targets", s.targets); err != nil {
+		log.Println("rendering targets:", err)
+	}
+}
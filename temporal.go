@@ -0,0 +1,174 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/antlr/antlr4/runtime/Go/antlr"
+	parser "github.com/nycmonkey/sprocs/tsql"
+)
+
+// temporalQ lists the system-versioned tables of the dbo schema with their history tables. The columns it reads
+// were added in SQL Server 2016.
+const temporalQ = `
+SELECT t.name, h.name FROM sys.tables t JOIN sys.tables h ON h.object_id = t.history_table_id
+WHERE t.schema_id = SCHEMA_ID('dbo') AND t.temporal_type = 2
+`
+
+// historyTables maps the upper-cased name of each history table of the sproc database to its system-versioned table
+var historyTables map[string]string
+
+// loadTemporalTables fetches the history tables of the sproc database. Servers predating temporal tables have
+// none, so a failed query is logged rather than returned.
+func loadTemporalTables(db *sql.DB) {
+	historyTables = make(map[string]string)
+	logSQL(temporalQ)
+	rows, err := db.Query(temporalQ)
+	if err != nil {
+		log.Println("Not checking for history tables:", err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var current, history string
+		if err = rows.Scan(&current, &history); err != nil {
+			log.Println("Not checking for history tables:", err)
+			return
+		}
+		historyTables[strings.ToUpper(history)] = current
+	}
+	log.Println("Found", len(historyTables), "system-versioned tables")
+}
+
+func init() {
+	RegisterExtractor(temporalExtractor{})
+}
+
+// temporalExtractor reports the tables each sproc reads as of a point in time or over a period with
+// FOR SYSTEM_TIME, and its direct references to history tables, so the history reads can be told from reads of
+// current data. The clause is found in the tokens, as the grammar predates it.
+type temporalExtractor struct{}
+
+func (temporalExtractor) Name() string {
+	return "temporal_tables"
+}
+
+func (temporalExtractor) Columns() []string {
+	return []string{"Table", "Current Table", "Usage", "Clause", "Line"}
+}
+
+func (temporalExtractor) NewListener(sproc string, names *nameScope, emit func([]string)) antlr.ParseTreeListener {
+	return &temporalListener{&parser.BasetsqlListener{}, names, emit}
+}
+
+type temporalListener struct {
+	*parser.BasetsqlListener
+	names *nameScope
+	emit  func([]string)
+}
+
+// ExitTsql_file is called when the parser reaches the end of the TSQL input, at which point its tokens are scanned
+func (l *temporalListener) ExitTsql_file(ctx *parser.Tsql_fileContext) {
+	var tokens []antlr.Token
+	if stream, ok := ctx.GetParser().GetTokenStream().(*antlr.CommonTokenStream); ok {
+		for _, t := range stream.GetAllTokens() {
+			if t.GetChannel() == antlr.TokenDefaultChannel && t.GetTokenType() != antlr.TokenEOF {
+				tokens = append(tokens, t)
+			}
+		}
+	}
+	seen := make(map[string]bool)
+	for i, t := range tokens {
+		if strings.EqualFold(t.GetText(), "FOR") && i+2 < len(tokens) && strings.EqualFold(tokens[i+1].GetText(), "SYSTEM_TIME") {
+			table := l.names.dottedName(tokens, i-1)
+			if len(table) == 0 {
+				continue
+			}
+			usage, end := systemTimeClause(tokens, i+2)
+			clause := t.GetInputStream().GetText(t.GetStart(), tokens[end].GetStop())
+			l.emit([]string{table, table, usage, clause, strconv.Itoa(t.GetLine())})
+			continue
+		}
+		if i+1 < len(tokens) && tokens[i+1].GetText() == "." {
+			continue
+		}
+		table := l.names.dottedName(tokens, i)
+		if current, ok := historyTables[strings.ToUpper(table)]; ok && !seen[strings.ToUpper(table)] {
+			seen[strings.ToUpper(table)] = true
+			l.emit([]string{table, l.names.normalizeTableName(current), "history table", "", strconv.Itoa(t.GetLine())})
+		}
+	}
+}
+
+// dottedName returns the normalized name of the up to four part identifier ending with the token at end, or an
+// empty string when that token is not an identifier
+func (s *nameScope) dottedName(tokens []antlr.Token, end int) string {
+	if end < 0 || !isIdentifier(tokens[end].GetText()) {
+		return ""
+	}
+	start, parts := end, 1
+	for start >= 2 && parts < 4 && tokens[start-1].GetText() == "." && isIdentifier(tokens[start-2].GetText()) {
+		start -= 2
+		parts++
+	}
+	var name string
+	for _, t := range tokens[start : end+1] {
+		name += t.GetText()
+	}
+	return s.normalizeTableName(name)
+}
+
+func isIdentifier(text string) bool {
+	if len(text) == 0 {
+		return false
+	}
+	c := text[0]
+	return c == '[' || c == '"' || c == '_' || c == '#' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// systemTimeClause classifies the FOR SYSTEM_TIME sub-clause starting at the token at i and returns the index of
+// its last token. Its arguments are taken to be literals, variables or function calls.
+func systemTimeClause(tokens []antlr.Token, i int) (usage string, end int) {
+	switch strings.ToUpper(tokens[i].GetText()) {
+	case "AS":
+		// AS OF x
+		return "point in time", argumentEnd(tokens, i+2)
+	case "FROM", "BETWEEN":
+		// FROM x TO y, BETWEEN x AND y
+		return "period", argumentEnd(tokens, argumentEnd(tokens, i+1)+2)
+	case "CONTAINED":
+		// CONTAINED IN (x, y)
+		return "period", argumentEnd(tokens, i+2)
+	case "ALL":
+		return "all history", i
+	}
+	return "unknown", i - 1
+}
+
+// argumentEnd returns the index of the last token of the argument starting at i, including any parenthesized
+// group following it
+func argumentEnd(tokens []antlr.Token, i int) int {
+	if i >= len(tokens) {
+		return len(tokens) - 1
+	}
+	if tokens[i].GetText() != "(" {
+		if i+1 >= len(tokens) || tokens[i+1].GetText() != "(" {
+			return i
+		}
+		i++
+	}
+	depth := 0
+	for ; i < len(tokens); i++ {
+		switch tokens[i].GetText() {
+		case "(":
+			depth++
+		case ")":
+			if depth--; depth == 0 {
+				return i
+			}
+		}
+	}
+	return len(tokens) - 1
+}
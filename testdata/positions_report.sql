@@ -0,0 +1,18 @@
+CREATE PROCEDURE dbo.PositionsReport
+	@AsOf datetime,
+	@Portfolio varchar(20) = NULL OUTPUT
+AS
+BEGIN
+	SET NOCOUNT ON;
+
+	SELECT p.PortfolioCode, p.Quantity, s.Ticker
+	FROM dbo.Positions p
+	JOIN BRS.dbo.Securities s ON s.SecurityId = p.SecurityId
+	WHERE p.AsOfDate = @AsOf
+	  AND p.PortfolioCode = @Portfolio;
+
+	INSERT INTO dbo.ReportLog (ReportName, RunAt)
+	VALUES ('PositionsReport', GETDATE());
+
+	EXEC dbo.NotifyReportDone @Name = 'PositionsReport';
+END
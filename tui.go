@@ -0,0 +1,317 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/mattn/go-runewidth"
+	"github.com/nsf/termbox-go"
+)
+
+const (
+	listKeys = "↑↓ move  / filter  enter definition  q quit"
+	defKeys  = "↑↓ scroll  / search  n/N next/previous match  esc back"
+)
+
+// explorer is the terminal UI of browse: a filterable list of sprocs beside the report rows of the selected one,
+// and a scrollable, searchable view of a definition
+type explorer struct {
+	run     *runResults
+	matches []string
+	filter  string
+	sel     int
+	top     int
+	// typing is set while the filter or search text is being edited
+	typing bool
+
+	// sproc is the sproc whose definition is shown, or "" in the list
+	sproc  string
+	lines  []string
+	line   int
+	search string
+	msg    string
+}
+
+// explore runs the terminal UI until the user quits
+func (r *runResults) explore() error {
+	if err := termbox.Init(); err != nil {
+		return fmt.Errorf("couldn't open the terminal UI (%v); use -plain to type commands instead", err)
+	}
+	defer termbox.Close()
+	e := &explorer{run: r, matches: r.sprocs}
+	for {
+		e.draw()
+		ev := termbox.PollEvent()
+		switch ev.Type {
+		case termbox.EventError:
+			return ev.Err
+		case termbox.EventKey:
+			if ev.Key == termbox.KeyCtrlC {
+				return nil
+			}
+			var quit bool
+			switch {
+			case e.typing:
+				e.edit(ev)
+			case e.sproc != "":
+				e.readKey(ev)
+			default:
+				quit = e.listKey(ev)
+			}
+			if quit {
+				return nil
+			}
+		}
+	}
+}
+
+// edit applies a key to the filter or search being typed
+func (e *explorer) edit(ev termbox.Event) {
+	text := &e.filter
+	if e.sproc != "" {
+		text = &e.search
+	}
+	switch ev.Key {
+	case termbox.KeyEnter:
+		e.typing = false
+		if e.sproc != "" {
+			e.next(e.line, 1)
+		}
+		return
+	case termbox.KeyEsc:
+		e.typing = false
+		*text = ""
+	case termbox.KeyBackspace, termbox.KeyBackspace2:
+		if rs := []rune(*text); len(rs) > 0 {
+			*text = string(rs[:len(rs)-1])
+		}
+	case termbox.KeySpace:
+		*text += " "
+	default:
+		if ev.Ch != 0 {
+			*text += string(ev.Ch)
+		}
+	}
+	if e.sproc == "" {
+		e.matches = e.run.filter(e.filter)
+		e.sel, e.top = 0, 0
+	}
+}
+
+// listKey applies a key to the sproc list, reporting whether the user quit
+func (e *explorer) listKey(ev termbox.Event) bool {
+	_, h := termbox.Size()
+	page := h - 2
+	switch {
+	case ev.Key == termbox.KeyArrowUp || ev.Ch == 'k':
+		e.sel--
+	case ev.Key == termbox.KeyArrowDown || ev.Ch == 'j':
+		e.sel++
+	case ev.Key == termbox.KeyPgup:
+		e.sel -= page
+	case ev.Key == termbox.KeyPgdn || ev.Key == termbox.KeySpace:
+		e.sel += page
+	case ev.Key == termbox.KeyHome || ev.Ch == 'g':
+		e.sel = 0
+	case ev.Key == termbox.KeyEnd || ev.Ch == 'G':
+		e.sel = len(e.matches) - 1
+	case ev.Ch == '/':
+		e.typing = true
+	case ev.Key == termbox.KeyEsc:
+		if e.filter == "" {
+			return true
+		}
+		e.filter, e.matches, e.sel = "", e.run.sprocs, 0
+	case ev.Key == termbox.KeyEnter:
+		if e.sel < len(e.matches) {
+			e.open(e.matches[e.sel])
+		}
+	case ev.Ch == 'q':
+		return true
+	}
+	e.sel = clamp(e.sel, 0, len(e.matches)-1)
+	return false
+}
+
+// open switches to the definition of sproc
+func (e *explorer) open(sproc string) {
+	def, err := e.run.definition(sproc)
+	if err != nil {
+		e.msg = err.Error()
+		return
+	}
+	e.sproc, e.line, e.msg = sproc, 0, ""
+	e.lines = strings.Split(strings.Replace(def, "\t", "    ", -1), "\n")
+}
+
+// readKey applies a key to the definition view
+func (e *explorer) readKey(ev termbox.Event) {
+	_, h := termbox.Size()
+	page := h - 2
+	e.msg = ""
+	switch {
+	case ev.Key == termbox.KeyArrowUp || ev.Ch == 'k':
+		e.line--
+	case ev.Key == termbox.KeyArrowDown || ev.Ch == 'j':
+		e.line++
+	case ev.Key == termbox.KeyPgup:
+		e.line -= page
+	case ev.Key == termbox.KeyPgdn || ev.Key == termbox.KeySpace:
+		e.line += page
+	case ev.Key == termbox.KeyHome || ev.Ch == 'g':
+		e.line = 0
+	case ev.Key == termbox.KeyEnd || ev.Ch == 'G':
+		e.line = len(e.lines) - page
+	case ev.Ch == '/':
+		e.typing, e.search = true, ""
+	case ev.Ch == 'n':
+		e.next(e.line+1, 1)
+	case ev.Ch == 'N':
+		e.next(e.line-1, -1)
+	case ev.Key == termbox.KeyEsc || ev.Ch == 'q':
+		e.sproc, e.lines, e.search = "", nil, ""
+		return
+	}
+	e.line = clamp(e.line, 0, len(e.lines)-page)
+}
+
+// next scrolls to the first line from start, searching in direction dir, that contains the search text
+func (e *explorer) next(start, dir int) {
+	if e.search == "" {
+		return
+	}
+	want := strings.ToUpper(e.search)
+	for i := start; i >= 0 && i < len(e.lines); i += dir {
+		if strings.Contains(strings.ToUpper(e.lines[i]), want) {
+			e.line = i
+			return
+		}
+	}
+	e.msg = "not found: " + e.search
+}
+
+func (e *explorer) draw() {
+	termbox.Clear(termbox.ColorDefault, termbox.ColorDefault)
+	if e.sproc != "" {
+		e.drawDefinition()
+	} else {
+		e.drawList()
+	}
+	termbox.Flush()
+}
+
+func (e *explorer) drawList() {
+	w, h := termbox.Size()
+	listW := clamp(w/3, 20, w)
+	rows := h - 1
+	if e.sel < e.top {
+		e.top = e.sel
+	} else if e.sel >= e.top+rows {
+		e.top = e.sel - rows + 1
+	}
+	for y := 0; y < rows && e.top+y < len(e.matches); y++ {
+		fg, bg := termbox.ColorDefault, termbox.ColorDefault
+		if e.top+y == e.sel {
+			fg, bg = termbox.ColorBlack, termbox.ColorCyan
+		}
+		fillRow(0, y, listW, fg, bg)
+		drawText(0, y, listW, e.matches[e.top+y], fg, bg)
+	}
+	for y := 0; y < rows; y++ {
+		termbox.SetCell(listW, y, '│', termbox.ColorDefault, termbox.ColorDefault)
+	}
+	if e.sel < len(e.matches) {
+		var buf bytes.Buffer
+		e.run.show(&buf, e.matches[e.sel])
+		for y, line := range strings.Split(strings.TrimRight(buf.String(), "\n"), "\n") {
+			if y >= rows {
+				break
+			}
+			drawText(listW+2, y, w-listW-2, line, termbox.ColorDefault, termbox.ColorDefault)
+		}
+	}
+	status := fmt.Sprintf("%d of %d sprocs  %s", len(e.matches), len(e.run.sprocs), listKeys)
+	if e.msg != "" {
+		status = e.msg
+	}
+	e.drawStatus(w, h, e.filter, status)
+}
+
+func (e *explorer) drawDefinition() {
+	w, h := termbox.Size()
+	fillRow(0, 0, w, termbox.ColorBlack, termbox.ColorCyan)
+	drawText(0, 0, w, e.sproc, termbox.ColorBlack, termbox.ColorCyan)
+	gutter := len(strconv.Itoa(len(e.lines))) + 1
+	want := strings.ToUpper(e.search)
+	for y := 1; y < h-1 && e.line+y-1 < len(e.lines); y++ {
+		n := e.line + y - 1
+		drawText(0, y, gutter, fmt.Sprintf("%*d", gutter-1, n+1), termbox.ColorYellow, termbox.ColorDefault)
+		line := e.lines[n]
+		drawText(gutter+1, y, w-gutter-1, line, termbox.ColorDefault, termbox.ColorDefault)
+		if want == "" || e.typing {
+			continue
+		}
+		// highlight each match, measuring its offset in cells since the line may hold wide runes
+		upper := strings.ToUpper(line)
+		for from := 0; ; {
+			i := strings.Index(upper[from:], want)
+			if i < 0 || len(upper) != len(line) {
+				break
+			}
+			from += i
+			x := gutter + 1 + runewidth.StringWidth(line[:from])
+			drawText(x, y, w-x, line[from:from+len(want)], termbox.ColorBlack, termbox.ColorYellow)
+			from += len(want)
+		}
+	}
+	status := fmt.Sprintf("lines %d-%d of %d  %s", e.line+1, clamp(e.line+h-2, 0, len(e.lines)), len(e.lines), defKeys)
+	if e.msg != "" {
+		status = e.msg
+	}
+	e.drawStatus(w, h, e.search, status)
+}
+
+// drawStatus draws the bottom line: the filter or search being typed, or else status
+func (e *explorer) drawStatus(w, h int, typed, status string) {
+	fg, bg := termbox.ColorBlack, termbox.ColorWhite
+	fillRow(0, h-1, w, fg, bg)
+	if e.typing {
+		prompt := "/" + typed
+		drawText(0, h-1, w, prompt, fg, bg)
+		termbox.SetCursor(runewidth.StringWidth(prompt), h-1)
+		return
+	}
+	termbox.HideCursor()
+	drawText(0, h-1, w, status, fg, bg)
+}
+
+// drawText draws s from column x of row y, cut off after width cells
+func drawText(x, y, width int, s string, fg, bg termbox.Attribute) {
+	end := x + width
+	for _, r := range s {
+		rw := runewidth.RuneWidth(r)
+		if x+rw > end {
+			return
+		}
+		termbox.SetCell(x, y, r, fg, bg)
+		x += rw
+	}
+}
+
+func fillRow(x, y, width int, fg, bg termbox.Attribute) {
+	for i := 0; i < width; i++ {
+		termbox.SetCell(x+i, y, ' ', fg, bg)
+	}
+}
+
+func clamp(n, lo, hi int) int {
+	if n > hi {
+		n = hi
+	}
+	if n < lo {
+		n = lo
+	}
+	return n
+}
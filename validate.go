@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+const (
+	verdictClean      = "parsed cleanly"
+	verdictRecovered  = "recovered"
+	verdictFailed     = "failed"
+	verdictUnreadable = "unreadable"
+)
+
+func init() {
+	commands["validate"] = command{
+		summary: "parse every .sql file under a directory and report a verdict per file, without stopping on failures",
+		run:     validate,
+	}
+}
+
+func validate(args []string) error {
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+	extract := fs.String("extract", "", "comma-separated extractors to run over each file (default all)")
+	addParseFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: sprocs validate [flags] <dir>\n\nWrites File,Verdict,Errors,Detail rows as CSV to stdout.\n\nFlags:")
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	extractors, err := selectExtractors(*extract)
+	if err != nil {
+		return err
+	}
+	w := csv.NewWriter(os.Stdout)
+	w.UseCRLF = true
+	w.Write([]string{"File", "Verdict", "Errors", "Detail"})
+	counts := make(map[string]int)
+	err = filepath.Walk(fs.Arg(0), func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			// an unreadable directory is reported like an unreadable file rather than ending the walk
+			counts[verdictUnreadable]++
+			w.Write(safeRow([]string{path, verdictUnreadable, "", err.Error()}))
+			if fi != nil && fi.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if fi.IsDir() || !strings.EqualFold(filepath.Ext(path), ".sql") {
+			return nil
+		}
+		verdict, errCount, detail := validateFile(path, extractors)
+		counts[verdict]++
+		w.Write(safeRow([]string{path, verdict, strconv.Itoa(errCount), detail}))
+		w.Flush()
+		return nil
+	})
+	w.Flush()
+	log.Printf("%d %s, %d %s, %d %s, %d %s", counts[verdictClean], verdictClean, counts[verdictRecovered], verdictRecovered,
+		counts[verdictFailed], verdictFailed, counts[verdictUnreadable], verdictUnreadable)
+	if err != nil {
+		return err
+	}
+	return w.Error()
+}
+
+// validateFile parses a file with the extractors' listeners, recovering from any panic along the way, and returns
+// its verdict, the number of syntax errors and the first error or the panic
+func validateFile(path string, extractors []Extractor) (verdict string, errCount int, detail string) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return verdictUnreadable, 0, err.Error()
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			verdict, detail = verdictFailed, fmt.Sprint(r)
+		}
+	}()
+	errors, _ := parseSproc(keyValue{path, decodeSQLFile(b)}, runNames, extractors, false)
+	if len(errors) == 0 {
+		return verdictClean, 0, ""
+	}
+	if last := errors[len(errors)-1]; strings.HasPrefix(last, parserPanic) {
+		return verdictFailed, len(errors) - 1, last
+	}
+	return verdictRecovered, len(errors), errors[0]
+}
+
+// decodeSQLFile returns the text of a script, which SQL Server Management Studio often saves as UTF-16 with a
+// byte order mark
+func decodeSQLFile(b []byte) string {
+	switch {
+	case bytes.HasPrefix(b, []byte{0xEF, 0xBB, 0xBF}):
+		return string(b[3:])
+	case bytes.HasPrefix(b, []byte{0xFF, 0xFE}), bytes.HasPrefix(b, []byte{0xFE, 0xFF}):
+		bigEndian := b[0] == 0xFE
+		b = b[2:]
+		u := make([]uint16, len(b)/2)
+		for i := range u {
+			if bigEndian {
+				u[i] = uint16(b[2*i])<<8 | uint16(b[2*i+1])
+			} else {
+				u[i] = uint16(b[2*i+1])<<8 | uint16(b[2*i])
+			}
+		}
+		return string(utf16.Decode(u))
+	}
+	return string(b)
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+func init() {
+	commands["verify"] = command{
+		summary: "check the extraction of .sql fixtures still matches their expected .json results",
+		run:     verify,
+	}
+}
+
+// verify checks every fixture of a directory, testdata by default. A fixture is a pair of files: <name>.sql, a
+// T-SQL definition, and <name>.json, the expected result of analyzing it, in the format returned by POST /analyze
+// for one document. Only the extractors present in the expected findings are compared, and rows are compared
+// regardless of order.
+func verify(args []string) error {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	update := fs.Bool("update", false, "write the current results as the expected results of the fixtures")
+	extract := fs.String("extract", "", "comma-separated extractors to record with -update (default all)")
+	addParseFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: sprocs verify [flags] [fixtures dir (default testdata)]\n\nFlags:")
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	if fs.NArg() > 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	dir := "testdata"
+	if fs.NArg() == 1 {
+		dir = fs.Arg(0)
+	}
+	extractors, err := selectExtractors(*extract)
+	if err != nil {
+		return err
+	}
+	paths, err := filepath.Glob(filepath.Join(dir, "*.sql"))
+	if err != nil {
+		return err
+	}
+	if len(paths) == 0 {
+		return fmt.Errorf("no .sql fixtures in %s", dir)
+	}
+	var failed int
+	for _, path := range paths {
+		name := strings.TrimSuffix(filepath.Base(path), ".sql")
+		if *update {
+			if err = updateFixture(path, extractors); err != nil {
+				return err
+			}
+			fmt.Println("updated", name)
+			continue
+		}
+		diffs, err := verifyFixture(path)
+		if err != nil {
+			return err
+		}
+		if len(diffs) == 0 {
+			fmt.Println("ok  ", name)
+			continue
+		}
+		failed++
+		fmt.Println("FAIL", name)
+		for _, d := range diffs {
+			fmt.Println("    ", d)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d fixtures failed", failed, len(paths))
+	}
+	return nil
+}
+
+// expectedPath returns the expected result file of a .sql fixture
+func expectedPath(sqlPath string) string {
+	return strings.TrimSuffix(sqlPath, filepath.Ext(sqlPath)) + ".json"
+}
+
+// updateFixture writes the result of analyzing a .sql fixture with the extractors as its expected result
+func updateFixture(sqlPath string, extractors []Extractor) error {
+	body, err := ioutil.ReadFile(sqlPath)
+	if err != nil {
+		return err
+	}
+	res := analyzeOne(analyzeDocument{strings.TrimSuffix(filepath.Base(sqlPath), ".sql"), string(body)}, runNames, extractors)
+	for name, rows := range res.Findings {
+		res.Findings[name] = canonicalRows(rows)
+	}
+	b, err := json.MarshalIndent(res, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(expectedPath(sqlPath), append(b, '\n'), 0644)
+}
+
+// verifyFixture analyzes a .sql fixture and describes each way the result differs from the expected one. It is
+// meant for Go tests as much as for `sprocs verify`: a fixture passes when no differences are returned.
+func verifyFixture(sqlPath string) ([]string, error) {
+	body, err := ioutil.ReadFile(sqlPath)
+	if err != nil {
+		return nil, err
+	}
+	b, err := ioutil.ReadFile(expectedPath(sqlPath))
+	if err != nil {
+		return nil, err
+	}
+	var want analyzeResult
+	if err = json.Unmarshal(b, &want); err != nil {
+		return nil, fmt.Errorf("invalid expected result %s: %v", expectedPath(sqlPath), err)
+	}
+	var selected []string
+	for name := range want.Findings {
+		selected = append(selected, name)
+	}
+	sort.Strings(selected)
+	extractors, err := selectExtractors(strings.Join(selected, ","))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", expectedPath(sqlPath), err)
+	}
+	got := analyzeOne(analyzeDocument{want.Name, string(body)}, runNames, extractors)
+	var diffs []string
+	if len(got.Errors) != len(want.Errors) {
+		diffs = append(diffs, fmt.Sprintf("%d syntax errors, expected %d", len(got.Errors), len(want.Errors)))
+	}
+	for _, name := range selected {
+		missing, extra := diffRows(canonicalRows(want.Findings[name]), canonicalRows(got.Findings[name]))
+		for _, row := range missing {
+			diffs = append(diffs, name+": missing "+row)
+		}
+		for _, row := range extra {
+			diffs = append(diffs, name+": unexpected "+row)
+		}
+	}
+	return diffs, nil
+}
+
+// canonicalRows orders findings by their JSON encoding, which lists columns in name order
+func canonicalRows(rows []map[string]string) []map[string]string {
+	sort.Slice(rows, func(i, j int) bool {
+		return encodeRow(rows[i]) < encodeRow(rows[j])
+	})
+	return rows
+}
+
+func encodeRow(row map[string]string) string {
+	b, _ := json.Marshal(row)
+	return string(b)
+}
+
+// diffRows returns the encoded rows of want missing from got, and those of got not in want, counting duplicates
+func diffRows(want, got []map[string]string) (missing, extra []string) {
+	counts := make(map[string]int)
+	for _, row := range got {
+		counts[encodeRow(row)]++
+	}
+	for _, row := range want {
+		key := encodeRow(row)
+		if counts[key] > 0 {
+			counts[key]--
+			continue
+		}
+		missing = append(missing, key)
+	}
+	for _, row := range got {
+		key := encodeRow(row)
+		if counts[key] > 0 {
+			counts[key]--
+			extra = append(extra, key)
+		}
+	}
+	return
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestFixtures checks the extraction of each fixture in testdata against its expected result, as `sprocs verify`
+// does
+func TestFixtures(t *testing.T) {
+	paths, err := filepath.Glob(filepath.Join("testdata", "*.sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) == 0 {
+		t.Fatal("no .sql fixtures in testdata")
+	}
+	for _, path := range paths {
+		path := path
+		t.Run(strings.TrimSuffix(filepath.Base(path), ".sql"), func(t *testing.T) {
+			diffs, err := verifyFixture(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, d := range diffs {
+				t.Error(d)
+			}
+		})
+	}
+}
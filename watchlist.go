@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// watchlist names the tables and portfolios to watch with -watchlist. Patterns are globs matched without regard
+// to case: tables against the names reported in table_sources.csv, portfolios against the account master values
+// reported in codes.csv.
+type watchlist struct {
+	Tables     []string `json:"tables"`
+	Portfolios []string `json:"portfolios"`
+	// Notify is a URL to which the sprocs found touching watched objects they didn't in the previous run are
+	// posted as JSON
+	Notify string `json:"notify"`
+}
+
+// watched holds the -watchlist, nil when none was given
+var watched *watchlist
+
+// watchTouch is a sproc using a watched object
+type watchTouch struct {
+	Sproc string `json:"sproc"`
+	// Kind is table or portfolio
+	Kind   string `json:"kind"`
+	Object string `json:"object"`
+	// Detail is the access of a table, or the account master column of a portfolio
+	Detail string `json:"detail"`
+}
+
+// watchNotice is posted to the watchlist's Notify URL when sprocs start touching watched objects
+type watchNotice struct {
+	Run     string       `json:"run"`
+	Touches []watchTouch `json:"touches"`
+}
+
+// watchlistFlag is a flag.Value that loads a JSON watchlist file
+type watchlistFlag struct{}
+
+func (watchlistFlag) String() string {
+	return ""
+}
+
+func (watchlistFlag) Set(file string) error {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	w := new(watchlist)
+	if err = json.Unmarshal(b, w); err != nil {
+		return fmt.Errorf("invalid watchlist file %s: %v", file, err)
+	}
+	if len(w.Tables) == 0 && len(w.Portfolios) == 0 {
+		return fmt.Errorf("invalid watchlist file %s: it names no tables or portfolios", file)
+	}
+	for _, pattern := range append(w.Tables, w.Portfolios...) {
+		if _, err = path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid watchlist file %s: pattern %q: %v", file, pattern, err)
+		}
+	}
+	watched = w
+	return nil
+}
+
+// touches returns the uses of watched objects reported by the run in dir, keyed by sproc, kind and object, or
+// nil if the run has neither table_sources.csv nor codes.csv
+func (w *watchlist) touches(dir string) (map[string]watchTouch, error) {
+	var found map[string]watchTouch
+	for _, report := range []struct {
+		file, kind string
+		patterns   []string
+	}{
+		{"table_sources.csv", "table", w.Tables},
+		{portfolios.name + ".csv", "portfolio", w.Portfolios},
+	} {
+		records, err := readCSVFile(filepath.Join(dir, report.file))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if found == nil {
+			found = make(map[string]watchTouch)
+		}
+		if len(records) == 0 {
+			continue
+		}
+		for _, rec := range records[1:] {
+			if len(rec) < 3 {
+				continue
+			}
+			// tables are reported with their access, portfolios with their column before their value
+			object, detail := rec[1], rec[2]
+			if report.kind == "portfolio" {
+				object, detail = rec[2], rec[1]
+			}
+			if !matchesAny(report.patterns, object) {
+				continue
+			}
+			key := strings.ToUpper(rec[0] + "\x00" + report.kind + "\x00" + object)
+			if _, ok := found[key]; !ok {
+				found[key] = watchTouch{rec[0], report.kind, object, detail}
+			}
+		}
+	}
+	return found, nil
+}
+
+// recordWatchlist writes watchlist.csv, the uses of watched objects by the sprocs of the run, marking those new
+// since the previous run, and posts the new ones to the watchlist's Notify URL. Sprocs the previous run left out
+// for lack of time aren't taken for new.
+func recordWatchlist() error {
+	if watched == nil {
+		return nil
+	}
+	current, err := watched.touches(outDir)
+	if err != nil || current == nil {
+		return err
+	}
+	var previous map[string]watchTouch
+	skipped := make(map[string]bool)
+	if prev := previousRun(); len(prev) > 0 {
+		if previous, err = watched.touches(prev); err != nil {
+			return err
+		}
+		manifest, err := readCSVFile(filepath.Join(prev, manifestFile))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		for _, rec := range manifest {
+			if len(rec) >= 3 && (rec[2] == statusSkipped || rec[2] == statusNotFetched) {
+				skipped[strings.ToUpper(rec[0])] = true
+			}
+		}
+	}
+	var rows [][]string
+	var fresh []watchTouch
+	for key, t := range current {
+		isNew := ""
+		if _, seen := previous[key]; previous != nil && !seen && !skipped[strings.ToUpper(t.Sproc)] {
+			isNew = "yes"
+			fresh = append(fresh, t)
+			log.Println("Watchlist:", t.Sproc, "now uses", t.Kind, t.Object)
+		}
+		rows = append(rows, []string{t.Sproc, t.Kind, t.Object, t.Detail, isNew})
+	}
+	sortRows(rows)
+	if err = writeCSVFile("watchlist.csv", []string{"Stored Procedure", "Object Type", "Object", "Detail", "New"}, rows); err != nil {
+		return err
+	}
+	if len(fresh) > 0 && len(watched.Notify) > 0 {
+		if err = postJSON(watched.Notify, nil, watchNotice{filepath.Base(outDir), fresh}); err != nil {
+			log.Println("notifying the watchlist failed:", err)
+		}
+	}
+	return nil
+}